@@ -0,0 +1,307 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"planner/storage"
+	"strings"
+)
+
+func commandUsage() {
+	fmt.Fprintf(os.Stderr, "usage: planner [command]\n\n")
+	fmt.Fprintf(os.Stderr, "Without a command the server is started. Commands:\n")
+	fmt.Fprintf(os.Stderr, "  purge-user [-yes] <userId>  permanently delete all data of a user, counts it without -yes\n")
+	fmt.Fprintf(os.Stderr, "  migrate-storage -from <type> -to <type> [-checkpoint <file>]\n")
+	fmt.Fprintf(os.Stderr, "                              copy all users' data between storage types, keeping ids\n")
+	fmt.Fprintf(os.Stderr, "  backup [file]               write a sqlite backup to file or to PLANNER_BACKUP_DIR\n")
+	fmt.Fprintf(os.Stderr, "  restore [-yes] <file>       replace the sqlite database with a backup, the server must be stopped\n")
+}
+
+// runCommand runs an admin command and returns the exit code. Commands use the
+// storage selected by PLANNER_STORAGE_TYPE unless they take their own.
+func runCommand(args []string) int {
+	if args[0] == "purge-user" {
+		return runPurgeUser(args[1:])
+	}
+	if args[0] == "migrate-storage" {
+		return runMigrateStorage(args[1:])
+	}
+	if args[0] == "backup" {
+		return runBackup(args[1:])
+	}
+	if args[0] == "restore" {
+		return runRestore(args[1:])
+	}
+	commandUsage()
+	return 2
+}
+
+func runPurgeUser(args []string) int {
+	flags := flag.NewFlagSet("purge-user", flag.ContinueOnError)
+	confirmed := flags.Bool("yes", false, "delete the data rather than only counting it")
+	err := flags.Parse(args)
+	if err != nil {
+		return 2
+	}
+	if flags.NArg() != 1 || flags.Arg(0) == "" {
+		commandUsage()
+		return 2
+	}
+	userId := flags.Arg(0)
+
+	strg, err := storage.GetStorage(storageTypeFromEnv())
+	if err != nil {
+		fmt.Printf("error opening storage: %s\n", err)
+		return 1
+	}
+	defer strg.Close()
+
+	ctx := context.Background()
+	if !*confirmed {
+		summary, err := storage.CountUserData(ctx, strg, userId)
+		if err != nil {
+			fmt.Printf("error counting data: %s\n", err)
+			return 1
+		}
+		fmt.Printf("user %s has %d plans, %d activities and %d recurring activities, rerun with -yes to delete them\n",
+			userId, summary.Plans, summary.Activities, summary.RecurringActivities)
+		return 0
+	}
+
+	summary, err := storage.PurgeUser(ctx, strg, userId)
+	if err != nil {
+		fmt.Printf("error purging data: %s\n", err)
+		return 1
+	}
+	fmt.Printf("purged user %s: %d plans, %d activities and %d recurring activities deleted\n",
+		userId, summary.Plans, summary.Activities, summary.RecurringActivities)
+	return 0
+}
+
+func runMigrateStorage(args []string) int {
+	flags := flag.NewFlagSet("migrate-storage", flag.ContinueOnError)
+	from := flags.String("from", "", "storage type to copy from")
+	to := flags.String("to", "", "storage type to copy to")
+	checkpointPath := flags.String("checkpoint", "migrate-storage.checkpoint", "file recording the users already copied")
+	err := flags.Parse(args)
+	if err != nil {
+		return 2
+	}
+	if flags.NArg() != 0 || *from == "" || *to == "" {
+		commandUsage()
+		return 2
+	}
+	if *from == *to || *from == string(storage.Memory) || *to == string(storage.Memory) {
+		fmt.Println("-from and -to must be two different persistent storage types")
+		return 2
+	}
+
+	source, err := storage.GetStorage(storage.StorageType(*from))
+	if err != nil {
+		fmt.Printf("error opening %s storage: %s\n", *from, err)
+		return 1
+	}
+	defer source.Close()
+	target, err := storage.GetStorage(storage.StorageType(*to))
+	if err != nil {
+		fmt.Printf("error opening %s storage: %s\n", *to, err)
+		return 1
+	}
+	defer target.Close()
+
+	ctx := context.Background()
+	userIds, err := storage.UserIds(ctx, source)
+	if err != nil {
+		fmt.Printf("error listing users: %s\n", err)
+		return 1
+	}
+	checkpoint, copied, err := openMigrationCheckpoint(*checkpointPath, *from, *to)
+	if err != nil {
+		fmt.Printf("error reading checkpoint: %s\n", err)
+		return 1
+	}
+	defer checkpoint.Close()
+	if len(copied) > 0 {
+		fmt.Printf("resuming from %s, %d users already copied\n", *checkpointPath, len(copied))
+	}
+	for i, userId := range userIds {
+		if copied[userId] {
+			continue
+		}
+		err = storage.CopyUser(ctx, source, target, userId)
+		if err != nil {
+			fmt.Printf("error copying user %s: %s\n", userId, err)
+			return 1
+		}
+		_, err = fmt.Fprintln(checkpoint, userId)
+		if err == nil {
+			err = checkpoint.Sync()
+		}
+		if err != nil {
+			fmt.Printf("error writing checkpoint: %s\n", err)
+			return 1
+		}
+		fmt.Printf("copied user %s (%d/%d)\n", userId, i+1, len(userIds))
+	}
+
+	total := storage.PurgeSummary{}
+	totalEntries := 0
+	mismatches := 0
+	for _, userId := range userIds {
+		expected, err := storage.CountUserData(ctx, source, userId)
+		if err != nil {
+			fmt.Printf("error counting %s data of user %s: %s\n", *from, userId, err)
+			return 1
+		}
+		actual, err := storage.CountUserData(ctx, target, userId)
+		if err != nil {
+			fmt.Printf("error counting %s data of user %s: %s\n", *to, userId, err)
+			return 1
+		}
+		if actual != expected {
+			mismatches++
+			fmt.Printf("user %s has %d plans, %d activities and %d recurring activities in %s but %d, %d and %d in %s\n",
+				userId, expected.Plans, expected.Activities, expected.RecurringActivities, *from,
+				actual.Plans, actual.Activities, actual.RecurringActivities, *to)
+		}
+		expectedEntries, err := storage.CountAuditEntries(ctx, source, userId)
+		if err != nil {
+			fmt.Printf("error counting %s audit entries of user %s: %s\n", *from, userId, err)
+			return 1
+		}
+		actualEntries, err := storage.CountAuditEntries(ctx, target, userId)
+		if err != nil {
+			fmt.Printf("error counting %s audit entries of user %s: %s\n", *to, userId, err)
+			return 1
+		}
+		if actualEntries != expectedEntries {
+			mismatches++
+			fmt.Printf("user %s has %d audit entries in %s but %d in %s\n",
+				userId, expectedEntries, *from, actualEntries, *to)
+		}
+		total.Plans += expected.Plans
+		total.Activities += expected.Activities
+		total.RecurringActivities += expected.RecurringActivities
+		totalEntries += expectedEntries
+	}
+	if mismatches > 0 {
+		fmt.Printf("verification failed for %d of %d users\n", mismatches, len(userIds))
+		return 1
+	}
+	// A later migration should start from scratch
+	checkpoint.Close()
+	err = os.Remove(*checkpointPath)
+	if err != nil {
+		fmt.Printf("error removing checkpoint: %s\n", err)
+	}
+	fmt.Printf("migrated and verified %d users: %d plans, %d activities, %d recurring activities and %d audit entries\n",
+		len(userIds), total.Plans, total.Activities, total.RecurringActivities, totalEntries)
+	return 0
+}
+
+func runBackup(args []string) int {
+	if len(args) > 1 {
+		commandUsage()
+		return 2
+	}
+	schedule, err := storage.BackupScheduleFromEnv()
+	if err != nil {
+		fmt.Printf("error reading backup settings: %s\n", err)
+		return 1
+	}
+	strg, err := storage.GetStorage(storageTypeFromEnv())
+	if err != nil {
+		fmt.Printf("error opening storage: %s\n", err)
+		return 1
+	}
+	defer strg.Close()
+
+	ctx := context.Background()
+	path := ""
+	if len(args) == 1 {
+		path = args[0]
+		err = strg.Backup(ctx, path)
+	} else {
+		path, err = schedule.BackupNow(ctx, strg)
+	}
+	if err != nil {
+		fmt.Printf("error writing backup: %s\n", err)
+		return 1
+	}
+	fmt.Printf("wrote backup %s\n", path)
+	return 0
+}
+
+func runRestore(args []string) int {
+	flags := flag.NewFlagSet("restore", flag.ContinueOnError)
+	confirmed := flags.Bool("yes", false, "replace the database rather than only showing what would be done")
+	err := flags.Parse(args)
+	if err != nil {
+		return 2
+	}
+	if flags.NArg() != 1 || flags.Arg(0) == "" {
+		commandUsage()
+		return 2
+	}
+	if storageTypeFromEnv() != storage.Sqlite {
+		fmt.Println("restore only supports sqlite storage")
+		return 2
+	}
+	backupPath := flags.Arg(0)
+	if !*confirmed {
+		fmt.Printf("rerun with -yes to replace %s with %s, stop the server first\n", storage.SqlitePath(), backupPath)
+		return 0
+	}
+
+	err = storage.RestoreSqlite(context.Background(), backupPath, storage.SqlitePath())
+	if err != nil {
+		fmt.Printf("error restoring backup: %s\n", err)
+		return 1
+	}
+	fmt.Printf("restored %s from %s\n", storage.SqlitePath(), backupPath)
+	return 0
+}
+
+// openMigrationCheckpoint opens the checkpoint for appending and returns the
+// users it records as copied. Its first line names the storages so it isn't
+// resumed for a different migration.
+func openMigrationCheckpoint(path string, from string, to string) (*os.File, map[string]bool, error) {
+	header := fmt.Sprintf("# planner migrate-storage %s %s", from, to)
+	copied := make(map[string]bool)
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0o644)
+	if err != nil {
+		return nil, nil, err
+	}
+	scanner := bufio.NewScanner(file)
+	if !scanner.Scan() {
+		err = scanner.Err()
+		if err == nil {
+			_, err = fmt.Fprintln(file, header)
+		}
+		if err != nil {
+			file.Close()
+			return nil, nil, err
+		}
+		return file, copied, nil
+	}
+	if scanner.Text() != header {
+		file.Close()
+		return nil, nil, errors.New(path + " belongs to a different migration, remove it to start over")
+	}
+	for scanner.Scan() {
+		userId := strings.TrimSpace(scanner.Text())
+		if userId != "" {
+			copied[userId] = true
+		}
+	}
+	err = scanner.Err()
+	if err != nil {
+		file.Close()
+		return nil, nil, err
+	}
+	return file, copied, nil
+}
@@ -4,7 +4,9 @@ go 1.21
 
 require (
 	github.com/google/uuid v1.3.0
+	github.com/lib/pq v1.10.9
 	github.com/mattn/go-sqlite3 v1.14.16
+	github.com/teambition/rrule-go v1.8.2
 )
 
 require (
@@ -18,6 +20,6 @@ require (
 	github.com/gocql/gocql v1.3.2
 	github.com/pmezard/go-difflib v1.0.0 // indirect
 	github.com/stretchr/objx v0.5.0 // indirect
-	github.com/stretchr/testify v1.8.2 // indirect
+	github.com/stretchr/testify v1.8.2
 	gopkg.in/yaml.v3 v3.0.1 // indirect
 )
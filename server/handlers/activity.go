@@ -2,20 +2,29 @@ package handlers
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
+	"net/url"
 	"planner/middlewares"
 	"planner/storage"
+	"strconv"
 	"strings"
 	"time"
 
 	"github.com/google/uuid"
 )
 
-func AddActivityHandlers(mux *http.ServeMux, strg storage.ActivityStorage, plnStrg storage.PlanStorage, useridMiddleware middlewares.Middleware) {
+const MAX_ACTIVITY_PAGE_SIZE = 1000
+const DEFAULT_SEARCH_LIMIT = 20
+const MAX_SEARCH_LIMIT = 100
+const NEXT_CURSOR_HEADER = "X-Next-Cursor"
+
+func AddActivityHandlers(mux *http.ServeMux, strg storage.ActivityStorage, plnStrg storage.PlanStorage, audit storage.AuditStorage, useridMiddleware middlewares.Middleware) {
 	mux.Handle("/api/activities", useridMiddleware(registerActivityRoot(strg, plnStrg)))
-	mux.Handle("/api/activities/", useridMiddleware(registerActivityId(strg, plnStrg)))
+	mux.Handle("/api/activities/", useridMiddleware(registerActivityId(strg, plnStrg, audit)))
+	mux.Handle("/api/activities/search", useridMiddleware(registerActivitySearch(strg)))
 }
 
 func registerActivityRoot(strg storage.ActivityStorage, plnStrg storage.PlanStorage) http.HandlerFunc {
@@ -31,7 +40,18 @@ func registerActivityRoot(strg storage.ActivityStorage, plnStrg storage.PlanStor
 	}
 }
 
-func registerActivityId(strg storage.ActivityStorage, plnStrg storage.PlanStorage) http.HandlerFunc {
+func registerActivitySearch(strg storage.ActivityStorage) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet {
+			handleSearchActivity(w, r, strg)
+		} else {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			fmt.Fprintf(w, "Invalid method: %s", r.Method)
+		}
+	}
+}
+
+func registerActivityId(strg storage.ActivityStorage, plnStrg storage.PlanStorage, audit storage.AuditStorage) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		parts := strings.Split(r.URL.Path, "/")
 		id := parts[3]
@@ -43,12 +63,21 @@ func registerActivityId(strg storage.ActivityStorage, plnStrg storage.PlanStorag
 			return
 		}
 
+		if len(parts) > 4 && parts[4] != "" {
+			if parts[4] == "history" && r.Method == http.MethodGet {
+				handleHistory(w, r, audit, storage.AuditActivity, uuid)
+			} else {
+				http.Error(w, "Not Found", http.StatusNotFound)
+			}
+			return
+		}
+
 		if r.Method == http.MethodPut {
 			handleUpdateActivity(w, r, strg, plnStrg, uuid)
 		} else if r.Method == http.MethodGet {
 			handleReadActivity(w, r, strg, uuid)
 		} else if r.Method == http.MethodDelete {
-			handleDeleteActivity(w, r, strg, uuid)
+			handleDeleteActivity(w, r, strg, plnStrg, uuid)
 		} else {
 			w.WriteHeader(http.StatusMethodNotAllowed)
 			fmt.Fprintf(w, "Invalid method: %s", r.Method)
@@ -73,13 +102,19 @@ func handleCreateActivity(w http.ResponseWriter, r *http.Request, strg storage.A
 	}
 
 	activity.UserId = w.Header().Get(middlewares.VALIDATED_HEADER)
+	activity.DeletedAt = nil
 
-	if activity.PlanId != nil && !validPlanId(plnStrg, activity) {
-		http.Error(w, "Plan not found", http.StatusBadRequest)
+	status, message := writablePlan(r.Context(), plnStrg, activity.UserId, activity.PlanId)
+	if status != http.StatusOK {
+		http.Error(w, message, status)
 		return
 	}
 
-	created, err := strg.Create(activity)
+	created, err := strg.Create(r.Context(), activity)
+	if err != nil {
+		writeStorageError(w, err)
+		return
+	}
 	jsonData, err := json.Marshal(created.Id.String())
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
@@ -89,25 +124,17 @@ func handleCreateActivity(w http.ResponseWriter, r *http.Request, strg storage.A
 	w.Write(jsonData)
 }
 
-func validPlanId(plnStrg storage.PlanStorage, activity storage.Activity) bool {
-	plan, err := plnStrg.Read(activity.UserId, *activity.PlanId)
-	if plan == nil || plan.UserId != activity.UserId || err != nil {
-		return false
-	}
-	return true
-}
-
 func handleReadActivity(w http.ResponseWriter, r *http.Request, strg storage.ActivityStorage, uuid uuid.UUID) {
 	userId := w.Header().Get(middlewares.VALIDATED_HEADER)
 
-	storedActivity, err := strg.Read(userId, uuid)
+	storedActivity, err := strg.Read(r.Context(), userId, uuid)
 
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeStorageError(w, err)
 		return
 	}
 
-	if storedActivity == nil || storedActivity.UserId != userId {
+	if storedActivity == nil || storedActivity.UserId != userId || storedActivity.DeletedAt != nil {
 		http.Error(w, "Not Found", http.StatusNotFound)
 		return
 	}
@@ -118,43 +145,54 @@ func handleReadActivity(w http.ResponseWriter, r *http.Request, strg storage.Act
 	}
 
 	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("ETag", etag(storedActivity.Version))
 	w.Write(jsonData)
 }
 
 func handleUserQueryActivity(w http.ResponseWriter, r *http.Request, strg storage.ActivityStorage) {
 	userId := w.Header().Get(middlewares.VALIDATED_HEADER)
 
-	rawPlanId := r.URL.Query().Get("planId")
-	var planid *uuid.UUID
-	parsedPlanId, err := uuid.Parse(rawPlanId)
-	if rawPlanId != "" && err != nil {
-		http.Error(w, "Bad Plan Id", http.StatusBadRequest)
+	query, err := parseActivityQuery(userId, r.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
-	if rawPlanId != "" {
-		planid = &parsedPlanId
-	}
 
-	rawStartTime := r.URL.Query().Get("timeStart")
-	rawEndTime := r.URL.Query().Get("timeEnd")
+	rawLimit := r.URL.Query().Get("limit")
+	limit := 0
+	if rawLimit != "" {
+		limit, err = strconv.Atoi(rawLimit)
+		if err != nil || limit < 1 || limit > MAX_ACTIVITY_PAGE_SIZE {
+			http.Error(w, fmt.Sprintf("Limit must be between 1 and %d", MAX_ACTIVITY_PAGE_SIZE), http.StatusBadRequest)
+			return
+		}
+		// One extra activity tells us whether another page follows
+		query.Limit = limit + 1
+	}
 
-	var timeRange *storage.DateRange
-	startTime, startErr := time.Parse(time.RFC3339, rawStartTime)
-	endTime, endErr := time.Parse(time.RFC3339, rawEndTime)
-	if rawStartTime != "" && rawEndTime != "" && startErr == nil && endErr == nil {
-		timeRange = &storage.DateRange{
-			Start: startTime,
-			End:   endTime,
+	rawCursor := r.URL.Query().Get("cursor")
+	if rawCursor != "" {
+		cursor, err := storage.ParseActivityCursor(rawCursor)
+		if err != nil {
+			http.Error(w, "Bad Cursor", http.StatusBadRequest)
+			return
 		}
+		query.Cursor = &cursor
 	}
 
-	queried, err := strg.Query(storage.ActivityStorageQuery{UserId: userId, PlanId: planid, DateRange: timeRange})
+	queried, err := strg.Query(r.Context(), query)
 
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeStorageError(w, err)
 		return
 	}
 
+	if limit > 0 && len(*queried) > limit {
+		page := (*queried)[:limit]
+		queried = &page
+		w.Header().Set(NEXT_CURSOR_HEADER, storage.CursorAfter(page[limit-1]).Encode())
+	}
+
 	jsonData, err := json.Marshal(queried)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
@@ -166,6 +204,170 @@ func handleUserQueryActivity(w http.ResponseWriter, r *http.Request, strg storag
 
 }
 
+func handleSearchActivity(w http.ResponseWriter, r *http.Request, strg storage.ActivityStorage) {
+	userId := w.Header().Get(middlewares.VALIDATED_HEADER)
+
+	text := strings.TrimSpace(r.URL.Query().Get("q"))
+	if text == "" {
+		http.Error(w, "Search text is required", http.StatusBadRequest)
+		return
+	}
+
+	limit := DEFAULT_SEARCH_LIMIT
+	rawLimit := r.URL.Query().Get("limit")
+	if rawLimit != "" {
+		var err error
+		limit, err = strconv.Atoi(rawLimit)
+		if err != nil || limit < 1 || limit > MAX_SEARCH_LIMIT {
+			http.Error(w, fmt.Sprintf("Limit must be between 1 and %d", MAX_SEARCH_LIMIT), http.StatusBadRequest)
+			return
+		}
+	}
+
+	found, err := strg.Search(r.Context(), storage.ActivitySearchQuery{UserId: userId, Text: text, Limit: limit})
+	if err != nil {
+		writeStorageError(w, err)
+		return
+	}
+
+	jsonData, err := json.Marshal(found)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(jsonData)
+}
+
+func parseActivityQuery(userId string, params url.Values) (storage.ActivityStorageQuery, error) {
+	query := storage.ActivityStorageQuery{UserId: userId}
+
+	rawPlanIds := params["planId"]
+	for _, rawPlanId := range rawPlanIds {
+		planId, err := uuid.Parse(rawPlanId)
+		if err != nil {
+			return query, errors.New("Bad Plan Id")
+		}
+		query.PlanIds = append(query.PlanIds, planId)
+	}
+	if len(query.PlanIds) == 1 {
+		query.PlanId = &query.PlanIds[0]
+		query.PlanIds = nil
+	}
+
+	if rawNoPlan := params.Get("noPlan"); rawNoPlan != "" {
+		noPlan, err := strconv.ParseBool(rawNoPlan)
+		if err != nil {
+			return query, errors.New("Bad noPlan, expected true or false")
+		}
+		query.NoPlan = noPlan
+	}
+	if query.NoPlan && len(rawPlanIds) > 0 {
+		return query, errors.New("noPlan cannot be combined with planId")
+	}
+
+	if rawActivePlansOnly := params.Get("activePlansOnly"); rawActivePlansOnly != "" {
+		activePlansOnly, err := strconv.ParseBool(rawActivePlansOnly)
+		if err != nil {
+			return query, errors.New("Bad activePlansOnly, expected true or false")
+		}
+		query.ActivePlansOnly = activePlansOnly
+	}
+	if query.NoPlan && query.ActivePlansOnly {
+		return query, errors.New("noPlan cannot be combined with activePlansOnly")
+	}
+
+	if rawRecurringActivityId := params.Get("recurringActivityId"); rawRecurringActivityId != "" {
+		recurringActivityId, err := uuid.Parse(rawRecurringActivityId)
+		if err != nil {
+			return query, errors.New("Bad Recurring Activity Id")
+		}
+		query.RecurringActivityId = &recurringActivityId
+	}
+
+	if rawCompleted := params.Get("completed"); rawCompleted != "" {
+		completed, err := strconv.ParseBool(rawCompleted)
+		if err != nil {
+			return query, errors.New("Bad completed, expected true or false")
+		}
+		query.Completed = &completed
+	}
+
+	rawStartTime := params.Get("timeStart")
+	rawEndTime := params.Get("timeEnd")
+	if rawStartTime != "" || rawEndTime != "" {
+		if rawStartTime == "" || rawEndTime == "" {
+			return query, errors.New("timeStart and timeEnd must be given together")
+		}
+		startTime, err := time.Parse(time.RFC3339, rawStartTime)
+		if err != nil {
+			return query, errors.New("Bad timeStart, expected an RFC3339 time")
+		}
+		endTime, err := time.Parse(time.RFC3339, rawEndTime)
+		if err != nil {
+			return query, errors.New("Bad timeEnd, expected an RFC3339 time")
+		}
+		if !endTime.After(startTime) {
+			return query, errors.New("timeEnd must be after timeStart")
+		}
+		query.DateRange = &storage.DateRange{
+			Start: startTime,
+			End:   endTime,
+		}
+	}
+
+	for _, rawMetric := range params["metric"] {
+		filter, err := parseMetricFilter(rawMetric)
+		if err != nil {
+			return query, err
+		}
+		query.Metrics = append(query.Metrics, filter)
+	}
+
+	switch order := storage.SortOrder(params.Get("order")); order {
+	case "":
+	case storage.Ascending, storage.Descending:
+		query.Order = order
+	default:
+		return query, errors.New("Bad order, expected asc or desc")
+	}
+
+	return query, nil
+}
+
+// parseMetricFilter reads unit:min:max, where either bound may be empty. The
+// unit itself may contain colons.
+func parseMetricFilter(raw string) (storage.MetricFilter, error) {
+	filter := storage.MetricFilter{}
+	parts := strings.Split(raw, ":")
+	if len(parts) < 3 {
+		return filter, errors.New("Bad metric, expected unit:min:max")
+	}
+	filter.Unit = strings.Join(parts[:len(parts)-2], ":")
+	if filter.Unit == "" {
+		return filter, errors.New("Bad metric, the unit is required")
+	}
+	if rawMin := parts[len(parts)-2]; rawMin != "" {
+		min, err := strconv.Atoi(rawMin)
+		if err != nil {
+			return filter, errors.New("Bad metric, bounds must be whole numbers")
+		}
+		filter.Min = &min
+	}
+	if rawMax := parts[len(parts)-1]; rawMax != "" {
+		max, err := strconv.Atoi(rawMax)
+		if err != nil {
+			return filter, errors.New("Bad metric, bounds must be whole numbers")
+		}
+		filter.Max = &max
+	}
+	if filter.Min != nil && filter.Max != nil && *filter.Min > *filter.Max {
+		return filter, errors.New("Bad metric, min must not be above max")
+	}
+	return filter, nil
+}
+
 func handleUpdateActivity(w http.ResponseWriter, r *http.Request, strg storage.ActivityStorage, plnStrg storage.PlanStorage, uuid uuid.UUID) {
 
 	userId := w.Header().Get(middlewares.VALIDATED_HEADER)
@@ -176,55 +378,83 @@ func handleUpdateActivity(w http.ResponseWriter, r *http.Request, strg storage.A
 		return
 	}
 
-	storedActivity, err := strg.Read(userId, uuid)
+	storedActivity, err := strg.Read(r.Context(), userId, uuid)
 
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeStorageError(w, err)
 		return
 	}
 
-	if storedActivity == nil || storedActivity.UserId != userId {
+	if storedActivity == nil || storedActivity.UserId != userId || storedActivity.DeletedAt != nil {
 		http.Error(w, "Not Found", http.StatusNotFound)
 		return
 	}
 
+	if !ifMatch(r, storedActivity.Version) {
+		http.Error(w, PRECONDITION_FAILED_MESSAGE, http.StatusPreconditionFailed)
+		return
+	}
+
+	status, message := writablePlan(r.Context(), plnStrg, userId, storedActivity.PlanId)
+	if status == http.StatusConflict {
+		http.Error(w, message, status)
+		return
+	}
+
 	activity.Id = uuid
 	activity.UserId = userId
+	activity.DeletedAt = nil
+	activity.Version = storedActivity.Version
 
-	if activity.PlanId != nil && !validPlanId(plnStrg, activity) {
-		http.Error(w, "Plan not found", http.StatusBadRequest)
+	status, message = writablePlan(r.Context(), plnStrg, activity.UserId, activity.PlanId)
+	if status != http.StatusOK {
+		http.Error(w, message, status)
 		return
 	}
 
-	updateErr := strg.Update(activity)
+	updateErr := strg.Update(r.Context(), activity)
 	if updateErr != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeStorageError(w, updateErr)
 		return
 	}
 	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("ETag", etag(activity.Version+1))
 	w.Write([]byte(`{ "status": "ok" }`))
 
 }
 
-func handleDeleteActivity(w http.ResponseWriter, r *http.Request, strg storage.ActivityStorage, uuid uuid.UUID) {
+func handleDeleteActivity(w http.ResponseWriter, r *http.Request, strg storage.ActivityStorage, plnStrg storage.PlanStorage, uuid uuid.UUID) {
 
 	userId := w.Header().Get(middlewares.VALIDATED_HEADER)
 
-	storedActivity, err := strg.Read(userId, uuid)
+	storedActivity, err := strg.Read(r.Context(), userId, uuid)
 
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeStorageError(w, err)
 		return
 	}
 
-	if storedActivity == nil || storedActivity.UserId != userId {
+	if storedActivity == nil || storedActivity.UserId != userId || storedActivity.DeletedAt != nil {
 		http.Error(w, "Not Found", http.StatusNotFound)
 		return
 	}
 
-	deleteErr := strg.Delete(userId, uuid)
+	if !ifMatch(r, storedActivity.Version) {
+		http.Error(w, PRECONDITION_FAILED_MESSAGE, http.StatusPreconditionFailed)
+		return
+	}
+
+	status, message := writablePlan(r.Context(), plnStrg, userId, storedActivity.PlanId)
+	if status == http.StatusConflict {
+		http.Error(w, message, status)
+		return
+	}
+
+	deletedAt := trashTime()
+	storedActivity.DeletedAt = &deletedAt
+	deleteErr := strg.Update(r.Context(), *storedActivity)
 	if deleteErr != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeStorageError(w, deleteErr)
 		return
 	}
 	w.Header().Set("Content-Type", "application/json")
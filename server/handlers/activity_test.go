@@ -1,6 +1,7 @@
 package handlers
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"net/http"
@@ -9,6 +10,7 @@ import (
 	"planner/storage"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/google/uuid"
 	"github.com/stretchr/testify/assert"
@@ -21,7 +23,7 @@ func TestHappyPathCreateActivityHandler(t *testing.T) {
 	returnedActivity := storage.Activity{
 		Id: uuid.New(),
 	}
-	mockStorage.EXPECT().Create(mock.Anything).Return(returnedActivity, nil).Once()
+	mockStorage.EXPECT().Create(mock.Anything, mock.Anything).Return(returnedActivity, nil).Once()
 
 	testUserId := "some-valid-expected-userid"
 
@@ -53,9 +55,9 @@ func TestHappyPathUpdateActivityHandler(t *testing.T) {
 		Id:     uuid.New(),
 		UserId: testUserId,
 	}
-	mockStorage.EXPECT().Read(testUserId, returnedActivity.Id).Return(&returnedActivity, nil).Once()
+	mockStorage.EXPECT().Read(mock.Anything, testUserId, returnedActivity.Id).Return(&returnedActivity, nil).Once()
 
-	mockStorage.EXPECT().Update(mock.Anything).Return(nil).Once()
+	mockStorage.EXPECT().Update(mock.Anything, mock.Anything).Return(nil).Once()
 
 	updateBody := `{
 		"summary": "some activity name update"
@@ -68,7 +70,7 @@ func TestHappyPathUpdateActivityHandler(t *testing.T) {
 	rr := httptest.NewRecorder()
 	rr.Header().Set(middlewares.VALIDATED_HEADER, testUserId)
 
-	handler := http.Handler(registerActivityId(mockStorage, mockPlanStorage))
+	handler := http.Handler(registerActivityId(mockStorage, mockPlanStorage, storage.NewMockAuditStorage(t)))
 	handler.ServeHTTP(rr, req)
 
 	assert.Equal(t, http.StatusOK, rr.Code)
@@ -84,9 +86,11 @@ func TestHappyPathDeleteActivityHandler(t *testing.T) {
 		Id:     uuid.New(),
 		UserId: testUserId,
 	}
-	mockStorage.EXPECT().Read(testUserId, returnedActivity.Id).Return(&returnedActivity, nil).Once()
+	mockStorage.EXPECT().Read(mock.Anything, testUserId, returnedActivity.Id).Return(&returnedActivity, nil).Once()
 
-	mockStorage.EXPECT().Delete(testUserId, returnedActivity.Id).Return(nil).Once()
+	mockStorage.EXPECT().Update(mock.Anything, mock.MatchedBy(func(activity storage.Activity) bool {
+		return activity.Id == returnedActivity.Id && activity.DeletedAt != nil
+	})).Return(nil).Once()
 
 	// We have to use "real" query params here
 	req, err := http.NewRequest("DELETE", fmt.Sprintf("/api/activities/%s", returnedActivity.Id), nil)
@@ -96,7 +100,7 @@ func TestHappyPathDeleteActivityHandler(t *testing.T) {
 	rr := httptest.NewRecorder()
 	rr.Header().Set(middlewares.VALIDATED_HEADER, testUserId)
 
-	handler := http.Handler(registerActivityId(mockStorage, mockPlanStorage))
+	handler := http.Handler(registerActivityId(mockStorage, mockPlanStorage, storage.NewMockAuditStorage(t)))
 	handler.ServeHTTP(rr, req)
 
 	assert.Equal(t, http.StatusOK, rr.Code)
@@ -134,7 +138,7 @@ func TestPlanDoesntExistReturns400CreateActivityHandler(t *testing.T) {
 	testUserId := "some-valid-expected-userid"
 	planId := uuid.New()
 
-	mockPlanStorage.EXPECT().Read(testUserId, planId).Return(nil, nil).Once()
+	mockPlanStorage.EXPECT().Read(mock.Anything, testUserId, planId).Return(nil, nil).Once()
 
 	createBody := fmt.Sprintf(`{
 		"summary": "some summary",
@@ -163,7 +167,7 @@ func TestHappyPathReadActivityHandler(t *testing.T) {
 		Id:     uuid.New(),
 		UserId: testUserId,
 	}
-	mockStorage.EXPECT().Read(testUserId, returnedActivity.Id).Return(&returnedActivity, nil).Once()
+	mockStorage.EXPECT().Read(mock.Anything, testUserId, returnedActivity.Id).Return(&returnedActivity, nil).Once()
 
 	// We have to use "real" query params here
 	req, err := http.NewRequest("GET", fmt.Sprintf("/api/activities/%s", returnedActivity.Id), nil)
@@ -173,7 +177,7 @@ func TestHappyPathReadActivityHandler(t *testing.T) {
 	rr := httptest.NewRecorder()
 	rr.Header().Set(middlewares.VALIDATED_HEADER, testUserId)
 
-	handler := http.Handler(registerActivityId(mockStorage, mockPlanStorage))
+	handler := http.Handler(registerActivityId(mockStorage, mockPlanStorage, storage.NewMockAuditStorage(t)))
 	handler.ServeHTTP(rr, req)
 
 	expectedBody, err := json.Marshal(returnedActivity)
@@ -181,3 +185,224 @@ func TestHappyPathReadActivityHandler(t *testing.T) {
 	assert.Equal(t, http.StatusOK, rr.Code)
 	assert.Equal(t, string(expectedBody), rr.Body.String())
 }
+
+func TestMemoryStorageCreateThenQueryActivityHandler(t *testing.T) {
+	strg := storage.NewMemoryStorage()
+	testUserId := "some-valid-expected-userid"
+
+	createBody := `{
+		"summary": "some activity name",
+		"dateTime": "2023-05-01T10:00:00Z"
+	}`
+
+	req, err := http.NewRequest("POST", "/api/activities", strings.NewReader(createBody))
+	if err != nil {
+		t.Fatal(err)
+	}
+	rr := httptest.NewRecorder()
+	rr.Header().Set(middlewares.VALIDATED_HEADER, testUserId)
+
+	handler := http.Handler(registerActivityRoot(strg.Activity, strg.Plan))
+	handler.ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusOK, rr.Code)
+
+	var createdId string
+	err = json.Unmarshal(rr.Body.Bytes(), &createdId)
+	assert.Nil(t, err)
+
+	req, err = http.NewRequest("GET", "/api/activities", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rr = httptest.NewRecorder()
+	rr.Header().Set(middlewares.VALIDATED_HEADER, testUserId)
+	handler.ServeHTTP(rr, req)
+
+	var queried []storage.Activity
+	err = json.Unmarshal(rr.Body.Bytes(), &queried)
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, 1, len(queried))
+	assert.Equal(t, createdId, queried[0].Id.String())
+	assert.Equal(t, "some activity name", queried[0].Summary)
+}
+
+func TestPaginatedQueryActivityHandler(t *testing.T) {
+	strg := storage.NewMemoryStorage()
+	testUserId := "some-valid-expected-userid"
+	for i := 0; i < 3; i++ {
+		strg.Activity.Create(context.Background(), storage.Activity{
+			UserId:   testUserId,
+			Summary:  fmt.Sprintf("activity %d", i),
+			DateTime: time.Date(2023, 5, i+1, 10, 0, 0, 0, time.UTC),
+		})
+	}
+	handler := http.Handler(registerActivityRoot(strg.Activity, strg.Plan))
+
+	req, err := http.NewRequest("GET", "/api/activities?limit=2", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rr := httptest.NewRecorder()
+	rr.Header().Set(middlewares.VALIDATED_HEADER, testUserId)
+	handler.ServeHTTP(rr, req)
+
+	var firstPage []storage.Activity
+	err = json.Unmarshal(rr.Body.Bytes(), &firstPage)
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, 2, len(firstPage))
+	assert.Equal(t, "activity 0", firstPage[0].Summary)
+	nextCursor := rr.Header().Get(NEXT_CURSOR_HEADER)
+	assert.NotEmpty(t, nextCursor)
+
+	req, err = http.NewRequest("GET", "/api/activities?limit=2&cursor="+nextCursor, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rr = httptest.NewRecorder()
+	rr.Header().Set(middlewares.VALIDATED_HEADER, testUserId)
+	handler.ServeHTTP(rr, req)
+
+	var secondPage []storage.Activity
+	err = json.Unmarshal(rr.Body.Bytes(), &secondPage)
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, 1, len(secondPage))
+	assert.Equal(t, "activity 2", secondPage[0].Summary)
+	assert.Empty(t, rr.Header().Get(NEXT_CURSOR_HEADER))
+}
+
+func TestBadPaginationReturns400QueryActivityHandler(t *testing.T) {
+	mockStorage := storage.NewMockActivityStorage(t)
+	mockPlanStorage := storage.NewMockPlanStorage(t)
+	for _, params := range []string{"limit=0", "limit=abc", "limit=1001", "cursor=not-a-cursor"} {
+		req, err := http.NewRequest("GET", "/api/activities?"+params, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		rr := httptest.NewRecorder()
+		rr.Header().Set(middlewares.VALIDATED_HEADER, "some-valid-expected-userid")
+
+		handler := http.Handler(registerActivityRoot(mockStorage, mockPlanStorage))
+		handler.ServeHTTP(rr, req)
+		assert.Equal(t, http.StatusBadRequest, rr.Code, params)
+	}
+}
+
+func TestFiltersParsedQueryActivityHandler(t *testing.T) {
+	mockStorage := storage.NewMockActivityStorage(t)
+	mockPlanStorage := storage.NewMockPlanStorage(t)
+	testUserId := "some-valid-expected-userid"
+	firstPlanId := uuid.New()
+	secondPlanId := uuid.New()
+	recurringActivityId := uuid.New()
+	completed := false
+	minKm := 10
+	maxMinutes := 60
+
+	mockStorage.EXPECT().Query(mock.Anything, storage.ActivityStorageQuery{
+		UserId:              testUserId,
+		PlanIds:             []uuid.UUID{firstPlanId, secondPlanId},
+		ActivePlansOnly:     true,
+		RecurringActivityId: &recurringActivityId,
+		Completed:           &completed,
+		DateRange: &storage.DateRange{
+			Start: time.Date(2023, 5, 1, 0, 0, 0, 0, time.UTC),
+			End:   time.Date(2023, 5, 2, 0, 0, 0, 0, time.UTC),
+		},
+		Order: storage.Descending,
+		Metrics: []storage.MetricFilter{
+			{Unit: "km", Min: &minKm},
+			{Unit: "min(s)", Max: &maxMinutes},
+		},
+	}).Return(&[]storage.Activity{}, nil).Once()
+
+	params := fmt.Sprintf("planId=%s&planId=%s&activePlansOnly=true&recurringActivityId=%s&completed=false&timeStart=2023-05-01T00:00:00.000Z&timeEnd=2023-05-02T00:00:00Z&order=desc&metric=km:10:&metric=min(s)::60",
+		firstPlanId, secondPlanId, recurringActivityId)
+	req, err := http.NewRequest("GET", "/api/activities?"+params, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rr := httptest.NewRecorder()
+	rr.Header().Set(middlewares.VALIDATED_HEADER, testUserId)
+
+	handler := http.Handler(registerActivityRoot(mockStorage, mockPlanStorage))
+	handler.ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusOK, rr.Code)
+}
+
+func TestBadFiltersReturn400QueryActivityHandler(t *testing.T) {
+	mockStorage := storage.NewMockActivityStorage(t)
+	mockPlanStorage := storage.NewMockPlanStorage(t)
+	for _, params := range []string{
+		"planId=not-a-uuid",
+		"recurringActivityId=not-a-uuid",
+		"completed=maybe",
+		"noPlan=true&planId=" + uuid.New().String(),
+		"noPlan=true&activePlansOnly=true",
+		"timeStart=2023-05-01T00:00:00Z",
+		"timeEnd=2023-05-01T00:00:00Z",
+		"timeStart=yesterday&timeEnd=2023-05-01T00:00:00Z",
+		"timeStart=2023-05-02T00:00:00Z&timeEnd=2023-05-01T00:00:00Z",
+		"order=sideways",
+		"metric=km",
+		"metric=:1:2",
+		"metric=km:ten:",
+		"metric=km:5:1",
+	} {
+		req, err := http.NewRequest("GET", "/api/activities?"+params, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		rr := httptest.NewRecorder()
+		rr.Header().Set(middlewares.VALIDATED_HEADER, "some-valid-expected-userid")
+
+		handler := http.Handler(registerActivityRoot(mockStorage, mockPlanStorage))
+		handler.ServeHTTP(rr, req)
+		assert.Equal(t, http.StatusBadRequest, rr.Code, params)
+	}
+}
+
+func TestHappyPathSearchActivityHandler(t *testing.T) {
+	mockStorage := storage.NewMockActivityStorage(t)
+	testUserId := "some-valid-expected-userid"
+	found := []storage.Activity{{Id: uuid.New(), UserId: testUserId, Summary: "Tempo run"}}
+	mockStorage.EXPECT().Search(mock.Anything, storage.ActivitySearchQuery{
+		UserId: testUserId,
+		Text:   "tempo knee",
+		Limit:  5,
+	}).Return(&found, nil).Once()
+
+	req, err := http.NewRequest("GET", "/api/activities/search?q=tempo+knee&limit=5", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rr := httptest.NewRecorder()
+	rr.Header().Set(middlewares.VALIDATED_HEADER, testUserId)
+
+	handler := http.Handler(registerActivitySearch(mockStorage))
+	handler.ServeHTTP(rr, req)
+
+	var result []storage.Activity
+	err = json.Unmarshal(rr.Body.Bytes(), &result)
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, found, result)
+}
+
+func TestBadSearchReturns400SearchActivityHandler(t *testing.T) {
+	mockStorage := storage.NewMockActivityStorage(t)
+	for _, params := range []string{"", "q=++", "q=tempo&limit=0", "q=tempo&limit=101"} {
+		req, err := http.NewRequest("GET", "/api/activities/search?"+params, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		rr := httptest.NewRecorder()
+		rr.Header().Set(middlewares.VALIDATED_HEADER, "some-valid-expected-userid")
+
+		handler := http.Handler(registerActivitySearch(mockStorage))
+		handler.ServeHTTP(rr, req)
+		assert.Equal(t, http.StatusBadRequest, rr.Code, params)
+	}
+}
@@ -0,0 +1,76 @@
+package handlers
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"planner/storage"
+	"strings"
+	"time"
+)
+
+type BackupResult struct {
+	File      string    `json:"file"`
+	Size      int64     `json:"size"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// AddBackupHandlers serves POST /api/admin/backup, which writes a backup of
+// sqlite storage to the schedule's directory. It is only registered when an
+// admin token is configured, and requests must send it as a bearer token.
+func AddBackupHandlers(mux *http.ServeMux, strg storage.Storage, schedule storage.BackupSchedule, adminToken string) {
+	if adminToken == "" {
+		return
+	}
+	mux.Handle("/api/admin/backup", registerBackupRoot(strg, schedule, adminToken))
+}
+
+func registerBackupRoot(strg storage.Storage, schedule storage.BackupSchedule, adminToken string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		token, found := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !found || subtle.ConstantTimeCompare([]byte(token), []byte(adminToken)) != 1 {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		if r.Method == http.MethodPost {
+			handleBackup(w, r, strg, schedule)
+		} else {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			fmt.Fprintf(w, "Invalid method: %s", r.Method)
+		}
+	}
+}
+
+func handleBackup(w http.ResponseWriter, r *http.Request, strg storage.Storage, schedule storage.BackupSchedule) {
+	path, err := schedule.BackupNow(r.Context(), strg)
+	if errors.Is(err, storage.ErrBackupUnsupported) {
+		http.Error(w, err.Error(), http.StatusNotImplemented)
+		return
+	}
+	if err != nil {
+		writeStorageError(w, err)
+		return
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	jsonData, err := json.Marshal(BackupResult{
+		File:      filepath.Base(path),
+		Size:      info.Size(),
+		CreatedAt: info.ModTime().UTC(),
+	})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(jsonData)
+}
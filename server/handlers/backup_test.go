@@ -0,0 +1,52 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"planner/storage"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func serveBackup(handler http.Handler, method string, token string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, "/api/admin/backup", nil)
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	return rr
+}
+
+func TestBackupHandler(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("PLANNER_SQLITE_PATH", filepath.Join(dir, "planner.sqlite"))
+	strg, err := storage.GetStorage(storage.Sqlite)
+	assert.Nil(t, err)
+	defer strg.Close()
+	schedule := storage.BackupSchedule{Dir: filepath.Join(dir, "backups")}
+	handler := registerBackupRoot(strg, schedule, "admin-token")
+
+	rr := serveBackup(handler, "POST", "")
+	assert.Equal(t, http.StatusUnauthorized, rr.Code)
+	rr = serveBackup(handler, "POST", "wrong-token")
+	assert.Equal(t, http.StatusUnauthorized, rr.Code)
+	rr = serveBackup(handler, "GET", "admin-token")
+	assert.Equal(t, http.StatusMethodNotAllowed, rr.Code)
+
+	rr = serveBackup(handler, "POST", "admin-token")
+	assert.Equal(t, http.StatusOK, rr.Code)
+	var result BackupResult
+	assert.Nil(t, json.Unmarshal(rr.Body.Bytes(), &result))
+	info, err := os.Stat(filepath.Join(schedule.Dir, result.File))
+	assert.Nil(t, err)
+	assert.Equal(t, info.Size(), result.Size)
+
+	handler = registerBackupRoot(storage.NewMemoryStorage(), schedule, "admin-token")
+	rr = serveBackup(handler, "POST", "admin-token")
+	assert.Equal(t, http.StatusNotImplemented, rr.Code)
+}
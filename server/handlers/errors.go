@@ -0,0 +1,37 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"planner/storage"
+)
+
+// storageErrorResponse maps a failed storage call to the status of its error
+// kind and a message safe to show. The error itself may hold SQL or CQL
+// details, so unexpected ones are logged instead. A version conflict answers
+// 412 as if the If-Match check had failed.
+func storageErrorResponse(err error) (int, string) {
+	if errors.Is(err, storage.ErrVersionConflict) {
+		return http.StatusPreconditionFailed, PRECONDITION_FAILED_MESSAGE
+	}
+	if errors.Is(err, storage.ErrNotFound) {
+		return http.StatusNotFound, "Not Found"
+	}
+	if errors.Is(err, storage.ErrConflict) {
+		return http.StatusConflict, "It conflicts with a concurrent change, reload and try again"
+	}
+	if errors.Is(err, storage.ErrInvalid) {
+		return http.StatusBadRequest, "The data was rejected as invalid"
+	}
+	fmt.Printf("storage error: %s\n", err)
+	if errors.Is(err, storage.ErrUnavailable) {
+		return http.StatusServiceUnavailable, "Storage is unavailable, try again later"
+	}
+	return http.StatusInternalServerError, "Internal Server Error"
+}
+
+func writeStorageError(w http.ResponseWriter, err error) {
+	status, message := storageErrorResponse(err)
+	http.Error(w, message, status)
+}
@@ -0,0 +1,72 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"planner/middlewares"
+	"planner/storage"
+	"strings"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestStorageErrorResponse(t *testing.T) {
+	rawErr := errors.New(`near "SELEC": syntax error`)
+	cases := []struct {
+		err    error
+		status int
+	}{
+		{storage.ErrVersionConflict, http.StatusPreconditionFailed},
+		{storage.ErrNotFound, http.StatusNotFound},
+		{fmt.Errorf("%w: %w", storage.ErrConflict, rawErr), http.StatusConflict},
+		{fmt.Errorf("%w: %w", storage.ErrInvalid, rawErr), http.StatusBadRequest},
+		{storage.ErrInvalidCursor, http.StatusBadRequest},
+		{fmt.Errorf("%w: %w", storage.ErrUnavailable, rawErr), http.StatusServiceUnavailable},
+		{rawErr, http.StatusInternalServerError},
+	}
+	for _, c := range cases {
+		status, message := storageErrorResponse(c.err)
+		assert.Equal(t, c.status, status, c.err.Error())
+		assert.NotContains(t, message, "SELEC")
+	}
+}
+
+func TestCreateActivityHandlerReportsStorageErrors(t *testing.T) {
+	mockStorage := storage.NewMockActivityStorage(t)
+	mockPlanStorage := storage.NewMockPlanStorage(t)
+	testUserId := "some-valid-expected-userid"
+
+	mockStorage.EXPECT().Create(mock.Anything, mock.Anything).Return(storage.Activity{}, fmt.Errorf("%w: database is locked", storage.ErrUnavailable)).Once()
+
+	req, err := http.NewRequest("POST", "/my-endpoint", strings.NewReader(`{"summary": "some summary"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	rr := httptest.NewRecorder()
+	rr.Header().Set(middlewares.VALIDATED_HEADER, testUserId)
+
+	handler := http.Handler(registerActivityRoot(mockStorage, mockPlanStorage))
+	handler.ServeHTTP(rr, req)
+
+	assert.Equal(t, http.StatusServiceUnavailable, rr.Code)
+	assert.NotContains(t, rr.Body.String(), "locked")
+}
+
+func TestReadPlanHandlerHidesStorageErrors(t *testing.T) {
+	mockStorage := storage.NewMockPlanStorage(t)
+	testUserId := "some-valid-expected-userid"
+	planId := uuid.New()
+
+	mockStorage.EXPECT().Read(mock.Anything, testUserId, planId).Return(nil, errors.New("pq: relation \"plans\" does not exist")).Once()
+
+	handler := http.Handler(registerPlanId(mockStorage, storage.NewMockActivityStorage(t), storage.NewMockRecurringActivityStorage(t), storage.NewMockUnitOfWork(t), storage.NewMockAuditStorage(t)))
+	rr := serveAs(t, handler, testUserId, "GET", fmt.Sprintf("/api/plans/%s", planId))
+
+	assert.Equal(t, http.StatusInternalServerError, rr.Code)
+	assert.NotContains(t, rr.Body.String(), "relation")
+}
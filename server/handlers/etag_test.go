@@ -0,0 +1,154 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"planner/middlewares"
+	"planner/storage"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func serveIfMatch(t *testing.T, handler http.Handler, userId string, method string, path string, ifMatch string, body string) *httptest.ResponseRecorder {
+	req, err := http.NewRequest(method, path, strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ifMatch != "" {
+		req.Header.Set("If-Match", ifMatch)
+	}
+	rr := httptest.NewRecorder()
+	rr.Header().Set(middlewares.VALIDATED_HEADER, userId)
+	handler.ServeHTTP(rr, req)
+	return rr
+}
+
+func TestIfMatch(t *testing.T) {
+	cases := []struct {
+		header   string
+		expected bool
+	}{
+		{"", true},
+		{`"3"`, true},
+		{"*", true},
+		{`"1", "3"`, true},
+		{`"2"`, false},
+		{`W/"3"`, false},
+		{"3", false},
+	}
+	for _, c := range cases {
+		req, _ := http.NewRequest("PUT", "/", nil)
+		if c.header != "" {
+			req.Header.Set("If-Match", c.header)
+		}
+		assert.Equal(t, c.expected, ifMatch(req, 3), c.header)
+	}
+}
+
+func TestMemoryStorageActivityETagHandler(t *testing.T) {
+	ctx := context.Background()
+	strg := storage.NewMemoryStorage()
+	testUserId := "some-valid-expected-userid"
+
+	activity, _ := strg.Activity.Create(ctx, storage.Activity{UserId: testUserId, Summary: "run", DateTime: time.Date(2023, 5, 1, 10, 0, 0, 0, time.UTC)})
+	handler := http.Handler(registerActivityId(strg.Activity, strg.Plan, strg.Audit))
+	path := fmt.Sprintf("/api/activities/%s", activity.Id)
+	body := `{"summary":"tempo run","stages":[],"dateTime":"2023-05-01T10:00:00Z"}`
+
+	rr := serveAs(t, handler, testUserId, "GET", path)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, `"1"`, rr.Header().Get("ETag"))
+
+	rr = serveIfMatch(t, handler, testUserId, "PUT", path, `"1"`, body)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, `"2"`, rr.Header().Get("ETag"))
+
+	// A second device still holding the first version loses
+	rr = serveIfMatch(t, handler, testUserId, "PUT", path, `"1"`, `{"summary":"easy run","stages":[]}`)
+	assert.Equal(t, http.StatusPreconditionFailed, rr.Code)
+	rr = serveIfMatch(t, handler, testUserId, "DELETE", path, `"1"`, "")
+	assert.Equal(t, http.StatusPreconditionFailed, rr.Code)
+	stored, _ := strg.Activity.Read(ctx, testUserId, activity.Id)
+	assert.Equal(t, "tempo run", stored.Summary)
+	assert.Equal(t, int64(2), stored.Version)
+	assert.Nil(t, stored.DeletedAt)
+
+	// Without If-Match the last write still wins
+	rr = serveIfMatch(t, handler, testUserId, "PUT", path, "", body)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	rr = serveIfMatch(t, handler, testUserId, "DELETE", path, `"3"`, "")
+	assert.Equal(t, http.StatusOK, rr.Code)
+}
+
+func TestMemoryStoragePlanETagHandler(t *testing.T) {
+	ctx := context.Background()
+	strg := storage.NewMemoryStorage()
+	testUserId := "some-valid-expected-userid"
+
+	plan, _ := strg.Plan.Create(ctx, storage.Plan{UserId: testUserId, Name: "plan"})
+	recurring, _ := strg.RecurringActivity.Create(ctx, storage.RecurringActivity{UserId: testUserId, RecurrEachDays: 7})
+	planHandler := http.Handler(registerPlanId(strg.Plan, strg.Activity, strg.RecurringActivity, strg.UnitOfWork, strg.Audit))
+	recurringHandler := http.Handler(registerRecurringActivityId(strg.RecurringActivity, strg.Plan))
+	planPath := fmt.Sprintf("/api/plans/%s", plan.Id)
+	recurringPath := fmt.Sprintf("/api/recurring_activities/%s", recurring.Id)
+
+	rr := serveAs(t, planHandler, testUserId, "GET", planPath)
+	assert.Equal(t, `"1"`, rr.Header().Get("ETag"))
+	rr = serveIfMatch(t, planHandler, testUserId, "PUT", planPath, `"1"`, `{"name":"renamed","active":true}`)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, `"2"`, rr.Header().Get("ETag"))
+	rr = serveIfMatch(t, planHandler, testUserId, "PUT", planPath, `"1"`, `{"name":"other"}`)
+	assert.Equal(t, http.StatusPreconditionFailed, rr.Code)
+	rr = serveIfMatch(t, planHandler, testUserId, "DELETE", planPath, `"1"`, "")
+	assert.Equal(t, http.StatusPreconditionFailed, rr.Code)
+	rr = serveIfMatch(t, planHandler, testUserId, "DELETE", planPath, `"2"`, "")
+	assert.Equal(t, http.StatusOK, rr.Code)
+
+	rr = serveAs(t, recurringHandler, testUserId, "GET", recurringPath)
+	assert.Equal(t, `"1"`, rr.Header().Get("ETag"))
+	rr = serveIfMatch(t, recurringHandler, testUserId, "PUT", recurringPath, `"2"`, `{"recurrEachDays":3,"stages":[]}`)
+	assert.Equal(t, http.StatusPreconditionFailed, rr.Code)
+	rr = serveIfMatch(t, recurringHandler, testUserId, "PUT", recurringPath, `"1"`, `{"recurrEachDays":3,"stages":[]}`)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, `"2"`, rr.Header().Get("ETag"))
+}
+
+func TestMemoryStoragePlanArchiveETagHandler(t *testing.T) {
+	ctx := context.Background()
+	strg := storage.NewMemoryStorage()
+	testUserId := "some-valid-expected-userid"
+
+	plan, _ := strg.Plan.Create(ctx, storage.Plan{UserId: testUserId, Name: "plan", Active: true})
+	planHandler := http.Handler(registerPlanId(strg.Plan, strg.Activity, strg.RecurringActivity, strg.UnitOfWork, strg.Audit))
+	archivePath := fmt.Sprintf("/api/plans/%s/archive", plan.Id)
+	unarchivePath := fmt.Sprintf("/api/plans/%s/unarchive", plan.Id)
+
+	rr := serveIfMatch(t, planHandler, testUserId, "POST", archivePath, `"2"`, "")
+	assert.Equal(t, http.StatusPreconditionFailed, rr.Code)
+	rr = serveIfMatch(t, planHandler, testUserId, "POST", archivePath, `"1"`, "")
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, `"2"`, rr.Header().Get("ETag"))
+
+	// Archiving an archived plan changes nothing
+	rr = serveIfMatch(t, planHandler, testUserId, "POST", archivePath, `"2"`, "")
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, `"2"`, rr.Header().Get("ETag"))
+	stored, _ := strg.Plan.Read(ctx, testUserId, plan.Id)
+	assert.Equal(t, int64(2), stored.Version)
+	assert.NotNil(t, stored.ArchivedAt)
+
+	rr = serveIfMatch(t, planHandler, testUserId, "POST", unarchivePath, `"1"`, "")
+	assert.Equal(t, http.StatusPreconditionFailed, rr.Code)
+	rr = serveIfMatch(t, planHandler, testUserId, "POST", unarchivePath, `"2"`, "")
+	assert.Equal(t, http.StatusOK, rr.Code)
+	rr = serveIfMatch(t, planHandler, testUserId, "POST", unarchivePath, "", "")
+	assert.Equal(t, http.StatusOK, rr.Code)
+	stored, _ = strg.Plan.Read(ctx, testUserId, plan.Id)
+	assert.Equal(t, int64(3), stored.Version)
+	assert.Nil(t, stored.ArchivedAt)
+}
@@ -0,0 +1,107 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"planner/middlewares"
+	"planner/recurrence"
+	"planner/storage"
+)
+
+const IMPORT_MAX_BYTES = 64 << 20
+
+func AddExportHandlers(mux *http.ServeMux, strg storage.Storage, useridMiddleware middlewares.Middleware) {
+	mux.Handle("/api/export", useridMiddleware(registerExportRoot(strg)))
+	mux.Handle("/api/import", useridMiddleware(registerImportRoot(strg)))
+}
+
+func registerExportRoot(strg storage.Storage) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet {
+			handleExport(w, r, strg)
+		} else {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			fmt.Fprintf(w, "Invalid method: %s", r.Method)
+		}
+	}
+}
+
+func registerImportRoot(strg storage.Storage) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPost {
+			handleImport(w, r, strg)
+		} else {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			fmt.Fprintf(w, "Invalid method: %s", r.Method)
+		}
+	}
+}
+
+func handleExport(w http.ResponseWriter, r *http.Request, strg storage.Storage) {
+	userId := w.Header().Get(middlewares.VALIDATED_HEADER)
+
+	archive, err := storage.LoadArchive(r.Context(), strg, userId)
+	if err != nil {
+		writeStorageError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="planner-export-%s.json"`, archive.ExportedAt.Format("2006-01-02")))
+	err = storage.StreamArchive(r.Context(), strg, userId, archive, w)
+	if err != nil {
+		// The status is already sent, abort so the client sees a broken
+		// download rather than a truncated archive that looks complete
+		panic(http.ErrAbortHandler)
+	}
+}
+
+func handleImport(w http.ResponseWriter, r *http.Request, strg storage.Storage) {
+	userId := w.Header().Get(middlewares.VALIDATED_HEADER)
+
+	mode := storage.ImportMode(r.URL.Query().Get("mode"))
+	if mode == "" {
+		mode = storage.ImportMerge
+	}
+	if mode != storage.ImportMerge && mode != storage.ImportReplace {
+		http.Error(w, "Bad mode, expected merge or replace", http.StatusBadRequest)
+		return
+	}
+
+	var archive storage.Archive
+	decoder := json.NewDecoder(http.MaxBytesReader(w, r.Body, IMPORT_MAX_BYTES))
+	decoder.DisallowUnknownFields()
+	err := decoder.Decode(&archive)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	err = storage.ValidateArchive(archive)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	for _, activity := range archive.RecurringActivities {
+		err = recurrence.Validate(activity)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("recurring activity %s: %s", activity.Id, err), http.StatusBadRequest)
+			return
+		}
+	}
+
+	summary, err := storage.ImportArchive(r.Context(), strg, userId, archive, mode)
+	if err != nil {
+		writeStorageError(w, err)
+		return
+	}
+
+	jsonData, err := json.Marshal(summary)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(jsonData)
+}
@@ -0,0 +1,142 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"planner/middlewares"
+	"planner/storage"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func postAs(t *testing.T, handler http.Handler, userId string, path string, body []byte) *httptest.ResponseRecorder {
+	req, err := http.NewRequest("POST", path, bytes.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	rr := httptest.NewRecorder()
+	rr.Header().Set(middlewares.VALIDATED_HEADER, userId)
+	handler.ServeHTTP(rr, req)
+	return rr
+}
+
+func TestMemoryStorageExportImportHandler(t *testing.T) {
+	ctx := context.Background()
+	strg := storage.NewMemoryStorage()
+	testUserId := "some-valid-expected-userid"
+	otherUserId := "some-other-userid"
+
+	plan, _ := strg.Plan.Create(ctx, storage.Plan{UserId: testUserId, Name: "plan", Active: true})
+	recurring, _ := strg.RecurringActivity.Create(ctx, storage.RecurringActivity{UserId: testUserId, PlanId: &plan.Id, RecurrEachDays: 7})
+	strg.Activity.Create(ctx, storage.Activity{UserId: testUserId, PlanId: &plan.Id, RecurringActivityId: &recurring.Id, Summary: "done", DateTime: time.Date(2023, 5, 1, 10, 0, 0, 0, time.UTC)})
+	deletedAt := time.Date(2023, 5, 2, 10, 0, 0, 0, time.UTC)
+	strg.Activity.Create(ctx, storage.Activity{UserId: testUserId, Summary: "trashed", DeletedAt: &deletedAt})
+	strg.Plan.Create(ctx, storage.Plan{UserId: otherUserId, Name: "existing"})
+
+	exportHandler := http.Handler(registerExportRoot(strg))
+	importHandler := http.Handler(registerImportRoot(strg))
+
+	rr := serveAs(t, exportHandler, testUserId, "GET", "/api/export")
+	assert.Equal(t, http.StatusOK, rr.Code)
+	exported := rr.Body.Bytes()
+	var archive storage.Archive
+	err := json.Unmarshal(exported, &archive)
+	assert.Nil(t, err)
+	assert.Equal(t, storage.ARCHIVE_VERSION, archive.Version)
+	assert.Equal(t, 1, len(archive.Plans))
+	assert.Equal(t, 1, len(archive.RecurringActivities))
+	assert.Equal(t, 2, len(archive.Activities))
+
+	rr = postAs(t, importHandler, otherUserId, "/api/import", exported)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	var summary storage.ImportSummary
+	err = json.Unmarshal(rr.Body.Bytes(), &summary)
+	assert.Nil(t, err)
+	assert.Equal(t, storage.ImportSummary{Plans: 1, Activities: 2, RecurringActivities: 1}, summary)
+
+	// Merging keeps the existing plan and links the copies under new ids
+	plans, _ := strg.Plan.Query(ctx, storage.PlanStorageQuery{UserId: otherUserId})
+	assert.Equal(t, 2, len(*plans))
+	activities, _ := strg.Activity.Query(ctx, storage.ActivityStorageQuery{UserId: otherUserId})
+	assert.Equal(t, 1, len(*activities))
+	imported := (*activities)[0]
+	assert.NotEqual(t, plan.Id, *imported.PlanId)
+	assert.NotEqual(t, recurring.Id, *imported.RecurringActivityId)
+	importedPlan, _ := strg.Plan.Read(ctx, otherUserId, *imported.PlanId)
+	assert.Equal(t, "plan", importedPlan.Name)
+	importedRecurring, _ := strg.RecurringActivity.Read(ctx, otherUserId, *imported.RecurringActivityId)
+	assert.Equal(t, importedPlan.Id, *importedRecurring.PlanId)
+	trashed, _ := strg.Activity.Query(ctx, storage.ActivityStorageQuery{UserId: otherUserId, Trashed: true})
+	assert.Equal(t, 1, len(*trashed))
+
+	rr = postAs(t, importHandler, otherUserId, "/api/import?mode=replace", exported)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	plans, _ = strg.Plan.Query(ctx, storage.PlanStorageQuery{UserId: otherUserId})
+	assert.Equal(t, 1, len(*plans))
+	assert.Equal(t, "plan", (*plans)[0].Name)
+	// The exporting user is left alone
+	plans, _ = strg.Plan.Query(ctx, storage.PlanStorageQuery{UserId: testUserId})
+	assert.Equal(t, plan.Id, (*plans)[0].Id)
+}
+
+func TestImportHandlerRejectsBadArchives(t *testing.T) {
+	strg := storage.NewMemoryStorage()
+	testUserId := "some-valid-expected-userid"
+	importHandler := http.Handler(registerImportRoot(strg))
+
+	rr := postAs(t, importHandler, testUserId, "/api/import?mode=overwrite", []byte(`{"version":1}`))
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+	rr = postAs(t, importHandler, testUserId, "/api/import", []byte(`{"version":2}`))
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+	rr = postAs(t, importHandler, testUserId, "/api/import", []byte(`{"version":1,"unknown":true}`))
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+	rr = postAs(t, importHandler, testUserId, "/api/import", []byte(`{"version":1,"activities":[{"id":"00000000-0000-0000-0000-000000000001","planId":"00000000-0000-0000-0000-000000000002"}]}`))
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+	rr = postAs(t, importHandler, testUserId, "/api/import", []byte(`{"version":1}`))
+	assert.Equal(t, http.StatusOK, rr.Code)
+}
+
+func TestMemoryStorageImportAgainKeepsVersionsHandler(t *testing.T) {
+	ctx := context.Background()
+	strg := storage.NewMemoryStorage()
+	testUserId := "some-valid-expected-userid"
+	otherUserId := "some-other-userid"
+
+	strg.Activity.Create(ctx, storage.Activity{UserId: testUserId, Summary: "run", DateTime: time.Date(2023, 5, 1, 10, 0, 0, 0, time.UTC)})
+	exportHandler := http.Handler(registerExportRoot(strg))
+	importHandler := http.Handler(registerImportRoot(strg))
+	activityHandler := http.Handler(registerActivityId(strg.Activity, strg.Plan, strg.Audit))
+	body := `{"summary":"tempo run","stages":[],"dateTime":"2023-05-01T10:00:00Z"}`
+
+	exported := serveAs(t, exportHandler, testUserId, "GET", "/api/export").Body.Bytes()
+	rr := postAs(t, importHandler, otherUserId, "/api/import", exported)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	activities, _ := strg.Activity.Query(ctx, storage.ActivityStorageQuery{UserId: otherUserId})
+	path := fmt.Sprintf("/api/activities/%s", (*activities)[0].Id)
+	rr = serveIfMatch(t, activityHandler, otherUserId, "PUT", path, `"1"`, body)
+	assert.Equal(t, http.StatusOK, rr.Code)
+
+	// Merging again keeps the edit
+	rr = postAs(t, importHandler, otherUserId, "/api/import", exported)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	rr = serveIfMatch(t, activityHandler, otherUserId, "PUT", path, `"1"`, `{"summary":"easy run","stages":[]}`)
+	assert.Equal(t, http.StatusPreconditionFailed, rr.Code)
+	stored, _ := strg.Activity.Read(ctx, otherUserId, (*activities)[0].Id)
+	assert.Equal(t, "tempo run", stored.Summary)
+	assert.Equal(t, int64(2), stored.Version)
+
+	// Replacing writes the archived item past the version it replaced
+	rr = postAs(t, importHandler, otherUserId, "/api/import?mode=replace", exported)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	rr = serveIfMatch(t, activityHandler, otherUserId, "PUT", path, `"2"`, `{"summary":"easy run","stages":[]}`)
+	assert.Equal(t, http.StatusPreconditionFailed, rr.Code)
+	stored, _ = strg.Activity.Read(ctx, otherUserId, (*activities)[0].Id)
+	assert.Equal(t, "run", stored.Summary)
+	assert.Equal(t, int64(3), stored.Version)
+}
@@ -0,0 +1,34 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"planner/middlewares"
+	"planner/storage"
+
+	"github.com/google/uuid"
+)
+
+// handleHistory lists the audit entries of one item, oldest first. Deleted
+// items keep their history until the user's data is purged.
+func handleHistory(w http.ResponseWriter, r *http.Request, audit storage.AuditStorage, entityType string, uuid uuid.UUID) {
+	userId := w.Header().Get(middlewares.VALIDATED_HEADER)
+
+	history, err := audit.History(r.Context(), userId, entityType, uuid)
+	if err != nil {
+		writeStorageError(w, err)
+		return
+	}
+	if len(*history) == 0 {
+		http.Error(w, "Not Found", http.StatusNotFound)
+		return
+	}
+	jsonData, err := json.Marshal(history)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(jsonData)
+}
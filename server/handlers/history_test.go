@@ -0,0 +1,65 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"planner/storage"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMemoryStorageHistoryHandler(t *testing.T) {
+	ctx := context.Background()
+	strg := storage.NewMemoryStorage()
+	testUserId := "some-valid-expected-userid"
+
+	plan, _ := strg.Plan.Create(ctx, storage.Plan{UserId: testUserId, Name: "plan"})
+	activity, _ := strg.Activity.Create(ctx, storage.Activity{UserId: testUserId, PlanId: &plan.Id, Summary: "run", DateTime: time.Date(2023, 5, 1, 10, 0, 0, 0, time.UTC)})
+	activityHandler := http.Handler(registerActivityId(strg.Activity, strg.Plan, strg.Audit))
+	planHandler := http.Handler(registerPlanId(strg.Plan, strg.Activity, strg.RecurringActivity, strg.UnitOfWork, strg.Audit))
+	activityPath := fmt.Sprintf("/api/activities/%s", activity.Id)
+
+	rr := serveIfMatch(t, activityHandler, testUserId, "PUT", activityPath, "", fmt.Sprintf(`{"summary":"tempo run","stages":[],"planId":"%s","dateTime":"2023-05-01T10:00:00Z"}`, plan.Id))
+	assert.Equal(t, http.StatusOK, rr.Code)
+	rr = serveAs(t, planHandler, testUserId, "DELETE", fmt.Sprintf("/api/plans/%s", plan.Id))
+	assert.Equal(t, http.StatusOK, rr.Code)
+
+	rr = serveAs(t, activityHandler, testUserId, "GET", activityPath+"/history")
+	assert.Equal(t, http.StatusOK, rr.Code)
+	var history []storage.AuditEntry
+	assert.Nil(t, json.Unmarshal(rr.Body.Bytes(), &history))
+	assert.Equal(t, 3, len(history))
+	assert.Equal(t, storage.AuditCreate, history[0].Action)
+	assert.Nil(t, history[0].OldValue)
+	assert.Equal(t, storage.AuditUpdate, history[1].Action)
+	var before, after storage.Activity
+	assert.Nil(t, json.Unmarshal(history[1].OldValue, &before))
+	assert.Nil(t, json.Unmarshal(history[1].NewValue, &after))
+	assert.Equal(t, "run", before.Summary)
+	assert.Equal(t, "tempo run", after.Summary)
+	assert.Equal(t, int64(2), after.Version)
+	// Trashing the plan moves the activity to the trash along with it
+	assert.Nil(t, json.Unmarshal(history[2].NewValue, &after))
+	assert.NotNil(t, after.DeletedAt)
+	for _, entry := range history {
+		assert.Equal(t, testUserId, entry.UserId)
+		assert.Equal(t, activity.Id, entry.EntityId)
+	}
+
+	rr = serveAs(t, planHandler, testUserId, "GET", fmt.Sprintf("/api/plans/%s/history", plan.Id))
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Nil(t, json.Unmarshal(rr.Body.Bytes(), &history))
+	assert.Equal(t, 2, len(history))
+	assert.Equal(t, storage.AuditPlan, history[1].EntityType)
+
+	rr = serveAs(t, activityHandler, "another-user", "GET", activityPath+"/history")
+	assert.Equal(t, http.StatusNotFound, rr.Code)
+	rr = serveAs(t, planHandler, testUserId, "GET", fmt.Sprintf("/api/plans/%s/history", activity.Id))
+	assert.Equal(t, http.StatusNotFound, rr.Code)
+	rr = serveAs(t, activityHandler, testUserId, "POST", activityPath+"/history")
+	assert.Equal(t, http.StatusNotFound, rr.Code)
+}
@@ -1,21 +1,26 @@
 package handlers
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
 	"net/http"
 	"planner/middlewares"
 	"planner/storage"
+	"strconv"
 	"strings"
 	"time"
 
 	"github.com/google/uuid"
 )
 
-func AddPlanHandlers(mux *http.ServeMux, strg storage.PlanStorage, actStrg storage.ActivityStorage, recActStrg storage.RecurringActivityStorage, useridMiddleware middlewares.Middleware) {
+const ARCHIVED_PLAN_MESSAGE = "Plan is archived, its activities are read-only"
+const ARCHIVED_PLAN_ACTIVE_MESSAGE = "Plan is archived, unarchive it before activating it"
+
+func AddPlanHandlers(mux *http.ServeMux, strg storage.PlanStorage, actStrg storage.ActivityStorage, recActStrg storage.RecurringActivityStorage, uow storage.UnitOfWork, audit storage.AuditStorage, useridMiddleware middlewares.Middleware) {
 	mux.Handle("/api/plans", useridMiddleware(registerPlanRoot(strg)))
-	mux.Handle("/api/plans/", useridMiddleware(registerPlanId(strg, actStrg, recActStrg)))
+	mux.Handle("/api/plans/", useridMiddleware(registerPlanId(strg, actStrg, recActStrg, uow, audit)))
 }
 
 func registerPlanRoot(strg storage.PlanStorage) http.HandlerFunc {
@@ -31,13 +36,13 @@ func registerPlanRoot(strg storage.PlanStorage) http.HandlerFunc {
 	}
 }
 
-func registerPlanId(strg storage.PlanStorage, actStrg storage.ActivityStorage, recActStrg storage.RecurringActivityStorage) http.HandlerFunc {
+func registerPlanId(strg storage.PlanStorage, actStrg storage.ActivityStorage, recActStrg storage.RecurringActivityStorage, uow storage.UnitOfWork, audit storage.AuditStorage) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		parts := strings.Split(r.URL.Path, "/")
 		id := parts[3]
 
 		if id == "clone" && r.Method == http.MethodPost {
-			handleClonePlan(w, r, strg, actStrg, recActStrg)
+			handleClonePlan(w, r, strg, uow)
 			return
 		}
 
@@ -48,12 +53,24 @@ func registerPlanId(strg storage.PlanStorage, actStrg storage.ActivityStorage, r
 			return
 		}
 
+		if len(parts) > 4 && parts[4] != "" {
+			action := parts[4]
+			if (action == "archive" || action == "unarchive") && r.Method == http.MethodPost {
+				handleArchivePlan(w, r, strg, uuid, action == "archive")
+			} else if action == "history" && r.Method == http.MethodGet {
+				handleHistory(w, r, audit, storage.AuditPlan, uuid)
+			} else {
+				http.Error(w, "Not Found", http.StatusNotFound)
+			}
+			return
+		}
+
 		if r.Method == http.MethodPut {
 			handleUpdatePlan(w, r, strg, uuid)
 		} else if r.Method == http.MethodGet {
 			handleReadPlan(w, r, strg, uuid)
 		} else if r.Method == http.MethodDelete {
-			handleDeletePlan(w, r, strg, actStrg, recActStrg, uuid)
+			handleDeletePlan(w, r, storage.Storage{Plan: strg, Activity: actStrg, RecurringActivity: recActStrg}, uuid)
 		} else {
 			w.WriteHeader(http.StatusMethodNotAllowed)
 			fmt.Fprintf(w, "Invalid method: %s", r.Method)
@@ -92,8 +109,14 @@ func handleCreatePlan(w http.ResponseWriter, r *http.Request, strg storage.PlanS
 	}
 
 	plan.UserId = w.Header().Get(middlewares.VALIDATED_HEADER)
+	plan.DeletedAt = nil
+	plan.ArchivedAt = nil
 
-	created, err := strg.Create(plan)
+	created, err := strg.Create(r.Context(), plan)
+	if err != nil {
+		writeStorageError(w, err)
+		return
+	}
 	jsonData, err := json.Marshal(created.Id.String())
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
@@ -107,13 +130,13 @@ func handleReadPlan(w http.ResponseWriter, r *http.Request, strg storage.PlanSto
 
 	userId := w.Header().Get(middlewares.VALIDATED_HEADER)
 
-	storedPlan, err := strg.Read(userId, uuid)
+	storedPlan, err := strg.Read(r.Context(), userId, uuid)
 
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeStorageError(w, err)
 		return
 	}
-	if storedPlan == nil || storedPlan.UserId != userId {
+	if storedPlan == nil || storedPlan.UserId != userId || storedPlan.DeletedAt != nil {
 		http.Error(w, "Not Found", http.StatusNotFound)
 		return
 	}
@@ -124,16 +147,27 @@ func handleReadPlan(w http.ResponseWriter, r *http.Request, strg storage.PlanSto
 	}
 
 	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("ETag", etag(storedPlan.Version))
 	w.Write(jsonData)
 }
 
 func handleUserQueryPlan(w http.ResponseWriter, r *http.Request, strg storage.PlanStorage) {
 	userId := w.Header().Get(middlewares.VALIDATED_HEADER)
 
-	queried, err := strg.Query(storage.PlanStorageQuery{UserId: userId})
+	query := storage.PlanStorageQuery{UserId: userId}
+	if value := r.URL.Query().Get("includeArchived"); value != "" {
+		includeArchived, err := strconv.ParseBool(value)
+		if err != nil {
+			http.Error(w, "includeArchived must be true or false", http.StatusBadRequest)
+			return
+		}
+		query.IncludeArchived = includeArchived
+	}
+
+	queried, err := strg.Query(r.Context(), query)
 
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeStorageError(w, err)
 		return
 	}
 
@@ -158,39 +192,57 @@ func handleUpdatePlan(w http.ResponseWriter, r *http.Request, strg storage.PlanS
 		return
 	}
 
-	storedPlan, err := strg.Read(userId, uuid)
+	storedPlan, err := strg.Read(r.Context(), userId, uuid)
 
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeStorageError(w, err)
 		return
 	}
 
-	if storedPlan == nil || storedPlan.UserId != userId {
+	if storedPlan == nil || storedPlan.UserId != userId || storedPlan.DeletedAt != nil {
 		http.Error(w, "Not Found", http.StatusNotFound)
 		return
 	}
 
+	if !ifMatch(r, storedPlan.Version) {
+		http.Error(w, PRECONDITION_FAILED_MESSAGE, http.StatusPreconditionFailed)
+		return
+	}
+
+	// Archiving deactivates a plan, which stays inactive until unarchived
+	if storedPlan.ArchivedAt != nil && plan.Active {
+		http.Error(w, ARCHIVED_PLAN_ACTIVE_MESSAGE, http.StatusConflict)
+		return
+	}
+
 	plan.Id = uuid
 	plan.UserId = userId
+	plan.DeletedAt = nil
+	// Archiving goes through its own endpoint
+	plan.ArchivedAt = storedPlan.ArchivedAt
+	plan.Version = storedPlan.Version
 
-	updateErr := strg.Update(plan)
+	updateErr := strg.Update(r.Context(), plan)
 	if updateErr != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeStorageError(w, updateErr)
 		return
 	}
 	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("ETag", etag(plan.Version+1))
 	w.Write([]byte(`{ "status": "ok" }`))
 
 }
 
-func handleDeletePlan(w http.ResponseWriter, r *http.Request, strg storage.PlanStorage, actStrg storage.ActivityStorage, recActStrg storage.RecurringActivityStorage, uuid uuid.UUID) {
+// handleDeletePlan moves the plan and its items into the trash. Deleting a
+// trashed plan again finishes trashing its items when that failed part way.
+func handleDeletePlan(w http.ResponseWriter, r *http.Request, strg storage.Storage, uuid uuid.UUID) {
 
 	userId := w.Header().Get(middlewares.VALIDATED_HEADER)
 
-	storedPlan, err := strg.Read(userId, uuid)
+	storedPlan, err := strg.Plan.Read(r.Context(), userId, uuid)
 
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeStorageError(w, err)
 		return
 	}
 
@@ -199,29 +251,106 @@ func handleDeletePlan(w http.ResponseWriter, r *http.Request, strg storage.PlanS
 		return
 	}
 
-	deleteErr := strg.Delete(userId, uuid)
+	if storedPlan.DeletedAt != nil {
+		trashed, err := trashPlanItems(r.Context(), strg, *storedPlan, *storedPlan.DeletedAt)
+		if err != nil {
+			writeStorageError(w, err)
+			return
+		}
+		if trashed == 0 {
+			http.Error(w, "Not Found", http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{ "status": "ok" }`))
+		return
+	}
+
+	if !ifMatch(r, storedPlan.Version) {
+		http.Error(w, PRECONDITION_FAILED_MESSAGE, http.StatusPreconditionFailed)
+		return
+	}
+
+	deleteErr := trashPlan(r.Context(), strg, *storedPlan, trashTime())
 	if deleteErr != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeStorageError(w, deleteErr)
 		return
 	}
-	activityDeleteErr := actStrg.DeleteForPlan(userId, uuid)
-	if activityDeleteErr != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+	w.Header().Set("Content-Type", "application/json")
+	w.Write([]byte(`{ "status": "ok" }`))
+}
+
+// Archiving a plan also deactivates it. Unarchiving leaves it inactive.
+func handleArchivePlan(w http.ResponseWriter, r *http.Request, strg storage.PlanStorage, uuid uuid.UUID, archive bool) {
+	userId := w.Header().Get(middlewares.VALIDATED_HEADER)
+
+	storedPlan, err := strg.Read(r.Context(), userId, uuid)
+
+	if err != nil {
+		writeStorageError(w, err)
 		return
 	}
-	recActDeleteErr := recActStrg.DeleteForPlan(userId, uuid)
-	if recActDeleteErr != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+
+	if storedPlan == nil || storedPlan.UserId != userId || storedPlan.DeletedAt != nil {
+		http.Error(w, "Not Found", http.StatusNotFound)
 		return
 	}
+
+	if !ifMatch(r, storedPlan.Version) {
+		http.Error(w, PRECONDITION_FAILED_MESSAGE, http.StatusPreconditionFailed)
+		return
+	}
+
+	// A plan already in the requested state is left as it is, version included
+	version := storedPlan.Version
+	if archive != (storedPlan.ArchivedAt != nil) {
+		if archive {
+			archivedAt := time.Now().UTC()
+			storedPlan.ArchivedAt = &archivedAt
+			storedPlan.Active = false
+		} else {
+			storedPlan.ArchivedAt = nil
+		}
+		updateErr := strg.Update(r.Context(), *storedPlan)
+		if updateErr != nil {
+			writeStorageError(w, updateErr)
+			return
+		}
+		version++
+	}
+	w.Header().Set("ETag", etag(version))
 	w.Header().Set("Content-Type", "application/json")
 	w.Write([]byte(`{ "status": "ok" }`))
 }
 
-func handleClonePlan(w http.ResponseWriter, r *http.Request, strg storage.PlanStorage, actStrg storage.ActivityStorage, recActStrg storage.RecurringActivityStorage) {
+// writablePlan checks the plan activities are being written to. A missing
+// plan is a bad request and an archived one a conflict, as its activities
+// are read-only.
+func writablePlan(ctx context.Context, plnStrg storage.PlanStorage, userId string, planId *uuid.UUID) (int, string) {
+	if planId == nil {
+		return http.StatusOK, ""
+	}
+	plan, err := plnStrg.Read(ctx, userId, *planId)
+	if err != nil {
+		return storageErrorResponse(err)
+	}
+	if plan == nil || plan.UserId != userId || plan.DeletedAt != nil {
+		return http.StatusBadRequest, "Plan not found"
+	}
+	if plan.ArchivedAt != nil {
+		return http.StatusConflict, ARCHIVED_PLAN_MESSAGE
+	}
+	return http.StatusOK, ""
+}
+
+func handleClonePlan(w http.ResponseWriter, r *http.Request, strg storage.PlanStorage, uow storage.UnitOfWork) {
 	userId := w.Header().Get(middlewares.VALIDATED_HEADER)
 
 	plan, err := parsePlanClone(r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
 
 	id, err := uuid.Parse(plan.Id)
 
@@ -240,13 +369,13 @@ func handleClonePlan(w http.ResponseWriter, r *http.Request, strg storage.PlanSt
 		return
 	}
 
-	storedPlan, err := strg.Read(userId, id)
+	storedPlan, err := strg.Read(r.Context(), userId, id)
 
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeStorageError(w, err)
 		return
 	}
-	if storedPlan == nil || storedPlan.UserId != userId {
+	if storedPlan == nil || storedPlan.UserId != userId || storedPlan.DeletedAt != nil {
 		http.Error(w, "Not Found", http.StatusNotFound)
 		return
 	}
@@ -255,17 +384,24 @@ func handleClonePlan(w http.ResponseWriter, r *http.Request, strg storage.PlanSt
 
 	newPlan.Id = uuid.Nil
 	newPlan.Name = "Cloned - " + storedPlan.Name
+	newPlan.ArchivedAt = nil
+
+	var created storage.Plan
+	err = uow.Do(r.Context(), func(tx storage.Storage) error {
+		var createErr error
+		created, createErr = tx.Plan.Create(r.Context(), newPlan)
+		if createErr != nil {
+			return createErr
+		}
+		return cloneActivities(r.Context(), tx.Activity, userId, storedPlan.Id, created.Id, plan.NewStartDateTime, plan.NewEndDateTime)
+	})
 
-	created, err := strg.Create(newPlan)
-	str := created.Id.String()
-	jsonData, err := json.Marshal(str)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeStorageError(w, err)
 		return
 	}
 
-	err = cloneActivities(actStrg, userId, storedPlan.Id, created.Id, plan.NewStartDateTime, plan.NewEndDateTime)
-
+	jsonData, err := json.Marshal(created.Id.String())
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
@@ -275,8 +411,8 @@ func handleClonePlan(w http.ResponseWriter, r *http.Request, strg storage.PlanSt
 	w.Write(jsonData)
 }
 
-func cloneActivities(actStrg storage.ActivityStorage, userId string, originPlanId uuid.UUID, targetPlanId uuid.UUID, newStartDate *time.Time, newEndDate *time.Time) error {
-	acts, err := actStrg.Query(storage.ActivityStorageQuery{
+func cloneActivities(ctx context.Context, actStrg storage.ActivityStorage, userId string, originPlanId uuid.UUID, targetPlanId uuid.UUID, newStartDate *time.Time, newEndDate *time.Time) error {
+	acts, err := actStrg.Query(ctx, storage.ActivityStorageQuery{
 		UserId: userId,
 		PlanId: &originPlanId,
 	})
@@ -311,9 +447,9 @@ func cloneActivities(actStrg storage.ActivityStorage, userId string, originPlanI
 		oldAct.DateTime = oldAct.DateTime.Add(*offset)
 		oldAct.PlanId = &targetPlanId
 		oldAct.Completed = false
-		_, err := actStrg.Create(oldAct)
+		_, err := actStrg.Create(ctx, oldAct)
 		if err != nil {
-			fmt.Printf("Error creating new activity:\n\n%v", err)
+			return err
 		}
 	}
 
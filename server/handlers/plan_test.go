@@ -1,7 +1,9 @@
 package handlers
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
 	"net/http/httptest"
@@ -16,12 +18,20 @@ import (
 	"github.com/stretchr/testify/mock"
 )
 
+func passThroughUnitOfWork(t *testing.T, tx storage.Storage) *storage.MockUnitOfWork {
+	mockUow := storage.NewMockUnitOfWork(t)
+	mockUow.EXPECT().Do(mock.Anything, mock.Anything).RunAndReturn(func(ctx context.Context, fn func(storage.Storage) error) error {
+		return fn(tx)
+	}).Once()
+	return mockUow
+}
+
 func TestHappyPathCreatePlanHandler(t *testing.T) {
 	mockStorage := storage.NewMockPlanStorage(t)
 	returnedPlan := storage.Plan{
 		Id: uuid.New(),
 	}
-	mockStorage.EXPECT().Create(mock.Anything).Return(returnedPlan, nil).Once()
+	mockStorage.EXPECT().Create(mock.Anything, mock.Anything).Return(returnedPlan, nil).Once()
 
 	testUserId := "some-valid-expected-userid"
 
@@ -54,9 +64,9 @@ func TestHappyPathUpdatePlanHandler(t *testing.T) {
 		Id:     uuid.New(),
 		UserId: testUserId,
 	}
-	mockStorage.EXPECT().Read(testUserId, returnedPlan.Id).Return(&returnedPlan, nil).Once()
+	mockStorage.EXPECT().Read(mock.Anything, testUserId, returnedPlan.Id).Return(&returnedPlan, nil).Once()
 
-	mockStorage.EXPECT().Update(mock.Anything).Return(nil).Once()
+	mockStorage.EXPECT().Update(mock.Anything, mock.Anything).Return(nil).Once()
 
 	updateBody := `{
 		"name": "some plan name update"
@@ -69,7 +79,7 @@ func TestHappyPathUpdatePlanHandler(t *testing.T) {
 	rr := httptest.NewRecorder()
 	rr.Header().Set(middlewares.VALIDATED_HEADER, testUserId)
 
-	handler := http.Handler(registerPlanId(mockStorage, mockActStorage, storage.NewMockRecurringActivityStorage(t)))
+	handler := http.Handler(registerPlanId(mockStorage, mockActStorage, storage.NewMockRecurringActivityStorage(t), storage.NewMockUnitOfWork(t), storage.NewMockAuditStorage(t)))
 	handler.ServeHTTP(rr, req)
 
 	assert.Equal(t, http.StatusOK, rr.Code)
@@ -86,11 +96,17 @@ func TestHappyPathDeletePlanHandler(t *testing.T) {
 		Id:     uuid.New(),
 		UserId: testUserId,
 	}
-	mockStorage.EXPECT().Read(testUserId, returnedPlan.Id).Return(&returnedPlan, nil).Once()
-
-	mockStorage.EXPECT().Delete(testUserId, returnedPlan.Id).Return(nil).Once()
-	mockActStorage.EXPECT().DeleteForPlan(testUserId, returnedPlan.Id).Return(nil).Once()
-	mockRecActStorage.EXPECT().DeleteForPlan(testUserId, returnedPlan.Id).Return(nil).Once()
+	mockStorage.EXPECT().Read(mock.Anything, testUserId, returnedPlan.Id).Return(&returnedPlan, nil).Once()
+
+	planActivity := storage.Activity{Id: uuid.New(), UserId: testUserId, PlanId: &returnedPlan.Id}
+	mockStorage.EXPECT().Update(mock.Anything, mock.MatchedBy(func(plan storage.Plan) bool {
+		return plan.Id == returnedPlan.Id && plan.DeletedAt != nil
+	})).Return(nil).Once()
+	mockActStorage.EXPECT().Query(mock.Anything, storage.ActivityStorageQuery{UserId: testUserId, PlanId: &returnedPlan.Id}).Return(&[]storage.Activity{planActivity}, nil).Once()
+	mockActStorage.EXPECT().Update(mock.Anything, mock.MatchedBy(func(activity storage.Activity) bool {
+		return activity.Id == planActivity.Id && activity.DeletedAt != nil
+	})).Return(nil).Once()
+	mockRecActStorage.EXPECT().Query(mock.Anything, storage.RecurringActivityStorageQuery{UserId: testUserId, PlanId: &returnedPlan.Id}).Return(&[]storage.RecurringActivity{}, nil).Once()
 
 	// We have to use "real" query params here
 	req, err := http.NewRequest("DELETE", fmt.Sprintf("/api/plans/%s", returnedPlan.Id), nil)
@@ -100,13 +116,42 @@ func TestHappyPathDeletePlanHandler(t *testing.T) {
 	rr := httptest.NewRecorder()
 	rr.Header().Set(middlewares.VALIDATED_HEADER, testUserId)
 
-	handler := http.Handler(registerPlanId(mockStorage, mockActStorage, mockRecActStorage))
+	handler := http.Handler(registerPlanId(mockStorage, mockActStorage, mockRecActStorage, storage.NewMockUnitOfWork(t), storage.NewMockAuditStorage(t)))
 	handler.ServeHTTP(rr, req)
 
 	assert.Equal(t, http.StatusOK, rr.Code)
 	assert.Equal(t, `{ "status": "ok" }`, rr.Body.String())
 }
 
+func TestDeletePlanHandlerReportsFailedCascade(t *testing.T) {
+	mockStorage := storage.NewMockPlanStorage(t)
+	mockActStorage := storage.NewMockActivityStorage(t)
+	mockRecActStorage := storage.NewMockRecurringActivityStorage(t)
+	testUserId := "some-valid-expected-userid"
+
+	returnedPlan := storage.Plan{
+		Id:     uuid.New(),
+		UserId: testUserId,
+	}
+	mockStorage.EXPECT().Read(mock.Anything, testUserId, returnedPlan.Id).Return(&returnedPlan, nil).Once()
+
+	mockStorage.EXPECT().Update(mock.Anything, mock.Anything).Return(nil).Once()
+	mockActStorage.EXPECT().Query(mock.Anything, mock.Anything).Return(&[]storage.Activity{{Id: uuid.New(), UserId: testUserId}}, nil).Once()
+	mockActStorage.EXPECT().Update(mock.Anything, mock.Anything).Return(errors.New("write failed")).Once()
+
+	req, err := http.NewRequest("DELETE", fmt.Sprintf("/api/plans/%s", returnedPlan.Id), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rr := httptest.NewRecorder()
+	rr.Header().Set(middlewares.VALIDATED_HEADER, testUserId)
+
+	handler := http.Handler(registerPlanId(mockStorage, mockActStorage, mockRecActStorage, storage.NewMockUnitOfWork(t), storage.NewMockAuditStorage(t)))
+	handler.ServeHTTP(rr, req)
+
+	assert.Equal(t, http.StatusInternalServerError, rr.Code)
+}
+
 func TestMalformedReturns400CreatePlanHandler(t *testing.T) {
 
 	mockStorage := storage.NewMockPlanStorage(t)
@@ -139,7 +184,7 @@ func TestHappyPathReadPlanHandler(t *testing.T) {
 		Id:     uuid.New(),
 		UserId: testUserId,
 	}
-	mockStorage.EXPECT().Read(testUserId, returnedPlan.Id).Return(&returnedPlan, nil).Once()
+	mockStorage.EXPECT().Read(mock.Anything, testUserId, returnedPlan.Id).Return(&returnedPlan, nil).Once()
 
 	// We have to use "real" query params here
 	req, err := http.NewRequest("GET", fmt.Sprintf("/api/plans/%s", returnedPlan.Id), nil)
@@ -149,7 +194,7 @@ func TestHappyPathReadPlanHandler(t *testing.T) {
 	rr := httptest.NewRecorder()
 	rr.Header().Set(middlewares.VALIDATED_HEADER, testUserId)
 
-	handler := http.Handler(registerPlanId(mockStorage, mockActStorage, storage.NewMockRecurringActivityStorage(t)))
+	handler := http.Handler(registerPlanId(mockStorage, mockActStorage, storage.NewMockRecurringActivityStorage(t), storage.NewMockUnitOfWork(t), storage.NewMockAuditStorage(t)))
 	handler.ServeHTTP(rr, req)
 
 	expectedBody, err := json.Marshal(returnedPlan)
@@ -169,7 +214,7 @@ func TestHappyPathClonePlanHandler_StartDateShift(t *testing.T) {
 		UserId: testUserId,
 		Name:   "Some Plan Name",
 	}
-	mockStorage.EXPECT().Read(testUserId, returnedPlan.Id).Return(&returnedPlan, nil).Once()
+	mockStorage.EXPECT().Read(mock.Anything, testUserId, returnedPlan.Id).Return(&returnedPlan, nil).Once()
 
 	actExpected := storage.Plan{
 		Id:     uuid.New(),
@@ -182,7 +227,7 @@ func TestHappyPathClonePlanHandler_StartDateShift(t *testing.T) {
 		Name:   "Cloned - Some Plan Name",
 	}
 
-	mockStorage.EXPECT().Create(expectedInput).Return(actExpected, nil).Once()
+	mockStorage.EXPECT().Create(mock.Anything, expectedInput).Return(actExpected, nil).Once()
 
 	existingActs := []storage.Activity{
 		{
@@ -196,18 +241,18 @@ func TestHappyPathClonePlanHandler_StartDateShift(t *testing.T) {
 		},
 	}
 
-	mockActStorage.EXPECT().Query(storage.ActivityStorageQuery{
+	mockActStorage.EXPECT().Query(mock.Anything, storage.ActivityStorageQuery{
 		UserId: testUserId,
 		PlanId: &returnedPlan.Id,
 	}).Return(&existingActs, nil)
 
-	mockActStorage.EXPECT().Create(storage.Activity{
+	mockActStorage.EXPECT().Create(mock.Anything, storage.Activity{
 		Summary:   "First",
 		PlanId:    &actExpected.Id,
 		Completed: false,
 		DateTime:  time.Date(2023, 12, 12, 11, 30, 00, 00, &time.Location{}),
 	}).Return(storage.Activity{}, nil).Times(1)
-	mockActStorage.EXPECT().Create(storage.Activity{
+	mockActStorage.EXPECT().Create(mock.Anything, storage.Activity{
 		Summary:  "Second",
 		PlanId:   &actExpected.Id,
 		DateTime: time.Date(2023, 12, 15, 11, 30, 00, 00, &time.Location{}),
@@ -224,7 +269,9 @@ func TestHappyPathClonePlanHandler_StartDateShift(t *testing.T) {
 	rr := httptest.NewRecorder()
 	rr.Header().Set(middlewares.VALIDATED_HEADER, testUserId)
 
-	handler := http.Handler(registerPlanId(mockStorage, mockActStorage, mockRecActStorage))
+	mockUow := passThroughUnitOfWork(t, storage.Storage{Plan: mockStorage, Activity: mockActStorage, RecurringActivity: mockRecActStorage})
+
+	handler := http.Handler(registerPlanId(mockStorage, mockActStorage, mockRecActStorage, mockUow, storage.NewMockAuditStorage(t)))
 	handler.ServeHTTP(rr, req)
 
 	assert.Equal(t, http.StatusOK, rr.Code)
@@ -242,7 +289,7 @@ func TestHappyPathClonePlanHandler_EndDateShift(t *testing.T) {
 		UserId: testUserId,
 		Name:   "Some Plan Name",
 	}
-	mockStorage.EXPECT().Read(testUserId, returnedPlan.Id).Return(&returnedPlan, nil).Once()
+	mockStorage.EXPECT().Read(mock.Anything, testUserId, returnedPlan.Id).Return(&returnedPlan, nil).Once()
 
 	actExpected := storage.Plan{
 		Id:     uuid.New(),
@@ -255,7 +302,7 @@ func TestHappyPathClonePlanHandler_EndDateShift(t *testing.T) {
 		Name:   "Cloned - Some Plan Name",
 	}
 
-	mockStorage.EXPECT().Create(expectedInput).Return(actExpected, nil).Once()
+	mockStorage.EXPECT().Create(mock.Anything, expectedInput).Return(actExpected, nil).Once()
 
 	existingActs := []storage.Activity{
 		{
@@ -268,17 +315,17 @@ func TestHappyPathClonePlanHandler_EndDateShift(t *testing.T) {
 		},
 	}
 
-	mockActStorage.EXPECT().Query(storage.ActivityStorageQuery{
+	mockActStorage.EXPECT().Query(mock.Anything, storage.ActivityStorageQuery{
 		UserId: testUserId,
 		PlanId: &returnedPlan.Id,
 	}).Return(&existingActs, nil)
 
-	mockActStorage.EXPECT().Create(storage.Activity{
+	mockActStorage.EXPECT().Create(mock.Anything, storage.Activity{
 		Summary:  "First",
 		PlanId:   &actExpected.Id,
 		DateTime: time.Date(2023, 12, 9, 11, 30, 00, 00, &time.Location{}),
 	}).Return(storage.Activity{}, nil).Times(1)
-	mockActStorage.EXPECT().Create(storage.Activity{
+	mockActStorage.EXPECT().Create(mock.Anything, storage.Activity{
 		Summary:  "Second",
 		PlanId:   &actExpected.Id,
 		DateTime: time.Date(2023, 12, 12, 11, 30, 00, 00, &time.Location{}),
@@ -295,9 +342,66 @@ func TestHappyPathClonePlanHandler_EndDateShift(t *testing.T) {
 	rr := httptest.NewRecorder()
 	rr.Header().Set(middlewares.VALIDATED_HEADER, testUserId)
 
-	handler := http.Handler(registerPlanId(mockStorage, mockActStorage, mockRecActStorage))
+	mockUow := passThroughUnitOfWork(t, storage.Storage{Plan: mockStorage, Activity: mockActStorage, RecurringActivity: mockRecActStorage})
+
+	handler := http.Handler(registerPlanId(mockStorage, mockActStorage, mockRecActStorage, mockUow, storage.NewMockAuditStorage(t)))
 	handler.ServeHTTP(rr, req)
 
 	assert.Equal(t, http.StatusOK, rr.Code)
 	assert.Equal(t, fmt.Sprintf("\"%s\"", actExpected.Id.String()), rr.Body.String())
 }
+
+func TestMemoryStorageArchivedPlanIsReadOnlyHandler(t *testing.T) {
+	ctx := context.Background()
+	strg := storage.NewMemoryStorage()
+	testUserId := "some-valid-expected-userid"
+
+	plan, _ := strg.Plan.Create(ctx, storage.Plan{UserId: testUserId, Name: "plan", Active: true})
+	activity, _ := strg.Activity.Create(ctx, storage.Activity{UserId: testUserId, PlanId: &plan.Id})
+
+	planRoot := http.Handler(registerPlanRoot(strg.Plan))
+	planHandler := http.Handler(registerPlanId(strg.Plan, strg.Activity, strg.RecurringActivity, strg.UnitOfWork, strg.Audit))
+	activityRoot := http.Handler(registerActivityRoot(strg.Activity, strg.Plan))
+	activityHandler := http.Handler(registerActivityId(strg.Activity, strg.Plan, strg.Audit))
+
+	rr := serveAs(t, planHandler, testUserId, "POST", fmt.Sprintf("/api/plans/%s/archive", plan.Id))
+	assert.Equal(t, http.StatusOK, rr.Code)
+
+	var plans []storage.Plan
+	rr = serveAs(t, planRoot, testUserId, "GET", "/api/plans")
+	assert.Nil(t, json.Unmarshal(rr.Body.Bytes(), &plans))
+	assert.Equal(t, 0, len(plans))
+	rr = serveAs(t, planRoot, testUserId, "GET", "/api/plans?includeArchived=true")
+	assert.Nil(t, json.Unmarshal(rr.Body.Bytes(), &plans))
+	assert.Equal(t, 1, len(plans))
+	assert.NotNil(t, plans[0].ArchivedAt)
+	assert.False(t, plans[0].Active)
+	rr = serveAs(t, planRoot, testUserId, "GET", "/api/plans?includeArchived=maybe")
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+
+	req, _ := http.NewRequest("PUT", fmt.Sprintf("/api/plans/%s", plan.Id), strings.NewReader(`{ "name": "plan", "active": true }`))
+	rr = httptest.NewRecorder()
+	rr.Header().Set(middlewares.VALIDATED_HEADER, testUserId)
+	planHandler.ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusConflict, rr.Code)
+
+	req, _ = http.NewRequest("PUT", fmt.Sprintf("/api/activities/%s", activity.Id), strings.NewReader(`{ "summary": "changed" }`))
+	rr = httptest.NewRecorder()
+	rr.Header().Set(middlewares.VALIDATED_HEADER, testUserId)
+	activityHandler.ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusConflict, rr.Code)
+
+	rr = serveAs(t, activityHandler, testUserId, "DELETE", fmt.Sprintf("/api/activities/%s", activity.Id))
+	assert.Equal(t, http.StatusConflict, rr.Code)
+
+	req, _ = http.NewRequest("POST", "/api/activities", strings.NewReader(fmt.Sprintf(`{ "summary": "new", "planId": "%s" }`, plan.Id)))
+	rr = httptest.NewRecorder()
+	rr.Header().Set(middlewares.VALIDATED_HEADER, testUserId)
+	activityRoot.ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusConflict, rr.Code)
+
+	rr = serveAs(t, planHandler, testUserId, "POST", fmt.Sprintf("/api/plans/%s/unarchive", plan.Id))
+	assert.Equal(t, http.StatusOK, rr.Code)
+	rr = serveAs(t, activityHandler, testUserId, "DELETE", fmt.Sprintf("/api/activities/%s", activity.Id))
+	assert.Equal(t, http.StatusOK, rr.Code)
+}
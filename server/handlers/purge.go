@@ -0,0 +1,111 @@
+package handlers
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"planner/middlewares"
+	"planner/storage"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const PURGE_TOKEN_TTL = 10 * time.Minute
+
+type PurgeToken struct {
+	Token     string    `json:"token"`
+	ExpiresAt time.Time `json:"expiresAt"`
+}
+
+// AddPurgeHandlers serves GET /api/purge, which hands out a short lived
+// confirmation token, and DELETE /api/purge?token= which permanently deletes
+// all of the user's data once given that token.
+func AddPurgeHandlers(mux *http.ServeMux, strg storage.Storage, secret []byte, useridMiddleware middlewares.Middleware) {
+	mux.Handle("/api/purge", useridMiddleware(registerPurgeRoot(strg, secret)))
+}
+
+func registerPurgeRoot(strg storage.Storage, secret []byte) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet {
+			handleCreatePurgeToken(w, r, secret)
+		} else if r.Method == http.MethodDelete {
+			handlePurge(w, r, strg, secret)
+		} else {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			fmt.Fprintf(w, "Invalid method: %s", r.Method)
+		}
+	}
+}
+
+func signPurgeToken(secret []byte, userId string, expiresAt time.Time) string {
+	expiry := strconv.FormatInt(expiresAt.Unix(), 10)
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(userId + "|" + expiry))
+	return expiry + "." + hex.EncodeToString(mac.Sum(nil))
+}
+
+func validPurgeToken(secret []byte, userId string, token string, now time.Time) bool {
+	expiry, _, found := strings.Cut(token, ".")
+	if !found {
+		return false
+	}
+	unix, err := strconv.ParseInt(expiry, 10, 64)
+	if err != nil {
+		return false
+	}
+	expiresAt := time.Unix(unix, 0)
+	if !now.Before(expiresAt) {
+		return false
+	}
+	return hmac.Equal([]byte(token), []byte(signPurgeToken(secret, userId, expiresAt)))
+}
+
+func handleCreatePurgeToken(w http.ResponseWriter, r *http.Request, secret []byte) {
+	userId := w.Header().Get(middlewares.VALIDATED_HEADER)
+
+	expiresAt := time.Now().Add(PURGE_TOKEN_TTL).Truncate(time.Second)
+	jsonData, err := json.Marshal(PurgeToken{
+		Token:     signPurgeToken(secret, userId, expiresAt),
+		ExpiresAt: expiresAt.UTC(),
+	})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(jsonData)
+}
+
+func handlePurge(w http.ResponseWriter, r *http.Request, strg storage.Storage, secret []byte) {
+	userId := w.Header().Get(middlewares.VALIDATED_HEADER)
+
+	token := r.URL.Query().Get("token")
+	if token == "" {
+		http.Error(w, "A confirmation token is required, request one from GET /api/purge", http.StatusBadRequest)
+		return
+	}
+	if !validPurgeToken(secret, userId, token, time.Now()) {
+		http.Error(w, "Invalid or expired confirmation token", http.StatusForbidden)
+		return
+	}
+
+	summary, err := storage.PurgeUser(r.Context(), strg, userId)
+	if err != nil {
+		writeStorageError(w, err)
+		return
+	}
+
+	jsonData, err := json.Marshal(summary)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(jsonData)
+}
@@ -0,0 +1,67 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"planner/storage"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPurgeToken(t *testing.T) {
+	secret := []byte("some-secret")
+	now := time.Date(2023, 5, 1, 10, 0, 0, 0, time.UTC)
+	token := signPurgeToken(secret, "some-user", now.Add(PURGE_TOKEN_TTL))
+
+	assert.True(t, validPurgeToken(secret, "some-user", token, now))
+	assert.False(t, validPurgeToken(secret, "some-other-user", token, now))
+	assert.False(t, validPurgeToken([]byte("other-secret"), "some-user", token, now))
+	assert.False(t, validPurgeToken(secret, "some-user", token, now.Add(PURGE_TOKEN_TTL)))
+	assert.False(t, validPurgeToken(secret, "some-user", "not-a-token", now))
+	assert.False(t, validPurgeToken(secret, "some-user", token+"0", now))
+}
+
+func TestMemoryStoragePurgeHandler(t *testing.T) {
+	ctx := context.Background()
+	strg := storage.NewMemoryStorage()
+	testUserId := "some-valid-expected-userid"
+	otherUserId := "some-other-userid"
+
+	plan, _ := strg.Plan.Create(ctx, storage.Plan{UserId: testUserId, Name: "plan"})
+	strg.Activity.Create(ctx, storage.Activity{UserId: testUserId, PlanId: &plan.Id})
+	strg.RecurringActivity.Create(ctx, storage.RecurringActivity{UserId: testUserId, RecurrEachDays: 7})
+	otherUsers, _ := strg.Activity.Create(ctx, storage.Activity{UserId: otherUserId})
+
+	handler := http.Handler(registerPurgeRoot(strg, []byte("some-secret")))
+
+	rr := serveAs(t, handler, testUserId, "DELETE", "/api/purge")
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+	rr = serveAs(t, handler, testUserId, "DELETE", "/api/purge?token=1.abc")
+	assert.Equal(t, http.StatusForbidden, rr.Code)
+
+	rr = serveAs(t, handler, testUserId, "GET", "/api/purge")
+	assert.Equal(t, http.StatusOK, rr.Code)
+	var token PurgeToken
+	err := json.Unmarshal(rr.Body.Bytes(), &token)
+	assert.Nil(t, err)
+	assert.True(t, token.ExpiresAt.After(time.Now()))
+
+	// A token is only good for the user it was issued to
+	rr = serveAs(t, handler, otherUserId, "DELETE", "/api/purge?token="+token.Token)
+	assert.Equal(t, http.StatusForbidden, rr.Code)
+
+	rr = serveAs(t, handler, testUserId, "DELETE", "/api/purge?token="+token.Token)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	var summary storage.PurgeSummary
+	err = json.Unmarshal(rr.Body.Bytes(), &summary)
+	assert.Nil(t, err)
+	assert.Equal(t, storage.PurgeSummary{Plans: 1, Activities: 1, RecurringActivities: 1}, summary)
+
+	remaining, _ := storage.CountUserData(ctx, strg, testUserId)
+	assert.Equal(t, storage.PurgeSummary{}, remaining)
+	readActivity, _ := strg.Activity.Read(ctx, otherUserId, otherUsers.Id)
+	assert.NotNil(t, readActivity)
+}
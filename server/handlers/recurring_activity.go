@@ -6,15 +6,21 @@ import (
 	"io"
 	"net/http"
 	"planner/middlewares"
+	"planner/recurrence"
 	"planner/storage"
 	"strings"
+	"time"
 
 	"github.com/google/uuid"
 )
 
-func AddRecurringActivityHandlers(mux *http.ServeMux, strg storage.RecurringActivityStorage, plnStrg storage.PlanStorage, useridMiddleware middlewares.Middleware) {
+// MAX_OCCURRENCE_RANGE caps how far a single occurrences request may expand
+const MAX_OCCURRENCE_RANGE = 366 * 24 * time.Hour
+
+func AddRecurringActivityHandlers(mux *http.ServeMux, strg storage.RecurringActivityStorage, actStrg storage.ActivityStorage, plnStrg storage.PlanStorage, useridMiddleware middlewares.Middleware) {
 	mux.Handle("/api/recurring_activities", useridMiddleware(registerRecurringActivityRoot(strg, plnStrg)))
 	mux.Handle("/api/recurring_activities/", useridMiddleware(registerRecurringActivityId(strg, plnStrg)))
+	mux.Handle("/api/recurring_activities/occurrences", useridMiddleware(registerRecurringActivityOccurrences(strg, actStrg)))
 }
 
 func registerRecurringActivityRoot(strg storage.RecurringActivityStorage, plnStrg storage.PlanStorage) http.HandlerFunc {
@@ -47,7 +53,7 @@ func registerRecurringActivityId(strg storage.RecurringActivityStorage, plnStrg
 		} else if r.Method == http.MethodGet {
 			handleReadRecurringActivity(w, r, strg, uuid)
 		} else if r.Method == http.MethodDelete {
-			handleDeleteRecurringActivity(w, r, strg, uuid)
+			handleDeleteRecurringActivity(w, r, strg, plnStrg, uuid)
 		} else {
 			w.WriteHeader(http.StatusMethodNotAllowed)
 			fmt.Fprintf(w, "Invalid method: %s", r.Method)
@@ -56,12 +62,26 @@ func registerRecurringActivityId(strg storage.RecurringActivityStorage, plnStrg
 
 }
 
+func registerRecurringActivityOccurrences(strg storage.RecurringActivityStorage, actStrg storage.ActivityStorage) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet {
+			handleRecurringActivityOccurrences(w, r, strg, actStrg)
+		} else {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			fmt.Fprintf(w, "Invalid method: %s", r.Method)
+		}
+	}
+}
+
 func parseRecurringActivity(rdr io.Reader) (storage.RecurringActivity, error) {
 	var activity storage.RecurringActivity
 	decoder := json.NewDecoder(rdr)
 	decoder.DisallowUnknownFields()
 	err := decoder.Decode(&activity)
-	return activity, err
+	if err != nil {
+		return activity, err
+	}
+	return activity, recurrence.Validate(activity)
 }
 
 func handleCreateRecurringActivity(w http.ResponseWriter, r *http.Request, strg storage.RecurringActivityStorage, plnStrg storage.PlanStorage) {
@@ -72,13 +92,19 @@ func handleCreateRecurringActivity(w http.ResponseWriter, r *http.Request, strg
 	}
 
 	activity.UserId = w.Header().Get(middlewares.VALIDATED_HEADER)
+	activity.DeletedAt = nil
 
-	if activity.PlanId != nil && !validRecurringPlanId(plnStrg, activity) {
-		http.Error(w, "Plan not found", http.StatusBadRequest)
+	status, message := writablePlan(r.Context(), plnStrg, activity.UserId, activity.PlanId)
+	if status != http.StatusOK {
+		http.Error(w, message, status)
 		return
 	}
 
-	created, err := strg.Create(activity)
+	created, err := strg.Create(r.Context(), activity)
+	if err != nil {
+		writeStorageError(w, err)
+		return
+	}
 	jsonData, err := json.Marshal(created.Id.String())
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
@@ -88,25 +114,17 @@ func handleCreateRecurringActivity(w http.ResponseWriter, r *http.Request, strg
 	w.Write(jsonData)
 }
 
-func validRecurringPlanId(plnStrg storage.PlanStorage, activity storage.RecurringActivity) bool {
-	plan, err := plnStrg.Read(activity.UserId, *activity.PlanId)
-	if plan == nil || plan.UserId != activity.UserId || err != nil {
-		return false
-	}
-	return true
-}
-
 func handleReadRecurringActivity(w http.ResponseWriter, r *http.Request, strg storage.RecurringActivityStorage, uuid uuid.UUID) {
 	userId := w.Header().Get(middlewares.VALIDATED_HEADER)
 
-	storedActivity, err := strg.Read(userId, uuid)
+	storedActivity, err := strg.Read(r.Context(), userId, uuid)
 
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeStorageError(w, err)
 		return
 	}
 
-	if storedActivity == nil || storedActivity.UserId != userId {
+	if storedActivity == nil || storedActivity.UserId != userId || storedActivity.DeletedAt != nil {
 		http.Error(w, "Not Found", http.StatusNotFound)
 		return
 	}
@@ -117,6 +135,7 @@ func handleReadRecurringActivity(w http.ResponseWriter, r *http.Request, strg st
 	}
 
 	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("ETag", etag(storedActivity.Version))
 	w.Write(jsonData)
 }
 
@@ -134,10 +153,10 @@ func handleUserQueryRecurringActivity(w http.ResponseWriter, r *http.Request, st
 		planid = &parsedPlanId
 	}
 
-	queried, err := strg.Query(storage.RecurringActivityStorageQuery{UserId: userId, PlanId: planid})
+	queried, err := strg.Query(r.Context(), storage.RecurringActivityStorageQuery{UserId: userId, PlanId: planid})
 
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeStorageError(w, err)
 		return
 	}
 
@@ -162,57 +181,154 @@ func handleUpdateRecurringActivity(w http.ResponseWriter, r *http.Request, strg
 		return
 	}
 
-	storedActivity, err := strg.Read(userId, uuid)
+	storedActivity, err := strg.Read(r.Context(), userId, uuid)
 
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeStorageError(w, err)
 		return
 	}
 
-	if storedActivity == nil || storedActivity.UserId != userId {
+	if storedActivity == nil || storedActivity.UserId != userId || storedActivity.DeletedAt != nil {
 		http.Error(w, "Not Found", http.StatusNotFound)
 		return
 	}
 
+	if !ifMatch(r, storedActivity.Version) {
+		http.Error(w, PRECONDITION_FAILED_MESSAGE, http.StatusPreconditionFailed)
+		return
+	}
+
+	status, message := writablePlan(r.Context(), plnStrg, userId, storedActivity.PlanId)
+	if status == http.StatusConflict {
+		http.Error(w, message, status)
+		return
+	}
+
 	activity.Id = uuid
 	activity.UserId = userId
+	activity.DeletedAt = nil
+	activity.Version = storedActivity.Version
 
-	if activity.PlanId != nil && !validRecurringPlanId(plnStrg, activity) {
-		http.Error(w, "Plan not found", http.StatusBadRequest)
+	status, message = writablePlan(r.Context(), plnStrg, activity.UserId, activity.PlanId)
+	if status != http.StatusOK {
+		http.Error(w, message, status)
 		return
 	}
 
-	updateErr := strg.Update(activity)
+	updateErr := strg.Update(r.Context(), activity)
 	if updateErr != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeStorageError(w, updateErr)
 		return
 	}
 	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("ETag", etag(activity.Version+1))
 	w.Write([]byte(`{ "status": "ok" }`))
 
 }
 
-func handleDeleteRecurringActivity(w http.ResponseWriter, r *http.Request, strg storage.RecurringActivityStorage, uuid uuid.UUID) {
+func handleDeleteRecurringActivity(w http.ResponseWriter, r *http.Request, strg storage.RecurringActivityStorage, plnStrg storage.PlanStorage, uuid uuid.UUID) {
 
 	userId := w.Header().Get(middlewares.VALIDATED_HEADER)
 
-	storedActivity, err := strg.Read(userId, uuid)
+	storedActivity, err := strg.Read(r.Context(), userId, uuid)
 
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeStorageError(w, err)
 		return
 	}
 
-	if storedActivity == nil || storedActivity.UserId != userId {
+	if storedActivity == nil || storedActivity.UserId != userId || storedActivity.DeletedAt != nil {
 		http.Error(w, "Not Found", http.StatusNotFound)
 		return
 	}
 
-	deleteErr := strg.Delete(userId, uuid)
+	if !ifMatch(r, storedActivity.Version) {
+		http.Error(w, PRECONDITION_FAILED_MESSAGE, http.StatusPreconditionFailed)
+		return
+	}
+
+	status, message := writablePlan(r.Context(), plnStrg, userId, storedActivity.PlanId)
+	if status == http.StatusConflict {
+		http.Error(w, message, status)
+		return
+	}
+
+	deletedAt := trashTime()
+	storedActivity.DeletedAt = &deletedAt
+	deleteErr := strg.Update(r.Context(), *storedActivity)
 	if deleteErr != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeStorageError(w, deleteErr)
 		return
 	}
 	w.Header().Set("Content-Type", "application/json")
 	w.Write([]byte(`{ "status": "ok" }`))
 }
+
+// handleRecurringActivityOccurrences lists the occurrences of the user's
+// recurring activities from timeStart, included, until timeEnd, excluded,
+// merged with the activities recorded for them.
+func handleRecurringActivityOccurrences(w http.ResponseWriter, r *http.Request, strg storage.RecurringActivityStorage, actStrg storage.ActivityStorage) {
+	userId := w.Header().Get(middlewares.VALIDATED_HEADER)
+	params := r.URL.Query()
+
+	startTime, err := time.Parse(time.RFC3339, params.Get("timeStart"))
+	if err != nil {
+		http.Error(w, "Bad timeStart, expected an RFC3339 time", http.StatusBadRequest)
+		return
+	}
+	endTime, err := time.Parse(time.RFC3339, params.Get("timeEnd"))
+	if err != nil {
+		http.Error(w, "Bad timeEnd, expected an RFC3339 time", http.StatusBadRequest)
+		return
+	}
+	if !endTime.After(startTime) {
+		http.Error(w, "timeEnd must be after timeStart", http.StatusBadRequest)
+		return
+	}
+	if endTime.Sub(startTime) > MAX_OCCURRENCE_RANGE {
+		http.Error(w, "timeStart and timeEnd may be at most 366 days apart", http.StatusBadRequest)
+		return
+	}
+
+	query := storage.RecurringActivityStorageQuery{UserId: userId}
+	if rawPlanId := params.Get("planId"); rawPlanId != "" {
+		planId, err := uuid.Parse(rawPlanId)
+		if err != nil {
+			http.Error(w, "Bad Plan Id", http.StatusBadRequest)
+			return
+		}
+		query.PlanId = &planId
+	}
+
+	recurring, err := strg.Query(r.Context(), query)
+	if err != nil {
+		writeStorageError(w, err)
+		return
+	}
+
+	// Recorded activities are matched to occurrences by date, so every day the
+	// range touches is read. Storage ranges exclude their start, hence the
+	// second before midnight.
+	firstDay := startTime.UTC().Truncate(24 * time.Hour)
+	lastDay := endTime.UTC().Truncate(24 * time.Hour)
+	activities, err := actStrg.Query(r.Context(), storage.ActivityStorageQuery{
+		UserId: userId,
+		DateRange: &storage.DateRange{
+			Start: firstDay.Add(-time.Second),
+			End:   lastDay.AddDate(0, 0, 1),
+		},
+	})
+	if err != nil {
+		writeStorageError(w, err)
+		return
+	}
+
+	jsonData, err := json.Marshal(recurrence.Schedule(*recurring, *activities, startTime, endTime))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(jsonData)
+}
@@ -6,9 +6,11 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"planner/middlewares"
+	"planner/recurrence"
 	"planner/storage"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/google/uuid"
 	"github.com/stretchr/testify/assert"
@@ -21,7 +23,7 @@ func TestHappyPathCreateRecurringRecurringActivityHandler(t *testing.T) {
 	returnedActivity := storage.RecurringActivity{
 		Id: uuid.New(),
 	}
-	mockStorage.EXPECT().Create(mock.Anything).Return(returnedActivity, nil).Once()
+	mockStorage.EXPECT().Create(mock.Anything, mock.Anything).Return(returnedActivity, nil).Once()
 
 	testUserId := "some-valid-expected-userid"
 
@@ -53,9 +55,9 @@ func TestHappyPathUpdateRecurringActivityHandler(t *testing.T) {
 		Id:     uuid.New(),
 		UserId: testUserId,
 	}
-	mockStorage.EXPECT().Read(testUserId, returnedActivity.Id).Return(&returnedActivity, nil).Once()
+	mockStorage.EXPECT().Read(mock.Anything, testUserId, returnedActivity.Id).Return(&returnedActivity, nil).Once()
 
-	mockStorage.EXPECT().Update(mock.Anything).Return(nil).Once()
+	mockStorage.EXPECT().Update(mock.Anything, mock.Anything).Return(nil).Once()
 
 	updateBody := `{
 		"summary": "some activity name update"
@@ -84,9 +86,11 @@ func TestHappyPathDeleteRecurringActivityHandler(t *testing.T) {
 		Id:     uuid.New(),
 		UserId: testUserId,
 	}
-	mockStorage.EXPECT().Read(testUserId, returnedActivity.Id).Return(&returnedActivity, nil).Once()
+	mockStorage.EXPECT().Read(mock.Anything, testUserId, returnedActivity.Id).Return(&returnedActivity, nil).Once()
 
-	mockStorage.EXPECT().Delete(testUserId, returnedActivity.Id).Return(nil).Once()
+	mockStorage.EXPECT().Update(mock.Anything, mock.MatchedBy(func(activity storage.RecurringActivity) bool {
+		return activity.Id == returnedActivity.Id && activity.DeletedAt != nil
+	})).Return(nil).Once()
 
 	// We have to use "real" query params here
 	req, err := http.NewRequest("DELETE", fmt.Sprintf("/api/recurring_activities/%s", returnedActivity.Id), nil)
@@ -134,7 +138,7 @@ func TestPlanDoesntExistReturns400CreateRecurringActivityHandler(t *testing.T) {
 	testUserId := "some-valid-expected-userid"
 	planId := uuid.New()
 
-	mockPlanStorage.EXPECT().Read(testUserId, planId).Return(nil, nil).Once()
+	mockPlanStorage.EXPECT().Read(mock.Anything, testUserId, planId).Return(nil, nil).Once()
 
 	createBody := fmt.Sprintf(`{
 		"summary": "some summary",
@@ -163,7 +167,7 @@ func TestHappyPathReadRecurringActivityHandler(t *testing.T) {
 		Id:     uuid.New(),
 		UserId: testUserId,
 	}
-	mockStorage.EXPECT().Read(testUserId, returnedActivity.Id).Return(&returnedActivity, nil).Once()
+	mockStorage.EXPECT().Read(mock.Anything, testUserId, returnedActivity.Id).Return(&returnedActivity, nil).Once()
 
 	// We have to use "real" query params here
 	req, err := http.NewRequest("GET", fmt.Sprintf("/api/recurring_activities/%s", returnedActivity.Id), nil)
@@ -181,3 +185,144 @@ func TestHappyPathReadRecurringActivityHandler(t *testing.T) {
 	assert.Equal(t, http.StatusOK, rr.Code)
 	assert.Equal(t, string(expectedBody), rr.Body.String())
 }
+
+func TestRecurringActivityOccurrencesHandler(t *testing.T) {
+	mockStorage := storage.NewMockRecurringActivityStorage(t)
+	mockActivityStorage := storage.NewMockActivityStorage(t)
+	testUserId := "some-valid-expected-userid"
+	planId := uuid.New()
+	recurring := storage.RecurringActivity{Id: uuid.New(), UserId: testUserId, PlanId: &planId, Summary: "Run", Stages: []storage.ActivityStage{}, RecurrEachDays: 2, DateTimeStart: time.Date(2023, 5, 1, 0, 0, 0, 0, time.UTC)}
+	recorded := storage.Activity{Id: uuid.New(), UserId: testUserId, RecurringActivityId: &recurring.Id, Summary: "Run", Stages: []storage.ActivityStage{}, Completed: true, DateTime: time.Date(2023, 5, 2, 22, 0, 0, 0, time.UTC)}
+	mockStorage.EXPECT().Query(mock.Anything, storage.RecurringActivityStorageQuery{UserId: testUserId, PlanId: &planId}).Return(&[]storage.RecurringActivity{recurring}, nil).Once()
+	mockActivityStorage.EXPECT().Query(mock.Anything, storage.ActivityStorageQuery{
+		UserId: testUserId,
+		DateRange: &storage.DateRange{
+			Start: time.Date(2023, 4, 30, 23, 59, 59, 0, time.UTC),
+			End:   time.Date(2023, 5, 6, 0, 0, 0, 0, time.UTC),
+		},
+	}).Return(&[]storage.Activity{recorded}, nil).Once()
+
+	req, err := http.NewRequest("GET", "/my-endpoint?timeStart=2023-05-01T00:00:00Z&timeEnd=2023-05-05T12:00:00Z&planId="+planId.String(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rr := httptest.NewRecorder()
+	rr.Header().Set(middlewares.VALIDATED_HEADER, testUserId)
+
+	handler := http.Handler(registerRecurringActivityOccurrences(mockStorage, mockActivityStorage))
+	handler.ServeHTTP(rr, req)
+
+	assert.Equal(t, http.StatusOK, rr.Code)
+	var occurrences []recurrence.Occurrence
+	json.Unmarshal(rr.Body.Bytes(), &occurrences)
+	assert.Len(t, occurrences, 3)
+	assert.Equal(t, "2023-05-01", occurrences[0].Date)
+	assert.Nil(t, occurrences[0].ActivityId)
+	assert.Equal(t, "2023-05-03", occurrences[1].Date)
+	assert.Nil(t, occurrences[1].ActivityId, "activities are matched by UTC date")
+	assert.Equal(t, "2023-05-05", occurrences[2].Date)
+}
+
+func TestRecurringActivityOccurrencesHandlerMergesRecordedActivity(t *testing.T) {
+	mockStorage := storage.NewMockRecurringActivityStorage(t)
+	mockActivityStorage := storage.NewMockActivityStorage(t)
+	testUserId := "some-valid-expected-userid"
+	recurring := storage.RecurringActivity{Id: uuid.New(), UserId: testUserId, Summary: "Run", Stages: []storage.ActivityStage{}, RecurrEachDays: 1, DateTimeStart: time.Date(2023, 5, 1, 7, 0, 0, 0, time.UTC)}
+	recorded := storage.Activity{Id: uuid.New(), UserId: testUserId, RecurringActivityId: &recurring.Id, Summary: "Run", Stages: []storage.ActivityStage{}, Completed: true, DateTime: time.Date(2023, 5, 2, 0, 0, 0, 0, time.UTC)}
+	mockStorage.EXPECT().Query(mock.Anything, mock.Anything).Return(&[]storage.RecurringActivity{recurring}, nil).Once()
+	mockActivityStorage.EXPECT().Query(mock.Anything, mock.Anything).Return(&[]storage.Activity{recorded}, nil).Once()
+
+	req, err := http.NewRequest("GET", "/my-endpoint?timeStart=2023-05-02T00:00:00Z&timeEnd=2023-05-03T00:00:00Z", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rr := httptest.NewRecorder()
+	rr.Header().Set(middlewares.VALIDATED_HEADER, testUserId)
+
+	handler := http.Handler(registerRecurringActivityOccurrences(mockStorage, mockActivityStorage))
+	handler.ServeHTTP(rr, req)
+
+	assert.Equal(t, http.StatusOK, rr.Code)
+	var occurrences []recurrence.Occurrence
+	json.Unmarshal(rr.Body.Bytes(), &occurrences)
+	assert.Len(t, occurrences, 1)
+	assert.Equal(t, recorded.Id, *occurrences[0].ActivityId)
+	assert.True(t, occurrences[0].Completed)
+}
+
+func TestRecurringActivityOccurrencesHandlerRejectsBadRanges(t *testing.T) {
+	for _, params := range []string{
+		"",
+		"timeStart=2023-05-01T00:00:00Z",
+		"timeStart=yesterday&timeEnd=2023-05-05T00:00:00Z",
+		"timeStart=2023-05-05T00:00:00Z&timeEnd=2023-05-01T00:00:00Z",
+		"timeStart=2023-01-01T00:00:00Z&timeEnd=2024-01-03T00:00:00Z",
+		"timeStart=2023-05-01T00:00:00Z&timeEnd=2023-05-05T00:00:00Z&planId=nope",
+	} {
+		req, err := http.NewRequest("GET", "/my-endpoint?"+params, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		rr := httptest.NewRecorder()
+		rr.Header().Set(middlewares.VALIDATED_HEADER, "some-valid-expected-userid")
+
+		handler := http.Handler(registerRecurringActivityOccurrences(storage.NewMockRecurringActivityStorage(t), storage.NewMockActivityStorage(t)))
+		handler.ServeHTTP(rr, req)
+
+		assert.Equal(t, http.StatusBadRequest, rr.Code, params)
+	}
+}
+
+func TestCreateRecurringActivityHandlerRejectsInvalidRule(t *testing.T) {
+	mockStorage := storage.NewMockRecurringActivityStorage(t)
+	mockPlanStorage := storage.NewMockPlanStorage(t)
+
+	createBody := `{
+		"summary": "some activity name",
+		"dateTimeStart": "2023-05-01T00:00:00Z",
+		"rrule": "FREQ=HOURLY"
+	}`
+
+	req, err := http.NewRequest("POST", "/my-endpoint", strings.NewReader(createBody))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rr := httptest.NewRecorder()
+	rr.Header().Set(middlewares.VALIDATED_HEADER, "some-valid-expected-userid")
+
+	handler := http.Handler(registerRecurringActivityRoot(mockStorage, mockPlanStorage))
+	handler.ServeHTTP(rr, req)
+
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+	assert.Contains(t, rr.Body.String(), "FREQ may be at most DAILY")
+}
+
+func TestRecurringActivityOccurrencesHandlerFollowsRules(t *testing.T) {
+	mockStorage := storage.NewMockRecurringActivityStorage(t)
+	mockActivityStorage := storage.NewMockActivityStorage(t)
+	recurring := storage.RecurringActivity{Id: uuid.New(), Summary: "Intervals", Stages: []storage.ActivityStage{}, RRule: "FREQ=WEEKLY;BYDAY=TU,TH", DateTimeStart: time.Date(2023, 5, 1, 0, 0, 0, 0, time.UTC)}
+	mockStorage.EXPECT().Query(mock.Anything, mock.Anything).Return(&[]storage.RecurringActivity{recurring}, nil).Once()
+	mockActivityStorage.EXPECT().Query(mock.Anything, mock.Anything).Return(&[]storage.Activity{}, nil).Once()
+
+	req, err := http.NewRequest("GET", "/my-endpoint?timeStart=2023-05-01T00:00:00Z&timeEnd=2023-05-08T00:00:00Z", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rr := httptest.NewRecorder()
+	rr.Header().Set(middlewares.VALIDATED_HEADER, "some-valid-expected-userid")
+
+	handler := http.Handler(registerRecurringActivityOccurrences(mockStorage, mockActivityStorage))
+	handler.ServeHTTP(rr, req)
+
+	assert.Equal(t, http.StatusOK, rr.Code)
+	var occurrences []recurrence.Occurrence
+	json.Unmarshal(rr.Body.Bytes(), &occurrences)
+	assert.Len(t, occurrences, 2)
+	assert.Equal(t, "2023-05-02", occurrences[0].Date)
+	assert.Equal(t, "2023-05-04", occurrences[1].Date)
+}
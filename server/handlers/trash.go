@@ -0,0 +1,352 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"planner/middlewares"
+	"planner/storage"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const TRASH_PLANS = "plans"
+const TRASH_ACTIVITIES = "activities"
+const TRASH_RECURRING_ACTIVITIES = "recurring_activities"
+
+type Trash struct {
+	Plans               *[]storage.Plan              `json:"plans"`
+	Activities          *[]storage.Activity          `json:"activities"`
+	RecurringActivities *[]storage.RecurringActivity `json:"recurringActivities"`
+}
+
+func AddTrashHandlers(mux *http.ServeMux, strg storage.PlanStorage, actStrg storage.ActivityStorage, recActStrg storage.RecurringActivityStorage, uow storage.UnitOfWork, useridMiddleware middlewares.Middleware) {
+	mux.Handle("/api/trash", useridMiddleware(registerTrashRoot(strg, actStrg, recActStrg)))
+	mux.Handle("/api/trash/", useridMiddleware(registerTrashRestore(strg, actStrg, recActStrg, uow)))
+}
+
+func registerTrashRoot(strg storage.PlanStorage, actStrg storage.ActivityStorage, recActStrg storage.RecurringActivityStorage) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet {
+			handleListTrash(w, r, strg, actStrg, recActStrg)
+		} else if r.Method == http.MethodDelete {
+			handleEmptyTrash(w, r, storage.Storage{Plan: strg, Activity: actStrg, RecurringActivity: recActStrg})
+		} else {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			fmt.Fprintf(w, "Invalid method: %s", r.Method)
+		}
+	}
+}
+
+// registerTrashRestore serves POST /api/trash/{kind}/{id}/restore
+func registerTrashRestore(strg storage.PlanStorage, actStrg storage.ActivityStorage, recActStrg storage.RecurringActivityStorage, uow storage.UnitOfWork) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		parts := strings.Split(r.URL.Path, "/")
+		if len(parts) != 6 || parts[5] != "restore" {
+			http.Error(w, "Not Found", http.StatusNotFound)
+			return
+		}
+		kind := parts[3]
+		if kind != TRASH_PLANS && kind != TRASH_ACTIVITIES && kind != TRASH_RECURRING_ACTIVITIES {
+			http.Error(w, "Not Found", http.StatusNotFound)
+			return
+		}
+
+		uuid, err := uuid.Parse(parts[4])
+
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		if r.Method == http.MethodPost {
+			handleRestoreTrashed(w, r, storage.Storage{Plan: strg, Activity: actStrg, RecurringActivity: recActStrg}, uow, kind, uuid)
+		} else {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			fmt.Fprintf(w, "Invalid method: %s", r.Method)
+		}
+	}
+}
+
+// trashTime is truncated to the millisecond so the timestamps that tie a
+// plan to the items trashed with it survive every backend's precision.
+func trashTime() time.Time {
+	return time.Now().UTC().Truncate(time.Millisecond)
+}
+
+// trashPlan moves the plan into the trash first and then its items, one write
+// each, since a unit of work spanning a whole plan is too large for Cassandra.
+// Should trashing the items fail part way, trashPlanItems finishes it later.
+func trashPlan(ctx context.Context, strg storage.Storage, plan storage.Plan, deletedAt time.Time) error {
+	plan.DeletedAt = &deletedAt
+	err := strg.Plan.Update(ctx, plan)
+	if err != nil {
+		return err
+	}
+	_, err = trashPlanItems(ctx, strg, plan, deletedAt)
+	return err
+}
+
+// trashPlanItems trashes the activities and recurring activities of the plan
+// still outside the trash and returns how many there were
+func trashPlanItems(ctx context.Context, strg storage.Storage, plan storage.Plan, deletedAt time.Time) (int, error) {
+	activities, err := strg.Activity.Query(ctx, storage.ActivityStorageQuery{UserId: plan.UserId, PlanId: &plan.Id})
+	if err != nil {
+		return 0, err
+	}
+	for _, activity := range *activities {
+		activity.DeletedAt = &deletedAt
+		err = strg.Activity.Update(ctx, activity)
+		if err != nil {
+			return 0, err
+		}
+	}
+	recurringActivities, err := strg.RecurringActivity.Query(ctx, storage.RecurringActivityStorageQuery{UserId: plan.UserId, PlanId: &plan.Id})
+	if err != nil {
+		return 0, err
+	}
+	for _, activity := range *recurringActivities {
+		activity.DeletedAt = &deletedAt
+		err = strg.RecurringActivity.Update(ctx, activity)
+		if err != nil {
+			return 0, err
+		}
+	}
+	return len(*activities) + len(*recurringActivities), nil
+}
+
+// restorePlan brings back the plan with the activities trashed along with it,
+// those deleted on their own beforehand stay in the trash. The items come
+// back one write each before the plan, so a restore that failed part way is
+// finished by restoring the plan again.
+func restorePlan(ctx context.Context, strg storage.Storage, plan storage.Plan) error {
+	deletedAt := *plan.DeletedAt
+	activities, err := strg.Activity.Query(ctx, storage.ActivityStorageQuery{UserId: plan.UserId, PlanId: &plan.Id, Trashed: true})
+	if err != nil {
+		return err
+	}
+	for _, activity := range *activities {
+		if !activity.DeletedAt.Equal(deletedAt) {
+			continue
+		}
+		activity.DeletedAt = nil
+		err = strg.Activity.Update(ctx, activity)
+		if err != nil {
+			return err
+		}
+	}
+	recurringActivities, err := strg.RecurringActivity.Query(ctx, storage.RecurringActivityStorageQuery{UserId: plan.UserId, PlanId: &plan.Id, Trashed: true})
+	if err != nil {
+		return err
+	}
+	for _, activity := range *recurringActivities {
+		if !activity.DeletedAt.Equal(deletedAt) {
+			continue
+		}
+		activity.DeletedAt = nil
+		err = strg.RecurringActivity.Update(ctx, activity)
+		if err != nil {
+			return err
+		}
+	}
+	plan.DeletedAt = nil
+	return strg.Plan.Update(ctx, plan)
+}
+
+func handleListTrash(w http.ResponseWriter, r *http.Request, strg storage.PlanStorage, actStrg storage.ActivityStorage, recActStrg storage.RecurringActivityStorage) {
+	userId := w.Header().Get(middlewares.VALIDATED_HEADER)
+
+	plans, err := strg.Query(r.Context(), storage.PlanStorageQuery{UserId: userId, Trashed: true, IncludeArchived: true})
+	if err != nil {
+		writeStorageError(w, err)
+		return
+	}
+	activities, err := actStrg.Query(r.Context(), storage.ActivityStorageQuery{UserId: userId, Trashed: true})
+	if err != nil {
+		writeStorageError(w, err)
+		return
+	}
+	recurringActivities, err := recActStrg.Query(r.Context(), storage.RecurringActivityStorageQuery{UserId: userId, Trashed: true})
+	if err != nil {
+		writeStorageError(w, err)
+		return
+	}
+
+	jsonData, err := json.Marshal(Trash{
+		Plans:               plans,
+		Activities:          activities,
+		RecurringActivities: recurringActivities,
+	})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(jsonData)
+}
+
+// handleEmptyTrash deletes plans after their items and every item on its own,
+// rather than in one unit of work too large for Cassandra, so emptying the
+// trash again picks up whatever a failed attempt left.
+func handleEmptyTrash(w http.ResponseWriter, r *http.Request, strg storage.Storage) {
+	userId := w.Header().Get(middlewares.VALIDATED_HEADER)
+
+	emptyErr := emptyTrash(r.Context(), strg, userId)
+	if emptyErr != nil {
+		writeStorageError(w, emptyErr)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write([]byte(`{ "status": "ok" }`))
+}
+
+func emptyTrash(ctx context.Context, strg storage.Storage, userId string) error {
+	plans, err := strg.Plan.Query(ctx, storage.PlanStorageQuery{UserId: userId, Trashed: true, IncludeArchived: true})
+	if err != nil {
+		return err
+	}
+	for _, plan := range *plans {
+		err = strg.Activity.DeleteForPlan(ctx, userId, plan.Id)
+		if err != nil {
+			return err
+		}
+		err = strg.RecurringActivity.DeleteForPlan(ctx, userId, plan.Id)
+		if err != nil {
+			return err
+		}
+		err = strg.Plan.Delete(ctx, userId, plan.Id)
+		if err != nil {
+			return err
+		}
+	}
+	activities, err := strg.Activity.Query(ctx, storage.ActivityStorageQuery{UserId: userId, Trashed: true})
+	if err != nil {
+		return err
+	}
+	for _, activity := range *activities {
+		err = strg.Activity.Delete(ctx, userId, activity.Id)
+		if err != nil {
+			return err
+		}
+	}
+	recurringActivities, err := strg.RecurringActivity.Query(ctx, storage.RecurringActivityStorageQuery{UserId: userId, Trashed: true})
+	if err != nil {
+		return err
+	}
+	for _, activity := range *recurringActivities {
+		err = strg.RecurringActivity.Delete(ctx, userId, activity.Id)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Restoring returns 404 for items that are not in the trash, and 409 for
+// activities whose plan is still trashed, as the plan has to come back first,
+// or archived.
+func handleRestoreTrashed(w http.ResponseWriter, r *http.Request, strg storage.Storage, uow storage.UnitOfWork, kind string, uuid uuid.UUID) {
+	userId := w.Header().Get(middlewares.VALIDATED_HEADER)
+
+	status := http.StatusOK
+	var restoreErr error
+	if kind == TRASH_PLANS {
+		// A plan comes back item by item, see restorePlan
+		status, restoreErr = restoreTrashedPlan(r.Context(), strg, userId, uuid)
+	} else {
+		restoreErr = uow.Do(r.Context(), func(tx storage.Storage) error {
+			var err error
+			if kind == TRASH_ACTIVITIES {
+				status, err = restoreTrashedActivity(r.Context(), tx, userId, uuid)
+			} else {
+				status, err = restoreTrashedRecurringActivity(r.Context(), tx, userId, uuid)
+			}
+			return err
+		})
+	}
+	if restoreErr != nil {
+		writeStorageError(w, restoreErr)
+		return
+	}
+	if status == http.StatusNotFound {
+		http.Error(w, "Not Found", http.StatusNotFound)
+		return
+	}
+	if status == http.StatusBadRequest {
+		http.Error(w, "Plan not found", http.StatusBadRequest)
+		return
+	}
+	if status == http.StatusConflict {
+		http.Error(w, "The plan is in the trash or archived", http.StatusConflict)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write([]byte(`{ "status": "ok" }`))
+}
+
+func restoreTrashedPlan(ctx context.Context, strg storage.Storage, userId string, id uuid.UUID) (int, error) {
+	storedPlan, err := strg.Plan.Read(ctx, userId, id)
+	if err != nil {
+		return http.StatusInternalServerError, err
+	}
+	if storedPlan == nil || storedPlan.UserId != userId || storedPlan.DeletedAt == nil {
+		return http.StatusNotFound, nil
+	}
+	return http.StatusOK, restorePlan(ctx, strg, *storedPlan)
+}
+
+func restoreTrashedActivity(ctx context.Context, tx storage.Storage, userId string, id uuid.UUID) (int, error) {
+	storedActivity, err := tx.Activity.Read(ctx, userId, id)
+	if err != nil {
+		return http.StatusInternalServerError, err
+	}
+	if storedActivity == nil || storedActivity.UserId != userId || storedActivity.DeletedAt == nil {
+		return http.StatusNotFound, nil
+	}
+	status, err := restorablePlan(ctx, tx.Plan, userId, storedActivity.PlanId)
+	if status != http.StatusOK || err != nil {
+		return status, err
+	}
+	storedActivity.DeletedAt = nil
+	return http.StatusOK, tx.Activity.Update(ctx, *storedActivity)
+}
+
+func restoreTrashedRecurringActivity(ctx context.Context, tx storage.Storage, userId string, id uuid.UUID) (int, error) {
+	storedActivity, err := tx.RecurringActivity.Read(ctx, userId, id)
+	if err != nil {
+		return http.StatusInternalServerError, err
+	}
+	if storedActivity == nil || storedActivity.UserId != userId || storedActivity.DeletedAt == nil {
+		return http.StatusNotFound, nil
+	}
+	status, err := restorablePlan(ctx, tx.Plan, userId, storedActivity.PlanId)
+	if status != http.StatusOK || err != nil {
+		return status, err
+	}
+	storedActivity.DeletedAt = nil
+	return http.StatusOK, tx.RecurringActivity.Update(ctx, *storedActivity)
+}
+
+// restorablePlan checks the plan of an item leaving the trash. Only a plan in
+// the trash or archived conflicts, a missing one answers like writablePlan and
+// a failed read is returned for writeStorageError to map.
+func restorablePlan(ctx context.Context, plnStrg storage.PlanStorage, userId string, planId *uuid.UUID) (int, error) {
+	if planId == nil {
+		return http.StatusOK, nil
+	}
+	plan, err := plnStrg.Read(ctx, userId, *planId)
+	if err != nil {
+		return http.StatusInternalServerError, err
+	}
+	if plan == nil || plan.UserId != userId {
+		return http.StatusBadRequest, nil
+	}
+	if plan.DeletedAt != nil || plan.ArchivedAt != nil {
+		return http.StatusConflict, nil
+	}
+	return http.StatusOK, nil
+}
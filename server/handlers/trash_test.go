@@ -0,0 +1,176 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"planner/middlewares"
+	"planner/storage"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func serveAs(t *testing.T, handler http.Handler, userId string, method string, path string) *httptest.ResponseRecorder {
+	req, err := http.NewRequest(method, path, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rr := httptest.NewRecorder()
+	rr.Header().Set(middlewares.VALIDATED_HEADER, userId)
+	handler.ServeHTTP(rr, req)
+	return rr
+}
+
+func TestMemoryStorageTrashAndRestorePlanHandler(t *testing.T) {
+	ctx := context.Background()
+	strg := storage.NewMemoryStorage()
+	testUserId := "some-valid-expected-userid"
+
+	plan, _ := strg.Plan.Create(ctx, storage.Plan{UserId: testUserId, Name: "plan"})
+	kept, _ := strg.Activity.Create(ctx, storage.Activity{UserId: testUserId, PlanId: &plan.Id, DateTime: time.Date(2023, 5, 1, 10, 0, 0, 0, time.UTC)})
+	deletedFirst, _ := strg.Activity.Create(ctx, storage.Activity{UserId: testUserId, PlanId: &plan.Id, DateTime: time.Date(2023, 5, 2, 10, 0, 0, 0, time.UTC)})
+
+	activityHandler := http.Handler(registerActivityId(strg.Activity, strg.Plan, strg.Audit))
+	planHandler := http.Handler(registerPlanId(strg.Plan, strg.Activity, strg.RecurringActivity, strg.UnitOfWork, strg.Audit))
+	trashHandler := http.Handler(registerTrashRoot(strg.Plan, strg.Activity, strg.RecurringActivity))
+	restoreHandler := http.Handler(registerTrashRestore(strg.Plan, strg.Activity, strg.RecurringActivity, strg.UnitOfWork))
+
+	rr := serveAs(t, activityHandler, testUserId, "DELETE", fmt.Sprintf("/api/activities/%s", deletedFirst.Id))
+	assert.Equal(t, http.StatusOK, rr.Code)
+	// Deleting moves the activity into the trash rather than destroying it
+	rr = serveAs(t, activityHandler, testUserId, "GET", fmt.Sprintf("/api/activities/%s", deletedFirst.Id))
+	assert.Equal(t, http.StatusNotFound, rr.Code)
+	trashed, _ := strg.Activity.Read(ctx, testUserId, deletedFirst.Id)
+	assert.NotNil(t, trashed.DeletedAt)
+	earlier := trashed.DeletedAt.Add(-time.Hour)
+	trashed.DeletedAt = &earlier
+	strg.Activity.Update(ctx, *trashed)
+
+	rr = serveAs(t, planHandler, testUserId, "DELETE", fmt.Sprintf("/api/plans/%s", plan.Id))
+	assert.Equal(t, http.StatusOK, rr.Code)
+	active, _ := strg.Activity.Query(ctx, storage.ActivityStorageQuery{UserId: testUserId})
+	assert.Equal(t, 0, len(*active))
+
+	rr = serveAs(t, trashHandler, testUserId, "GET", "/api/trash")
+	assert.Equal(t, http.StatusOK, rr.Code)
+	var trash Trash
+	err := json.Unmarshal(rr.Body.Bytes(), &trash)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(*trash.Plans))
+	assert.Equal(t, 2, len(*trash.Activities))
+	assert.NotNil(t, (*trash.Plans)[0].DeletedAt)
+
+	// An activity cannot come back while its plan is in the trash
+	rr = serveAs(t, restoreHandler, testUserId, "POST", fmt.Sprintf("/api/trash/activities/%s/restore", kept.Id))
+	assert.Equal(t, http.StatusConflict, rr.Code)
+
+	rr = serveAs(t, restoreHandler, testUserId, "POST", fmt.Sprintf("/api/trash/plans/%s/restore", plan.Id))
+	assert.Equal(t, http.StatusOK, rr.Code)
+	active, _ = strg.Activity.Query(ctx, storage.ActivityStorageQuery{UserId: testUserId})
+	assert.Equal(t, 1, len(*active))
+	assert.Equal(t, kept.Id, (*active)[0].Id)
+
+	// The activity deleted before the plan stays in the trash until restored
+	rr = serveAs(t, restoreHandler, testUserId, "POST", fmt.Sprintf("/api/trash/plans/%s/restore", plan.Id))
+	assert.Equal(t, http.StatusNotFound, rr.Code)
+	rr = serveAs(t, restoreHandler, testUserId, "POST", fmt.Sprintf("/api/trash/activities/%s/restore", deletedFirst.Id))
+	assert.Equal(t, http.StatusOK, rr.Code)
+	active, _ = strg.Activity.Query(ctx, storage.ActivityStorageQuery{UserId: testUserId})
+	assert.Equal(t, 2, len(*active))
+}
+
+func TestMemoryStorageEmptyTrashHandler(t *testing.T) {
+	ctx := context.Background()
+	strg := storage.NewMemoryStorage()
+	testUserId := "some-valid-expected-userid"
+	otherUserId := "some-other-userid"
+
+	plan, _ := strg.Plan.Create(ctx, storage.Plan{UserId: testUserId, Name: "plan"})
+	planActivity, _ := strg.Activity.Create(ctx, storage.Activity{UserId: testUserId, PlanId: &plan.Id})
+	recurring, _ := strg.RecurringActivity.Create(ctx, storage.RecurringActivity{UserId: testUserId, RecurrEachDays: 7})
+	kept, _ := strg.Activity.Create(ctx, storage.Activity{UserId: testUserId})
+	otherUsers, _ := strg.Activity.Create(ctx, storage.Activity{UserId: otherUserId})
+
+	planHandler := http.Handler(registerPlanId(strg.Plan, strg.Activity, strg.RecurringActivity, strg.UnitOfWork, strg.Audit))
+	recurringHandler := http.Handler(registerRecurringActivityId(strg.RecurringActivity, strg.Plan))
+	activityHandler := http.Handler(registerActivityId(strg.Activity, strg.Plan, strg.Audit))
+	trashHandler := http.Handler(registerTrashRoot(strg.Plan, strg.Activity, strg.RecurringActivity))
+
+	rr := serveAs(t, planHandler, testUserId, "DELETE", fmt.Sprintf("/api/plans/%s", plan.Id))
+	assert.Equal(t, http.StatusOK, rr.Code)
+	rr = serveAs(t, recurringHandler, testUserId, "DELETE", fmt.Sprintf("/api/recurring_activities/%s", recurring.Id))
+	assert.Equal(t, http.StatusOK, rr.Code)
+	rr = serveAs(t, activityHandler, otherUserId, "DELETE", fmt.Sprintf("/api/activities/%s", otherUsers.Id))
+	assert.Equal(t, http.StatusOK, rr.Code)
+
+	rr = serveAs(t, trashHandler, testUserId, "DELETE", "/api/trash")
+	assert.Equal(t, http.StatusOK, rr.Code)
+
+	readPlan, _ := strg.Plan.Read(ctx, testUserId, plan.Id)
+	assert.Nil(t, readPlan)
+	readActivity, _ := strg.Activity.Read(ctx, testUserId, planActivity.Id)
+	assert.Nil(t, readActivity)
+	readRecurring, _ := strg.RecurringActivity.Read(ctx, testUserId, recurring.Id)
+	assert.Nil(t, readRecurring)
+	readActivity, _ = strg.Activity.Read(ctx, testUserId, kept.Id)
+	assert.NotNil(t, readActivity)
+	// Only the requesting user's trash is emptied
+	readActivity, _ = strg.Activity.Read(ctx, otherUserId, otherUsers.Id)
+	assert.NotNil(t, readActivity)
+	assert.NotNil(t, readActivity.DeletedAt)
+}
+
+func TestMemoryStorageDeletePlanFinishesTrashingHandler(t *testing.T) {
+	ctx := context.Background()
+	strg := storage.NewMemoryStorage()
+	testUserId := "some-valid-expected-userid"
+
+	plan, _ := strg.Plan.Create(ctx, storage.Plan{UserId: testUserId, Name: "plan"})
+	activity, _ := strg.Activity.Create(ctx, storage.Activity{UserId: testUserId, PlanId: &plan.Id, DateTime: time.Date(2023, 5, 1, 10, 0, 0, 0, time.UTC)})
+	// As left behind by a delete that failed after trashing the plan
+	deletedAt := time.Date(2023, 6, 1, 12, 0, 0, 0, time.UTC)
+	plan.DeletedAt = &deletedAt
+	strg.Plan.Update(ctx, plan)
+
+	planHandler := http.Handler(registerPlanId(strg.Plan, strg.Activity, strg.RecurringActivity, strg.UnitOfWork, strg.Audit))
+	rr := serveAs(t, planHandler, testUserId, "DELETE", fmt.Sprintf("/api/plans/%s", plan.Id))
+	assert.Equal(t, http.StatusOK, rr.Code)
+	trashed, _ := strg.Activity.Read(ctx, testUserId, activity.Id)
+	assert.NotNil(t, trashed.DeletedAt)
+	assert.True(t, deletedAt.Equal(*trashed.DeletedAt))
+
+	rr = serveAs(t, planHandler, testUserId, "DELETE", fmt.Sprintf("/api/plans/%s", plan.Id))
+	assert.Equal(t, http.StatusNotFound, rr.Code)
+}
+
+func TestRestoreActivityReportsPlanReadErrorsHandler(t *testing.T) {
+	testUserId := "some-valid-expected-userid"
+	planId := uuid.New()
+	deletedAt := time.Now()
+	trashed := storage.Activity{Id: uuid.New(), UserId: testUserId, PlanId: &planId, DeletedAt: &deletedAt}
+
+	mockActivityStorage := storage.NewMockActivityStorage(t)
+	mockActivityStorage.EXPECT().Read(mock.Anything, testUserId, trashed.Id).Return(&trashed, nil)
+	mockPlanStorage := storage.NewMockPlanStorage(t)
+	mockPlanStorage.EXPECT().Read(mock.Anything, testUserId, planId).Return(nil, fmt.Errorf("%w: database is locked", storage.ErrUnavailable)).Once()
+	tx := storage.Storage{Activity: mockActivityStorage, Plan: mockPlanStorage}
+	mockUow := storage.NewMockUnitOfWork(t)
+	mockUow.EXPECT().Do(mock.Anything, mock.Anything).RunAndReturn(func(ctx context.Context, fn func(storage.Storage) error) error {
+		return fn(tx)
+	}).Times(2)
+	restoreHandler := http.Handler(registerTrashRestore(mockPlanStorage, mockActivityStorage, storage.NewMockRecurringActivityStorage(t), mockUow))
+
+	rr := serveAs(t, restoreHandler, testUserId, "POST", fmt.Sprintf("/api/trash/activities/%s/restore", trashed.Id))
+	assert.Equal(t, http.StatusServiceUnavailable, rr.Code)
+
+	// A plan deleted for good is missing rather than in the trash
+	mockPlanStorage.EXPECT().Read(mock.Anything, testUserId, planId).Return(nil, nil).Once()
+	rr = serveAs(t, restoreHandler, testUserId, "POST", fmt.Sprintf("/api/trash/activities/%s/restore", trashed.Id))
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+}
@@ -1,15 +1,20 @@
 package main
 
 import (
+	"context"
+	"crypto/rand"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
 	"net/http"
 	"os"
+	"os/signal"
 	"planner/handlers"
 	"planner/middlewares"
 	"planner/storage"
+	"syscall"
+	"time"
 )
 
 func serveFile(w http.ResponseWriter, r *http.Request, f *os.File) {
@@ -65,7 +70,26 @@ func getUserInfo(w http.ResponseWriter, r *http.Request) {
 	w.Write(jsonData)
 }
 
+func storageTypeFromEnv() storage.StorageType {
+	storageType := storage.Sqlite
+	storageTypeSetting := os.Getenv("PLANNER_STORAGE_TYPE")
+	if storageTypeSetting == string(storage.Cassandra) {
+		storageType = storage.Cassandra
+	}
+	if storageTypeSetting == string(storage.Postgres) {
+		storageType = storage.Postgres
+	}
+	if storageTypeSetting == string(storage.Memory) {
+		storageType = storage.Memory
+	}
+	return storageType
+}
+
 func main() {
+	if len(os.Args) > 1 {
+		os.Exit(runCommand(os.Args[1:]))
+	}
+
 	mux := http.NewServeMux()
 
 	useridHeader := os.Getenv("PLANNER_USERID_HEADER")
@@ -74,14 +98,27 @@ func main() {
 		useridHeader = "x-planner-userid"
 	}
 
-	storageType := storage.Sqlite
-	storageTypeSetting := os.Getenv("PLANNER_STORAGE_TYPE")
-	if storageTypeSetting == string(storage.Cassandra) {
-		storageType = storage.Cassandra
+	purgeSecret := []byte(os.Getenv("PLANNER_PURGE_SECRET"))
+	if len(purgeSecret) == 0 {
+		purgeSecret = make([]byte, 32)
+		_, err := rand.Read(purgeSecret)
+		if err != nil {
+			fmt.Printf("error starting server: %s\n", err)
+			os.Exit(1)
+		}
+	}
+
+	backupSchedule, err := storage.BackupScheduleFromEnv()
+	if err == nil && backupSchedule.Interval > 0 && storageTypeFromEnv() != storage.Sqlite {
+		err = errors.New("scheduled backups need sqlite storage")
+	}
+	if err != nil {
+		fmt.Printf("error starting server: %s\n", err)
+		os.Exit(1)
 	}
 
 	useridMiddleware := middlewares.RequiresUserIdHeader(useridHeader)
-	storage, err := storage.GetStorage(storageType)
+	storage, err := storage.GetStorage(storageTypeFromEnv())
 
 	if err != nil {
 		fmt.Printf("error starting server: %s\n", err)
@@ -92,9 +129,13 @@ func main() {
 
 	mux.Handle("/api/whoami", useridMiddleware(http.HandlerFunc(getUserInfo)))
 
-	handlers.AddActivityHandlers(mux, storage.Activity, storage.Plan, useridMiddleware)
-	handlers.AddPlanHandlers(mux, storage.Plan, storage.Activity, storage.RecurringActivity, useridMiddleware)
-	handlers.AddRecurringActivityHandlers(mux, storage.RecurringActivity, storage.Plan, useridMiddleware)
+	handlers.AddActivityHandlers(mux, storage.Activity, storage.Plan, storage.Audit, useridMiddleware)
+	handlers.AddPlanHandlers(mux, storage.Plan, storage.Activity, storage.RecurringActivity, storage.UnitOfWork, storage.Audit, useridMiddleware)
+	handlers.AddRecurringActivityHandlers(mux, storage.RecurringActivity, storage.Activity, storage.Plan, useridMiddleware)
+	handlers.AddTrashHandlers(mux, storage.Plan, storage.Activity, storage.RecurringActivity, storage.UnitOfWork, useridMiddleware)
+	handlers.AddPurgeHandlers(mux, storage, purgeSecret, useridMiddleware)
+	handlers.AddExportHandlers(mux, storage, useridMiddleware)
+	handlers.AddBackupHandlers(mux, storage, backupSchedule, os.Getenv("PLANNER_ADMIN_TOKEN"))
 
 	mux.HandleFunc("/", getPublicFile)
 	mux.HandleFunc("*", getPublicFile)
@@ -104,11 +145,39 @@ func main() {
 	if portSetting != "" {
 		port = portSetting
 	}
-	err = http.ListenAndServe(":"+port, mux)
+	server := &http.Server{Addr: ":" + port, Handler: mux}
+
+	// Stop taking requests on SIGINT/SIGTERM, let in-flight ones and backups
+	// finish and only then release the storage connections
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	backupsDone := make(chan struct{})
+	go func() {
+		defer close(backupsDone)
+		backupSchedule.Run(ctx, storage)
+	}()
+	shutdownDone := make(chan struct{})
+	go func() {
+		defer close(shutdownDone)
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+		defer cancel()
+		server.Shutdown(shutdownCtx)
+	}()
+
+	err = server.ListenAndServe()
 	if errors.Is(err, http.ErrServerClosed) {
+		<-shutdownDone
+		<-backupsDone
 		fmt.Printf("server closed\n")
 	} else if err != nil {
 		fmt.Printf("error starting server: %s\n", err)
+		storage.Close()
+		os.Exit(1)
+	}
+	err = storage.Close()
+	if err != nil {
+		fmt.Printf("error closing storage: %s\n", err)
 		os.Exit(1)
 	}
 }
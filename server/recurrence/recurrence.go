@@ -0,0 +1,127 @@
+// Package recurrence expands recurring activities into the concrete dates they
+// fall on.
+package recurrence
+
+import (
+	"planner/storage"
+	"sort"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const day = 24 * time.Hour
+
+// Occurrence is one date a recurring activity falls on. Once an activity has
+// been recorded for it the activity's details replace the recurring
+// activity's.
+type Occurrence struct {
+	RecurringActivityId uuid.UUID `json:"recurringActivityId"`
+	// ActivityId is the activity recorded for the occurrence, if any
+	ActivityId   *uuid.UUID              `json:"activityId,omitempty"`
+	PlanId       *uuid.UUID              `json:"planId"`
+	Date         string                  `json:"date"`
+	DateTime     time.Time               `json:"dateTime"`
+	Summary      string                  `json:"summary"`
+	Stages       []storage.ActivityStage `json:"stages"`
+	TimeRelevant bool                    `json:"timeRelevant"`
+	Completed    bool                    `json:"completed"`
+}
+
+// Dates returns the times activity recurs at from start, included, until end,
+// excluded. An RRule is evaluated from DateTimeStart, a rule that doesn't
+// parse never recurs. Without one it recurs every RecurrEachDays days from
+// DateTimeStart, or only once when RecurrEachDays is below 1.
+func Dates(activity storage.RecurringActivity, start time.Time, end time.Time) []time.Time {
+	dates := make([]time.Time, 0)
+	if activity.RRule != "" {
+		rule, err := parseRule(activity.RRule, activity.DateTimeStart)
+		if err != nil {
+			return dates
+		}
+		for _, date := range rule.Between(start, end, true) {
+			if date.Before(end) {
+				dates = append(dates, date)
+			}
+		}
+		return dates
+	}
+	first := activity.DateTimeStart.UTC()
+	if activity.RecurrEachDays < 1 {
+		if !first.Before(start) && first.Before(end) {
+			dates = append(dates, first)
+		}
+		return dates
+	}
+	// Counted in UTC days, so the time of day never drifts
+	each := int(activity.RecurrEachDays)
+	n := 0
+	if start.After(first) {
+		n = int(start.Sub(first) / (day * time.Duration(each)))
+	}
+	for date := first.AddDate(0, 0, n*each); date.Before(end); date = first.AddDate(0, 0, n*each) {
+		if !date.Before(start) {
+			dates = append(dates, date)
+		}
+		n++
+	}
+	return dates
+}
+
+// Date is the UTC calendar date of t, which is how occurrences and the
+// activities recorded for them are matched
+func Date(t time.Time) string {
+	return t.UTC().Format(time.DateOnly)
+}
+
+// Schedule expands recurring from start until end and merges each occurrence
+// with the first of activities linked to it on the same date. Activities not
+// linked to any of recurring are ignored.
+func Schedule(recurring []storage.RecurringActivity, activities []storage.Activity, start time.Time, end time.Time) []Occurrence {
+	type key struct {
+		recurringActivityId uuid.UUID
+		date                string
+	}
+	recorded := make(map[key]storage.Activity)
+	for _, activity := range activities {
+		if activity.RecurringActivityId == nil {
+			continue
+		}
+		k := key{*activity.RecurringActivityId, Date(activity.DateTime)}
+		if existing, ok := recorded[k]; !ok || activity.DateTime.Before(existing.DateTime) {
+			recorded[k] = activity
+		}
+	}
+
+	occurrences := make([]Occurrence, 0)
+	for _, activity := range recurring {
+		for _, date := range Dates(activity, start, end) {
+			occurrence := Occurrence{
+				RecurringActivityId: activity.Id,
+				PlanId:              activity.PlanId,
+				Date:                Date(date),
+				DateTime:            date,
+				Summary:             activity.Summary,
+				Stages:              activity.Stages,
+				TimeRelevant:        activity.TimeRelevant,
+			}
+			if recordedActivity, ok := recorded[key{activity.Id, occurrence.Date}]; ok {
+				occurrence.ActivityId = &recordedActivity.Id
+				occurrence.PlanId = recordedActivity.PlanId
+				occurrence.DateTime = recordedActivity.DateTime
+				occurrence.Summary = recordedActivity.Summary
+				occurrence.Stages = recordedActivity.Stages
+				occurrence.TimeRelevant = recordedActivity.TimeRelevant
+				occurrence.Completed = recordedActivity.Completed
+			}
+			occurrences = append(occurrences, occurrence)
+		}
+	}
+	sort.SliceStable(occurrences, func(i, j int) bool {
+		if occurrences[i].Date != occurrences[j].Date {
+			return occurrences[i].Date < occurrences[j].Date
+		}
+		return occurrences[i].DateTime.Before(occurrences[j].DateTime)
+	})
+	return occurrences
+}
@@ -0,0 +1,59 @@
+package recurrence
+
+import (
+	"planner/storage"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+)
+
+func utc(day int, hour int) time.Time {
+	return time.Date(2023, 5, day, hour, 0, 0, 0, time.UTC)
+}
+
+func TestDatesRecurEveryNDays(t *testing.T) {
+	activity := storage.RecurringActivity{RecurrEachDays: 3, DateTimeStart: utc(1, 7)}
+
+	assert.Equal(t, []time.Time{utc(4, 7), utc(7, 7), utc(10, 7)}, Dates(activity, utc(2, 0), utc(11, 0)))
+	assert.Equal(t, []time.Time{utc(1, 7), utc(4, 7)}, Dates(activity, utc(1, 7), utc(7, 7)), "the start is included and the end excluded")
+	assert.Equal(t, []time.Time{}, Dates(activity, utc(5, 0), utc(7, 0)))
+	assert.Equal(t, []time.Time{utc(1, 7)}, Dates(activity, time.Date(2023, 4, 1, 0, 0, 0, 0, time.UTC), utc(2, 0)), "nothing recurs before DateTimeStart")
+}
+
+func TestDatesKeepTheTimeOfDay(t *testing.T) {
+	start := time.Date(2023, 3, 20, 8, 30, 0, 0, time.FixedZone("UTC+2", 2*60*60))
+	activity := storage.RecurringActivity{RecurrEachDays: 7, DateTimeStart: start}
+
+	dates := Dates(activity, time.Date(2023, 10, 1, 0, 0, 0, 0, time.UTC), time.Date(2023, 10, 10, 0, 0, 0, 0, time.UTC))
+
+	assert.Equal(t, []time.Time{time.Date(2023, 10, 2, 6, 30, 0, 0, time.UTC), time.Date(2023, 10, 9, 6, 30, 0, 0, time.UTC)}, dates)
+}
+
+func TestDatesWithoutRecurrenceOccurOnce(t *testing.T) {
+	activity := storage.RecurringActivity{DateTimeStart: utc(1, 7)}
+
+	assert.Equal(t, []time.Time{utc(1, 7)}, Dates(activity, utc(1, 0), utc(30, 0)))
+	assert.Equal(t, []time.Time{}, Dates(activity, utc(2, 0), utc(30, 0)))
+}
+
+func TestScheduleMergesRecordedActivities(t *testing.T) {
+	planId := uuid.New()
+	recordedPlanId := uuid.New()
+	running := storage.RecurringActivity{Id: uuid.New(), PlanId: &planId, Summary: "Run", Stages: []storage.ActivityStage{}, RecurrEachDays: 2, DateTimeStart: utc(1, 7)}
+	swimming := storage.RecurringActivity{Id: uuid.New(), Summary: "Swim", Stages: []storage.ActivityStage{}, RecurrEachDays: 7, DateTimeStart: utc(2, 18)}
+	recorded := storage.Activity{Id: uuid.New(), RecurringActivityId: &running.Id, PlanId: &recordedPlanId, Summary: "Long run", Completed: true, DateTime: utc(3, 9)}
+	later := storage.Activity{Id: uuid.New(), RecurringActivityId: &running.Id, Summary: "Second run", DateTime: utc(3, 20)}
+	unlinked := storage.Activity{Id: uuid.New(), Summary: "Walk", DateTime: utc(5, 9)}
+	otherDay := storage.Activity{Id: uuid.New(), RecurringActivityId: &swimming.Id, Summary: "Moved swim", DateTime: utc(3, 18)}
+
+	occurrences := Schedule([]storage.RecurringActivity{swimming, running}, []storage.Activity{later, unlinked, recorded, otherDay}, utc(1, 0), utc(6, 0))
+
+	assert.Equal(t, []Occurrence{
+		{RecurringActivityId: running.Id, PlanId: &planId, Date: "2023-05-01", DateTime: utc(1, 7), Summary: "Run", Stages: []storage.ActivityStage{}},
+		{RecurringActivityId: swimming.Id, Date: "2023-05-02", DateTime: utc(2, 18), Summary: "Swim", Stages: []storage.ActivityStage{}},
+		{RecurringActivityId: running.Id, ActivityId: &recorded.Id, PlanId: &recordedPlanId, Date: "2023-05-03", DateTime: utc(3, 9), Summary: "Long run", Completed: true},
+		{RecurringActivityId: running.Id, PlanId: &planId, Date: "2023-05-05", DateTime: utc(5, 7), Summary: "Run", Stages: []storage.ActivityStage{}},
+	}, occurrences)
+}
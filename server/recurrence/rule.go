@@ -0,0 +1,53 @@
+package recurrence
+
+import (
+	"errors"
+	"fmt"
+	"planner/storage"
+	"strings"
+	"time"
+
+	"github.com/teambition/rrule-go"
+)
+
+var ErrInvalidRule = errors.New("invalid rrule")
+
+// parseRule reads an RFC 5545 RRULE, with or without its "RRULE:" prefix,
+// starting at dtstart. Occurrences are matched to activities by date, so rules
+// recurring more than once a day are rejected.
+func parseRule(rule string, dtstart time.Time) (*rrule.RRule, error) {
+	if strings.ContainsAny(rule, "\r\n") {
+		return nil, fmt.Errorf("%w: expected a single RRULE line", ErrInvalidRule)
+	}
+	options, err := rrule.StrToROption(rule)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrInvalidRule, err)
+	}
+	if !options.Dtstart.IsZero() {
+		return nil, fmt.Errorf("%w: DTSTART is taken from dateTimeStart", ErrInvalidRule)
+	}
+	if options.Freq > rrule.DAILY {
+		return nil, fmt.Errorf("%w: FREQ may be at most DAILY", ErrInvalidRule)
+	}
+	if len(options.Byhour) > 0 || len(options.Byminute) > 0 || len(options.Bysecond) > 0 {
+		return nil, fmt.Errorf("%w: BYHOUR, BYMINUTE and BYSECOND are not supported", ErrInvalidRule)
+	}
+	options.Dtstart = dtstart.UTC()
+	parsed, err := rrule.NewRRule(*options)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrInvalidRule, err)
+	}
+	return parsed, nil
+}
+
+// Validate checks the recurrence of activity can be expanded
+func Validate(activity storage.RecurringActivity) error {
+	if activity.RRule == "" {
+		return nil
+	}
+	if activity.DateTimeStart.IsZero() {
+		return fmt.Errorf("%w: an rrule needs a dateTimeStart", ErrInvalidRule)
+	}
+	_, err := parseRule(activity.RRule, activity.DateTimeStart)
+	return err
+}
@@ -0,0 +1,66 @@
+package recurrence
+
+import (
+	"errors"
+	"planner/storage"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func date(year int, month time.Month, day int) time.Time {
+	return time.Date(year, month, day, 6, 0, 0, 0, time.UTC)
+}
+
+func TestDatesFollowTheRule(t *testing.T) {
+	// Monday the 1st of May 2023
+	start := date(2023, 5, 1)
+	cases := []struct {
+		rule     string
+		expected []time.Time
+	}{
+		{"FREQ=WEEKLY;BYDAY=TU,TH", []time.Time{date(2023, 5, 2), date(2023, 5, 4), date(2023, 5, 9), date(2023, 5, 11)}},
+		{"RRULE:FREQ=WEEKLY;INTERVAL=2;BYDAY=SA", []time.Time{date(2023, 5, 6)}},
+		{"FREQ=MONTHLY;BYDAY=1SU", []time.Time{date(2023, 5, 7)}},
+		{"FREQ=DAILY;COUNT=3", []time.Time{date(2023, 5, 1), date(2023, 5, 2), date(2023, 5, 3)}},
+		{"FREQ=DAILY;UNTIL=20230502T235959Z", []time.Time{date(2023, 5, 1), date(2023, 5, 2)}},
+	}
+	for _, c := range cases {
+		activity := storage.RecurringActivity{RRule: c.rule, RecurrEachDays: 1, DateTimeStart: start}
+
+		assert.Equal(t, c.expected, Dates(activity, start, date(2023, 5, 14)), c.rule)
+	}
+}
+
+func TestDatesOfARuleExcludeTheEnd(t *testing.T) {
+	activity := storage.RecurringActivity{RRule: "FREQ=WEEKLY;BYDAY=MO", DateTimeStart: date(2023, 5, 1)}
+
+	assert.Equal(t, []time.Time{date(2023, 5, 8)}, Dates(activity, date(2023, 5, 8), date(2023, 5, 15)))
+	assert.Equal(t, []time.Time{}, Dates(activity, date(2023, 4, 1), date(2023, 5, 1)), "nothing recurs before DateTimeStart")
+}
+
+func TestValidate(t *testing.T) {
+	start := date(2023, 5, 1)
+	valid := []string{"", "FREQ=WEEKLY;BYDAY=TU,TH", "RRULE:FREQ=MONTHLY;BYSETPOS=1;BYDAY=SU", "FREQ=YEARLY;COUNT=2"}
+	for _, rule := range valid {
+		assert.NoError(t, Validate(storage.RecurringActivity{RRule: rule, DateTimeStart: start}), rule)
+	}
+
+	invalid := []string{
+		"BYDAY=TU",
+		"FREQ=FORTNIGHTLY",
+		"FREQ=WEEKLY;BYDAY=XX",
+		"FREQ=HOURLY",
+		"FREQ=DAILY;BYHOUR=6,18",
+		"FREQ=DAILY;DTSTART=20230501T000000Z",
+		"DTSTART:20230501T000000Z\nRRULE:FREQ=DAILY",
+	}
+	for _, rule := range invalid {
+		err := Validate(storage.RecurringActivity{RRule: rule, DateTimeStart: start})
+		assert.True(t, errors.Is(err, ErrInvalidRule), "%q: %v", rule, err)
+	}
+
+	err := Validate(storage.RecurringActivity{RRule: "FREQ=DAILY"})
+	assert.True(t, errors.Is(err, ErrInvalidRule), "a rule needs a start")
+}
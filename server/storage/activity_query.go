@@ -0,0 +1,171 @@
+package storage
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/google/uuid"
+)
+
+type SortOrder string
+
+const (
+	Ascending  SortOrder = "asc"
+	Descending SortOrder = "desc"
+)
+
+func containsPlanId(planIds []uuid.UUID, planId *uuid.UUID) bool {
+	if planId == nil {
+		return false
+	}
+	for _, id := range planIds {
+		if id == *planId {
+			return true
+		}
+	}
+	return false
+}
+
+// activePlan tells whether ActivePlansOnly selects the plan's activities.
+// Archived and trashed plans never count, whatever their Active flag says.
+func activePlan(plan Plan) bool {
+	return plan.Active && plan.ArchivedAt == nil && plan.DeletedAt == nil
+}
+
+// matchesActivityQuery applies the query filters in Go, for backends that
+// cannot express them all in the database. activePlans holds the ids of the
+// user's active plans, see activePlan, and is only consulted when
+// ActivePlansOnly is set.
+func matchesActivityQuery(activity Activity, query ActivityStorageQuery, activePlans []uuid.UUID) bool {
+	if activity.UserId != query.UserId {
+		return false
+	}
+	if (activity.DeletedAt != nil) != query.Trashed {
+		return false
+	}
+	if query.PlanId != nil && (activity.PlanId == nil || *activity.PlanId != *query.PlanId) {
+		return false
+	}
+	if len(query.PlanIds) > 0 && !containsPlanId(query.PlanIds, activity.PlanId) {
+		return false
+	}
+	if query.NoPlan && activity.PlanId != nil {
+		return false
+	}
+	if query.ActivePlansOnly && !containsPlanId(activePlans, activity.PlanId) {
+		return false
+	}
+	if query.RecurringActivityId != nil && (activity.RecurringActivityId == nil || *activity.RecurringActivityId != *query.RecurringActivityId) {
+		return false
+	}
+	if query.Completed != nil && activity.Completed != *query.Completed {
+		return false
+	}
+	if query.DateRange != nil && !(activity.DateTime.After(query.DateRange.Start) && activity.DateTime.Before(query.DateRange.End)) {
+		return false
+	}
+	if len(query.Metrics) > 0 {
+		totals := metricTotals(activity.Stages)
+		for _, filter := range query.Metrics {
+			total, ok := totals[filter.Unit]
+			if !ok || (filter.Min != nil && total < *filter.Min) || (filter.Max != nil && total > *filter.Max) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// metricTotals adds up the metric amounts of each unit, counting a stage once
+// per repetition. Stages without repetitions count once.
+func metricTotals(stages []ActivityStage) map[string]int {
+	totals := make(map[string]int)
+	for _, stage := range stages {
+		repetitions := stage.Repetitions
+		if repetitions < 1 {
+			repetitions = 1
+		}
+		for _, metric := range stage.Metrics {
+			totals[metric.Unit] += metric.Amount * repetitions
+		}
+	}
+	return totals
+}
+
+func trashedSQL(trashed bool) string {
+	if trashed {
+		return "deletedAt IS NOT NULL"
+	}
+	return "deletedAt IS NULL"
+}
+
+// metricTotalSQL is metricTotals over the normalised stage tables
+const metricTotalSQL = "SUM(m.amount * CASE WHEN s.repetitions > 1 THEN s.repetitions ELSE 1 END)"
+
+// activityQuerySQL builds the WHERE, ORDER BY and LIMIT clauses for an
+// activity query. placeholder renders the nth (1-based) bind parameter so the
+// same filters serve both sqlite and postgres.
+func activityQuerySQL(query ActivityStorageQuery, placeholder func(int) string) (string, []interface{}) {
+	params := []interface{}{}
+	param := func(value interface{}) string {
+		params = append(params, value)
+		return placeholder(len(params))
+	}
+	conditions := []string{"userId = " + param(query.UserId), trashedSQL(query.Trashed)}
+	if query.PlanId != nil {
+		conditions = append(conditions, "planId = "+param(*query.PlanId))
+	}
+	if len(query.PlanIds) > 0 {
+		placeholders := make([]string, len(query.PlanIds))
+		for i, planId := range query.PlanIds {
+			placeholders[i] = param(planId)
+		}
+		conditions = append(conditions, "planId IN ("+strings.Join(placeholders, ", ")+")")
+	}
+	if query.NoPlan {
+		conditions = append(conditions, "planId IS NULL")
+	}
+	if query.ActivePlansOnly {
+		conditions = append(conditions, "planId IN (SELECT id FROM plans WHERE userId = "+param(query.UserId)+" AND active = "+param(true)+" AND archivedAt IS NULL AND deletedAt IS NULL)")
+	}
+	if query.RecurringActivityId != nil {
+		conditions = append(conditions, "recurringActivityId = "+param(*query.RecurringActivityId))
+	}
+	if query.Completed != nil {
+		conditions = append(conditions, "completed = "+param(*query.Completed))
+	}
+	if query.DateRange != nil {
+		conditions = append(conditions, "dateTime > "+param(query.DateRange.Start), "dateTime < "+param(query.DateRange.End))
+	}
+	for _, filter := range query.Metrics {
+		unit := param(filter.Unit)
+		bounds := []string{"COUNT(*) > 0"}
+		if filter.Min != nil {
+			bounds = append(bounds, metricTotalSQL+" >= "+param(*filter.Min))
+		}
+		if filter.Max != nil {
+			bounds = append(bounds, metricTotalSQL+" <= "+param(*filter.Max))
+		}
+		conditions = append(conditions, `id IN (
+		SELECT m.activityId
+		FROM activity_stage_metrics m
+		JOIN activity_stages s ON s.activityId = m.activityId AND s.position = m.stagePosition
+		WHERE m.unit = `+unit+`
+		GROUP BY m.activityId
+		HAVING `+strings.Join(bounds, " AND ")+`)`)
+	}
+	direction, comparison := "ASC", ">"
+	if query.Order == Descending {
+		direction, comparison = "DESC", "<"
+	}
+	if query.Cursor != nil {
+		conditions = append(conditions, "(dateTime "+comparison+" "+param(query.Cursor.DateTime)+
+			" OR (dateTime = "+param(query.Cursor.DateTime)+" AND id "+comparison+" "+param(query.Cursor.Id)+"))")
+	}
+	clauses := "WHERE " + strings.Join(conditions, "\n\tAND ") +
+		"\n\tORDER BY dateTime " + direction + ", id " + direction
+	if query.Limit > 0 {
+		clauses = clauses + "\n\tLIMIT " + strconv.Itoa(query.Limit)
+	}
+	return clauses, params
+}
@@ -0,0 +1,318 @@
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// ARCHIVE_VERSION is bumped whenever the archive layout changes in a way
+// older importers can't read.
+const ARCHIVE_VERSION = 1
+
+// Archive is the portable copy of everything a user has stored, trashed and
+// archived items included.
+type Archive struct {
+	Version             int                 `json:"version"`
+	ExportedAt          time.Time           `json:"exportedAt"`
+	Plans               []Plan              `json:"plans"`
+	Activities          []Activity          `json:"activities"`
+	RecurringActivities []RecurringActivity `json:"recurringActivities"`
+}
+
+// ARCHIVE_PAGE_SIZE is how many activities are read at a time while streaming
+// an archive.
+const ARCHIVE_PAGE_SIZE = 500
+
+// LoadArchive reads the user's plans and recurring activities, activities are
+// left out as StreamArchive pages through them while writing.
+func LoadArchive(ctx context.Context, strg Storage, userId string) (Archive, error) {
+	archive := Archive{
+		Version:             ARCHIVE_VERSION,
+		ExportedAt:          time.Now().UTC(),
+		Plans:               []Plan{},
+		Activities:          []Activity{},
+		RecurringActivities: []RecurringActivity{},
+	}
+	for _, trashed := range []bool{false, true} {
+		plans, err := strg.Plan.Query(ctx, PlanStorageQuery{UserId: userId, Trashed: trashed, IncludeArchived: true})
+		if err != nil {
+			return archive, err
+		}
+		recurringActivities, err := strg.RecurringActivity.Query(ctx, RecurringActivityStorageQuery{UserId: userId, Trashed: trashed})
+		if err != nil {
+			return archive, err
+		}
+		archive.Plans = append(archive.Plans, *plans...)
+		archive.RecurringActivities = append(archive.RecurringActivities, *recurringActivities...)
+	}
+	return archive, nil
+}
+
+// StreamArchive writes the archive as JSON, followed by the user's activities
+// read a page at a time so large accounts aren't held in memory at once.
+func StreamArchive(ctx context.Context, strg Storage, userId string, archive Archive, w io.Writer) error {
+	head, err := json.Marshal(struct {
+		Version             int                 `json:"version"`
+		ExportedAt          time.Time           `json:"exportedAt"`
+		Plans               []Plan              `json:"plans"`
+		RecurringActivities []RecurringActivity `json:"recurringActivities"`
+	}{archive.Version, archive.ExportedAt, archive.Plans, archive.RecurringActivities})
+	if err != nil {
+		return err
+	}
+	// Reopen the object to append the activities array
+	_, err = w.Write(append(head[:len(head)-1], []byte(`,"activities":[`)...))
+	if err != nil {
+		return err
+	}
+	first := true
+	err = forEachActivity(ctx, strg, userId, func(activity Activity) error {
+		jsonData, err := json.Marshal(activity)
+		if err != nil {
+			return err
+		}
+		if !first {
+			jsonData = append([]byte(","), jsonData...)
+		}
+		first = false
+		_, err = w.Write(jsonData)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	_, err = w.Write([]byte("]}\n"))
+	return err
+}
+
+// forEachActivity calls fn with every activity of the user, trashed ones
+// included, reading them a page at a time.
+func forEachActivity(ctx context.Context, strg Storage, userId string, fn func(Activity) error) error {
+	for _, trashed := range []bool{false, true} {
+		query := ActivityStorageQuery{UserId: userId, Trashed: trashed, Limit: ARCHIVE_PAGE_SIZE}
+		for {
+			page, err := strg.Activity.Query(ctx, query)
+			if err != nil {
+				return err
+			}
+			for _, activity := range *page {
+				err = fn(activity)
+				if err != nil {
+					return err
+				}
+			}
+			if len(*page) < ARCHIVE_PAGE_SIZE {
+				break
+			}
+			cursor := CursorAfter((*page)[len(*page)-1])
+			query.Cursor = &cursor
+		}
+	}
+	return nil
+}
+
+type ImportMode string
+
+const (
+	ImportMerge   ImportMode = "merge"
+	ImportReplace ImportMode = "replace"
+)
+
+type ImportSummary struct {
+	Plans               int `json:"plans"`
+	Activities          int `json:"activities"`
+	RecurringActivities int `json:"recurringActivities"`
+}
+
+// ValidateArchive checks the archive can be imported as a whole, so an import
+// doesn't fail half way through on a dangling link.
+func ValidateArchive(archive Archive) error {
+	if archive.Version != ARCHIVE_VERSION {
+		return fmt.Errorf("unsupported archive version %d, expected %d", archive.Version, ARCHIVE_VERSION)
+	}
+	plans := map[uuid.UUID]bool{}
+	for _, plan := range archive.Plans {
+		if plans[plan.Id] {
+			return fmt.Errorf("duplicate plan %s", plan.Id)
+		}
+		plans[plan.Id] = true
+	}
+	recurringActivities := map[uuid.UUID]bool{}
+	for _, activity := range archive.RecurringActivities {
+		if recurringActivities[activity.Id] {
+			return fmt.Errorf("duplicate recurring activity %s", activity.Id)
+		}
+		if activity.PlanId != nil && !plans[*activity.PlanId] {
+			return fmt.Errorf("recurring activity %s references unknown plan %s", activity.Id, activity.PlanId)
+		}
+		recurringActivities[activity.Id] = true
+	}
+	activities := map[uuid.UUID]bool{}
+	for _, activity := range archive.Activities {
+		if activities[activity.Id] {
+			return fmt.Errorf("duplicate activity %s", activity.Id)
+		}
+		activities[activity.Id] = true
+		if activity.PlanId != nil && !plans[*activity.PlanId] {
+			return fmt.Errorf("activity %s references unknown plan %s", activity.Id, activity.PlanId)
+		}
+	}
+	return nil
+}
+
+// ImportArchive recreates the archive for the user under fresh ids, keeping the
+// links between plans, recurring activities and activities. Activities may
+// outlive the recurring activity they were completed for, those links are
+// dropped. Replace removes the user's existing data first, merge adds to it.
+//
+// Every item is written on its own rather than in one unit of work, whose
+// Cassandra batch couldn't hold a whole account. The fresh ids are derived
+// from the archived ones and the user, so an import that failed part way is
+// completed by importing the same archive again. Merging leaves the items an
+// earlier import already created as they are, edits included, and replacing
+// writes them one version past the deleted ones, so ETags read before an
+// import never match what it wrote.
+func ImportArchive(ctx context.Context, strg Storage, userId string, archive Archive, mode ImportMode) (ImportSummary, error) {
+	summary := ImportSummary{}
+	err := ValidateArchive(archive)
+	if err != nil {
+		return summary, err
+	}
+	replaced := map[uuid.UUID]int64{}
+	if mode == ImportReplace {
+		replaced, err = storedVersions(ctx, strg, userId)
+		if err != nil {
+			return summary, err
+		}
+		err = strg.Activity.DeleteAllForUser(ctx, userId)
+		if err != nil {
+			return summary, err
+		}
+		err = strg.RecurringActivity.DeleteAllForUser(ctx, userId)
+		if err != nil {
+			return summary, err
+		}
+		err = strg.Plan.DeleteAllForUser(ctx, userId)
+		if err != nil {
+			return summary, err
+		}
+	}
+	planIds := map[uuid.UUID]uuid.UUID{}
+	for _, plan := range archive.Plans {
+		planIds[plan.Id] = importedId(userId, plan.Id)
+	}
+	recurringActivityIds := map[uuid.UUID]uuid.UUID{}
+	for _, activity := range archive.RecurringActivities {
+		recurringActivityIds[activity.Id] = importedId(userId, activity.Id)
+	}
+	for _, plan := range archive.Plans {
+		plan.Id = planIds[plan.Id]
+		stored, err := strg.Plan.Read(ctx, userId, plan.Id)
+		if err != nil {
+			return summary, err
+		}
+		if stored != nil {
+			continue
+		}
+		plan.UserId = userId
+		plan.Version = replaced[plan.Id] + 1
+		plan.UpdatedAt = versionTime(ctx)
+		err = strg.Plan.Put(ctx, plan)
+		if err != nil {
+			return summary, err
+		}
+	}
+	for _, activity := range archive.RecurringActivities {
+		activity.Id = recurringActivityIds[activity.Id]
+		stored, err := strg.RecurringActivity.Read(ctx, userId, activity.Id)
+		if err != nil {
+			return summary, err
+		}
+		if stored != nil {
+			continue
+		}
+		activity.UserId = userId
+		activity.PlanId = remapId(planIds, activity.PlanId)
+		activity.Version = replaced[activity.Id] + 1
+		activity.UpdatedAt = versionTime(ctx)
+		err = strg.RecurringActivity.Put(ctx, activity)
+		if err != nil {
+			return summary, err
+		}
+	}
+	for _, activity := range archive.Activities {
+		activity.Id = importedId(userId, activity.Id)
+		stored, err := strg.Activity.Read(ctx, userId, activity.Id)
+		if err != nil {
+			return summary, err
+		}
+		if stored != nil {
+			continue
+		}
+		activity.UserId = userId
+		activity.PlanId = remapId(planIds, activity.PlanId)
+		activity.RecurringActivityId = remapId(recurringActivityIds, activity.RecurringActivityId)
+		activity.Version = replaced[activity.Id] + 1
+		activity.UpdatedAt = versionTime(ctx)
+		err = strg.Activity.Put(ctx, activity)
+		if err != nil {
+			return summary, err
+		}
+	}
+	summary.Plans = len(archive.Plans)
+	summary.Activities = len(archive.Activities)
+	summary.RecurringActivities = len(archive.RecurringActivities)
+	return summary, nil
+}
+
+// storedVersions maps the ids of all the user's items, trashed and archived
+// ones included, to their versions
+func storedVersions(ctx context.Context, strg Storage, userId string) (map[uuid.UUID]int64, error) {
+	versions := map[uuid.UUID]int64{}
+	for _, trashed := range []bool{false, true} {
+		plans, err := strg.Plan.Query(ctx, PlanStorageQuery{UserId: userId, Trashed: trashed, IncludeArchived: true})
+		if err != nil {
+			return nil, err
+		}
+		for _, plan := range *plans {
+			versions[plan.Id] = plan.Version
+		}
+		recurringActivities, err := strg.RecurringActivity.Query(ctx, RecurringActivityStorageQuery{UserId: userId, Trashed: trashed})
+		if err != nil {
+			return nil, err
+		}
+		for _, activity := range *recurringActivities {
+			versions[activity.Id] = activity.Version
+		}
+		activities, err := strg.Activity.Query(ctx, ActivityStorageQuery{UserId: userId, Trashed: trashed})
+		if err != nil {
+			return nil, err
+		}
+		for _, activity := range *activities {
+			versions[activity.Id] = activity.Version
+		}
+	}
+	return versions, nil
+}
+
+// importedId is the id an archived item is imported under for the user, the
+// same every time the archive is imported
+func importedId(userId string, id uuid.UUID) uuid.UUID {
+	return uuid.NewSHA1(id, []byte(userId))
+}
+
+func remapId(ids map[uuid.UUID]uuid.UUID, id *uuid.UUID) *uuid.UUID {
+	if id == nil {
+		return nil
+	}
+	newId, ok := ids[*id]
+	if !ok {
+		return nil
+	}
+	return &newId
+}
@@ -0,0 +1,535 @@
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	AuditActivity          = "activity"
+	AuditRecurringActivity = "recurringActivity"
+	AuditPlan              = "plan"
+)
+
+const (
+	AuditCreate = "create"
+	AuditUpdate = "update"
+	AuditDelete = "delete"
+)
+
+// AuditEntry records one change to a stored item. OldValue is empty for
+// creations and NewValue for deletions.
+type AuditEntry struct {
+	Id         uuid.UUID       `json:"id"`
+	UserId     string          `json:"userId"`
+	EntityType string          `json:"entityType"`
+	EntityId   uuid.UUID       `json:"entityId"`
+	Action     string          `json:"action"`
+	OldValue   json.RawMessage `json:"oldValue,omitempty"`
+	NewValue   json.RawMessage `json:"newValue,omitempty"`
+	Timestamp  time.Time       `json:"timestamp"`
+}
+
+// AuditStorage is append-only, entries are only removed with the rest of a
+// user's data.
+//
+//go:generate mockery --name AuditStorage
+type AuditStorage interface {
+	Append(ctx context.Context, entry AuditEntry) error
+	// History returns the entries of one item, oldest first
+	History(ctx context.Context, userId string, entityType string, entityId uuid.UUID) (*[]AuditEntry, error)
+	// ForUser returns all the entries of the user, those of each item oldest
+	// first
+	ForUser(ctx context.Context, userId string) (*[]AuditEntry, error)
+	DeleteAllForUser(ctx context.Context, userId string) error
+}
+
+// withAudit wraps the item storages so every create, update, put and delete is
+// appended to strg.Audit in the same transaction or batch as the change. When
+// strg has a UnitOfWork each change runs in one of its own, otherwise strg is
+// taken to belong to a unit of work already. Deleting many items outside a
+// unit of work takes one per item, as a whole plan or account may be too large
+// for one.
+func withAudit(strg Storage) Storage {
+	strg.Activity = auditedActivityStorage{ActivityStorage: strg.Activity, audit: strg.Audit, uow: strg.UnitOfWork}
+	strg.RecurringActivity = auditedRecurringActivityStorage{RecurringActivityStorage: strg.RecurringActivity, audit: strg.Audit, uow: strg.UnitOfWork}
+	strg.Plan = auditedPlanStorage{PlanStorage: strg.Plan, audit: strg.Audit, uow: strg.UnitOfWork}
+	return strg
+}
+
+// unaudited strips the wrappers withAudit added, keeping the error kinds, for
+// writes that must not be recorded as changes, such as copying a user.
+func unaudited(strg Storage) Storage {
+	switch stg := strg.Activity.(type) {
+	case kindedActivityStorage:
+		stg.ActivityStorage = unaudited(Storage{Activity: stg.ActivityStorage}).Activity
+		strg.Activity = stg
+	case auditedActivityStorage:
+		strg.Activity = stg.ActivityStorage
+	}
+	switch stg := strg.RecurringActivity.(type) {
+	case kindedRecurringActivityStorage:
+		stg.RecurringActivityStorage = unaudited(Storage{RecurringActivity: stg.RecurringActivityStorage}).RecurringActivity
+		strg.RecurringActivity = stg
+	case auditedRecurringActivityStorage:
+		strg.RecurringActivity = stg.RecurringActivityStorage
+	}
+	switch stg := strg.Plan.(type) {
+	case kindedPlanStorage:
+		stg.PlanStorage = unaudited(Storage{Plan: stg.PlanStorage}).Plan
+		strg.Plan = stg
+	case auditedPlanStorage:
+		strg.Plan = stg.PlanStorage
+	}
+	return strg
+}
+
+func appendAudit(ctx context.Context, audit AuditStorage, userId string, entityType string, entityId uuid.UUID, action string, oldValue any, newValue any) error {
+	// Time based ids keep entries in order where the backend sorts by id
+	id, err := uuid.NewUUID()
+	if err != nil {
+		return err
+	}
+	entry := AuditEntry{
+		Id:         id,
+		UserId:     userId,
+		EntityType: entityType,
+		EntityId:   entityId,
+		Action:     action,
+		Timestamp:  versionTime(ctx),
+	}
+	if oldValue != nil {
+		entry.OldValue, err = json.Marshal(oldValue)
+		if err != nil {
+			return err
+		}
+	}
+	if newValue != nil {
+		entry.NewValue, err = json.Marshal(newValue)
+		if err != nil {
+			return err
+		}
+	}
+	return audit.Append(ctx, entry)
+}
+
+// putAction is the action of a put, which may or may not replace an item
+func putAction(replaced bool) string {
+	if replaced {
+		return AuditUpdate
+	}
+	return AuditCreate
+}
+
+// ignoreNotFound lets deleting many items skip those deleted in the meantime
+func ignoreNotFound(err error) error {
+	if errors.Is(err, ErrNotFound) {
+		return nil
+	}
+	return err
+}
+
+type auditedActivityStorage struct {
+	ActivityStorage
+	audit AuditStorage
+	uow   UnitOfWork
+}
+
+func (stg auditedActivityStorage) Create(ctx context.Context, activity Activity) (Activity, error) {
+	if stg.uow != nil {
+		var created Activity
+		err := stg.uow.Do(ctx, func(tx Storage) error {
+			var err error
+			created, err = tx.Activity.Create(ctx, activity)
+			return err
+		})
+		return created, err
+	}
+	created, err := stg.ActivityStorage.Create(ctx, activity)
+	if err != nil {
+		return created, err
+	}
+	return created, appendAudit(ctx, stg.audit, created.UserId, AuditActivity, created.Id, AuditCreate, nil, created)
+}
+
+func (stg auditedActivityStorage) Update(ctx context.Context, activity Activity) error {
+	if stg.uow != nil {
+		return stg.uow.Do(ctx, func(tx Storage) error {
+			return tx.Activity.Update(ctx, activity)
+		})
+	}
+	ctx = withVersionTime(ctx, versionTime(ctx))
+	old, err := stg.ActivityStorage.Read(ctx, activity.UserId, activity.Id)
+	if err != nil {
+		return err
+	}
+	err = stg.ActivityStorage.Update(ctx, activity)
+	if err != nil {
+		return err
+	}
+	updated := activity
+	updated.Version++
+	updated.UpdatedAt = versionTime(ctx)
+	return appendAudit(ctx, stg.audit, activity.UserId, AuditActivity, activity.Id, AuditUpdate, old, updated)
+}
+
+func (stg auditedActivityStorage) Delete(ctx context.Context, userId string, id uuid.UUID) error {
+	if stg.uow != nil {
+		return stg.uow.Do(ctx, func(tx Storage) error {
+			return tx.Activity.Delete(ctx, userId, id)
+		})
+	}
+	old, err := stg.ActivityStorage.Read(ctx, userId, id)
+	if err != nil {
+		return err
+	}
+	err = stg.ActivityStorage.Delete(ctx, userId, id)
+	if err != nil || old == nil {
+		return err
+	}
+	return appendAudit(ctx, stg.audit, userId, AuditActivity, id, AuditDelete, old, nil)
+}
+
+func (stg auditedActivityStorage) DeleteForPlan(ctx context.Context, userId string, planId uuid.UUID) error {
+	if stg.uow != nil {
+		return stg.deleteEach(ctx, ActivityStorageQuery{UserId: userId, PlanId: &planId})
+	}
+	deleted, err := stg.queryAll(ctx, ActivityStorageQuery{UserId: userId, PlanId: &planId})
+	if err != nil {
+		return err
+	}
+	err = stg.ActivityStorage.DeleteForPlan(ctx, userId, planId)
+	if err != nil {
+		return err
+	}
+	return stg.appendDeletes(ctx, deleted)
+}
+
+func (stg auditedActivityStorage) DeleteAllForUser(ctx context.Context, userId string) error {
+	if stg.uow != nil {
+		return stg.deleteEach(ctx, ActivityStorageQuery{UserId: userId})
+	}
+	deleted, err := stg.queryAll(ctx, ActivityStorageQuery{UserId: userId})
+	if err != nil {
+		return err
+	}
+	err = stg.ActivityStorage.DeleteAllForUser(ctx, userId)
+	if err != nil {
+		return err
+	}
+	return stg.appendDeletes(ctx, deleted)
+}
+
+func (stg auditedActivityStorage) Put(ctx context.Context, activity Activity) error {
+	if stg.uow != nil {
+		return stg.uow.Do(ctx, func(tx Storage) error {
+			return tx.Activity.Put(ctx, activity)
+		})
+	}
+	old, err := stg.ActivityStorage.Read(ctx, activity.UserId, activity.Id)
+	if err != nil {
+		return err
+	}
+	err = stg.ActivityStorage.Put(ctx, activity)
+	if err != nil {
+		return err
+	}
+	return appendAudit(ctx, stg.audit, activity.UserId, AuditActivity, activity.Id, putAction(old != nil), old, activity)
+}
+
+// queryAll returns the activities matching query, trashed or not
+func (stg auditedActivityStorage) queryAll(ctx context.Context, query ActivityStorageQuery) ([]Activity, error) {
+	activities := make([]Activity, 0)
+	for _, trashed := range []bool{false, true} {
+		query.Trashed = trashed
+		matching, err := stg.ActivityStorage.Query(ctx, query)
+		if err != nil {
+			return nil, err
+		}
+		activities = append(activities, *matching...)
+	}
+	return activities, nil
+}
+
+func (stg auditedActivityStorage) deleteEach(ctx context.Context, query ActivityStorageQuery) error {
+	activities, err := stg.queryAll(ctx, query)
+	if err != nil {
+		return err
+	}
+	for _, activity := range activities {
+		err = ignoreNotFound(stg.Delete(ctx, activity.UserId, activity.Id))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (stg auditedActivityStorage) appendDeletes(ctx context.Context, deleted []Activity) error {
+	for _, activity := range deleted {
+		err := appendAudit(ctx, stg.audit, activity.UserId, AuditActivity, activity.Id, AuditDelete, activity, nil)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type auditedRecurringActivityStorage struct {
+	RecurringActivityStorage
+	audit AuditStorage
+	uow   UnitOfWork
+}
+
+func (stg auditedRecurringActivityStorage) Create(ctx context.Context, activity RecurringActivity) (RecurringActivity, error) {
+	if stg.uow != nil {
+		var created RecurringActivity
+		err := stg.uow.Do(ctx, func(tx Storage) error {
+			var err error
+			created, err = tx.RecurringActivity.Create(ctx, activity)
+			return err
+		})
+		return created, err
+	}
+	created, err := stg.RecurringActivityStorage.Create(ctx, activity)
+	if err != nil {
+		return created, err
+	}
+	return created, appendAudit(ctx, stg.audit, created.UserId, AuditRecurringActivity, created.Id, AuditCreate, nil, created)
+}
+
+func (stg auditedRecurringActivityStorage) Update(ctx context.Context, activity RecurringActivity) error {
+	if stg.uow != nil {
+		return stg.uow.Do(ctx, func(tx Storage) error {
+			return tx.RecurringActivity.Update(ctx, activity)
+		})
+	}
+	ctx = withVersionTime(ctx, versionTime(ctx))
+	old, err := stg.RecurringActivityStorage.Read(ctx, activity.UserId, activity.Id)
+	if err != nil {
+		return err
+	}
+	err = stg.RecurringActivityStorage.Update(ctx, activity)
+	if err != nil {
+		return err
+	}
+	updated := activity
+	updated.Version++
+	updated.UpdatedAt = versionTime(ctx)
+	return appendAudit(ctx, stg.audit, activity.UserId, AuditRecurringActivity, activity.Id, AuditUpdate, old, updated)
+}
+
+func (stg auditedRecurringActivityStorage) Delete(ctx context.Context, userId string, id uuid.UUID) error {
+	if stg.uow != nil {
+		return stg.uow.Do(ctx, func(tx Storage) error {
+			return tx.RecurringActivity.Delete(ctx, userId, id)
+		})
+	}
+	old, err := stg.RecurringActivityStorage.Read(ctx, userId, id)
+	if err != nil {
+		return err
+	}
+	err = stg.RecurringActivityStorage.Delete(ctx, userId, id)
+	if err != nil || old == nil {
+		return err
+	}
+	return appendAudit(ctx, stg.audit, userId, AuditRecurringActivity, id, AuditDelete, old, nil)
+}
+
+func (stg auditedRecurringActivityStorage) DeleteForPlan(ctx context.Context, userId string, planId uuid.UUID) error {
+	if stg.uow != nil {
+		return stg.deleteEach(ctx, RecurringActivityStorageQuery{UserId: userId, PlanId: &planId})
+	}
+	deleted, err := stg.queryAll(ctx, RecurringActivityStorageQuery{UserId: userId, PlanId: &planId})
+	if err != nil {
+		return err
+	}
+	err = stg.RecurringActivityStorage.DeleteForPlan(ctx, userId, planId)
+	if err != nil {
+		return err
+	}
+	return stg.appendDeletes(ctx, deleted)
+}
+
+func (stg auditedRecurringActivityStorage) DeleteAllForUser(ctx context.Context, userId string) error {
+	if stg.uow != nil {
+		return stg.deleteEach(ctx, RecurringActivityStorageQuery{UserId: userId})
+	}
+	deleted, err := stg.queryAll(ctx, RecurringActivityStorageQuery{UserId: userId})
+	if err != nil {
+		return err
+	}
+	err = stg.RecurringActivityStorage.DeleteAllForUser(ctx, userId)
+	if err != nil {
+		return err
+	}
+	return stg.appendDeletes(ctx, deleted)
+}
+
+func (stg auditedRecurringActivityStorage) Put(ctx context.Context, activity RecurringActivity) error {
+	if stg.uow != nil {
+		return stg.uow.Do(ctx, func(tx Storage) error {
+			return tx.RecurringActivity.Put(ctx, activity)
+		})
+	}
+	old, err := stg.RecurringActivityStorage.Read(ctx, activity.UserId, activity.Id)
+	if err != nil {
+		return err
+	}
+	err = stg.RecurringActivityStorage.Put(ctx, activity)
+	if err != nil {
+		return err
+	}
+	return appendAudit(ctx, stg.audit, activity.UserId, AuditRecurringActivity, activity.Id, putAction(old != nil), old, activity)
+}
+
+// queryAll returns the recurring activities matching query, trashed or not
+func (stg auditedRecurringActivityStorage) queryAll(ctx context.Context, query RecurringActivityStorageQuery) ([]RecurringActivity, error) {
+	activities := make([]RecurringActivity, 0)
+	for _, trashed := range []bool{false, true} {
+		query.Trashed = trashed
+		matching, err := stg.RecurringActivityStorage.Query(ctx, query)
+		if err != nil {
+			return nil, err
+		}
+		activities = append(activities, *matching...)
+	}
+	return activities, nil
+}
+
+func (stg auditedRecurringActivityStorage) deleteEach(ctx context.Context, query RecurringActivityStorageQuery) error {
+	activities, err := stg.queryAll(ctx, query)
+	if err != nil {
+		return err
+	}
+	for _, activity := range activities {
+		err = ignoreNotFound(stg.Delete(ctx, activity.UserId, activity.Id))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (stg auditedRecurringActivityStorage) appendDeletes(ctx context.Context, deleted []RecurringActivity) error {
+	for _, activity := range deleted {
+		err := appendAudit(ctx, stg.audit, activity.UserId, AuditRecurringActivity, activity.Id, AuditDelete, activity, nil)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type auditedPlanStorage struct {
+	PlanStorage
+	audit AuditStorage
+	uow   UnitOfWork
+}
+
+func (stg auditedPlanStorage) Create(ctx context.Context, plan Plan) (Plan, error) {
+	if stg.uow != nil {
+		var created Plan
+		err := stg.uow.Do(ctx, func(tx Storage) error {
+			var err error
+			created, err = tx.Plan.Create(ctx, plan)
+			return err
+		})
+		return created, err
+	}
+	created, err := stg.PlanStorage.Create(ctx, plan)
+	if err != nil {
+		return created, err
+	}
+	return created, appendAudit(ctx, stg.audit, created.UserId, AuditPlan, created.Id, AuditCreate, nil, created)
+}
+
+func (stg auditedPlanStorage) Update(ctx context.Context, plan Plan) error {
+	if stg.uow != nil {
+		return stg.uow.Do(ctx, func(tx Storage) error {
+			return tx.Plan.Update(ctx, plan)
+		})
+	}
+	ctx = withVersionTime(ctx, versionTime(ctx))
+	old, err := stg.PlanStorage.Read(ctx, plan.UserId, plan.Id)
+	if err != nil {
+		return err
+	}
+	err = stg.PlanStorage.Update(ctx, plan)
+	if err != nil {
+		return err
+	}
+	updated := plan
+	updated.Version++
+	updated.UpdatedAt = versionTime(ctx)
+	return appendAudit(ctx, stg.audit, plan.UserId, AuditPlan, plan.Id, AuditUpdate, old, updated)
+}
+
+func (stg auditedPlanStorage) Delete(ctx context.Context, userId string, id uuid.UUID) error {
+	if stg.uow != nil {
+		return stg.uow.Do(ctx, func(tx Storage) error {
+			return tx.Plan.Delete(ctx, userId, id)
+		})
+	}
+	old, err := stg.PlanStorage.Read(ctx, userId, id)
+	if err != nil {
+		return err
+	}
+	err = stg.PlanStorage.Delete(ctx, userId, id)
+	if err != nil || old == nil {
+		return err
+	}
+	return appendAudit(ctx, stg.audit, userId, AuditPlan, id, AuditDelete, old, nil)
+}
+
+func (stg auditedPlanStorage) DeleteAllForUser(ctx context.Context, userId string) error {
+	deleted := make([]Plan, 0)
+	for _, trashed := range []bool{false, true} {
+		plans, err := stg.PlanStorage.Query(ctx, PlanStorageQuery{UserId: userId, Trashed: trashed, IncludeArchived: true})
+		if err != nil {
+			return err
+		}
+		deleted = append(deleted, *plans...)
+	}
+	if stg.uow != nil {
+		for _, plan := range deleted {
+			err := ignoreNotFound(stg.Delete(ctx, userId, plan.Id))
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	err := stg.PlanStorage.DeleteAllForUser(ctx, userId)
+	if err != nil {
+		return err
+	}
+	for _, plan := range deleted {
+		err = appendAudit(ctx, stg.audit, userId, AuditPlan, plan.Id, AuditDelete, plan, nil)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (stg auditedPlanStorage) Put(ctx context.Context, plan Plan) error {
+	if stg.uow != nil {
+		return stg.uow.Do(ctx, func(tx Storage) error {
+			return tx.Plan.Put(ctx, plan)
+		})
+	}
+	old, err := stg.PlanStorage.Read(ctx, plan.UserId, plan.Id)
+	if err != nil {
+		return err
+	}
+	err = stg.PlanStorage.Put(ctx, plan)
+	if err != nil {
+		return err
+	}
+	return appendAudit(ctx, stg.audit, plan.UserId, AuditPlan, plan.Id, putAction(old != nil), old, plan)
+}
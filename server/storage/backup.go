@@ -0,0 +1,261 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/mattn/go-sqlite3"
+)
+
+var ErrBackupUnsupported = errors.New("backups are only supported by sqlite storage")
+
+const backupFilePrefix = "planner-"
+const backupFileSuffix = ".sqlite"
+
+// Copies take this many pages at a time and pause in between, so writers are
+// only held off for one step rather than the whole copy
+const sqliteBackupPages = 100
+const sqliteBackupPause = 10 * time.Millisecond
+
+// SqlitePath is the database file of sqlite storage
+func SqlitePath() string {
+	path := os.Getenv("PLANNER_SQLITE_PATH")
+	if path == "" {
+		return ".sqlite"
+	}
+	return path
+}
+
+// Backup writes a consistent snapshot of the storage to path using the sqlite
+// online backup API, so the storage stays usable while it runs.
+func (strg Storage) Backup(ctx context.Context, path string) error {
+	if strg.backup == nil {
+		return ErrBackupUnsupported
+	}
+	return strg.backup(ctx, path)
+}
+
+func sqliteBackup(ctx context.Context, db *sql.DB, dbPath string, path string) error {
+	// The storage's pool has a single connection, so the copy reads through
+	// one of its own and requests keep being served meanwhile. An in-memory
+	// database can only be reached through the pool.
+	src := db
+	if dbPath != ":memory:" {
+		var err error
+		src, err = sql.Open("sqlite3", dbPath)
+		if err != nil {
+			return err
+		}
+		defer src.Close()
+	}
+	// Written next to the target and renamed so a half written backup never
+	// looks like a finished one
+	tmpPath := path + ".tmp"
+	os.Remove(tmpPath)
+	dst, err := sql.Open("sqlite3", tmpPath)
+	if err != nil {
+		return err
+	}
+	copyErr := copySqliteDatabase(ctx, src, dst)
+	closeErr := dst.Close()
+	if copyErr == nil {
+		copyErr = closeErr
+	}
+	if copyErr != nil {
+		os.Remove(tmpPath)
+		return copyErr
+	}
+	return os.Rename(tmpPath, path)
+}
+
+func copySqliteDatabase(ctx context.Context, src *sql.DB, dst *sql.DB) error {
+	srcConn, err := src.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer srcConn.Close()
+	dstConn, err := dst.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer dstConn.Close()
+	return dstConn.Raw(func(dstDriverConn any) error {
+		return srcConn.Raw(func(srcDriverConn any) error {
+			backup, err := dstDriverConn.(*sqlite3.SQLiteConn).Backup("main", srcDriverConn.(*sqlite3.SQLiteConn), "main")
+			if err != nil {
+				return err
+			}
+			stepErr := stepSqliteBackup(ctx, backup)
+			finishErr := backup.Finish()
+			if stepErr != nil {
+				return stepErr
+			}
+			return finishErr
+		})
+	})
+}
+
+// stepSqliteBackup copies the database in steps of sqliteBackupPages. Steps
+// that find the source locked by a writer are retried after the pause, and
+// the backup starts over by itself if the source changes in between.
+func stepSqliteBackup(ctx context.Context, backup *sqlite3.SQLiteBackup) error {
+	for {
+		done, err := backup.Step(sqliteBackupPages)
+		if err != nil || done {
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(sqliteBackupPause):
+		}
+	}
+}
+
+// RestoreSqlite replaces the database at dbPath with the backup at backupPath.
+// The backup is checked first, and nothing else may use the database while it
+// runs.
+func RestoreSqlite(ctx context.Context, backupPath string, dbPath string) error {
+	// sql.Open would create a missing backup as an empty database
+	_, err := os.Stat(backupPath)
+	if err != nil {
+		return err
+	}
+	src, err := sql.Open("sqlite3", "file:"+backupPath+"?mode=ro")
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	err = checkSqliteBackup(ctx, src)
+	if err != nil {
+		return fmt.Errorf("%s is not a usable backup: %w", backupPath, err)
+	}
+	dst, err := sql.Open("sqlite3", dbPath)
+	if err != nil {
+		return err
+	}
+	copyErr := copySqliteDatabase(ctx, src, dst)
+	closeErr := dst.Close()
+	if copyErr != nil {
+		return copyErr
+	}
+	return closeErr
+}
+
+func checkSqliteBackup(ctx context.Context, db *sql.DB) error {
+	var integrity string
+	err := db.QueryRowContext(ctx, `PRAGMA integrity_check;`).Scan(&integrity)
+	if err != nil {
+		return err
+	}
+	if integrity != "ok" {
+		return errors.New(integrity)
+	}
+	var version int
+	err = db.QueryRowContext(ctx, `SELECT MAX(version) FROM schema_version;`).Scan(&version)
+	if err != nil {
+		return err
+	}
+	latest := sqliteMigrations[len(sqliteMigrations)-1].Version
+	if version > latest {
+		return fmt.Errorf("it has schema version %d but this build only knows up to %d", version, latest)
+	}
+	return nil
+}
+
+type BackupSchedule struct {
+	Dir string
+	// Interval between scheduled backups, none are taken when it is 0
+	Interval time.Duration
+	// Retain is how many backups are kept in Dir, 0 keeps all of them
+	Retain int
+}
+
+func BackupScheduleFromEnv() (BackupSchedule, error) {
+	schedule := BackupSchedule{Dir: os.Getenv("PLANNER_BACKUP_DIR")}
+	if schedule.Dir == "" {
+		schedule.Dir = "backups"
+	}
+	var err error
+	schedule.Interval, err = durationSetting("PLANNER_BACKUP_INTERVAL", 0)
+	if err != nil {
+		return BackupSchedule{}, err
+	}
+	schedule.Retain, err = intSetting("PLANNER_BACKUP_RETAIN", 7)
+	if err != nil {
+		return BackupSchedule{}, err
+	}
+	return schedule, nil
+}
+
+// BackupNow writes a backup named after the current time to the schedule's
+// directory and removes the ones past retention. It returns the backup's path.
+func (schedule BackupSchedule) BackupNow(ctx context.Context, strg Storage) (string, error) {
+	err := os.MkdirAll(schedule.Dir, 0o755)
+	if err != nil {
+		return "", err
+	}
+	name := backupFilePrefix + time.Now().UTC().Format("20060102T150405.000Z") + backupFileSuffix
+	path := filepath.Join(schedule.Dir, name)
+	err = strg.Backup(ctx, path)
+	if err != nil {
+		return "", err
+	}
+	return path, pruneBackups(schedule.Dir, schedule.Retain)
+}
+
+// Run takes backups every Interval until ctx is done
+func (schedule BackupSchedule) Run(ctx context.Context, strg Storage) {
+	if schedule.Interval == 0 {
+		return
+	}
+	ticker := time.NewTicker(schedule.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			path, err := schedule.BackupNow(ctx, strg)
+			if err != nil {
+				fmt.Printf("error taking scheduled backup: %s\n", err)
+			} else {
+				fmt.Printf("wrote backup %s\n", path)
+			}
+		}
+	}
+}
+
+func pruneBackups(dir string, retain int) error {
+	if retain == 0 {
+		return nil
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	// The timestamps in the names sort chronologically
+	var backups []string
+	for _, entry := range entries {
+		name := entry.Name()
+		if !entry.IsDir() && strings.HasPrefix(name, backupFilePrefix) && strings.HasSuffix(name, backupFileSuffix) {
+			backups = append(backups, name)
+		}
+	}
+	sort.Strings(backups)
+	for len(backups) > retain {
+		err = os.Remove(filepath.Join(dir, backups[0]))
+		if err != nil {
+			return err
+		}
+		backups = backups[1:]
+	}
+	return nil
+}
@@ -0,0 +1,132 @@
+package storage
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestSqliteBackupAndRestore(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	strg, err := getSqliteStorageClient(filepath.Join(dir, "planner.sqlite"))
+	if err != nil {
+		t.Fatalf("Error creating storage: %s", err)
+	}
+	defer strg.Close()
+	plan, _ := strg.Plan.Create(ctx, Plan{UserId: "some-user", Name: "Backed up"})
+
+	backupPath := filepath.Join(dir, "backup.sqlite")
+	err = strg.Backup(ctx, backupPath)
+	if err != nil {
+		t.Fatalf("Error writing backup: %s", err)
+	}
+	strg.Plan.Create(ctx, Plan{UserId: "some-user", Name: "After the backup"})
+
+	restoredPath := filepath.Join(dir, "restored.sqlite")
+	err = RestoreSqlite(ctx, backupPath, restoredPath)
+	if err != nil {
+		t.Fatalf("Error restoring backup: %s", err)
+	}
+	restored, err := getSqliteStorageClient(restoredPath)
+	if err != nil {
+		t.Fatalf("Error opening restored storage: %s", err)
+	}
+	defer restored.Close()
+	plans, _ := restored.Plan.Query(ctx, PlanStorageQuery{UserId: "some-user"})
+	if plans == nil || len(*plans) != 1 || (*plans)[0].Id != plan.Id {
+		t.Errorf("Expected only the plan from before the backup, got %+v", plans)
+	}
+}
+
+func TestSqliteBackupDoesNotWaitForTheStoragesConnection(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	strg, err := getSqliteStorageClient(filepath.Join(dir, "planner.sqlite"))
+	if err != nil {
+		t.Fatalf("Error creating storage: %s", err)
+	}
+	defer strg.Close()
+	strg.Plan.Create(ctx, Plan{UserId: "some-user", Name: "Committed"})
+
+	backupPath := filepath.Join(dir, "backup.sqlite")
+	// The unit of work holds the storage's only connection throughout
+	err = strg.UnitOfWork.Do(ctx, func(tx Storage) error {
+		_, err := tx.Plan.Create(ctx, Plan{UserId: "some-user", Name: "Uncommitted"})
+		if err != nil {
+			return err
+		}
+		backupCtx, cancel := context.WithTimeout(ctx, 5*time.Second)
+		defer cancel()
+		return strg.Backup(backupCtx, backupPath)
+	})
+	if err != nil {
+		t.Fatalf("Error writing backup: %s", err)
+	}
+	backup, err := getSqliteStorageClient(backupPath)
+	if err != nil {
+		t.Fatalf("Error opening backup: %s", err)
+	}
+	defer backup.Close()
+	plans, _ := backup.Plan.Query(ctx, PlanStorageQuery{UserId: "some-user"})
+	if plans == nil || len(*plans) != 1 || (*plans)[0].Name != "Committed" {
+		t.Errorf("Expected only the committed plan, got %+v", plans)
+	}
+}
+
+func TestRestoreRejectsInvalidBackups(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	dbPath := filepath.Join(dir, "planner.sqlite")
+	err := RestoreSqlite(ctx, filepath.Join(dir, "missing.sqlite"), dbPath)
+	if err == nil {
+		t.Errorf("Expected restoring a missing backup to fail")
+	}
+	garbage := filepath.Join(dir, "garbage.sqlite")
+	os.WriteFile(garbage, []byte("not a database"), 0o644)
+	err = RestoreSqlite(ctx, garbage, dbPath)
+	if err == nil {
+		t.Errorf("Expected restoring a corrupt backup to fail")
+	}
+	_, err = os.Stat(dbPath)
+	if !os.IsNotExist(err) {
+		t.Errorf("Expected failed restores to leave the database alone")
+	}
+}
+
+func TestBackupNowPrunesOldBackups(t *testing.T) {
+	ctx := context.Background()
+	strg, err := getSqliteStorageClient(":memory:")
+	if err != nil {
+		t.Fatalf("Error creating storage: %s", err)
+	}
+	defer strg.Close()
+	schedule := BackupSchedule{Dir: filepath.Join(t.TempDir(), "backups"), Retain: 2}
+	os.MkdirAll(schedule.Dir, 0o755)
+	os.WriteFile(filepath.Join(schedule.Dir, "unrelated.txt"), nil, 0o644)
+
+	var paths []string
+	for i := 0; i < 3; i++ {
+		path, err := schedule.BackupNow(ctx, strg)
+		if err != nil {
+			t.Fatalf("Error writing backup: %s", err)
+		}
+		paths = append(paths, path)
+		time.Sleep(2 * time.Millisecond)
+	}
+	entries, _ := os.ReadDir(schedule.Dir)
+	if len(entries) != 3 {
+		t.Errorf("Expected 2 backups and the unrelated file, got %d entries", len(entries))
+	}
+	_, err = os.Stat(paths[0])
+	if !os.IsNotExist(err) {
+		t.Errorf("Expected the oldest backup to be removed")
+	}
+
+	_, err = schedule.BackupNow(ctx, NewMemoryStorage())
+	if err != ErrBackupUnsupported {
+		t.Errorf("Expected memory storage backups to be unsupported, got %v", err)
+	}
+}
@@ -0,0 +1,47 @@
+package storage_test
+
+import (
+	"os"
+	"path/filepath"
+	"planner/storage"
+	"planner/storage/storagetest"
+	"testing"
+)
+
+func TestMemoryConformance(t *testing.T) {
+	storagetest.Run(t, storage.NewMemoryStorage())
+}
+
+func TestSqliteConformance(t *testing.T) {
+	t.Setenv("PLANNER_SQLITE_PATH", filepath.Join(t.TempDir(), "planner.sqlite"))
+	strg, err := storage.GetStorage(storage.Sqlite)
+	if err != nil {
+		t.Fatalf("Error creating storage: %s", err)
+	}
+	defer strg.Close()
+	storagetest.Run(t, strg)
+}
+
+func TestCassandraConformance(t *testing.T) {
+	if os.Getenv("PLANNER_TEST_CASSANDRA") == "" {
+		t.Skip("set PLANNER_TEST_CASSANDRA to run against cassandra")
+	}
+	strg, err := storage.GetStorage(storage.Cassandra)
+	if err != nil {
+		t.Fatalf("Error creating storage: %s", err)
+	}
+	defer strg.Close()
+	storagetest.Run(t, strg)
+}
+
+func TestPostgresConformance(t *testing.T) {
+	if os.Getenv("PLANNER_TEST_POSTGRES") == "" {
+		t.Skip("set PLANNER_TEST_POSTGRES to run against postgres")
+	}
+	strg, err := storage.GetStorage(storage.Postgres)
+	if err != nil {
+		t.Fatalf("Error creating storage: %s", err)
+	}
+	defer strg.Close()
+	storagetest.Run(t, strg)
+}
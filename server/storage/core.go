@@ -1,7 +1,10 @@
 package storage
 
 import (
+	"context"
 	"errors"
+	"fmt"
+	"io"
 	"time"
 
 	"github.com/google/uuid"
@@ -31,6 +34,9 @@ type Activity struct {
 	TimeRelevant        bool            `json:"timeRelevant"`
 	Completed           bool            `json:"completed"`
 	Notes               string          `json:"notes"`
+	DeletedAt           *time.Time      `json:"deletedAt,omitempty"`
+	Version             int64           `json:"version"`
+	UpdatedAt           time.Time       `json:"updatedAt"`
 }
 
 type RecurringActivity struct {
@@ -40,8 +46,14 @@ type RecurringActivity struct {
 	Summary        string          `json:"summary"`
 	Stages         []ActivityStage `json:"stages"`
 	RecurrEachDays int32           `json:"recurrEachDays"`
-	DateTimeStart  time.Time       `json:"dateTimeStart"`
-	TimeRelevant   bool            `json:"timeRelevant"`
+	// RRule is an RFC 5545 recurrence rule starting at DateTimeStart, such as
+	// FREQ=WEEKLY;BYDAY=TU,TH. It replaces RecurrEachDays when set.
+	RRule         string     `json:"rrule"`
+	DateTimeStart time.Time  `json:"dateTimeStart"`
+	TimeRelevant  bool       `json:"timeRelevant"`
+	DeletedAt     *time.Time `json:"deletedAt,omitempty"`
+	Version       int64      `json:"version"`
+	UpdatedAt     time.Time  `json:"updatedAt"`
 }
 
 type Plan struct {
@@ -49,6 +61,36 @@ type Plan struct {
 	UserId string    `json:"userId"`
 	Name   string    `json:"name"`
 	Active bool      `json:"active"`
+	// DeletedAt is set while the plan is in the trash
+	DeletedAt *time.Time `json:"deletedAt,omitempty"`
+	// ArchivedAt is set while the plan is archived, its activities are then
+	// read-only
+	ArchivedAt *time.Time `json:"archivedAt,omitempty"`
+	Version    int64      `json:"version"`
+	UpdatedAt  time.Time  `json:"updatedAt"`
+}
+
+// Create starts every item at version 1 and Update only applies when the
+// given Version is the stored one, storing the next version. Otherwise Update
+// returns ErrVersionConflict. Every method only sees and changes the items of
+// the userId it is given.
+var ErrVersionConflict = fmt.Errorf("version %w", ErrConflict)
+
+type versionTimeKey struct{}
+
+// withVersionTime makes the writes done with ctx store t as their UpdatedAt,
+// so the audit log can record the value a backend writes.
+func withVersionTime(ctx context.Context, t time.Time) context.Context {
+	return context.WithValue(ctx, versionTimeKey{}, t)
+}
+
+// versionTime is truncated to the millisecond, the coarsest precision of the
+// backends, so UpdatedAt reads back the same everywhere.
+func versionTime(ctx context.Context) time.Time {
+	if t, ok := ctx.Value(versionTimeKey{}).(time.Time); ok {
+		return t
+	}
+	return time.Now().UTC().Truncate(time.Millisecond)
 }
 
 type DateRange struct {
@@ -60,50 +102,120 @@ type ActivityStorageQuery struct {
 	UserId    string
 	PlanId    *uuid.UUID
 	DateRange *DateRange
+	// PlanIds matches activities in any of the given plans
+	PlanIds             []uuid.UUID
+	NoPlan              bool
+	ActivePlansOnly     bool
+	RecurringActivityId *uuid.UUID
+	Completed           *bool
+	// Order defaults to Ascending by dateTime
+	Order SortOrder
+	// Limit caps the number of results, zero means no limit
+	Limit  int
+	Cursor *ActivityCursor
+	// Trashed selects only trashed activities, which are otherwise excluded
+	Trashed bool
+	// Metrics must all match
+	Metrics []MetricFilter
+}
+
+// MetricFilter matches activities whose metric amounts in Unit add up, over
+// all stages and their repetitions, to at least Min and at most Max. Either
+// bound may be left out, activities without any metric in Unit never match.
+type MetricFilter struct {
+	Unit string
+	Min  *int
+	Max  *int
 }
 
 type RecurringActivityStorageQuery struct {
-	UserId string
-	PlanId *uuid.UUID
+	UserId  string
+	PlanId  *uuid.UUID
+	Trashed bool
 }
 
 type PlanStorageQuery struct {
-	UserId string
+	UserId          string
+	Trashed         bool
+	IncludeArchived bool
 }
 
 //go:generate mockery --name ActivityStorage
 type ActivityStorage interface {
-	Create(activity Activity) (Activity, error)
-	Read(userId string, id uuid.UUID) (*Activity, error)
-	Query(query ActivityStorageQuery) (*[]Activity, error)
-	Update(activity Activity) error
-	Delete(userId string, id uuid.UUID) error
-	DeleteForPlan(userId string, planId uuid.UUID) error
+	Create(ctx context.Context, activity Activity) (Activity, error)
+	Read(ctx context.Context, userId string, id uuid.UUID) (*Activity, error)
+	Query(ctx context.Context, query ActivityStorageQuery) (*[]Activity, error)
+	Search(ctx context.Context, query ActivitySearchQuery) (*[]Activity, error)
+	Update(ctx context.Context, activity Activity) error
+	Delete(ctx context.Context, userId string, id uuid.UUID) error
+	DeleteForPlan(ctx context.Context, userId string, planId uuid.UUID) error
+	DeleteAllForUser(ctx context.Context, userId string) error
+	// Put stores the activity exactly as given, id and version included,
+	// replacing the user's stored one with the same id. An id another user
+	// owns is never replaced, backends whose ids are shared between users
+	// return ErrNotFound then. Together with UserIds it serves copying data
+	// between storages.
+	Put(ctx context.Context, activity Activity) error
+	UserIds(ctx context.Context) ([]string, error)
 }
 
 //go:generate mockery --name RecurringActivityStorage
 type RecurringActivityStorage interface {
-	Create(activity RecurringActivity) (RecurringActivity, error)
-	Read(userId string, id uuid.UUID) (*RecurringActivity, error)
-	Query(query RecurringActivityStorageQuery) (*[]RecurringActivity, error)
-	Update(activity RecurringActivity) error
-	Delete(userId string, id uuid.UUID) error
-	DeleteForPlan(userId string, planId uuid.UUID) error
+	Create(ctx context.Context, activity RecurringActivity) (RecurringActivity, error)
+	Read(ctx context.Context, userId string, id uuid.UUID) (*RecurringActivity, error)
+	Query(ctx context.Context, query RecurringActivityStorageQuery) (*[]RecurringActivity, error)
+	Update(ctx context.Context, activity RecurringActivity) error
+	Delete(ctx context.Context, userId string, id uuid.UUID) error
+	DeleteForPlan(ctx context.Context, userId string, planId uuid.UUID) error
+	DeleteAllForUser(ctx context.Context, userId string) error
+	Put(ctx context.Context, activity RecurringActivity) error
+	UserIds(ctx context.Context) ([]string, error)
 }
 
 //go:generate mockery --name PlanStorage
 type PlanStorage interface {
-	Create(plan Plan) (Plan, error)
-	Read(userId string, id uuid.UUID) (*Plan, error)
-	Query(query PlanStorageQuery) (*[]Plan, error)
-	Update(plan Plan) error
-	Delete(userId string, id uuid.UUID) error
+	Create(ctx context.Context, plan Plan) (Plan, error)
+	Read(ctx context.Context, userId string, id uuid.UUID) (*Plan, error)
+	Query(ctx context.Context, query PlanStorageQuery) (*[]Plan, error)
+	Update(ctx context.Context, plan Plan) error
+	Delete(ctx context.Context, userId string, id uuid.UUID) error
+	DeleteAllForUser(ctx context.Context, userId string) error
+	Put(ctx context.Context, plan Plan) error
+	UserIds(ctx context.Context) ([]string, error)
+}
+
+// UnitOfWork runs fn against a Storage whose writes are applied atomically,
+// either all of them land or, when fn returns an error, none do. Only the
+// Storage passed to fn may be used inside it.
+//
+//go:generate mockery --name UnitOfWork
+type UnitOfWork interface {
+	Do(ctx context.Context, fn func(Storage) error) error
 }
 
 type Storage struct {
 	Activity          ActivityStorage
 	RecurringActivity RecurringActivityStorage
 	Plan              PlanStorage
+	Audit             AuditStorage
+	UnitOfWork        UnitOfWork
+	closer            io.Closer
+	backup            func(ctx context.Context, path string) error
+}
+
+// Close releases the connections held by the storage. It must not be used
+// afterwards.
+func (strg Storage) Close() error {
+	if strg.closer == nil {
+		return nil
+	}
+	return strg.closer.Close()
+}
+
+type closerFunc func() error
+
+func (f closerFunc) Close() error {
+	return f()
 }
 
 type StorageType string
@@ -111,16 +223,25 @@ type StorageType string
 const (
 	Sqlite    StorageType = "sqlite"
 	Cassandra StorageType = "cassandra"
+	Postgres  StorageType = "postgres"
+	Memory    StorageType = "memory"
 )
 
 func GetStorage(storage_type StorageType) (Storage, error) {
 	if storage_type == Sqlite {
-		strg, err := getSqliteStorageClient(".sqlite")
+		strg, err := getSqliteStorageClient(SqlitePath())
 		return strg, err
 	}
 	if storage_type == Cassandra {
 		strg, err := getCassandratorageClient()
 		return strg, err
 	}
+	if storage_type == Postgres {
+		strg, err := getPostgresStorageClient()
+		return strg, err
+	}
+	if storage_type == Memory {
+		return NewMemoryStorage(), nil
+	}
 	return Storage{}, errors.New("Not Implemented")
 }
@@ -0,0 +1,145 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"net"
+
+	"github.com/gocql/gocql"
+	"github.com/lib/pq"
+	"github.com/mattn/go-sqlite3"
+)
+
+// Storage errors fall into these kinds, errors.Is tells them apart. Backend
+// errors of a known kind are wrapped so they keep their details for logging.
+var (
+	// ErrNotFound is returned by Update and Delete when the user has no item
+	// with the id, whether or not another user has one. Read returns nil then.
+	ErrNotFound = errors.New("not found")
+	// ErrConflict is returned when a write clashes with the stored data or a
+	// concurrent write
+	ErrConflict = errors.New("conflict")
+	// ErrUnavailable is returned when the backend can't be reached or is
+	// overloaded, retrying later may succeed
+	ErrUnavailable = errors.New("storage unavailable")
+	// ErrInvalid is returned when the backend rejects the given data
+	ErrInvalid = errors.New("invalid")
+)
+
+func classifyError(err error, kind error) error {
+	return fmt.Errorf("%w: %w", kind, err)
+}
+
+func hasErrorKind(err error) bool {
+	return errors.Is(err, ErrNotFound) || errors.Is(err, ErrConflict) || errors.Is(err, ErrUnavailable) || errors.Is(err, ErrInvalid)
+}
+
+// connectionError covers what the database/sql and network layers report when
+// a backend is out of reach.
+func connectionError(err error) bool {
+	var netErr net.Error
+	return errors.Is(err, context.DeadlineExceeded) || errors.Is(err, driver.ErrBadConn) || errors.Is(err, sql.ErrConnDone) || errors.As(err, &netErr)
+}
+
+func sqliteError(err error) error {
+	if err == nil || hasErrorKind(err) {
+		return err
+	}
+	if connectionError(err) {
+		return classifyError(err, ErrUnavailable)
+	}
+	var sqliteErr sqlite3.Error
+	if !errors.As(err, &sqliteErr) {
+		return err
+	}
+	switch sqliteErr.Code {
+	case sqlite3.ErrBusy, sqlite3.ErrLocked, sqlite3.ErrIoErr, sqlite3.ErrFull, sqlite3.ErrCantOpen:
+		return classifyError(err, ErrUnavailable)
+	case sqlite3.ErrConstraint:
+		if sqliteErr.ExtendedCode == sqlite3.ErrConstraintUnique || sqliteErr.ExtendedCode == sqlite3.ErrConstraintPrimaryKey {
+			return classifyError(err, ErrConflict)
+		}
+		return classifyError(err, ErrInvalid)
+	case sqlite3.ErrMismatch, sqlite3.ErrTooBig, sqlite3.ErrRange:
+		return classifyError(err, ErrInvalid)
+	}
+	return err
+}
+
+func postgresError(err error) error {
+	if err == nil || hasErrorKind(err) {
+		return err
+	}
+	if connectionError(err) {
+		return classifyError(err, ErrUnavailable)
+	}
+	var pqErr *pq.Error
+	if !errors.As(err, &pqErr) {
+		return err
+	}
+	if pqErr.Code == "23505" {
+		return classifyError(err, ErrConflict)
+	}
+	switch pqErr.Code.Class() {
+	// connection exception, insufficient resources, operator intervention
+	// and system error
+	case "08", "53", "57", "58":
+		return classifyError(err, ErrUnavailable)
+	// serialization failures and deadlocks
+	case "40":
+		return classifyError(err, ErrConflict)
+	// data exceptions and integrity constraint violations
+	case "22", "23":
+		return classifyError(err, ErrInvalid)
+	}
+	return err
+}
+
+func cassandraError(err error) error {
+	if err == nil || hasErrorKind(err) {
+		return err
+	}
+	if connectionError(err) ||
+		errors.Is(err, gocql.ErrNoConnections) ||
+		errors.Is(err, gocql.ErrSessionClosed) ||
+		errors.Is(err, gocql.ErrUnavailable) ||
+		errors.Is(err, gocql.ErrTimeoutNoResponse) ||
+		errors.Is(err, gocql.ErrTooManyTimeouts) ||
+		errors.Is(err, gocql.ErrConnectionClosed) ||
+		errors.Is(err, gocql.ErrNoStreams) {
+		return classifyError(err, ErrUnavailable)
+	}
+	var requestErr gocql.RequestError
+	if !errors.As(err, &requestErr) {
+		return err
+	}
+	// Invalid queries are bugs of ours and stay unclassified
+	switch requestErr.Code() {
+	case gocql.ErrCodeUnavailable, gocql.ErrCodeOverloaded, gocql.ErrCodeBootstrapping, gocql.ErrCodeTruncate,
+		gocql.ErrCodeWriteTimeout, gocql.ErrCodeReadTimeout, gocql.ErrCodeReadFailure, gocql.ErrCodeWriteFailure,
+		gocql.ErrCodeCASWriteUnknown:
+		return classifyError(err, ErrUnavailable)
+	case gocql.ErrCodeAlreadyExists:
+		return classifyError(err, ErrConflict)
+	}
+	return err
+}
+
+// withErrorKinds wraps the storage so that every error it returns is
+// classified by classify. Errors inside a unit of work are classified when Do
+// returns them. The wrappers are generated from the interfaces.
+//
+//go:generate go run ./internal/kindgen
+func withErrorKinds(strg Storage, classify func(error) error) Storage {
+	strg.Activity = kindedActivityStorage{ActivityStorage: strg.Activity, classify: classify}
+	strg.RecurringActivity = kindedRecurringActivityStorage{RecurringActivityStorage: strg.RecurringActivity, classify: classify}
+	strg.Plan = kindedPlanStorage{PlanStorage: strg.Plan, classify: classify}
+	strg.Audit = kindedAuditStorage{AuditStorage: strg.Audit, classify: classify}
+	if strg.UnitOfWork != nil {
+		strg.UnitOfWork = kindedUnitOfWork{UnitOfWork: strg.UnitOfWork, classify: classify}
+	}
+	return strg
+}
@@ -0,0 +1,177 @@
+// Code generated by go run ./internal/kindgen. DO NOT EDIT.
+
+package storage
+
+import (
+	"context"
+
+	"github.com/google/uuid"
+)
+
+type kindedActivityStorage struct {
+	ActivityStorage
+	classify func(error) error
+}
+
+func (stg kindedActivityStorage) Create(ctx context.Context, activity Activity) (Activity, error) {
+	r0, err := stg.ActivityStorage.Create(ctx, activity)
+	return r0, stg.classify(err)
+}
+
+func (stg kindedActivityStorage) Read(ctx context.Context, userId string, id uuid.UUID) (*Activity, error) {
+	r0, err := stg.ActivityStorage.Read(ctx, userId, id)
+	return r0, stg.classify(err)
+}
+
+func (stg kindedActivityStorage) Query(ctx context.Context, query ActivityStorageQuery) (*[]Activity, error) {
+	r0, err := stg.ActivityStorage.Query(ctx, query)
+	return r0, stg.classify(err)
+}
+
+func (stg kindedActivityStorage) Search(ctx context.Context, query ActivitySearchQuery) (*[]Activity, error) {
+	r0, err := stg.ActivityStorage.Search(ctx, query)
+	return r0, stg.classify(err)
+}
+
+func (stg kindedActivityStorage) Update(ctx context.Context, activity Activity) error {
+	return stg.classify(stg.ActivityStorage.Update(ctx, activity))
+}
+
+func (stg kindedActivityStorage) Delete(ctx context.Context, userId string, id uuid.UUID) error {
+	return stg.classify(stg.ActivityStorage.Delete(ctx, userId, id))
+}
+
+func (stg kindedActivityStorage) DeleteForPlan(ctx context.Context, userId string, planId uuid.UUID) error {
+	return stg.classify(stg.ActivityStorage.DeleteForPlan(ctx, userId, planId))
+}
+
+func (stg kindedActivityStorage) DeleteAllForUser(ctx context.Context, userId string) error {
+	return stg.classify(stg.ActivityStorage.DeleteAllForUser(ctx, userId))
+}
+
+func (stg kindedActivityStorage) Put(ctx context.Context, activity Activity) error {
+	return stg.classify(stg.ActivityStorage.Put(ctx, activity))
+}
+
+func (stg kindedActivityStorage) UserIds(ctx context.Context) ([]string, error) {
+	r0, err := stg.ActivityStorage.UserIds(ctx)
+	return r0, stg.classify(err)
+}
+
+type kindedRecurringActivityStorage struct {
+	RecurringActivityStorage
+	classify func(error) error
+}
+
+func (stg kindedRecurringActivityStorage) Create(ctx context.Context, activity RecurringActivity) (RecurringActivity, error) {
+	r0, err := stg.RecurringActivityStorage.Create(ctx, activity)
+	return r0, stg.classify(err)
+}
+
+func (stg kindedRecurringActivityStorage) Read(ctx context.Context, userId string, id uuid.UUID) (*RecurringActivity, error) {
+	r0, err := stg.RecurringActivityStorage.Read(ctx, userId, id)
+	return r0, stg.classify(err)
+}
+
+func (stg kindedRecurringActivityStorage) Query(ctx context.Context, query RecurringActivityStorageQuery) (*[]RecurringActivity, error) {
+	r0, err := stg.RecurringActivityStorage.Query(ctx, query)
+	return r0, stg.classify(err)
+}
+
+func (stg kindedRecurringActivityStorage) Update(ctx context.Context, activity RecurringActivity) error {
+	return stg.classify(stg.RecurringActivityStorage.Update(ctx, activity))
+}
+
+func (stg kindedRecurringActivityStorage) Delete(ctx context.Context, userId string, id uuid.UUID) error {
+	return stg.classify(stg.RecurringActivityStorage.Delete(ctx, userId, id))
+}
+
+func (stg kindedRecurringActivityStorage) DeleteForPlan(ctx context.Context, userId string, planId uuid.UUID) error {
+	return stg.classify(stg.RecurringActivityStorage.DeleteForPlan(ctx, userId, planId))
+}
+
+func (stg kindedRecurringActivityStorage) DeleteAllForUser(ctx context.Context, userId string) error {
+	return stg.classify(stg.RecurringActivityStorage.DeleteAllForUser(ctx, userId))
+}
+
+func (stg kindedRecurringActivityStorage) Put(ctx context.Context, activity RecurringActivity) error {
+	return stg.classify(stg.RecurringActivityStorage.Put(ctx, activity))
+}
+
+func (stg kindedRecurringActivityStorage) UserIds(ctx context.Context) ([]string, error) {
+	r0, err := stg.RecurringActivityStorage.UserIds(ctx)
+	return r0, stg.classify(err)
+}
+
+type kindedPlanStorage struct {
+	PlanStorage
+	classify func(error) error
+}
+
+func (stg kindedPlanStorage) Create(ctx context.Context, plan Plan) (Plan, error) {
+	r0, err := stg.PlanStorage.Create(ctx, plan)
+	return r0, stg.classify(err)
+}
+
+func (stg kindedPlanStorage) Read(ctx context.Context, userId string, id uuid.UUID) (*Plan, error) {
+	r0, err := stg.PlanStorage.Read(ctx, userId, id)
+	return r0, stg.classify(err)
+}
+
+func (stg kindedPlanStorage) Query(ctx context.Context, query PlanStorageQuery) (*[]Plan, error) {
+	r0, err := stg.PlanStorage.Query(ctx, query)
+	return r0, stg.classify(err)
+}
+
+func (stg kindedPlanStorage) Update(ctx context.Context, plan Plan) error {
+	return stg.classify(stg.PlanStorage.Update(ctx, plan))
+}
+
+func (stg kindedPlanStorage) Delete(ctx context.Context, userId string, id uuid.UUID) error {
+	return stg.classify(stg.PlanStorage.Delete(ctx, userId, id))
+}
+
+func (stg kindedPlanStorage) DeleteAllForUser(ctx context.Context, userId string) error {
+	return stg.classify(stg.PlanStorage.DeleteAllForUser(ctx, userId))
+}
+
+func (stg kindedPlanStorage) Put(ctx context.Context, plan Plan) error {
+	return stg.classify(stg.PlanStorage.Put(ctx, plan))
+}
+
+func (stg kindedPlanStorage) UserIds(ctx context.Context) ([]string, error) {
+	r0, err := stg.PlanStorage.UserIds(ctx)
+	return r0, stg.classify(err)
+}
+
+type kindedAuditStorage struct {
+	AuditStorage
+	classify func(error) error
+}
+
+func (stg kindedAuditStorage) Append(ctx context.Context, entry AuditEntry) error {
+	return stg.classify(stg.AuditStorage.Append(ctx, entry))
+}
+
+func (stg kindedAuditStorage) History(ctx context.Context, userId string, entityType string, entityId uuid.UUID) (*[]AuditEntry, error) {
+	r0, err := stg.AuditStorage.History(ctx, userId, entityType, entityId)
+	return r0, stg.classify(err)
+}
+
+func (stg kindedAuditStorage) ForUser(ctx context.Context, userId string) (*[]AuditEntry, error) {
+	r0, err := stg.AuditStorage.ForUser(ctx, userId)
+	return r0, stg.classify(err)
+}
+
+func (stg kindedAuditStorage) DeleteAllForUser(ctx context.Context, userId string) error {
+	return stg.classify(stg.AuditStorage.DeleteAllForUser(ctx, userId))
+}
+
+type kindedUnitOfWork struct {
+	UnitOfWork
+	classify func(error) error
+}
+
+func (stg kindedUnitOfWork) Do(ctx context.Context, fn func(Storage) error) error {
+	return stg.classify(stg.UnitOfWork.Do(ctx, fn))
+}
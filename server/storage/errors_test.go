@@ -0,0 +1,82 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/gocql/gocql"
+	"github.com/lib/pq"
+	"github.com/mattn/go-sqlite3"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestBackendErrorKinds(t *testing.T) {
+	plainErr := errors.New("something else")
+	cases := []struct {
+		name     string
+		err      error
+		expected error
+	}{
+		{"sqlite busy", sqliteError(sqlite3.Error{Code: sqlite3.ErrBusy}), ErrUnavailable},
+		{"sqlite unique", sqliteError(sqlite3.Error{Code: sqlite3.ErrConstraint, ExtendedCode: sqlite3.ErrConstraintUnique}), ErrConflict},
+		{"sqlite foreign key", sqliteError(sqlite3.Error{Code: sqlite3.ErrConstraint, ExtendedCode: sqlite3.ErrConstraintForeignKey}), ErrInvalid},
+		{"postgres unique", postgresError(&pq.Error{Code: "23505"}), ErrConflict},
+		{"postgres not null", postgresError(&pq.Error{Code: "23502"}), ErrInvalid},
+		{"postgres connection", postgresError(&pq.Error{Code: "08006"}), ErrUnavailable},
+		{"postgres deadlock", postgresError(&pq.Error{Code: "40P01"}), ErrConflict},
+		{"cassandra no hosts", cassandraError(gocql.ErrNoConnections), ErrUnavailable},
+		{"cassandra timeout", cassandraError(gocql.ErrTimeoutNoResponse), ErrUnavailable},
+		{"deadline", sqliteError(context.DeadlineExceeded), ErrUnavailable},
+		{"not found", postgresError(ErrNotFound), ErrNotFound},
+		{"version conflict", cassandraError(ErrVersionConflict), ErrVersionConflict},
+	}
+	for _, c := range cases {
+		if !errors.Is(c.err, c.expected) {
+			t.Errorf("%s: expected %v, got %v", c.name, c.expected, c.err)
+		}
+	}
+	if sqliteError(plainErr) != plainErr || sqliteError(nil) != nil {
+		t.Errorf("Expected unknown errors and nil to pass through")
+	}
+	invalidQuery := error(cassandraRequestError{code: gocql.ErrCodeInvalid})
+	if cassandraError(invalidQuery) != invalidQuery {
+		t.Errorf("Expected invalid queries, a bug of ours, to stay unclassified")
+	}
+	if !errors.Is(ErrVersionConflict, ErrConflict) || !errors.Is(ErrInvalidCursor, ErrInvalid) {
+		t.Errorf("Expected version conflicts and invalid cursors to be of their kind")
+	}
+}
+
+type cassandraRequestError struct {
+	code int
+}
+
+func (err cassandraRequestError) Code() int       { return err.code }
+func (err cassandraRequestError) Message() string { return "request error" }
+func (err cassandraRequestError) Error() string   { return err.Message() }
+
+func TestWithErrorKindsClassifiesEveryStorage(t *testing.T) {
+	ctx := context.Background()
+	busy := sqlite3.Error{Code: sqlite3.ErrBusy}
+	activities := NewMockActivityStorage(t)
+	activities.EXPECT().Delete(mock.Anything, "some-user", mock.Anything).Return(busy).Once()
+	uow := NewMockUnitOfWork(t)
+	uow.EXPECT().Do(mock.Anything, mock.Anything).Return(busy).Once()
+	strg := withErrorKinds(Storage{
+		Activity:          activities,
+		RecurringActivity: NewMockRecurringActivityStorage(t),
+		Plan:              NewMockPlanStorage(t),
+		Audit:             NewMockAuditStorage(t),
+		UnitOfWork:        uow,
+	}, sqliteError)
+
+	err := strg.Activity.Delete(ctx, "some-user", [16]byte{})
+	if !errors.Is(err, ErrUnavailable) || !errors.As(err, &busy) {
+		t.Errorf("Expected a classified error keeping its cause, got %v", err)
+	}
+	err = strg.UnitOfWork.Do(ctx, func(Storage) error { return nil })
+	if !errors.Is(err, ErrUnavailable) {
+		t.Errorf("Expected unit of work errors to be classified, got %v", err)
+	}
+}
@@ -0,0 +1,192 @@
+// kindgen writes errors_kinded.go, the wrappers withErrorKinds puts around the
+// storage interfaces so every error they return is classified. Run it through
+// go generate in the storage package after changing one of the interfaces.
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"go/ast"
+	"go/format"
+	"go/parser"
+	"go/printer"
+	"go/token"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// The interfaces wrapped, in the order they are written
+var interfaces = []string{"ActivityStorage", "RecurringActivityStorage", "PlanStorage", "AuditStorage", "UnitOfWork"}
+
+const output = "errors_kinded.go"
+
+func main() {
+	source, err := generate(".")
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "kindgen: %s\n", err)
+		os.Exit(1)
+	}
+	err = os.WriteFile(output, source, 0o644)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "kindgen: %s\n", err)
+		os.Exit(1)
+	}
+}
+
+// generate renders the wrappers of the interfaces declared in the package in
+// dir.
+func generate(dir string) ([]byte, error) {
+	fset := token.NewFileSet()
+	packages, err := parser.ParseDir(fset, dir, func(info os.FileInfo) bool {
+		return !strings.HasSuffix(info.Name(), "_test.go") && info.Name() != output
+	}, 0)
+	if err != nil {
+		return nil, err
+	}
+	pkg, ok := packages["storage"]
+	if !ok {
+		return nil, fmt.Errorf("no storage package in %s", dir)
+	}
+	declared := make(map[string]*ast.InterfaceType)
+	imports := make(map[string]string)
+	fileNames := make([]string, 0, len(pkg.Files))
+	for name := range pkg.Files {
+		fileNames = append(fileNames, name)
+	}
+	sort.Strings(fileNames)
+	for _, name := range fileNames {
+		file := pkg.Files[name]
+		found := false
+		ast.Inspect(file, func(node ast.Node) bool {
+			spec, ok := node.(*ast.TypeSpec)
+			if !ok {
+				return true
+			}
+			if iface, ok := spec.Type.(*ast.InterfaceType); ok {
+				declared[spec.Name.Name] = iface
+				found = true
+			}
+			return false
+		})
+		if !found {
+			continue
+		}
+		for _, spec := range file.Imports {
+			path := strings.Trim(spec.Path.Value, `"`)
+			alias := filepath.Base(path)
+			if spec.Name != nil {
+				alias = spec.Name.Name
+			}
+			imports[alias] = path
+		}
+	}
+
+	var body bytes.Buffer
+	for _, name := range interfaces {
+		iface, ok := declared[name]
+		if !ok {
+			return nil, fmt.Errorf("interface %s not found", name)
+		}
+		err = writeWrapper(&body, fset, name, iface)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var source bytes.Buffer
+	source.WriteString("// Code generated by go run ./internal/kindgen. DO NOT EDIT.\n\npackage storage\n\nimport (\n")
+	aliases := make([]string, 0, len(imports))
+	for alias := range imports {
+		aliases = append(aliases, alias)
+	}
+	// The standard library comes first and a blank line sets it apart, like
+	// goimports does
+	sort.Slice(aliases, func(i, j int) bool {
+		if standardImport(imports[aliases[i]]) != standardImport(imports[aliases[j]]) {
+			return standardImport(imports[aliases[i]])
+		}
+		return imports[aliases[i]] < imports[aliases[j]]
+	})
+	standard := true
+	for _, alias := range aliases {
+		if !strings.Contains(body.String(), alias+".") {
+			continue
+		}
+		if standard && !standardImport(imports[alias]) {
+			standard = false
+			source.WriteString("\n")
+		}
+		if filepath.Base(imports[alias]) == alias {
+			fmt.Fprintf(&source, "\t%q\n", imports[alias])
+		} else {
+			fmt.Fprintf(&source, "\t%s %q\n", alias, imports[alias])
+		}
+	}
+	source.WriteString(")\n")
+	source.Write(body.Bytes())
+	return format.Source(source.Bytes())
+}
+
+func writeWrapper(w *bytes.Buffer, fset *token.FileSet, name string, iface *ast.InterfaceType) error {
+	wrapper := "kinded" + name
+	fmt.Fprintf(w, "\ntype %s struct {\n\t%s\n\tclassify func(error) error\n}\n", wrapper, name)
+	for _, method := range iface.Methods.List {
+		funcType, ok := method.Type.(*ast.FuncType)
+		if !ok || len(method.Names) == 0 {
+			return fmt.Errorf("%s embeds %s, only methods are supported", name, render(fset, method.Type))
+		}
+		params := make([]string, 0)
+		args := make([]string, 0)
+		for _, field := range funcType.Params.List {
+			for _, paramName := range field.Names {
+				params = append(params, paramName.Name+" "+render(fset, field.Type))
+				args = append(args, paramName.Name)
+			}
+		}
+		results := make([]string, 0)
+		if funcType.Results != nil {
+			for _, field := range funcType.Results.List {
+				count := len(field.Names)
+				if count == 0 {
+					count = 1
+				}
+				for i := 0; i < count; i++ {
+					results = append(results, render(fset, field.Type))
+				}
+			}
+		}
+		if len(results) == 0 || results[len(results)-1] != "error" {
+			return fmt.Errorf("%s.%s doesn't return an error last", name, method.Names[0].Name)
+		}
+		resultList := results[0]
+		if len(results) > 1 {
+			resultList = "(" + strings.Join(results, ", ") + ")"
+		}
+		methodName := method.Names[0].Name
+		call := fmt.Sprintf("stg.%s.%s(%s)", name, methodName, strings.Join(args, ", "))
+		fmt.Fprintf(w, "\nfunc (stg %s) %s(%s) %s {\n", wrapper, methodName, strings.Join(params, ", "), resultList)
+		if len(results) == 1 {
+			fmt.Fprintf(w, "\treturn stg.classify(%s)\n}\n", call)
+			continue
+		}
+		values := make([]string, 0, len(results)-1)
+		for i := range results[:len(results)-1] {
+			values = append(values, fmt.Sprintf("r%d", i))
+		}
+		fmt.Fprintf(w, "\t%s, err := %s\n", strings.Join(values, ", "), call)
+		fmt.Fprintf(w, "\treturn %s, stg.classify(err)\n}\n", strings.Join(values, ", "))
+	}
+	return nil
+}
+
+func standardImport(path string) bool {
+	return !strings.Contains(strings.Split(path, "/")[0], ".")
+}
+
+func render(fset *token.FileSet, expr ast.Expr) string {
+	var buf bytes.Buffer
+	printer.Fprint(&buf, fset, expr)
+	return buf.String()
+}
@@ -0,0 +1,23 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestGeneratedWrappersAreUpToDate(t *testing.T) {
+	dir := filepath.Join("..", "..")
+	generated, err := generate(dir)
+	if err != nil {
+		t.Fatalf("Error generating wrappers: %s", err)
+	}
+	written, err := os.ReadFile(filepath.Join(dir, output))
+	if err != nil {
+		t.Fatalf("Error reading %s: %s", output, err)
+	}
+	if !bytes.Equal(generated, written) {
+		t.Errorf("%s is out of date, run go generate in the storage package", output)
+	}
+}
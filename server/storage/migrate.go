@@ -0,0 +1,96 @@
+package storage
+
+import (
+	"context"
+	"sort"
+
+	"github.com/google/uuid"
+)
+
+// UserIds lists every user holding a plan, activity or recurring activity,
+// sorted so repeated runs visit them in the same order.
+func UserIds(ctx context.Context, strg Storage) ([]string, error) {
+	seen := make(map[string]bool)
+	for _, list := range []func(ctx context.Context) ([]string, error){strg.Plan.UserIds, strg.Activity.UserIds, strg.RecurringActivity.UserIds} {
+		userIds, err := list(ctx)
+		if err != nil {
+			return nil, err
+		}
+		for _, userId := range userIds {
+			seen[userId] = true
+		}
+	}
+	userIds := make([]string, 0, len(seen))
+	for userId := range seen {
+		userIds = append(userIds, userId)
+	}
+	sort.Strings(userIds)
+	return userIds, nil
+}
+
+// CopyUser copies every plan, activity and recurring activity of the user,
+// trashed and archived ones included, keeping their ids and versions, and then
+// their audit log. The items are written past the target's audit wrappers, as
+// the copy isn't a change to them, and Put one at a time, so an interrupted
+// copy is finished by repeating it.
+func CopyUser(ctx context.Context, from Storage, to Storage, userId string) error {
+	archive, err := LoadArchive(ctx, from, userId)
+	if err != nil {
+		return err
+	}
+	to = unaudited(to)
+	for _, plan := range archive.Plans {
+		err = to.Plan.Put(ctx, plan)
+		if err != nil {
+			return err
+		}
+	}
+	for _, activity := range archive.RecurringActivities {
+		err = to.RecurringActivity.Put(ctx, activity)
+		if err != nil {
+			return err
+		}
+	}
+	err = forEachActivity(ctx, from, userId, func(activity Activity) error {
+		return to.Activity.Put(ctx, activity)
+	})
+	if err != nil {
+		return err
+	}
+	return copyAuditLog(ctx, from, to, userId)
+}
+
+// copyAuditLog appends the user's audit entries the target doesn't hold yet
+func copyAuditLog(ctx context.Context, from Storage, to Storage, userId string) error {
+	entries, err := from.Audit.ForUser(ctx, userId)
+	if err != nil {
+		return err
+	}
+	copied, err := to.Audit.ForUser(ctx, userId)
+	if err != nil {
+		return err
+	}
+	copiedIds := make(map[uuid.UUID]bool, len(*copied))
+	for _, entry := range *copied {
+		copiedIds[entry.Id] = true
+	}
+	for _, entry := range *entries {
+		if copiedIds[entry.Id] {
+			continue
+		}
+		err = to.Audit.Append(ctx, entry)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// CountAuditEntries counts the entries of the user's audit log
+func CountAuditEntries(ctx context.Context, strg Storage, userId string) (int, error) {
+	entries, err := strg.Audit.ForUser(ctx, userId)
+	if err != nil {
+		return 0, err
+	}
+	return len(*entries), nil
+}
@@ -0,0 +1,155 @@
+package storage
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Migrations are append-only: never edit or reorder an applied migration,
+// add a new one with the next version instead. The checksum of every applied
+// migration is recorded so edits to old migrations are caught on startup.
+type schemaMigration struct {
+	Version     int
+	Description string
+	Statements  []string
+}
+
+func (m schemaMigration) checksum() string {
+	hash := sha256.New()
+	for _, statement := range m.Statements {
+		hash.Write([]byte(statement))
+		hash.Write([]byte{0})
+	}
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+type appliedMigration struct {
+	Version  int
+	Checksum string
+}
+
+func validateMigrations(migrations []schemaMigration) error {
+	for i, migration := range migrations {
+		if migration.Version != i+1 {
+			return fmt.Errorf("migration %d is out of sequence, expected version %d", migration.Version, i+1)
+		}
+	}
+	return nil
+}
+
+// pendingMigrations checks the recorded history against the known migrations
+// and returns the ones still to be applied, in order.
+func pendingMigrations(applied []appliedMigration, migrations []schemaMigration) ([]schemaMigration, error) {
+	err := validateMigrations(migrations)
+	if err != nil {
+		return nil, err
+	}
+	if len(applied) > len(migrations) {
+		return nil, fmt.Errorf("database schema version %d is newer than this build supports (%d)", len(applied), len(migrations))
+	}
+	for i, record := range applied {
+		migration := migrations[i]
+		if record.Version != migration.Version {
+			return nil, fmt.Errorf("database schema history is inconsistent at version %d", migration.Version)
+		}
+		if record.Checksum != migration.checksum() {
+			return nil, fmt.Errorf("checksum mismatch for applied migration %d (%s)", migration.Version, migration.Description)
+		}
+	}
+	return migrations[len(applied):], nil
+}
+
+// sqlMigrationDialect holds the bookkeeping statements that differ between
+// database/sql backends. Lock and Unlock are optional and run on the same
+// connection as the migrations, so session level locks can be used.
+type sqlMigrationDialect struct {
+	CreateVersionTable string
+	InsertVersion      string
+	Lock               string
+	Unlock             string
+}
+
+func migrateSqlDatabase(db *sql.DB, dialect sqlMigrationDialect, migrations []schemaMigration) error {
+	ctx := context.Background()
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	if dialect.Lock != "" {
+		_, err = conn.ExecContext(ctx, dialect.Lock)
+		if err != nil {
+			return err
+		}
+		defer conn.ExecContext(ctx, dialect.Unlock)
+	}
+	_, err = conn.ExecContext(ctx, dialect.CreateVersionTable)
+	if err != nil {
+		return err
+	}
+	applied, err := readAppliedSqlMigrations(ctx, conn)
+	if err != nil {
+		return err
+	}
+	pending, err := pendingMigrations(applied, migrations)
+	if err != nil {
+		return err
+	}
+	for _, migration := range pending {
+		err = applySqlMigration(ctx, conn, dialect, migration)
+		if err != nil {
+			return fmt.Errorf("applying migration %d (%s): %w", migration.Version, migration.Description, err)
+		}
+	}
+	return nil
+}
+
+func readAppliedSqlMigrations(ctx context.Context, conn *sql.Conn) ([]appliedMigration, error) {
+	rows, err := conn.QueryContext(ctx, `SELECT version, checksum FROM schema_version ORDER BY version ASC;`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	applied := make([]appliedMigration, 0)
+	for rows.Next() {
+		var migration appliedMigration
+		err = rows.Scan(&migration.Version, &migration.Checksum)
+		if err != nil {
+			return nil, err
+		}
+		applied = append(applied, migration)
+	}
+	return applied, rows.Err()
+}
+
+func applySqlMigration(ctx context.Context, conn *sql.Conn, dialect sqlMigrationDialect, migration schemaMigration) error {
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	for _, statement := range migration.Statements {
+		if strings.TrimSpace(statement) == "" {
+			continue
+		}
+		_, err = tx.ExecContext(ctx, statement)
+		if err != nil {
+			return err
+		}
+	}
+	_, err = tx.ExecContext(ctx, dialect.InsertVersion,
+		migration.Version,
+		migration.Description,
+		migration.checksum(),
+		time.Now().UTC(),
+	)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
@@ -3,6 +3,8 @@
 package storage
 
 import (
+	context "context"
+
 	uuid "github.com/google/uuid"
 	mock "github.com/stretchr/testify/mock"
 )
@@ -20,23 +22,23 @@ func (_m *MockActivityStorage) EXPECT() *MockActivityStorage_Expecter {
 	return &MockActivityStorage_Expecter{mock: &_m.Mock}
 }
 
-// Create provides a mock function with given fields: activity
-func (_m *MockActivityStorage) Create(activity Activity) (Activity, error) {
-	ret := _m.Called(activity)
+// Create provides a mock function with given fields: ctx, activity
+func (_m *MockActivityStorage) Create(ctx context.Context, activity Activity) (Activity, error) {
+	ret := _m.Called(ctx, activity)
 
 	var r0 Activity
 	var r1 error
-	if rf, ok := ret.Get(0).(func(Activity) (Activity, error)); ok {
-		return rf(activity)
+	if rf, ok := ret.Get(0).(func(context.Context, Activity) (Activity, error)); ok {
+		return rf(ctx, activity)
 	}
-	if rf, ok := ret.Get(0).(func(Activity) Activity); ok {
-		r0 = rf(activity)
+	if rf, ok := ret.Get(0).(func(context.Context, Activity) Activity); ok {
+		r0 = rf(ctx, activity)
 	} else {
 		r0 = ret.Get(0).(Activity)
 	}
 
-	if rf, ok := ret.Get(1).(func(Activity) error); ok {
-		r1 = rf(activity)
+	if rf, ok := ret.Get(1).(func(context.Context, Activity) error); ok {
+		r1 = rf(ctx, activity)
 	} else {
 		r1 = ret.Error(1)
 	}
@@ -50,14 +52,15 @@ type MockActivityStorage_Create_Call struct {
 }
 
 // Create is a helper method to define mock.On call
+//   - ctx context.Context
 //   - activity Activity
-func (_e *MockActivityStorage_Expecter) Create(activity interface{}) *MockActivityStorage_Create_Call {
-	return &MockActivityStorage_Create_Call{Call: _e.mock.On("Create", activity)}
+func (_e *MockActivityStorage_Expecter) Create(ctx interface{}, activity interface{}) *MockActivityStorage_Create_Call {
+	return &MockActivityStorage_Create_Call{Call: _e.mock.On("Create", ctx, activity)}
 }
 
-func (_c *MockActivityStorage_Create_Call) Run(run func(activity Activity)) *MockActivityStorage_Create_Call {
+func (_c *MockActivityStorage_Create_Call) Run(run func(ctx context.Context, activity Activity)) *MockActivityStorage_Create_Call {
 	_c.Call.Run(func(args mock.Arguments) {
-		run(args[0].(Activity))
+		run(args[0].(context.Context), args[1].(Activity))
 	})
 	return _c
 }
@@ -67,18 +70,18 @@ func (_c *MockActivityStorage_Create_Call) Return(_a0 Activity, _a1 error) *Mock
 	return _c
 }
 
-func (_c *MockActivityStorage_Create_Call) RunAndReturn(run func(Activity) (Activity, error)) *MockActivityStorage_Create_Call {
+func (_c *MockActivityStorage_Create_Call) RunAndReturn(run func(context.Context, Activity) (Activity, error)) *MockActivityStorage_Create_Call {
 	_c.Call.Return(run)
 	return _c
 }
 
-// Delete provides a mock function with given fields: userId, id
-func (_m *MockActivityStorage) Delete(userId string, id uuid.UUID) error {
-	ret := _m.Called(userId, id)
+// Delete provides a mock function with given fields: ctx, userId, id
+func (_m *MockActivityStorage) Delete(ctx context.Context, userId string, id uuid.UUID) error {
+	ret := _m.Called(ctx, userId, id)
 
 	var r0 error
-	if rf, ok := ret.Get(0).(func(string, uuid.UUID) error); ok {
-		r0 = rf(userId, id)
+	if rf, ok := ret.Get(0).(func(context.Context, string, uuid.UUID) error); ok {
+		r0 = rf(ctx, userId, id)
 	} else {
 		r0 = ret.Error(0)
 	}
@@ -92,15 +95,16 @@ type MockActivityStorage_Delete_Call struct {
 }
 
 // Delete is a helper method to define mock.On call
+//   - ctx context.Context
 //   - userId string
 //   - id uuid.UUID
-func (_e *MockActivityStorage_Expecter) Delete(userId interface{}, id interface{}) *MockActivityStorage_Delete_Call {
-	return &MockActivityStorage_Delete_Call{Call: _e.mock.On("Delete", userId, id)}
+func (_e *MockActivityStorage_Expecter) Delete(ctx interface{}, userId interface{}, id interface{}) *MockActivityStorage_Delete_Call {
+	return &MockActivityStorage_Delete_Call{Call: _e.mock.On("Delete", ctx, userId, id)}
 }
 
-func (_c *MockActivityStorage_Delete_Call) Run(run func(userId string, id uuid.UUID)) *MockActivityStorage_Delete_Call {
+func (_c *MockActivityStorage_Delete_Call) Run(run func(ctx context.Context, userId string, id uuid.UUID)) *MockActivityStorage_Delete_Call {
 	_c.Call.Run(func(args mock.Arguments) {
-		run(args[0].(string), args[1].(uuid.UUID))
+		run(args[0].(context.Context), args[1].(string), args[2].(uuid.UUID))
 	})
 	return _c
 }
@@ -110,18 +114,61 @@ func (_c *MockActivityStorage_Delete_Call) Return(_a0 error) *MockActivityStorag
 	return _c
 }
 
-func (_c *MockActivityStorage_Delete_Call) RunAndReturn(run func(string, uuid.UUID) error) *MockActivityStorage_Delete_Call {
+func (_c *MockActivityStorage_Delete_Call) RunAndReturn(run func(context.Context, string, uuid.UUID) error) *MockActivityStorage_Delete_Call {
+	_c.Call.Return(run)
+	return _c
+}
+
+// DeleteAllForUser provides a mock function with given fields: ctx, userId
+func (_m *MockActivityStorage) DeleteAllForUser(ctx context.Context, userId string) error {
+	ret := _m.Called(ctx, userId)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, string) error); ok {
+		r0 = rf(ctx, userId)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// MockActivityStorage_DeleteAllForUser_Call is a *mock.Call that shadows Run/Return methods with type explicit version for method 'DeleteAllForUser'
+type MockActivityStorage_DeleteAllForUser_Call struct {
+	*mock.Call
+}
+
+// DeleteAllForUser is a helper method to define mock.On call
+//   - ctx context.Context
+//   - userId string
+func (_e *MockActivityStorage_Expecter) DeleteAllForUser(ctx interface{}, userId interface{}) *MockActivityStorage_DeleteAllForUser_Call {
+	return &MockActivityStorage_DeleteAllForUser_Call{Call: _e.mock.On("DeleteAllForUser", ctx, userId)}
+}
+
+func (_c *MockActivityStorage_DeleteAllForUser_Call) Run(run func(ctx context.Context, userId string)) *MockActivityStorage_DeleteAllForUser_Call {
+	_c.Call.Run(func(args mock.Arguments) {
+		run(args[0].(context.Context), args[1].(string))
+	})
+	return _c
+}
+
+func (_c *MockActivityStorage_DeleteAllForUser_Call) Return(_a0 error) *MockActivityStorage_DeleteAllForUser_Call {
+	_c.Call.Return(_a0)
+	return _c
+}
+
+func (_c *MockActivityStorage_DeleteAllForUser_Call) RunAndReturn(run func(context.Context, string) error) *MockActivityStorage_DeleteAllForUser_Call {
 	_c.Call.Return(run)
 	return _c
 }
 
-// DeleteForPlan provides a mock function with given fields: userId, planId
-func (_m *MockActivityStorage) DeleteForPlan(userId string, planId uuid.UUID) error {
-	ret := _m.Called(userId, planId)
+// DeleteForPlan provides a mock function with given fields: ctx, userId, planId
+func (_m *MockActivityStorage) DeleteForPlan(ctx context.Context, userId string, planId uuid.UUID) error {
+	ret := _m.Called(ctx, userId, planId)
 
 	var r0 error
-	if rf, ok := ret.Get(0).(func(string, uuid.UUID) error); ok {
-		r0 = rf(userId, planId)
+	if rf, ok := ret.Get(0).(func(context.Context, string, uuid.UUID) error); ok {
+		r0 = rf(ctx, userId, planId)
 	} else {
 		r0 = ret.Error(0)
 	}
@@ -135,15 +182,16 @@ type MockActivityStorage_DeleteForPlan_Call struct {
 }
 
 // DeleteForPlan is a helper method to define mock.On call
+//   - ctx context.Context
 //   - userId string
 //   - planId uuid.UUID
-func (_e *MockActivityStorage_Expecter) DeleteForPlan(userId interface{}, planId interface{}) *MockActivityStorage_DeleteForPlan_Call {
-	return &MockActivityStorage_DeleteForPlan_Call{Call: _e.mock.On("DeleteForPlan", userId, planId)}
+func (_e *MockActivityStorage_Expecter) DeleteForPlan(ctx interface{}, userId interface{}, planId interface{}) *MockActivityStorage_DeleteForPlan_Call {
+	return &MockActivityStorage_DeleteForPlan_Call{Call: _e.mock.On("DeleteForPlan", ctx, userId, planId)}
 }
 
-func (_c *MockActivityStorage_DeleteForPlan_Call) Run(run func(userId string, planId uuid.UUID)) *MockActivityStorage_DeleteForPlan_Call {
+func (_c *MockActivityStorage_DeleteForPlan_Call) Run(run func(ctx context.Context, userId string, planId uuid.UUID)) *MockActivityStorage_DeleteForPlan_Call {
 	_c.Call.Run(func(args mock.Arguments) {
-		run(args[0].(string), args[1].(uuid.UUID))
+		run(args[0].(context.Context), args[1].(string), args[2].(uuid.UUID))
 	})
 	return _c
 }
@@ -153,30 +201,73 @@ func (_c *MockActivityStorage_DeleteForPlan_Call) Return(_a0 error) *MockActivit
 	return _c
 }
 
-func (_c *MockActivityStorage_DeleteForPlan_Call) RunAndReturn(run func(string, uuid.UUID) error) *MockActivityStorage_DeleteForPlan_Call {
+func (_c *MockActivityStorage_DeleteForPlan_Call) RunAndReturn(run func(context.Context, string, uuid.UUID) error) *MockActivityStorage_DeleteForPlan_Call {
 	_c.Call.Return(run)
 	return _c
 }
 
-// Query provides a mock function with given fields: query
-func (_m *MockActivityStorage) Query(query ActivityStorageQuery) (*[]Activity, error) {
-	ret := _m.Called(query)
+// Put provides a mock function with given fields: ctx, activity
+func (_m *MockActivityStorage) Put(ctx context.Context, activity Activity) error {
+	ret := _m.Called(ctx, activity)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, Activity) error); ok {
+		r0 = rf(ctx, activity)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// MockActivityStorage_Put_Call is a *mock.Call that shadows Run/Return methods with type explicit version for method 'Put'
+type MockActivityStorage_Put_Call struct {
+	*mock.Call
+}
+
+// Put is a helper method to define mock.On call
+//   - ctx context.Context
+//   - activity Activity
+func (_e *MockActivityStorage_Expecter) Put(ctx interface{}, activity interface{}) *MockActivityStorage_Put_Call {
+	return &MockActivityStorage_Put_Call{Call: _e.mock.On("Put", ctx, activity)}
+}
+
+func (_c *MockActivityStorage_Put_Call) Run(run func(ctx context.Context, activity Activity)) *MockActivityStorage_Put_Call {
+	_c.Call.Run(func(args mock.Arguments) {
+		run(args[0].(context.Context), args[1].(Activity))
+	})
+	return _c
+}
+
+func (_c *MockActivityStorage_Put_Call) Return(_a0 error) *MockActivityStorage_Put_Call {
+	_c.Call.Return(_a0)
+	return _c
+}
+
+func (_c *MockActivityStorage_Put_Call) RunAndReturn(run func(context.Context, Activity) error) *MockActivityStorage_Put_Call {
+	_c.Call.Return(run)
+	return _c
+}
+
+// Query provides a mock function with given fields: ctx, query
+func (_m *MockActivityStorage) Query(ctx context.Context, query ActivityStorageQuery) (*[]Activity, error) {
+	ret := _m.Called(ctx, query)
 
 	var r0 *[]Activity
 	var r1 error
-	if rf, ok := ret.Get(0).(func(ActivityStorageQuery) (*[]Activity, error)); ok {
-		return rf(query)
+	if rf, ok := ret.Get(0).(func(context.Context, ActivityStorageQuery) (*[]Activity, error)); ok {
+		return rf(ctx, query)
 	}
-	if rf, ok := ret.Get(0).(func(ActivityStorageQuery) *[]Activity); ok {
-		r0 = rf(query)
+	if rf, ok := ret.Get(0).(func(context.Context, ActivityStorageQuery) *[]Activity); ok {
+		r0 = rf(ctx, query)
 	} else {
 		if ret.Get(0) != nil {
 			r0 = ret.Get(0).(*[]Activity)
 		}
 	}
 
-	if rf, ok := ret.Get(1).(func(ActivityStorageQuery) error); ok {
-		r1 = rf(query)
+	if rf, ok := ret.Get(1).(func(context.Context, ActivityStorageQuery) error); ok {
+		r1 = rf(ctx, query)
 	} else {
 		r1 = ret.Error(1)
 	}
@@ -190,14 +281,15 @@ type MockActivityStorage_Query_Call struct {
 }
 
 // Query is a helper method to define mock.On call
+//   - ctx context.Context
 //   - query ActivityStorageQuery
-func (_e *MockActivityStorage_Expecter) Query(query interface{}) *MockActivityStorage_Query_Call {
-	return &MockActivityStorage_Query_Call{Call: _e.mock.On("Query", query)}
+func (_e *MockActivityStorage_Expecter) Query(ctx interface{}, query interface{}) *MockActivityStorage_Query_Call {
+	return &MockActivityStorage_Query_Call{Call: _e.mock.On("Query", ctx, query)}
 }
 
-func (_c *MockActivityStorage_Query_Call) Run(run func(query ActivityStorageQuery)) *MockActivityStorage_Query_Call {
+func (_c *MockActivityStorage_Query_Call) Run(run func(ctx context.Context, query ActivityStorageQuery)) *MockActivityStorage_Query_Call {
 	_c.Call.Run(func(args mock.Arguments) {
-		run(args[0].(ActivityStorageQuery))
+		run(args[0].(context.Context), args[1].(ActivityStorageQuery))
 	})
 	return _c
 }
@@ -207,30 +299,30 @@ func (_c *MockActivityStorage_Query_Call) Return(_a0 *[]Activity, _a1 error) *Mo
 	return _c
 }
 
-func (_c *MockActivityStorage_Query_Call) RunAndReturn(run func(ActivityStorageQuery) (*[]Activity, error)) *MockActivityStorage_Query_Call {
+func (_c *MockActivityStorage_Query_Call) RunAndReturn(run func(context.Context, ActivityStorageQuery) (*[]Activity, error)) *MockActivityStorage_Query_Call {
 	_c.Call.Return(run)
 	return _c
 }
 
-// Read provides a mock function with given fields: userId, id
-func (_m *MockActivityStorage) Read(userId string, id uuid.UUID) (*Activity, error) {
-	ret := _m.Called(userId, id)
+// Read provides a mock function with given fields: ctx, userId, id
+func (_m *MockActivityStorage) Read(ctx context.Context, userId string, id uuid.UUID) (*Activity, error) {
+	ret := _m.Called(ctx, userId, id)
 
 	var r0 *Activity
 	var r1 error
-	if rf, ok := ret.Get(0).(func(string, uuid.UUID) (*Activity, error)); ok {
-		return rf(userId, id)
+	if rf, ok := ret.Get(0).(func(context.Context, string, uuid.UUID) (*Activity, error)); ok {
+		return rf(ctx, userId, id)
 	}
-	if rf, ok := ret.Get(0).(func(string, uuid.UUID) *Activity); ok {
-		r0 = rf(userId, id)
+	if rf, ok := ret.Get(0).(func(context.Context, string, uuid.UUID) *Activity); ok {
+		r0 = rf(ctx, userId, id)
 	} else {
 		if ret.Get(0) != nil {
 			r0 = ret.Get(0).(*Activity)
 		}
 	}
 
-	if rf, ok := ret.Get(1).(func(string, uuid.UUID) error); ok {
-		r1 = rf(userId, id)
+	if rf, ok := ret.Get(1).(func(context.Context, string, uuid.UUID) error); ok {
+		r1 = rf(ctx, userId, id)
 	} else {
 		r1 = ret.Error(1)
 	}
@@ -244,15 +336,16 @@ type MockActivityStorage_Read_Call struct {
 }
 
 // Read is a helper method to define mock.On call
+//   - ctx context.Context
 //   - userId string
 //   - id uuid.UUID
-func (_e *MockActivityStorage_Expecter) Read(userId interface{}, id interface{}) *MockActivityStorage_Read_Call {
-	return &MockActivityStorage_Read_Call{Call: _e.mock.On("Read", userId, id)}
+func (_e *MockActivityStorage_Expecter) Read(ctx interface{}, userId interface{}, id interface{}) *MockActivityStorage_Read_Call {
+	return &MockActivityStorage_Read_Call{Call: _e.mock.On("Read", ctx, userId, id)}
 }
 
-func (_c *MockActivityStorage_Read_Call) Run(run func(userId string, id uuid.UUID)) *MockActivityStorage_Read_Call {
+func (_c *MockActivityStorage_Read_Call) Run(run func(ctx context.Context, userId string, id uuid.UUID)) *MockActivityStorage_Read_Call {
 	_c.Call.Run(func(args mock.Arguments) {
-		run(args[0].(string), args[1].(uuid.UUID))
+		run(args[0].(context.Context), args[1].(string), args[2].(uuid.UUID))
 	})
 	return _c
 }
@@ -262,18 +355,73 @@ func (_c *MockActivityStorage_Read_Call) Return(_a0 *Activity, _a1 error) *MockA
 	return _c
 }
 
-func (_c *MockActivityStorage_Read_Call) RunAndReturn(run func(string, uuid.UUID) (*Activity, error)) *MockActivityStorage_Read_Call {
+func (_c *MockActivityStorage_Read_Call) RunAndReturn(run func(context.Context, string, uuid.UUID) (*Activity, error)) *MockActivityStorage_Read_Call {
 	_c.Call.Return(run)
 	return _c
 }
 
-// Update provides a mock function with given fields: activity
-func (_m *MockActivityStorage) Update(activity Activity) error {
-	ret := _m.Called(activity)
+// Search provides a mock function with given fields: ctx, query
+func (_m *MockActivityStorage) Search(ctx context.Context, query ActivitySearchQuery) (*[]Activity, error) {
+	ret := _m.Called(ctx, query)
+
+	var r0 *[]Activity
+	var r1 error
+	if rf, ok := ret.Get(0).(func(context.Context, ActivitySearchQuery) (*[]Activity, error)); ok {
+		return rf(ctx, query)
+	}
+	if rf, ok := ret.Get(0).(func(context.Context, ActivitySearchQuery) *[]Activity); ok {
+		r0 = rf(ctx, query)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*[]Activity)
+		}
+	}
+
+	if rf, ok := ret.Get(1).(func(context.Context, ActivitySearchQuery) error); ok {
+		r1 = rf(ctx, query)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// MockActivityStorage_Search_Call is a *mock.Call that shadows Run/Return methods with type explicit version for method 'Search'
+type MockActivityStorage_Search_Call struct {
+	*mock.Call
+}
+
+// Search is a helper method to define mock.On call
+//   - ctx context.Context
+//   - query ActivitySearchQuery
+func (_e *MockActivityStorage_Expecter) Search(ctx interface{}, query interface{}) *MockActivityStorage_Search_Call {
+	return &MockActivityStorage_Search_Call{Call: _e.mock.On("Search", ctx, query)}
+}
+
+func (_c *MockActivityStorage_Search_Call) Run(run func(ctx context.Context, query ActivitySearchQuery)) *MockActivityStorage_Search_Call {
+	_c.Call.Run(func(args mock.Arguments) {
+		run(args[0].(context.Context), args[1].(ActivitySearchQuery))
+	})
+	return _c
+}
+
+func (_c *MockActivityStorage_Search_Call) Return(_a0 *[]Activity, _a1 error) *MockActivityStorage_Search_Call {
+	_c.Call.Return(_a0, _a1)
+	return _c
+}
+
+func (_c *MockActivityStorage_Search_Call) RunAndReturn(run func(context.Context, ActivitySearchQuery) (*[]Activity, error)) *MockActivityStorage_Search_Call {
+	_c.Call.Return(run)
+	return _c
+}
+
+// Update provides a mock function with given fields: ctx, activity
+func (_m *MockActivityStorage) Update(ctx context.Context, activity Activity) error {
+	ret := _m.Called(ctx, activity)
 
 	var r0 error
-	if rf, ok := ret.Get(0).(func(Activity) error); ok {
-		r0 = rf(activity)
+	if rf, ok := ret.Get(0).(func(context.Context, Activity) error); ok {
+		r0 = rf(ctx, activity)
 	} else {
 		r0 = ret.Error(0)
 	}
@@ -287,14 +435,15 @@ type MockActivityStorage_Update_Call struct {
 }
 
 // Update is a helper method to define mock.On call
+//   - ctx context.Context
 //   - activity Activity
-func (_e *MockActivityStorage_Expecter) Update(activity interface{}) *MockActivityStorage_Update_Call {
-	return &MockActivityStorage_Update_Call{Call: _e.mock.On("Update", activity)}
+func (_e *MockActivityStorage_Expecter) Update(ctx interface{}, activity interface{}) *MockActivityStorage_Update_Call {
+	return &MockActivityStorage_Update_Call{Call: _e.mock.On("Update", ctx, activity)}
 }
 
-func (_c *MockActivityStorage_Update_Call) Run(run func(activity Activity)) *MockActivityStorage_Update_Call {
+func (_c *MockActivityStorage_Update_Call) Run(run func(ctx context.Context, activity Activity)) *MockActivityStorage_Update_Call {
 	_c.Call.Run(func(args mock.Arguments) {
-		run(args[0].(Activity))
+		run(args[0].(context.Context), args[1].(Activity))
 	})
 	return _c
 }
@@ -304,7 +453,61 @@ func (_c *MockActivityStorage_Update_Call) Return(_a0 error) *MockActivityStorag
 	return _c
 }
 
-func (_c *MockActivityStorage_Update_Call) RunAndReturn(run func(Activity) error) *MockActivityStorage_Update_Call {
+func (_c *MockActivityStorage_Update_Call) RunAndReturn(run func(context.Context, Activity) error) *MockActivityStorage_Update_Call {
+	_c.Call.Return(run)
+	return _c
+}
+
+// UserIds provides a mock function with given fields: ctx
+func (_m *MockActivityStorage) UserIds(ctx context.Context) ([]string, error) {
+	ret := _m.Called(ctx)
+
+	var r0 []string
+	var r1 error
+	if rf, ok := ret.Get(0).(func(context.Context) ([]string, error)); ok {
+		return rf(ctx)
+	}
+	if rf, ok := ret.Get(0).(func(context.Context) []string); ok {
+		r0 = rf(ctx)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).([]string)
+		}
+	}
+
+	if rf, ok := ret.Get(1).(func(context.Context) error); ok {
+		r1 = rf(ctx)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// MockActivityStorage_UserIds_Call is a *mock.Call that shadows Run/Return methods with type explicit version for method 'UserIds'
+type MockActivityStorage_UserIds_Call struct {
+	*mock.Call
+}
+
+// UserIds is a helper method to define mock.On call
+//   - ctx context.Context
+func (_e *MockActivityStorage_Expecter) UserIds(ctx interface{}) *MockActivityStorage_UserIds_Call {
+	return &MockActivityStorage_UserIds_Call{Call: _e.mock.On("UserIds", ctx)}
+}
+
+func (_c *MockActivityStorage_UserIds_Call) Run(run func(ctx context.Context)) *MockActivityStorage_UserIds_Call {
+	_c.Call.Run(func(args mock.Arguments) {
+		run(args[0].(context.Context))
+	})
+	return _c
+}
+
+func (_c *MockActivityStorage_UserIds_Call) Return(_a0 []string, _a1 error) *MockActivityStorage_UserIds_Call {
+	_c.Call.Return(_a0, _a1)
+	return _c
+}
+
+func (_c *MockActivityStorage_UserIds_Call) RunAndReturn(run func(context.Context) ([]string, error)) *MockActivityStorage_UserIds_Call {
 	_c.Call.Return(run)
 	return _c
 }
@@ -3,6 +3,8 @@
 package storage
 
 import (
+	context "context"
+
 	uuid "github.com/google/uuid"
 	mock "github.com/stretchr/testify/mock"
 )
@@ -20,23 +22,23 @@ func (_m *MockPlanStorage) EXPECT() *MockPlanStorage_Expecter {
 	return &MockPlanStorage_Expecter{mock: &_m.Mock}
 }
 
-// Create provides a mock function with given fields: plan
-func (_m *MockPlanStorage) Create(plan Plan) (Plan, error) {
-	ret := _m.Called(plan)
+// Create provides a mock function with given fields: ctx, plan
+func (_m *MockPlanStorage) Create(ctx context.Context, plan Plan) (Plan, error) {
+	ret := _m.Called(ctx, plan)
 
 	var r0 Plan
 	var r1 error
-	if rf, ok := ret.Get(0).(func(Plan) (Plan, error)); ok {
-		return rf(plan)
+	if rf, ok := ret.Get(0).(func(context.Context, Plan) (Plan, error)); ok {
+		return rf(ctx, plan)
 	}
-	if rf, ok := ret.Get(0).(func(Plan) Plan); ok {
-		r0 = rf(plan)
+	if rf, ok := ret.Get(0).(func(context.Context, Plan) Plan); ok {
+		r0 = rf(ctx, plan)
 	} else {
 		r0 = ret.Get(0).(Plan)
 	}
 
-	if rf, ok := ret.Get(1).(func(Plan) error); ok {
-		r1 = rf(plan)
+	if rf, ok := ret.Get(1).(func(context.Context, Plan) error); ok {
+		r1 = rf(ctx, plan)
 	} else {
 		r1 = ret.Error(1)
 	}
@@ -50,14 +52,15 @@ type MockPlanStorage_Create_Call struct {
 }
 
 // Create is a helper method to define mock.On call
+//   - ctx context.Context
 //   - plan Plan
-func (_e *MockPlanStorage_Expecter) Create(plan interface{}) *MockPlanStorage_Create_Call {
-	return &MockPlanStorage_Create_Call{Call: _e.mock.On("Create", plan)}
+func (_e *MockPlanStorage_Expecter) Create(ctx interface{}, plan interface{}) *MockPlanStorage_Create_Call {
+	return &MockPlanStorage_Create_Call{Call: _e.mock.On("Create", ctx, plan)}
 }
 
-func (_c *MockPlanStorage_Create_Call) Run(run func(plan Plan)) *MockPlanStorage_Create_Call {
+func (_c *MockPlanStorage_Create_Call) Run(run func(ctx context.Context, plan Plan)) *MockPlanStorage_Create_Call {
 	_c.Call.Run(func(args mock.Arguments) {
-		run(args[0].(Plan))
+		run(args[0].(context.Context), args[1].(Plan))
 	})
 	return _c
 }
@@ -67,18 +70,18 @@ func (_c *MockPlanStorage_Create_Call) Return(_a0 Plan, _a1 error) *MockPlanStor
 	return _c
 }
 
-func (_c *MockPlanStorage_Create_Call) RunAndReturn(run func(Plan) (Plan, error)) *MockPlanStorage_Create_Call {
+func (_c *MockPlanStorage_Create_Call) RunAndReturn(run func(context.Context, Plan) (Plan, error)) *MockPlanStorage_Create_Call {
 	_c.Call.Return(run)
 	return _c
 }
 
-// Delete provides a mock function with given fields: userId, id
-func (_m *MockPlanStorage) Delete(userId string, id uuid.UUID) error {
-	ret := _m.Called(userId, id)
+// Delete provides a mock function with given fields: ctx, userId, id
+func (_m *MockPlanStorage) Delete(ctx context.Context, userId string, id uuid.UUID) error {
+	ret := _m.Called(ctx, userId, id)
 
 	var r0 error
-	if rf, ok := ret.Get(0).(func(string, uuid.UUID) error); ok {
-		r0 = rf(userId, id)
+	if rf, ok := ret.Get(0).(func(context.Context, string, uuid.UUID) error); ok {
+		r0 = rf(ctx, userId, id)
 	} else {
 		r0 = ret.Error(0)
 	}
@@ -92,15 +95,16 @@ type MockPlanStorage_Delete_Call struct {
 }
 
 // Delete is a helper method to define mock.On call
+//   - ctx context.Context
 //   - userId string
 //   - id uuid.UUID
-func (_e *MockPlanStorage_Expecter) Delete(userId interface{}, id interface{}) *MockPlanStorage_Delete_Call {
-	return &MockPlanStorage_Delete_Call{Call: _e.mock.On("Delete", userId, id)}
+func (_e *MockPlanStorage_Expecter) Delete(ctx interface{}, userId interface{}, id interface{}) *MockPlanStorage_Delete_Call {
+	return &MockPlanStorage_Delete_Call{Call: _e.mock.On("Delete", ctx, userId, id)}
 }
 
-func (_c *MockPlanStorage_Delete_Call) Run(run func(userId string, id uuid.UUID)) *MockPlanStorage_Delete_Call {
+func (_c *MockPlanStorage_Delete_Call) Run(run func(ctx context.Context, userId string, id uuid.UUID)) *MockPlanStorage_Delete_Call {
 	_c.Call.Run(func(args mock.Arguments) {
-		run(args[0].(string), args[1].(uuid.UUID))
+		run(args[0].(context.Context), args[1].(string), args[2].(uuid.UUID))
 	})
 	return _c
 }
@@ -110,30 +114,116 @@ func (_c *MockPlanStorage_Delete_Call) Return(_a0 error) *MockPlanStorage_Delete
 	return _c
 }
 
-func (_c *MockPlanStorage_Delete_Call) RunAndReturn(run func(string, uuid.UUID) error) *MockPlanStorage_Delete_Call {
+func (_c *MockPlanStorage_Delete_Call) RunAndReturn(run func(context.Context, string, uuid.UUID) error) *MockPlanStorage_Delete_Call {
+	_c.Call.Return(run)
+	return _c
+}
+
+// DeleteAllForUser provides a mock function with given fields: ctx, userId
+func (_m *MockPlanStorage) DeleteAllForUser(ctx context.Context, userId string) error {
+	ret := _m.Called(ctx, userId)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, string) error); ok {
+		r0 = rf(ctx, userId)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// MockPlanStorage_DeleteAllForUser_Call is a *mock.Call that shadows Run/Return methods with type explicit version for method 'DeleteAllForUser'
+type MockPlanStorage_DeleteAllForUser_Call struct {
+	*mock.Call
+}
+
+// DeleteAllForUser is a helper method to define mock.On call
+//   - ctx context.Context
+//   - userId string
+func (_e *MockPlanStorage_Expecter) DeleteAllForUser(ctx interface{}, userId interface{}) *MockPlanStorage_DeleteAllForUser_Call {
+	return &MockPlanStorage_DeleteAllForUser_Call{Call: _e.mock.On("DeleteAllForUser", ctx, userId)}
+}
+
+func (_c *MockPlanStorage_DeleteAllForUser_Call) Run(run func(ctx context.Context, userId string)) *MockPlanStorage_DeleteAllForUser_Call {
+	_c.Call.Run(func(args mock.Arguments) {
+		run(args[0].(context.Context), args[1].(string))
+	})
+	return _c
+}
+
+func (_c *MockPlanStorage_DeleteAllForUser_Call) Return(_a0 error) *MockPlanStorage_DeleteAllForUser_Call {
+	_c.Call.Return(_a0)
+	return _c
+}
+
+func (_c *MockPlanStorage_DeleteAllForUser_Call) RunAndReturn(run func(context.Context, string) error) *MockPlanStorage_DeleteAllForUser_Call {
+	_c.Call.Return(run)
+	return _c
+}
+
+// Put provides a mock function with given fields: ctx, plan
+func (_m *MockPlanStorage) Put(ctx context.Context, plan Plan) error {
+	ret := _m.Called(ctx, plan)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, Plan) error); ok {
+		r0 = rf(ctx, plan)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// MockPlanStorage_Put_Call is a *mock.Call that shadows Run/Return methods with type explicit version for method 'Put'
+type MockPlanStorage_Put_Call struct {
+	*mock.Call
+}
+
+// Put is a helper method to define mock.On call
+//   - ctx context.Context
+//   - plan Plan
+func (_e *MockPlanStorage_Expecter) Put(ctx interface{}, plan interface{}) *MockPlanStorage_Put_Call {
+	return &MockPlanStorage_Put_Call{Call: _e.mock.On("Put", ctx, plan)}
+}
+
+func (_c *MockPlanStorage_Put_Call) Run(run func(ctx context.Context, plan Plan)) *MockPlanStorage_Put_Call {
+	_c.Call.Run(func(args mock.Arguments) {
+		run(args[0].(context.Context), args[1].(Plan))
+	})
+	return _c
+}
+
+func (_c *MockPlanStorage_Put_Call) Return(_a0 error) *MockPlanStorage_Put_Call {
+	_c.Call.Return(_a0)
+	return _c
+}
+
+func (_c *MockPlanStorage_Put_Call) RunAndReturn(run func(context.Context, Plan) error) *MockPlanStorage_Put_Call {
 	_c.Call.Return(run)
 	return _c
 }
 
-// Query provides a mock function with given fields: query
-func (_m *MockPlanStorage) Query(query PlanStorageQuery) (*[]Plan, error) {
-	ret := _m.Called(query)
+// Query provides a mock function with given fields: ctx, query
+func (_m *MockPlanStorage) Query(ctx context.Context, query PlanStorageQuery) (*[]Plan, error) {
+	ret := _m.Called(ctx, query)
 
 	var r0 *[]Plan
 	var r1 error
-	if rf, ok := ret.Get(0).(func(PlanStorageQuery) (*[]Plan, error)); ok {
-		return rf(query)
+	if rf, ok := ret.Get(0).(func(context.Context, PlanStorageQuery) (*[]Plan, error)); ok {
+		return rf(ctx, query)
 	}
-	if rf, ok := ret.Get(0).(func(PlanStorageQuery) *[]Plan); ok {
-		r0 = rf(query)
+	if rf, ok := ret.Get(0).(func(context.Context, PlanStorageQuery) *[]Plan); ok {
+		r0 = rf(ctx, query)
 	} else {
 		if ret.Get(0) != nil {
 			r0 = ret.Get(0).(*[]Plan)
 		}
 	}
 
-	if rf, ok := ret.Get(1).(func(PlanStorageQuery) error); ok {
-		r1 = rf(query)
+	if rf, ok := ret.Get(1).(func(context.Context, PlanStorageQuery) error); ok {
+		r1 = rf(ctx, query)
 	} else {
 		r1 = ret.Error(1)
 	}
@@ -147,14 +237,15 @@ type MockPlanStorage_Query_Call struct {
 }
 
 // Query is a helper method to define mock.On call
+//   - ctx context.Context
 //   - query PlanStorageQuery
-func (_e *MockPlanStorage_Expecter) Query(query interface{}) *MockPlanStorage_Query_Call {
-	return &MockPlanStorage_Query_Call{Call: _e.mock.On("Query", query)}
+func (_e *MockPlanStorage_Expecter) Query(ctx interface{}, query interface{}) *MockPlanStorage_Query_Call {
+	return &MockPlanStorage_Query_Call{Call: _e.mock.On("Query", ctx, query)}
 }
 
-func (_c *MockPlanStorage_Query_Call) Run(run func(query PlanStorageQuery)) *MockPlanStorage_Query_Call {
+func (_c *MockPlanStorage_Query_Call) Run(run func(ctx context.Context, query PlanStorageQuery)) *MockPlanStorage_Query_Call {
 	_c.Call.Run(func(args mock.Arguments) {
-		run(args[0].(PlanStorageQuery))
+		run(args[0].(context.Context), args[1].(PlanStorageQuery))
 	})
 	return _c
 }
@@ -164,30 +255,30 @@ func (_c *MockPlanStorage_Query_Call) Return(_a0 *[]Plan, _a1 error) *MockPlanSt
 	return _c
 }
 
-func (_c *MockPlanStorage_Query_Call) RunAndReturn(run func(PlanStorageQuery) (*[]Plan, error)) *MockPlanStorage_Query_Call {
+func (_c *MockPlanStorage_Query_Call) RunAndReturn(run func(context.Context, PlanStorageQuery) (*[]Plan, error)) *MockPlanStorage_Query_Call {
 	_c.Call.Return(run)
 	return _c
 }
 
-// Read provides a mock function with given fields: userId, id
-func (_m *MockPlanStorage) Read(userId string, id uuid.UUID) (*Plan, error) {
-	ret := _m.Called(userId, id)
+// Read provides a mock function with given fields: ctx, userId, id
+func (_m *MockPlanStorage) Read(ctx context.Context, userId string, id uuid.UUID) (*Plan, error) {
+	ret := _m.Called(ctx, userId, id)
 
 	var r0 *Plan
 	var r1 error
-	if rf, ok := ret.Get(0).(func(string, uuid.UUID) (*Plan, error)); ok {
-		return rf(userId, id)
+	if rf, ok := ret.Get(0).(func(context.Context, string, uuid.UUID) (*Plan, error)); ok {
+		return rf(ctx, userId, id)
 	}
-	if rf, ok := ret.Get(0).(func(string, uuid.UUID) *Plan); ok {
-		r0 = rf(userId, id)
+	if rf, ok := ret.Get(0).(func(context.Context, string, uuid.UUID) *Plan); ok {
+		r0 = rf(ctx, userId, id)
 	} else {
 		if ret.Get(0) != nil {
 			r0 = ret.Get(0).(*Plan)
 		}
 	}
 
-	if rf, ok := ret.Get(1).(func(string, uuid.UUID) error); ok {
-		r1 = rf(userId, id)
+	if rf, ok := ret.Get(1).(func(context.Context, string, uuid.UUID) error); ok {
+		r1 = rf(ctx, userId, id)
 	} else {
 		r1 = ret.Error(1)
 	}
@@ -201,15 +292,16 @@ type MockPlanStorage_Read_Call struct {
 }
 
 // Read is a helper method to define mock.On call
+//   - ctx context.Context
 //   - userId string
 //   - id uuid.UUID
-func (_e *MockPlanStorage_Expecter) Read(userId interface{}, id interface{}) *MockPlanStorage_Read_Call {
-	return &MockPlanStorage_Read_Call{Call: _e.mock.On("Read", userId, id)}
+func (_e *MockPlanStorage_Expecter) Read(ctx interface{}, userId interface{}, id interface{}) *MockPlanStorage_Read_Call {
+	return &MockPlanStorage_Read_Call{Call: _e.mock.On("Read", ctx, userId, id)}
 }
 
-func (_c *MockPlanStorage_Read_Call) Run(run func(userId string, id uuid.UUID)) *MockPlanStorage_Read_Call {
+func (_c *MockPlanStorage_Read_Call) Run(run func(ctx context.Context, userId string, id uuid.UUID)) *MockPlanStorage_Read_Call {
 	_c.Call.Run(func(args mock.Arguments) {
-		run(args[0].(string), args[1].(uuid.UUID))
+		run(args[0].(context.Context), args[1].(string), args[2].(uuid.UUID))
 	})
 	return _c
 }
@@ -219,18 +311,18 @@ func (_c *MockPlanStorage_Read_Call) Return(_a0 *Plan, _a1 error) *MockPlanStora
 	return _c
 }
 
-func (_c *MockPlanStorage_Read_Call) RunAndReturn(run func(string, uuid.UUID) (*Plan, error)) *MockPlanStorage_Read_Call {
+func (_c *MockPlanStorage_Read_Call) RunAndReturn(run func(context.Context, string, uuid.UUID) (*Plan, error)) *MockPlanStorage_Read_Call {
 	_c.Call.Return(run)
 	return _c
 }
 
-// Update provides a mock function with given fields: plan
-func (_m *MockPlanStorage) Update(plan Plan) error {
-	ret := _m.Called(plan)
+// Update provides a mock function with given fields: ctx, plan
+func (_m *MockPlanStorage) Update(ctx context.Context, plan Plan) error {
+	ret := _m.Called(ctx, plan)
 
 	var r0 error
-	if rf, ok := ret.Get(0).(func(Plan) error); ok {
-		r0 = rf(plan)
+	if rf, ok := ret.Get(0).(func(context.Context, Plan) error); ok {
+		r0 = rf(ctx, plan)
 	} else {
 		r0 = ret.Error(0)
 	}
@@ -244,14 +336,15 @@ type MockPlanStorage_Update_Call struct {
 }
 
 // Update is a helper method to define mock.On call
+//   - ctx context.Context
 //   - plan Plan
-func (_e *MockPlanStorage_Expecter) Update(plan interface{}) *MockPlanStorage_Update_Call {
-	return &MockPlanStorage_Update_Call{Call: _e.mock.On("Update", plan)}
+func (_e *MockPlanStorage_Expecter) Update(ctx interface{}, plan interface{}) *MockPlanStorage_Update_Call {
+	return &MockPlanStorage_Update_Call{Call: _e.mock.On("Update", ctx, plan)}
 }
 
-func (_c *MockPlanStorage_Update_Call) Run(run func(plan Plan)) *MockPlanStorage_Update_Call {
+func (_c *MockPlanStorage_Update_Call) Run(run func(ctx context.Context, plan Plan)) *MockPlanStorage_Update_Call {
 	_c.Call.Run(func(args mock.Arguments) {
-		run(args[0].(Plan))
+		run(args[0].(context.Context), args[1].(Plan))
 	})
 	return _c
 }
@@ -261,7 +354,61 @@ func (_c *MockPlanStorage_Update_Call) Return(_a0 error) *MockPlanStorage_Update
 	return _c
 }
 
-func (_c *MockPlanStorage_Update_Call) RunAndReturn(run func(Plan) error) *MockPlanStorage_Update_Call {
+func (_c *MockPlanStorage_Update_Call) RunAndReturn(run func(context.Context, Plan) error) *MockPlanStorage_Update_Call {
+	_c.Call.Return(run)
+	return _c
+}
+
+// UserIds provides a mock function with given fields: ctx
+func (_m *MockPlanStorage) UserIds(ctx context.Context) ([]string, error) {
+	ret := _m.Called(ctx)
+
+	var r0 []string
+	var r1 error
+	if rf, ok := ret.Get(0).(func(context.Context) ([]string, error)); ok {
+		return rf(ctx)
+	}
+	if rf, ok := ret.Get(0).(func(context.Context) []string); ok {
+		r0 = rf(ctx)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).([]string)
+		}
+	}
+
+	if rf, ok := ret.Get(1).(func(context.Context) error); ok {
+		r1 = rf(ctx)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// MockPlanStorage_UserIds_Call is a *mock.Call that shadows Run/Return methods with type explicit version for method 'UserIds'
+type MockPlanStorage_UserIds_Call struct {
+	*mock.Call
+}
+
+// UserIds is a helper method to define mock.On call
+//   - ctx context.Context
+func (_e *MockPlanStorage_Expecter) UserIds(ctx interface{}) *MockPlanStorage_UserIds_Call {
+	return &MockPlanStorage_UserIds_Call{Call: _e.mock.On("UserIds", ctx)}
+}
+
+func (_c *MockPlanStorage_UserIds_Call) Run(run func(ctx context.Context)) *MockPlanStorage_UserIds_Call {
+	_c.Call.Run(func(args mock.Arguments) {
+		run(args[0].(context.Context))
+	})
+	return _c
+}
+
+func (_c *MockPlanStorage_UserIds_Call) Return(_a0 []string, _a1 error) *MockPlanStorage_UserIds_Call {
+	_c.Call.Return(_a0, _a1)
+	return _c
+}
+
+func (_c *MockPlanStorage_UserIds_Call) RunAndReturn(run func(context.Context) ([]string, error)) *MockPlanStorage_UserIds_Call {
 	_c.Call.Return(run)
 	return _c
 }
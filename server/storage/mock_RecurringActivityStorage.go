@@ -3,6 +3,8 @@
 package storage
 
 import (
+	context "context"
+
 	uuid "github.com/google/uuid"
 	mock "github.com/stretchr/testify/mock"
 )
@@ -20,23 +22,23 @@ func (_m *MockRecurringActivityStorage) EXPECT() *MockRecurringActivityStorage_E
 	return &MockRecurringActivityStorage_Expecter{mock: &_m.Mock}
 }
 
-// Create provides a mock function with given fields: activity
-func (_m *MockRecurringActivityStorage) Create(activity RecurringActivity) (RecurringActivity, error) {
-	ret := _m.Called(activity)
+// Create provides a mock function with given fields: ctx, activity
+func (_m *MockRecurringActivityStorage) Create(ctx context.Context, activity RecurringActivity) (RecurringActivity, error) {
+	ret := _m.Called(ctx, activity)
 
 	var r0 RecurringActivity
 	var r1 error
-	if rf, ok := ret.Get(0).(func(RecurringActivity) (RecurringActivity, error)); ok {
-		return rf(activity)
+	if rf, ok := ret.Get(0).(func(context.Context, RecurringActivity) (RecurringActivity, error)); ok {
+		return rf(ctx, activity)
 	}
-	if rf, ok := ret.Get(0).(func(RecurringActivity) RecurringActivity); ok {
-		r0 = rf(activity)
+	if rf, ok := ret.Get(0).(func(context.Context, RecurringActivity) RecurringActivity); ok {
+		r0 = rf(ctx, activity)
 	} else {
 		r0 = ret.Get(0).(RecurringActivity)
 	}
 
-	if rf, ok := ret.Get(1).(func(RecurringActivity) error); ok {
-		r1 = rf(activity)
+	if rf, ok := ret.Get(1).(func(context.Context, RecurringActivity) error); ok {
+		r1 = rf(ctx, activity)
 	} else {
 		r1 = ret.Error(1)
 	}
@@ -50,14 +52,15 @@ type MockRecurringActivityStorage_Create_Call struct {
 }
 
 // Create is a helper method to define mock.On call
+//   - ctx context.Context
 //   - activity RecurringActivity
-func (_e *MockRecurringActivityStorage_Expecter) Create(activity interface{}) *MockRecurringActivityStorage_Create_Call {
-	return &MockRecurringActivityStorage_Create_Call{Call: _e.mock.On("Create", activity)}
+func (_e *MockRecurringActivityStorage_Expecter) Create(ctx interface{}, activity interface{}) *MockRecurringActivityStorage_Create_Call {
+	return &MockRecurringActivityStorage_Create_Call{Call: _e.mock.On("Create", ctx, activity)}
 }
 
-func (_c *MockRecurringActivityStorage_Create_Call) Run(run func(activity RecurringActivity)) *MockRecurringActivityStorage_Create_Call {
+func (_c *MockRecurringActivityStorage_Create_Call) Run(run func(ctx context.Context, activity RecurringActivity)) *MockRecurringActivityStorage_Create_Call {
 	_c.Call.Run(func(args mock.Arguments) {
-		run(args[0].(RecurringActivity))
+		run(args[0].(context.Context), args[1].(RecurringActivity))
 	})
 	return _c
 }
@@ -67,18 +70,18 @@ func (_c *MockRecurringActivityStorage_Create_Call) Return(_a0 RecurringActivity
 	return _c
 }
 
-func (_c *MockRecurringActivityStorage_Create_Call) RunAndReturn(run func(RecurringActivity) (RecurringActivity, error)) *MockRecurringActivityStorage_Create_Call {
+func (_c *MockRecurringActivityStorage_Create_Call) RunAndReturn(run func(context.Context, RecurringActivity) (RecurringActivity, error)) *MockRecurringActivityStorage_Create_Call {
 	_c.Call.Return(run)
 	return _c
 }
 
-// Delete provides a mock function with given fields: userId, id
-func (_m *MockRecurringActivityStorage) Delete(userId string, id uuid.UUID) error {
-	ret := _m.Called(userId, id)
+// Delete provides a mock function with given fields: ctx, userId, id
+func (_m *MockRecurringActivityStorage) Delete(ctx context.Context, userId string, id uuid.UUID) error {
+	ret := _m.Called(ctx, userId, id)
 
 	var r0 error
-	if rf, ok := ret.Get(0).(func(string, uuid.UUID) error); ok {
-		r0 = rf(userId, id)
+	if rf, ok := ret.Get(0).(func(context.Context, string, uuid.UUID) error); ok {
+		r0 = rf(ctx, userId, id)
 	} else {
 		r0 = ret.Error(0)
 	}
@@ -92,15 +95,16 @@ type MockRecurringActivityStorage_Delete_Call struct {
 }
 
 // Delete is a helper method to define mock.On call
+//   - ctx context.Context
 //   - userId string
 //   - id uuid.UUID
-func (_e *MockRecurringActivityStorage_Expecter) Delete(userId interface{}, id interface{}) *MockRecurringActivityStorage_Delete_Call {
-	return &MockRecurringActivityStorage_Delete_Call{Call: _e.mock.On("Delete", userId, id)}
+func (_e *MockRecurringActivityStorage_Expecter) Delete(ctx interface{}, userId interface{}, id interface{}) *MockRecurringActivityStorage_Delete_Call {
+	return &MockRecurringActivityStorage_Delete_Call{Call: _e.mock.On("Delete", ctx, userId, id)}
 }
 
-func (_c *MockRecurringActivityStorage_Delete_Call) Run(run func(userId string, id uuid.UUID)) *MockRecurringActivityStorage_Delete_Call {
+func (_c *MockRecurringActivityStorage_Delete_Call) Run(run func(ctx context.Context, userId string, id uuid.UUID)) *MockRecurringActivityStorage_Delete_Call {
 	_c.Call.Run(func(args mock.Arguments) {
-		run(args[0].(string), args[1].(uuid.UUID))
+		run(args[0].(context.Context), args[1].(string), args[2].(uuid.UUID))
 	})
 	return _c
 }
@@ -110,18 +114,61 @@ func (_c *MockRecurringActivityStorage_Delete_Call) Return(_a0 error) *MockRecur
 	return _c
 }
 
-func (_c *MockRecurringActivityStorage_Delete_Call) RunAndReturn(run func(string, uuid.UUID) error) *MockRecurringActivityStorage_Delete_Call {
+func (_c *MockRecurringActivityStorage_Delete_Call) RunAndReturn(run func(context.Context, string, uuid.UUID) error) *MockRecurringActivityStorage_Delete_Call {
 	_c.Call.Return(run)
 	return _c
 }
 
-// DeleteForPlan provides a mock function with given fields: userId, planId
-func (_m *MockRecurringActivityStorage) DeleteForPlan(userId string, planId uuid.UUID) error {
-	ret := _m.Called(userId, planId)
+// DeleteAllForUser provides a mock function with given fields: ctx, userId
+func (_m *MockRecurringActivityStorage) DeleteAllForUser(ctx context.Context, userId string) error {
+	ret := _m.Called(ctx, userId)
 
 	var r0 error
-	if rf, ok := ret.Get(0).(func(string, uuid.UUID) error); ok {
-		r0 = rf(userId, planId)
+	if rf, ok := ret.Get(0).(func(context.Context, string) error); ok {
+		r0 = rf(ctx, userId)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// MockRecurringActivityStorage_DeleteAllForUser_Call is a *mock.Call that shadows Run/Return methods with type explicit version for method 'DeleteAllForUser'
+type MockRecurringActivityStorage_DeleteAllForUser_Call struct {
+	*mock.Call
+}
+
+// DeleteAllForUser is a helper method to define mock.On call
+//   - ctx context.Context
+//   - userId string
+func (_e *MockRecurringActivityStorage_Expecter) DeleteAllForUser(ctx interface{}, userId interface{}) *MockRecurringActivityStorage_DeleteAllForUser_Call {
+	return &MockRecurringActivityStorage_DeleteAllForUser_Call{Call: _e.mock.On("DeleteAllForUser", ctx, userId)}
+}
+
+func (_c *MockRecurringActivityStorage_DeleteAllForUser_Call) Run(run func(ctx context.Context, userId string)) *MockRecurringActivityStorage_DeleteAllForUser_Call {
+	_c.Call.Run(func(args mock.Arguments) {
+		run(args[0].(context.Context), args[1].(string))
+	})
+	return _c
+}
+
+func (_c *MockRecurringActivityStorage_DeleteAllForUser_Call) Return(_a0 error) *MockRecurringActivityStorage_DeleteAllForUser_Call {
+	_c.Call.Return(_a0)
+	return _c
+}
+
+func (_c *MockRecurringActivityStorage_DeleteAllForUser_Call) RunAndReturn(run func(context.Context, string) error) *MockRecurringActivityStorage_DeleteAllForUser_Call {
+	_c.Call.Return(run)
+	return _c
+}
+
+// DeleteForPlan provides a mock function with given fields: ctx, userId, planId
+func (_m *MockRecurringActivityStorage) DeleteForPlan(ctx context.Context, userId string, planId uuid.UUID) error {
+	ret := _m.Called(ctx, userId, planId)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, string, uuid.UUID) error); ok {
+		r0 = rf(ctx, userId, planId)
 	} else {
 		r0 = ret.Error(0)
 	}
@@ -135,15 +182,16 @@ type MockRecurringActivityStorage_DeleteForPlan_Call struct {
 }
 
 // DeleteForPlan is a helper method to define mock.On call
+//   - ctx context.Context
 //   - userId string
 //   - planId uuid.UUID
-func (_e *MockRecurringActivityStorage_Expecter) DeleteForPlan(userId interface{}, planId interface{}) *MockRecurringActivityStorage_DeleteForPlan_Call {
-	return &MockRecurringActivityStorage_DeleteForPlan_Call{Call: _e.mock.On("DeleteForPlan", userId, planId)}
+func (_e *MockRecurringActivityStorage_Expecter) DeleteForPlan(ctx interface{}, userId interface{}, planId interface{}) *MockRecurringActivityStorage_DeleteForPlan_Call {
+	return &MockRecurringActivityStorage_DeleteForPlan_Call{Call: _e.mock.On("DeleteForPlan", ctx, userId, planId)}
 }
 
-func (_c *MockRecurringActivityStorage_DeleteForPlan_Call) Run(run func(userId string, planId uuid.UUID)) *MockRecurringActivityStorage_DeleteForPlan_Call {
+func (_c *MockRecurringActivityStorage_DeleteForPlan_Call) Run(run func(ctx context.Context, userId string, planId uuid.UUID)) *MockRecurringActivityStorage_DeleteForPlan_Call {
 	_c.Call.Run(func(args mock.Arguments) {
-		run(args[0].(string), args[1].(uuid.UUID))
+		run(args[0].(context.Context), args[1].(string), args[2].(uuid.UUID))
 	})
 	return _c
 }
@@ -153,30 +201,73 @@ func (_c *MockRecurringActivityStorage_DeleteForPlan_Call) Return(_a0 error) *Mo
 	return _c
 }
 
-func (_c *MockRecurringActivityStorage_DeleteForPlan_Call) RunAndReturn(run func(string, uuid.UUID) error) *MockRecurringActivityStorage_DeleteForPlan_Call {
+func (_c *MockRecurringActivityStorage_DeleteForPlan_Call) RunAndReturn(run func(context.Context, string, uuid.UUID) error) *MockRecurringActivityStorage_DeleteForPlan_Call {
+	_c.Call.Return(run)
+	return _c
+}
+
+// Put provides a mock function with given fields: ctx, activity
+func (_m *MockRecurringActivityStorage) Put(ctx context.Context, activity RecurringActivity) error {
+	ret := _m.Called(ctx, activity)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, RecurringActivity) error); ok {
+		r0 = rf(ctx, activity)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// MockRecurringActivityStorage_Put_Call is a *mock.Call that shadows Run/Return methods with type explicit version for method 'Put'
+type MockRecurringActivityStorage_Put_Call struct {
+	*mock.Call
+}
+
+// Put is a helper method to define mock.On call
+//   - ctx context.Context
+//   - activity RecurringActivity
+func (_e *MockRecurringActivityStorage_Expecter) Put(ctx interface{}, activity interface{}) *MockRecurringActivityStorage_Put_Call {
+	return &MockRecurringActivityStorage_Put_Call{Call: _e.mock.On("Put", ctx, activity)}
+}
+
+func (_c *MockRecurringActivityStorage_Put_Call) Run(run func(ctx context.Context, activity RecurringActivity)) *MockRecurringActivityStorage_Put_Call {
+	_c.Call.Run(func(args mock.Arguments) {
+		run(args[0].(context.Context), args[1].(RecurringActivity))
+	})
+	return _c
+}
+
+func (_c *MockRecurringActivityStorage_Put_Call) Return(_a0 error) *MockRecurringActivityStorage_Put_Call {
+	_c.Call.Return(_a0)
+	return _c
+}
+
+func (_c *MockRecurringActivityStorage_Put_Call) RunAndReturn(run func(context.Context, RecurringActivity) error) *MockRecurringActivityStorage_Put_Call {
 	_c.Call.Return(run)
 	return _c
 }
 
-// Query provides a mock function with given fields: query
-func (_m *MockRecurringActivityStorage) Query(query RecurringActivityStorageQuery) (*[]RecurringActivity, error) {
-	ret := _m.Called(query)
+// Query provides a mock function with given fields: ctx, query
+func (_m *MockRecurringActivityStorage) Query(ctx context.Context, query RecurringActivityStorageQuery) (*[]RecurringActivity, error) {
+	ret := _m.Called(ctx, query)
 
 	var r0 *[]RecurringActivity
 	var r1 error
-	if rf, ok := ret.Get(0).(func(RecurringActivityStorageQuery) (*[]RecurringActivity, error)); ok {
-		return rf(query)
+	if rf, ok := ret.Get(0).(func(context.Context, RecurringActivityStorageQuery) (*[]RecurringActivity, error)); ok {
+		return rf(ctx, query)
 	}
-	if rf, ok := ret.Get(0).(func(RecurringActivityStorageQuery) *[]RecurringActivity); ok {
-		r0 = rf(query)
+	if rf, ok := ret.Get(0).(func(context.Context, RecurringActivityStorageQuery) *[]RecurringActivity); ok {
+		r0 = rf(ctx, query)
 	} else {
 		if ret.Get(0) != nil {
 			r0 = ret.Get(0).(*[]RecurringActivity)
 		}
 	}
 
-	if rf, ok := ret.Get(1).(func(RecurringActivityStorageQuery) error); ok {
-		r1 = rf(query)
+	if rf, ok := ret.Get(1).(func(context.Context, RecurringActivityStorageQuery) error); ok {
+		r1 = rf(ctx, query)
 	} else {
 		r1 = ret.Error(1)
 	}
@@ -190,14 +281,15 @@ type MockRecurringActivityStorage_Query_Call struct {
 }
 
 // Query is a helper method to define mock.On call
+//   - ctx context.Context
 //   - query RecurringActivityStorageQuery
-func (_e *MockRecurringActivityStorage_Expecter) Query(query interface{}) *MockRecurringActivityStorage_Query_Call {
-	return &MockRecurringActivityStorage_Query_Call{Call: _e.mock.On("Query", query)}
+func (_e *MockRecurringActivityStorage_Expecter) Query(ctx interface{}, query interface{}) *MockRecurringActivityStorage_Query_Call {
+	return &MockRecurringActivityStorage_Query_Call{Call: _e.mock.On("Query", ctx, query)}
 }
 
-func (_c *MockRecurringActivityStorage_Query_Call) Run(run func(query RecurringActivityStorageQuery)) *MockRecurringActivityStorage_Query_Call {
+func (_c *MockRecurringActivityStorage_Query_Call) Run(run func(ctx context.Context, query RecurringActivityStorageQuery)) *MockRecurringActivityStorage_Query_Call {
 	_c.Call.Run(func(args mock.Arguments) {
-		run(args[0].(RecurringActivityStorageQuery))
+		run(args[0].(context.Context), args[1].(RecurringActivityStorageQuery))
 	})
 	return _c
 }
@@ -207,30 +299,30 @@ func (_c *MockRecurringActivityStorage_Query_Call) Return(_a0 *[]RecurringActivi
 	return _c
 }
 
-func (_c *MockRecurringActivityStorage_Query_Call) RunAndReturn(run func(RecurringActivityStorageQuery) (*[]RecurringActivity, error)) *MockRecurringActivityStorage_Query_Call {
+func (_c *MockRecurringActivityStorage_Query_Call) RunAndReturn(run func(context.Context, RecurringActivityStorageQuery) (*[]RecurringActivity, error)) *MockRecurringActivityStorage_Query_Call {
 	_c.Call.Return(run)
 	return _c
 }
 
-// Read provides a mock function with given fields: userId, id
-func (_m *MockRecurringActivityStorage) Read(userId string, id uuid.UUID) (*RecurringActivity, error) {
-	ret := _m.Called(userId, id)
+// Read provides a mock function with given fields: ctx, userId, id
+func (_m *MockRecurringActivityStorage) Read(ctx context.Context, userId string, id uuid.UUID) (*RecurringActivity, error) {
+	ret := _m.Called(ctx, userId, id)
 
 	var r0 *RecurringActivity
 	var r1 error
-	if rf, ok := ret.Get(0).(func(string, uuid.UUID) (*RecurringActivity, error)); ok {
-		return rf(userId, id)
+	if rf, ok := ret.Get(0).(func(context.Context, string, uuid.UUID) (*RecurringActivity, error)); ok {
+		return rf(ctx, userId, id)
 	}
-	if rf, ok := ret.Get(0).(func(string, uuid.UUID) *RecurringActivity); ok {
-		r0 = rf(userId, id)
+	if rf, ok := ret.Get(0).(func(context.Context, string, uuid.UUID) *RecurringActivity); ok {
+		r0 = rf(ctx, userId, id)
 	} else {
 		if ret.Get(0) != nil {
 			r0 = ret.Get(0).(*RecurringActivity)
 		}
 	}
 
-	if rf, ok := ret.Get(1).(func(string, uuid.UUID) error); ok {
-		r1 = rf(userId, id)
+	if rf, ok := ret.Get(1).(func(context.Context, string, uuid.UUID) error); ok {
+		r1 = rf(ctx, userId, id)
 	} else {
 		r1 = ret.Error(1)
 	}
@@ -244,15 +336,16 @@ type MockRecurringActivityStorage_Read_Call struct {
 }
 
 // Read is a helper method to define mock.On call
+//   - ctx context.Context
 //   - userId string
 //   - id uuid.UUID
-func (_e *MockRecurringActivityStorage_Expecter) Read(userId interface{}, id interface{}) *MockRecurringActivityStorage_Read_Call {
-	return &MockRecurringActivityStorage_Read_Call{Call: _e.mock.On("Read", userId, id)}
+func (_e *MockRecurringActivityStorage_Expecter) Read(ctx interface{}, userId interface{}, id interface{}) *MockRecurringActivityStorage_Read_Call {
+	return &MockRecurringActivityStorage_Read_Call{Call: _e.mock.On("Read", ctx, userId, id)}
 }
 
-func (_c *MockRecurringActivityStorage_Read_Call) Run(run func(userId string, id uuid.UUID)) *MockRecurringActivityStorage_Read_Call {
+func (_c *MockRecurringActivityStorage_Read_Call) Run(run func(ctx context.Context, userId string, id uuid.UUID)) *MockRecurringActivityStorage_Read_Call {
 	_c.Call.Run(func(args mock.Arguments) {
-		run(args[0].(string), args[1].(uuid.UUID))
+		run(args[0].(context.Context), args[1].(string), args[2].(uuid.UUID))
 	})
 	return _c
 }
@@ -262,18 +355,18 @@ func (_c *MockRecurringActivityStorage_Read_Call) Return(_a0 *RecurringActivity,
 	return _c
 }
 
-func (_c *MockRecurringActivityStorage_Read_Call) RunAndReturn(run func(string, uuid.UUID) (*RecurringActivity, error)) *MockRecurringActivityStorage_Read_Call {
+func (_c *MockRecurringActivityStorage_Read_Call) RunAndReturn(run func(context.Context, string, uuid.UUID) (*RecurringActivity, error)) *MockRecurringActivityStorage_Read_Call {
 	_c.Call.Return(run)
 	return _c
 }
 
-// Update provides a mock function with given fields: activity
-func (_m *MockRecurringActivityStorage) Update(activity RecurringActivity) error {
-	ret := _m.Called(activity)
+// Update provides a mock function with given fields: ctx, activity
+func (_m *MockRecurringActivityStorage) Update(ctx context.Context, activity RecurringActivity) error {
+	ret := _m.Called(ctx, activity)
 
 	var r0 error
-	if rf, ok := ret.Get(0).(func(RecurringActivity) error); ok {
-		r0 = rf(activity)
+	if rf, ok := ret.Get(0).(func(context.Context, RecurringActivity) error); ok {
+		r0 = rf(ctx, activity)
 	} else {
 		r0 = ret.Error(0)
 	}
@@ -287,14 +380,15 @@ type MockRecurringActivityStorage_Update_Call struct {
 }
 
 // Update is a helper method to define mock.On call
+//   - ctx context.Context
 //   - activity RecurringActivity
-func (_e *MockRecurringActivityStorage_Expecter) Update(activity interface{}) *MockRecurringActivityStorage_Update_Call {
-	return &MockRecurringActivityStorage_Update_Call{Call: _e.mock.On("Update", activity)}
+func (_e *MockRecurringActivityStorage_Expecter) Update(ctx interface{}, activity interface{}) *MockRecurringActivityStorage_Update_Call {
+	return &MockRecurringActivityStorage_Update_Call{Call: _e.mock.On("Update", ctx, activity)}
 }
 
-func (_c *MockRecurringActivityStorage_Update_Call) Run(run func(activity RecurringActivity)) *MockRecurringActivityStorage_Update_Call {
+func (_c *MockRecurringActivityStorage_Update_Call) Run(run func(ctx context.Context, activity RecurringActivity)) *MockRecurringActivityStorage_Update_Call {
 	_c.Call.Run(func(args mock.Arguments) {
-		run(args[0].(RecurringActivity))
+		run(args[0].(context.Context), args[1].(RecurringActivity))
 	})
 	return _c
 }
@@ -304,7 +398,61 @@ func (_c *MockRecurringActivityStorage_Update_Call) Return(_a0 error) *MockRecur
 	return _c
 }
 
-func (_c *MockRecurringActivityStorage_Update_Call) RunAndReturn(run func(RecurringActivity) error) *MockRecurringActivityStorage_Update_Call {
+func (_c *MockRecurringActivityStorage_Update_Call) RunAndReturn(run func(context.Context, RecurringActivity) error) *MockRecurringActivityStorage_Update_Call {
+	_c.Call.Return(run)
+	return _c
+}
+
+// UserIds provides a mock function with given fields: ctx
+func (_m *MockRecurringActivityStorage) UserIds(ctx context.Context) ([]string, error) {
+	ret := _m.Called(ctx)
+
+	var r0 []string
+	var r1 error
+	if rf, ok := ret.Get(0).(func(context.Context) ([]string, error)); ok {
+		return rf(ctx)
+	}
+	if rf, ok := ret.Get(0).(func(context.Context) []string); ok {
+		r0 = rf(ctx)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).([]string)
+		}
+	}
+
+	if rf, ok := ret.Get(1).(func(context.Context) error); ok {
+		r1 = rf(ctx)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// MockRecurringActivityStorage_UserIds_Call is a *mock.Call that shadows Run/Return methods with type explicit version for method 'UserIds'
+type MockRecurringActivityStorage_UserIds_Call struct {
+	*mock.Call
+}
+
+// UserIds is a helper method to define mock.On call
+//   - ctx context.Context
+func (_e *MockRecurringActivityStorage_Expecter) UserIds(ctx interface{}) *MockRecurringActivityStorage_UserIds_Call {
+	return &MockRecurringActivityStorage_UserIds_Call{Call: _e.mock.On("UserIds", ctx)}
+}
+
+func (_c *MockRecurringActivityStorage_UserIds_Call) Run(run func(ctx context.Context)) *MockRecurringActivityStorage_UserIds_Call {
+	_c.Call.Run(func(args mock.Arguments) {
+		run(args[0].(context.Context))
+	})
+	return _c
+}
+
+func (_c *MockRecurringActivityStorage_UserIds_Call) Return(_a0 []string, _a1 error) *MockRecurringActivityStorage_UserIds_Call {
+	_c.Call.Return(_a0, _a1)
+	return _c
+}
+
+func (_c *MockRecurringActivityStorage_UserIds_Call) RunAndReturn(run func(context.Context) ([]string, error)) *MockRecurringActivityStorage_UserIds_Call {
 	_c.Call.Return(run)
 	return _c
 }
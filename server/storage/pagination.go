@@ -0,0 +1,86 @@
+package storage
+
+import (
+	"encoding/base64"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Activities are paged in (dateTime, id) order. A cursor holds the position
+// of the last activity of a page and the next page starts strictly after it
+// in the direction of the query's sort order.
+type ActivityCursor struct {
+	DateTime time.Time
+	Id       uuid.UUID
+}
+
+var ErrInvalidCursor = fmt.Errorf("%w cursor", ErrInvalid)
+
+func CursorAfter(activity Activity) ActivityCursor {
+	return ActivityCursor{DateTime: activity.DateTime, Id: activity.Id}
+}
+
+func (cursor ActivityCursor) Encode() string {
+	raw := cursor.DateTime.Format(time.RFC3339Nano) + "|" + cursor.Id.String()
+	return base64.RawURLEncoding.EncodeToString([]byte(raw))
+}
+
+func ParseActivityCursor(encoded string) (ActivityCursor, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return ActivityCursor{}, ErrInvalidCursor
+	}
+	parts := strings.Split(string(raw), "|")
+	if len(parts) != 2 {
+		return ActivityCursor{}, ErrInvalidCursor
+	}
+	dateTime, err := time.Parse(time.RFC3339Nano, parts[0])
+	if err != nil {
+		return ActivityCursor{}, ErrInvalidCursor
+	}
+	id, err := uuid.Parse(parts[1])
+	if err != nil {
+		return ActivityCursor{}, ErrInvalidCursor
+	}
+	return ActivityCursor{DateTime: dateTime, Id: id}, nil
+}
+
+func activityBefore(a Activity, b Activity) bool {
+	if a.DateTime.Equal(b.DateTime) {
+		return a.Id.String() < b.Id.String()
+	}
+	return a.DateTime.Before(b.DateTime)
+}
+
+// pageActivities sorts the activities and applies the order, cursor and limit
+// of the query, for backends that cannot do so in the database. Activities
+// before the cursor are dropped first, so later pages sort less.
+func pageActivities(activities []Activity, query ActivityStorageQuery) []Activity {
+	precedes := activityBefore
+	if query.Order == Descending {
+		precedes = func(a Activity, b Activity) bool {
+			return activityBefore(b, a)
+		}
+	}
+	if query.Cursor != nil {
+		position := Activity{DateTime: query.Cursor.DateTime, Id: query.Cursor.Id}
+		after := activities[:0]
+		for _, activity := range activities {
+			if precedes(position, activity) {
+				after = append(after, activity)
+			}
+		}
+		activities = after
+	}
+	sort.Slice(activities, func(i, j int) bool {
+		return precedes(activities[i], activities[j])
+	})
+	if query.Limit > 0 && len(activities) > query.Limit {
+		activities = activities[:query.Limit]
+	}
+	return activities
+}
@@ -0,0 +1,25 @@
+package storage
+
+// planQueryConditionsSQL renders the filters of a plan query beyond the user.
+func planQueryConditionsSQL(query PlanStorageQuery) string {
+	conditions := trashedSQL(query.Trashed)
+	if !query.IncludeArchived {
+		conditions = conditions + " AND archivedAt IS NULL"
+	}
+	return conditions
+}
+
+// matchesPlanQuery applies the query filters in Go, for backends that cannot
+// express them in the database.
+func matchesPlanQuery(plan Plan, query PlanStorageQuery) bool {
+	if plan.UserId != query.UserId {
+		return false
+	}
+	if (plan.DeletedAt != nil) != query.Trashed {
+		return false
+	}
+	if plan.ArchivedAt != nil && !query.IncludeArchived {
+		return false
+	}
+	return true
+}
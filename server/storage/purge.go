@@ -0,0 +1,87 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+)
+
+type PurgeSummary struct {
+	Plans               int `json:"plans"`
+	Activities          int `json:"activities"`
+	RecurringActivities int `json:"recurringActivities"`
+}
+
+// CountUserData counts everything stored for the user, including trashed and
+// archived items.
+func CountUserData(ctx context.Context, strg Storage, userId string) (PurgeSummary, error) {
+	summary := PurgeSummary{}
+	for _, trashed := range []bool{false, true} {
+		plans, err := strg.Plan.Query(ctx, PlanStorageQuery{UserId: userId, Trashed: trashed, IncludeArchived: true})
+		if err != nil {
+			return summary, err
+		}
+		activities, err := strg.Activity.Query(ctx, ActivityStorageQuery{UserId: userId, Trashed: trashed})
+		if err != nil {
+			return summary, err
+		}
+		recurringActivities, err := strg.RecurringActivity.Query(ctx, RecurringActivityStorageQuery{UserId: userId, Trashed: trashed})
+		if err != nil {
+			return summary, err
+		}
+		summary.Plans += len(*plans)
+		summary.Activities += len(*activities)
+		summary.RecurringActivities += len(*recurringActivities)
+	}
+	return summary, nil
+}
+
+// PURGE_ATTEMPTS is how often PurgeUser deletes the user's data before giving
+// up on anything still left
+const PURGE_ATTEMPTS = 3
+
+// PurgeUser permanently deletes every plan, activity and recurring activity
+// of the user along with their audit log and returns what was removed. The
+// deletes are made one storage at a time rather than in a unit of work, whose
+// Cassandra batch couldn't hold a whole account, and are repeated until
+// nothing is left.
+func PurgeUser(ctx context.Context, strg Storage, userId string) (PurgeSummary, error) {
+	summary, err := CountUserData(ctx, strg, userId)
+	if err != nil {
+		return PurgeSummary{}, err
+	}
+	for attempt := 1; ; attempt++ {
+		remaining := summary
+		err = deleteUserData(ctx, strg, userId)
+		if err == nil {
+			remaining, err = CountUserData(ctx, strg, userId)
+		}
+		if err == nil && remaining == (PurgeSummary{}) {
+			return summary, nil
+		}
+		if attempt == PURGE_ATTEMPTS || ctx.Err() != nil {
+			if err != nil {
+				return PurgeSummary{}, err
+			}
+			return PurgeSummary{}, fmt.Errorf("%w: purge incomplete, %d plans, %d activities and %d recurring activities remain",
+				ErrUnavailable, remaining.Plans, remaining.Activities, remaining.RecurringActivities)
+		}
+	}
+}
+
+// deleteUserData deletes the audit log last, so the entries recorded while
+// deleting go with it
+func deleteUserData(ctx context.Context, strg Storage, userId string) error {
+	err := strg.Activity.DeleteAllForUser(ctx, userId)
+	if err != nil {
+		return err
+	}
+	err = strg.RecurringActivity.DeleteAllForUser(ctx, userId)
+	if err != nil {
+		return err
+	}
+	err = strg.Plan.DeleteAllForUser(ctx, userId)
+	if err != nil {
+		return err
+	}
+	return strg.Audit.DeleteAllForUser(ctx, userId)
+}
@@ -0,0 +1,87 @@
+package storage
+
+import (
+	"sort"
+	"strings"
+	"unicode"
+
+	"github.com/google/uuid"
+)
+
+type ActivitySearchQuery struct {
+	UserId string
+	Text   string
+	Limit  int
+}
+
+// Summary matches count for more than matches in the notes or stages
+const (
+	summarySearchWeight = 3
+	notesSearchWeight   = 1
+	stagesSearchWeight  = 1
+)
+
+// searchTerms splits text into the lower-cased words used by every backend,
+// so "Tempo-run, knee!" searches for tempo, run and knee.
+func searchTerms(text string) []string {
+	words := strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	terms := make([]string, 0, len(words))
+	seen := make(map[string]bool)
+	for _, word := range words {
+		if !seen[word] {
+			seen[word] = true
+			terms = append(terms, word)
+		}
+	}
+	return terms
+}
+
+func stageDescriptions(stages []ActivityStage) string {
+	descriptions := make([]string, len(stages))
+	for i, stage := range stages {
+		descriptions[i] = stage.Description
+	}
+	return strings.Join(descriptions, " ")
+}
+
+// activitySearchWeights scores every word of an activity by where it appears
+func activitySearchWeights(activity Activity) map[string]int {
+	weights := make(map[string]int)
+	for _, term := range searchTerms(activity.Summary) {
+		weights[term] += summarySearchWeight
+	}
+	for _, term := range searchTerms(activity.Notes) {
+		weights[term] += notesSearchWeight
+	}
+	for _, term := range searchTerms(stageDescriptions(activity.Stages)) {
+		weights[term] += stagesSearchWeight
+	}
+	return weights
+}
+
+func activitySearchScore(activity Activity, terms []string) int {
+	weights := activitySearchWeights(activity)
+	score := 0
+	for _, term := range terms {
+		score += weights[term]
+	}
+	return score
+}
+
+// rankSearchResults orders matched activities best first, most recent first
+// on equal scores, and applies the limit.
+func rankSearchResults(activities []Activity, scores map[uuid.UUID]int, limit int) []Activity {
+	sort.Slice(activities, func(i, j int) bool {
+		a, b := activities[i], activities[j]
+		if scores[a.Id] != scores[b.Id] {
+			return scores[a.Id] > scores[b.Id]
+		}
+		return activityBefore(b, a)
+	})
+	if limit > 0 && len(activities) > limit {
+		activities = activities[:limit]
+	}
+	return activities
+}
@@ -1,9 +1,12 @@
 package storage
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"os"
+	"strconv"
 	"strings"
 	"time"
 
@@ -12,16 +15,15 @@ import (
 )
 
 type CassandraActivityStorage struct {
-	Cluster *gocql.ClusterConfig
+	Session    *gocql.Session
+	Batch      *gocql.Batch
+	Conditions *cassandraConditions
 }
 
-func (stg CassandraActivityStorage) Create(activity Activity) (Activity, error) {
-	session, err := stg.Cluster.CreateSession()
-	if err != nil {
-		return Activity{}, errors.New("Cassandra Connection Error")
-	}
-	defer session.Close()
+func (stg CassandraActivityStorage) Create(ctx context.Context, activity Activity) (Activity, error) {
 	newId := uuid.New()
+	activity.Version = 1
+	activity.UpdatedAt = versionTime(ctx)
 	insertCQL := `
 			INSERT INTO ohs_planner.activities (
 				id,
@@ -33,7 +35,10 @@ func (stg CassandraActivityStorage) Create(activity Activity) (Activity, error)
 				dateTime,
 				timeRelevant,
 				completed,
-				notes
+				notes,
+				deletedAt,
+				version,
+				updatedAt
 			)
 			VALUES (
 				?,
@@ -45,6 +50,9 @@ func (stg CassandraActivityStorage) Create(activity Activity) (Activity, error)
 				?,
 				?,
 				?,
+				?,
+				?,
+				?,
 				?
 			);
 	`
@@ -62,18 +70,30 @@ func (stg CassandraActivityStorage) Create(activity Activity) (Activity, error)
 		dirString := activity.RecurringActivityId.String()
 		recurringIdString = &dirString
 	}
-	insertErr := session.Query(insertCQL,
-		newId.String(),
-		activity.UserId,
-		recurringIdString,
-		planIdString,
-		activity.Summary,
-		jsonStr,
-		activity.DateTime,
-		activity.TimeRelevant,
-		activity.Completed,
-		activity.Notes,
-	).Exec()
+	indexed := activity
+	indexed.Id = newId
+	insertErr := cassandraBatchWrite(ctx, stg.Session, stg.Batch, func(batch *gocql.Batch) error {
+		batch.Query(insertCQL,
+			newId.String(),
+			activity.UserId,
+			recurringIdString,
+			planIdString,
+			activity.Summary,
+			jsonStr,
+			activity.DateTime,
+			activity.TimeRelevant,
+			activity.Completed,
+			activity.Notes,
+			activity.DeletedAt,
+			activity.Version,
+			activity.UpdatedAt,
+		)
+		queueCassandraSearchIndex(batch, nil, &indexed)
+		queueCassandraMetricTotals(batch, nil, &indexed)
+		queueCassandraStages(batch, nil, &indexed)
+		queueCassandraActivityMonth(batch, &indexed)
+		return queueCassandraMonthBucket(batch, nil, &indexed)
+	})
 	if insertErr != nil {
 		return activity, insertErr
 	}
@@ -81,12 +101,7 @@ func (stg CassandraActivityStorage) Create(activity Activity) (Activity, error)
 	return activity, nil
 }
 
-func (stg CassandraActivityStorage) Read(userId string, id uuid.UUID) (*Activity, error) {
-	session, err := stg.Cluster.CreateSession()
-	if err != nil {
-		return nil, errors.New("Cassandra Connection Error")
-	}
-	defer session.Close()
+func (stg CassandraActivityStorage) Read(ctx context.Context, userId string, id uuid.UUID) (*Activity, error) {
 	selectCQL := `
 			SELECT 
 				id,
@@ -98,19 +113,22 @@ func (stg CassandraActivityStorage) Read(userId string, id uuid.UUID) (*Activity
 				dateTime,
 				timeRelevant,
 				completed,
-				notes
+				notes,
+				deletedAt,
+				version,
+				updatedAt
 			FROM ohs_planner.activities 
 			WHERE userId = ? AND id = ?
 			LIMIT 1;
 	`
-	scanner := session.Query(selectCQL, userId, id.String()).Iter().Scanner()
+	scanner := stg.Session.Query(selectCQL, userId, id.String()).WithContext(ctx).Iter().Scanner()
 	if scanner.Next() {
 		var activity Activity
 		rawStages := "[]"
 		rawId := ""
 		rawPlanId := ""
 		rawRecurringId := ""
-		err = scanner.Scan(
+		err := scanner.Scan(
 			&rawId,
 			&activity.UserId,
 			&rawRecurringId,
@@ -121,11 +139,14 @@ func (stg CassandraActivityStorage) Read(userId string, id uuid.UUID) (*Activity
 			&activity.TimeRelevant,
 			&activity.Completed,
 			&activity.Notes,
+			&activity.DeletedAt,
+			&activity.Version,
+			&activity.UpdatedAt,
 		)
 		if err != nil {
 			return nil, err
 		}
-		err := json.Unmarshal([]byte(rawStages), &activity.Stages)
+		err = json.Unmarshal([]byte(rawStages), &activity.Stages)
 		if err != nil {
 			return nil, err
 		}
@@ -143,15 +164,8 @@ func (stg CassandraActivityStorage) Read(userId string, id uuid.UUID) (*Activity
 	return nil, nil
 }
 
-func (stg CassandraActivityStorage) Query(query ActivityStorageQuery) (*[]Activity, error) {
-	session, err := stg.Cluster.CreateSession()
-	if err != nil {
-		return nil, errors.New("Cassandra Connection Error")
-	}
-	defer session.Close()
-	params := []interface{}{query.UserId}
-	selectCQL := `
-	SELECT 
+func (stg CassandraActivityStorage) Query(ctx context.Context, query ActivityStorageQuery) (*[]Activity, error) {
+	columns := `
 		id,
 		userId,
 		recurringActivityId,
@@ -161,70 +175,221 @@ func (stg CassandraActivityStorage) Query(query ActivityStorageQuery) (*[]Activi
 		dateTime,
 		timeRelevant,
 		completed,
-		notes
-	FROM ohs_planner.activities 
-	WHERE userId = ?`
-	if query.PlanId != nil {
-		selectCQL = selectCQL + ` AND planId = ?`
-		params = append(params, query.PlanId.String())
+		notes,
+		deletedAt,
+		version,
+		updatedAt`
+	statements := make([]cassandraStatement, 0)
+	// The metric totals narrow down every other way of reading activities
+	var candidates map[string]bool
+	if len(query.Metrics) > 0 {
+		var err error
+		candidates, err = cassandraMetricCandidates(ctx, stg.Session, query.UserId, query.Metrics)
+		if err != nil {
+			return nil, err
+		}
+		if len(candidates) == 0 {
+			activities := make([]Activity, 0)
+			return &activities, nil
+		}
 	}
-	if query.DateRange != nil {
-		selectCQL = selectCQL + ` AND dateTime >= ? AND dateTime <= ?`
-		params = append(params, query.DateRange.Start)
-		params = append(params, query.DateRange.End)
+	activePlans := make([]uuid.UUID, 0)
+	if query.ActivePlansOnly {
+		plans, err := CassandraPlanStorage{Session: stg.Session}.Query(ctx, PlanStorageQuery{UserId: query.UserId})
+		if err != nil {
+			return nil, err
+		}
+		for _, plan := range *plans {
+			if activePlan(plan) {
+				activePlans = append(activePlans, plan.Id)
+			}
+		}
 	}
-	if query.PlanId != nil || query.DateRange != nil {
-		selectCQL = selectCQL + ` ALLOW FILTERING`
+	if query.Limit > 0 {
+		return stg.queryPage(ctx, query, columns, candidates, activePlans)
+	}
+	buckets := monthBuckets(query.DateRange)
+	if buckets != nil {
+		// Range queries only read the month partitions they overlap
+		for _, bucket := range buckets {
+			statements = append(statements, cassandraStatement{
+				CQL: `
+	SELECT ` + columns + `
+	FROM ohs_planner.activities_by_month
+	WHERE userId = ? AND bucket = ? AND dateTime > ? AND dateTime < ?`,
+				Values: []interface{}{query.UserId, bucket, query.DateRange.Start, query.DateRange.End},
+			})
+		}
+	} else if candidates != nil {
+		ids := make([]string, 0, len(candidates))
+		for id := range candidates {
+			ids = append(ids, id)
+		}
+		for start := 0; start < len(ids); start += cassandraInChunk {
+			end := start + cassandraInChunk
+			if end > len(ids) {
+				end = len(ids)
+			}
+			statements = append(statements, cassandraStatement{
+				CQL: `
+	SELECT ` + columns + `
+	FROM ohs_planner.activities
+	WHERE userId = ? AND id IN ?`,
+				Values: []interface{}{query.UserId, ids[start:end]},
+			})
+		}
+	} else {
+		// Date ranges too wide for the buckets are left to the filter in Go,
+		// whose bounds are exclusive like the bucket reads
+		params := []interface{}{query.UserId}
+		selectCQL := `
+	SELECT ` + columns + `
+	FROM ohs_planner.activities 
+	WHERE userId = ?`
+		if query.PlanId != nil {
+			selectCQL = selectCQL + ` AND planId = ? ALLOW FILTERING`
+			params = append(params, query.PlanId.String())
+		}
+		statements = append(statements, cassandraStatement{CQL: selectCQL, Values: params})
 	}
-	rows := session.Query(selectCQL, params...).Iter().Scanner()
+	// Filters beyond the partition and plan are applied in Go
 	activities := make([]Activity, 0)
-	// Print the results of the query
-	for rows.Next() {
-		var activity Activity
-		rawStages := "[]"
-		rawId := ""
-		rawPlanId := ""
-		rawRecurringId := ""
-		err = rows.Scan(
-			&rawId,
-			&activity.UserId,
-			&rawRecurringId,
-			&rawPlanId,
-			&activity.Summary,
-			&rawStages,
-			&activity.DateTime,
-			&activity.TimeRelevant,
-			&activity.Completed,
-			&activity.Notes,
-		)
+	for _, statement := range statements {
+		rows := stg.Session.Query(statement.CQL, statement.Values...).WithContext(ctx).Iter().Scanner()
+		for rows.Next() {
+			activity, err := scanCassandraActivity(rows)
+			if err != nil {
+				return nil, err
+			}
+			if candidates != nil && !candidates[activity.Id.String()] {
+				continue
+			}
+			if matchesActivityQuery(activity, query, activePlans) {
+				activities = append(activities, activity)
+			}
+		}
+		err := rows.Err()
 		if err != nil {
 			return nil, err
 		}
+	}
+	activities = pageActivities(activities, query)
+	return &activities, nil
+}
 
-		err := json.Unmarshal([]byte(rawStages), &activity.Stages)
+// queryPage reads a page from the month buckets, which are clustered in page
+// order, starting at the cursor and stopping once the page is full. Walking
+// every page so reads each activity about once.
+func (stg CassandraActivityStorage) queryPage(ctx context.Context, query ActivityStorageQuery, columns string, candidates map[string]bool, activePlans []uuid.UUID) (*[]Activity, error) {
+	buckets, err := cassandraPageBuckets(ctx, stg.Session, query)
+	if err != nil {
+		return nil, err
+	}
+	var position *Activity
+	if query.Cursor != nil {
+		position = &Activity{DateTime: query.Cursor.DateTime, Id: query.Cursor.Id}
+	}
+	precedes := activityBefore
+	if query.Order == Descending {
+		precedes = func(a Activity, b Activity) bool {
+			return activityBefore(b, a)
+		}
+	}
+	activities := make([]Activity, 0, query.Limit)
+	full := false
+	for _, bucket := range buckets {
+		selectCQL := `
+	SELECT ` + columns + `
+	FROM ohs_planner.activities_by_month
+	WHERE userId = ? AND bucket = ?`
+		values := []interface{}{query.UserId, bucket}
+		if position != nil && bucket == monthBucket(position.DateTime) {
+			if query.Order == Descending {
+				selectCQL = selectCQL + ` AND dateTime <= ?`
+			} else {
+				selectCQL = selectCQL + ` AND dateTime >= ?`
+			}
+			values = append(values, position.DateTime)
+		}
+		if query.Order == Descending {
+			selectCQL = selectCQL + ` ORDER BY dateTime DESC`
+		}
+		rows := stg.Session.Query(selectCQL, values...).WithContext(ctx).PageSize(query.Limit + 1).Iter().Scanner()
+		for rows.Next() {
+			activity, err := scanCassandraActivity(rows)
+			if err != nil {
+				rows.Err()
+				return nil, err
+			}
+			// Activities at the same time as the last one are still read, as
+			// Cassandra orders their ids differently than pages do
+			if len(activities) >= query.Limit && !activity.DateTime.Equal(activities[len(activities)-1].DateTime) {
+				full = true
+				break
+			}
+			if position != nil && !precedes(*position, activity) {
+				continue
+			}
+			if candidates != nil && !candidates[activity.Id.String()] {
+				continue
+			}
+			if matchesActivityQuery(activity, query, activePlans) {
+				activities = append(activities, activity)
+			}
+		}
+		err := rows.Err()
 		if err != nil {
 			return nil, err
 		}
-		activity.Id = uuid.MustParse(rawId)
-		if rawPlanId != "" {
-			dirRef := uuid.MustParse(rawPlanId)
-			activity.PlanId = &dirRef
+		if full {
+			break
 		}
-		if rawRecurringId != "" {
-			dirRef := uuid.MustParse(rawRecurringId)
-			activity.RecurringActivityId = &dirRef
-		}
-		activities = append(activities, activity)
 	}
+	activities = pageActivities(activities, query)
 	return &activities, nil
 }
 
-func (stg CassandraActivityStorage) Update(activity Activity) error {
-	session, err := stg.Cluster.CreateSession()
+func scanCassandraActivity(rows gocql.Scanner) (Activity, error) {
+	var activity Activity
+	rawStages := "[]"
+	rawId := ""
+	rawPlanId := ""
+	rawRecurringId := ""
+	err := rows.Scan(
+		&rawId,
+		&activity.UserId,
+		&rawRecurringId,
+		&rawPlanId,
+		&activity.Summary,
+		&rawStages,
+		&activity.DateTime,
+		&activity.TimeRelevant,
+		&activity.Completed,
+		&activity.Notes,
+		&activity.DeletedAt,
+		&activity.Version,
+		&activity.UpdatedAt,
+	)
 	if err != nil {
-		return errors.New("Cassandra Connection Error")
+		return activity, err
 	}
-	defer session.Close()
+	err = json.Unmarshal([]byte(rawStages), &activity.Stages)
+	if err != nil {
+		return activity, err
+	}
+	activity.Id = uuid.MustParse(rawId)
+	if rawPlanId != "" {
+		dirRef := uuid.MustParse(rawPlanId)
+		activity.PlanId = &dirRef
+	}
+	if rawRecurringId != "" {
+		dirRef := uuid.MustParse(rawRecurringId)
+		activity.RecurringActivityId = &dirRef
+	}
+	return activity, nil
+}
+
+func (stg CassandraActivityStorage) Update(ctx context.Context, activity Activity) error {
 	updateCQL := `
 			UPDATE ohs_planner.activities
 			SET 
@@ -235,8 +400,11 @@ func (stg CassandraActivityStorage) Update(activity Activity) error {
 				dateTime = ?,
 				timeRelevant = ?,
 				completed = ?,
-				notes = ?
-			WHERE userId = ? AND id = ?;
+				notes = ?,
+				deletedAt = ?,
+				version = ?,
+				updatedAt = ?
+			WHERE userId = ? AND id = ?
 	`
 	jsonStr, err := json.Marshal(activity.Stages)
 	if err != nil {
@@ -252,7 +420,21 @@ func (stg CassandraActivityStorage) Update(activity Activity) error {
 		dirString := activity.RecurringActivityId.String()
 		recurringActivityIdString = &dirString
 	}
-	updateErr := session.Query(updateCQL,
+	// The previous activity tells which index rows to replace
+	previous, err := stg.Read(ctx, activity.UserId, activity.Id)
+	if err != nil {
+		return err
+	}
+	if previous == nil {
+		return ErrNotFound
+	}
+	if previous.Version != activity.Version {
+		return ErrVersionConflict
+	}
+	expectedVersion := activity.Version
+	activity.Version++
+	activity.UpdatedAt = versionTime(ctx)
+	values := []interface{}{
 		planIdString,
 		recurringActivityIdString,
 		activity.Summary,
@@ -261,98 +443,280 @@ func (stg CassandraActivityStorage) Update(activity Activity) error {
 		activity.TimeRelevant,
 		activity.Completed,
 		activity.Notes,
+		activity.DeletedAt,
+		activity.Version,
+		activity.UpdatedAt,
 		activity.UserId,
 		activity.Id.String(),
-	).Exec()
+	}
+	queueIndexes := func(batch *gocql.Batch) error {
+		queueCassandraSearchIndex(batch, previous, &activity)
+		queueCassandraMetricTotals(batch, previous, &activity)
+		queueCassandraStages(batch, previous, &activity)
+		queueCassandraActivityMonth(batch, &activity)
+		return queueCassandraMonthBucket(batch, previous, &activity)
+	}
+	updateErr := cassandraConditionalWrite(ctx, stg.Session, stg.Batch, stg.Conditions, cassandraCondition{
+		CQL:      updateCQL,
+		Values:   values,
+		If:       "IF version = ?",
+		IfValues: []interface{}{cassandraVersion(expectedVersion)},
+		Failed: func(ctx context.Context) error {
+			return stg.conflict(ctx, activity.UserId, activity.Id)
+		},
+	}, queueIndexes)
 	if updateErr != nil {
 		return updateErr
 	}
 	return nil
 }
 
-func (stg CassandraActivityStorage) Delete(userId string, id uuid.UUID) error {
-	session, err := stg.Cluster.CreateSession()
-	if err != nil {
-		return errors.New("Cassandra Connection Error")
-	}
-	defer session.Close()
+func (stg CassandraActivityStorage) Delete(ctx context.Context, userId string, id uuid.UUID) error {
 	deleteCQL := `
 			DELETE FROM ohs_planner.activities
-			WHERE userId = ? AND id = ?;
+			WHERE userId = ? AND id = ?
 	`
-	deleteErr := session.Query(deleteCQL, userId, id.String()).Exec()
+	previous, err := stg.Read(ctx, userId, id)
+	if err != nil {
+		return err
+	}
+	if previous == nil {
+		return ErrNotFound
+	}
+	queueIndexes := func(batch *gocql.Batch) error {
+		queueCassandraSearchIndex(batch, previous, nil)
+		queueCassandraMetricTotals(batch, previous, nil)
+		queueCassandraStages(batch, previous, nil)
+		return queueCassandraMonthBucket(batch, previous, nil)
+	}
+	// Conditioned on the version read, so the index rows removed are the
+	// ones of the activity deleted
+	deleteErr := cassandraConditionalWrite(ctx, stg.Session, stg.Batch, stg.Conditions, cassandraCondition{
+		CQL:      deleteCQL,
+		Values:   []interface{}{userId, id.String()},
+		If:       "IF version = ?",
+		IfValues: []interface{}{cassandraVersion(previous.Version)},
+		Failed: func(ctx context.Context) error {
+			return stg.conflict(ctx, userId, id)
+		},
+	}, queueIndexes)
 	if deleteErr != nil {
 		return deleteErr
 	}
 	return nil
 }
 
-func (stg CassandraActivityStorage) DeleteForPlan(userId string, planId uuid.UUID) error {
-	planActivities, err := stg.Query(ActivityStorageQuery{UserId: userId, PlanId: &planId})
+// conflict tells why a lightweight transaction on an activity didn't apply
+func (stg CassandraActivityStorage) conflict(ctx context.Context, userId string, id uuid.UUID) error {
+	current, err := stg.Read(ctx, userId, id)
+	return cassandraConflict(current != nil, err)
+}
+
+func (stg CassandraActivityStorage) DeleteForPlan(ctx context.Context, userId string, planId uuid.UUID) error {
+	planActivities, err := stg.Query(ctx, ActivityStorageQuery{UserId: userId, PlanId: &planId})
 	if err != nil {
 		return err
 	}
-	if len(*planActivities) == 0 {
-		return nil
+	trashedActivities, err := stg.Query(ctx, ActivityStorageQuery{UserId: userId, PlanId: &planId, Trashed: true})
+	if err != nil {
+		return err
 	}
-	session, err := stg.Cluster.CreateSession()
+	*planActivities = append(*planActivities, *trashedActivities...)
+	return stg.deleteEach(ctx, *planActivities)
+}
+
+// DeleteAllForUser deletes the user's activities one by one, the search
+// tokens, month buckets and metric totals are partitioned further so they are
+// removed per activity anyway. The months listed for paging go last, with the
+// whole partition.
+func (stg CassandraActivityStorage) DeleteAllForUser(ctx context.Context, userId string) error {
+	userActivities, err := stg.Query(ctx, ActivityStorageQuery{UserId: userId})
 	if err != nil {
-		return errors.New("Cassandra Connection Error")
+		return err
 	}
-	defer session.Close()
-	params := make([]interface{}, 0)
-	params = append(params, userId)
-	for _, value := range *planActivities {
-		activityId := value.Id.String()
-		params = append(params, activityId)
+	trashedActivities, err := stg.Query(ctx, ActivityStorageQuery{UserId: userId, Trashed: true})
+	if err != nil {
+		return err
 	}
-	deleteCQL := `
-			DELETE FROM ohs_planner.activities
-			WHERE userId = ? AND id IN (`
-	for i := 1; i < len(params); i++ {
-		deleteCQL = deleteCQL + "?,"
+	*userActivities = append(*userActivities, *trashedActivities...)
+	err = stg.deleteEach(ctx, *userActivities)
+	if err != nil {
+		return err
 	}
-	deleteCQL = deleteCQL[:len(deleteCQL)-1]
-	deleteCQL = deleteCQL + ")"
+	deleteCQL := `
+			DELETE FROM ohs_planner.activity_months
+			WHERE userId = ?;
+	`
+	return cassandraWrite(ctx, stg.Session, stg.Batch, deleteCQL, userId)
+}
 
-	deleteErr := session.Query(deleteCQL, params...).Exec()
-	if deleteErr != nil {
-		return deleteErr
+// deleteEach deletes activities one by one along with their index rows, each
+// conditioned like Delete so that no plain write lands on rows lightweight
+// transactions also write. A failure part way leaves the rest to a retry.
+func (stg CassandraActivityStorage) deleteEach(ctx context.Context, activities []Activity) error {
+	for _, activity := range activities {
+		deleteErr := stg.Delete(ctx, activity.UserId, activity.Id)
+		if deleteErr != nil && !errors.Is(deleteErr, ErrNotFound) {
+			return deleteErr
+		}
 	}
 	return nil
 }
 
+// Put can't reach another user's item with the same id, the user is part of
+// the primary key. It is conditioned on the activity it replaces, or on there
+// being none, like the other writes to the table.
+func (stg CassandraActivityStorage) Put(ctx context.Context, activity Activity) error {
+	insertCQL := `
+			INSERT INTO ohs_planner.activities (
+				id,
+				userId,
+				recurringActivityId,
+				planId,
+				summary,
+				stages,
+				dateTime,
+				timeRelevant,
+				completed,
+				notes,
+				deletedAt,
+				version,
+				updatedAt
+			)
+			VALUES (
+				?,
+				?,
+				?,
+				?,
+				?,
+				?,
+				?,
+				?,
+				?,
+				?,
+				?,
+				?,
+				?
+			)
+	`
+	updateCQL := `
+			UPDATE ohs_planner.activities
+			SET 
+				recurringActivityId = ?,
+				planId = ?,
+				summary = ?,
+				stages = ?,
+				dateTime = ?,
+				timeRelevant = ?,
+				completed = ?,
+				notes = ?,
+				deletedAt = ?,
+				version = ?,
+				updatedAt = ?
+			WHERE id = ? AND userId = ?
+	`
+	jsonStr, err := json.Marshal(activity.Stages)
+	if err != nil {
+		return err
+	}
+	var planIdString *string
+	if activity.PlanId != nil {
+		dirString := activity.PlanId.String()
+		planIdString = &dirString
+	}
+	var recurringIdString *string
+	if activity.RecurringActivityId != nil {
+		dirString := activity.RecurringActivityId.String()
+		recurringIdString = &dirString
+	}
+	previous, err := stg.Read(ctx, activity.UserId, activity.Id)
+	if err != nil {
+		return err
+	}
+	values := []interface{}{
+		activity.Id.String(),
+		activity.UserId,
+		recurringIdString,
+		planIdString,
+		activity.Summary,
+		jsonStr,
+		activity.DateTime,
+		activity.TimeRelevant,
+		activity.Completed,
+		activity.Notes,
+		activity.DeletedAt,
+		activity.Version,
+		activity.UpdatedAt,
+	}
+	condition := cassandraCondition{
+		CQL:    insertCQL,
+		Values: values,
+		If:     "IF NOT EXISTS",
+		Failed: func(ctx context.Context) error {
+			return stg.conflict(ctx, activity.UserId, activity.Id)
+		},
+	}
+	if previous != nil {
+		condition.CQL = updateCQL
+		condition.Values = append(append([]interface{}{}, values[2:]...), values[0], values[1])
+		condition.If = "IF version = ?"
+		condition.IfValues = []interface{}{cassandraVersion(previous.Version)}
+	}
+	return cassandraConditionalWrite(ctx, stg.Session, stg.Batch, stg.Conditions, condition, func(batch *gocql.Batch) error {
+		queueCassandraSearchIndex(batch, previous, &activity)
+		queueCassandraMetricTotals(batch, previous, &activity)
+		queueCassandraStages(batch, previous, &activity)
+		queueCassandraActivityMonth(batch, &activity)
+		return queueCassandraMonthBucket(batch, previous, &activity)
+	})
+}
+
+func (stg CassandraActivityStorage) UserIds(ctx context.Context) ([]string, error) {
+	return cassandraUserIds(ctx, stg.Session, `SELECT DISTINCT userId FROM ohs_planner.activities;`)
+}
+
 type CassandraPlanStorage struct {
-	Cluster *gocql.ClusterConfig
+	Session    *gocql.Session
+	Batch      *gocql.Batch
+	Conditions *cassandraConditions
 }
 
-func (stg CassandraPlanStorage) Create(plan Plan) (Plan, error) {
-	session, err := stg.Cluster.CreateSession()
-	if err != nil {
-		return Plan{}, errors.New("Cassandra Connection Error")
-	}
-	defer session.Close()
+func (stg CassandraPlanStorage) Create(ctx context.Context, plan Plan) (Plan, error) {
 	newId := uuid.New()
+	plan.Version = 1
+	plan.UpdatedAt = versionTime(ctx)
 	insertCQL := `
 			INSERT INTO ohs_planner.plans (
 				userId,
 				id,
 				name,
-				active
+				active,
+				deletedAt,
+				archivedAt,
+				version,
+				updatedAt
 			)
 			VALUES (
+				?,
+				?,
+				?,
+				?,
 				?,
 				?,
 				?,
 				?
 			);
 	`
-	insertErr := session.Query(insertCQL,
+	insertErr := cassandraWrite(ctx, stg.Session, stg.Batch, insertCQL,
 		plan.UserId,
 		newId.String(),
 		plan.Name,
 		plan.Active,
-	).Exec()
+		plan.DeletedAt,
+		plan.ArchivedAt,
+		plan.Version,
+		plan.UpdatedAt,
+	)
 	if insertErr != nil {
 		return plan, insertErr
 	}
@@ -360,31 +724,34 @@ func (stg CassandraPlanStorage) Create(plan Plan) (Plan, error) {
 	return plan, nil
 }
 
-func (stg CassandraPlanStorage) Read(userId string, id uuid.UUID) (*Plan, error) {
-	session, err := stg.Cluster.CreateSession()
-	if err != nil {
-		return nil, errors.New("Cassandra Connection Error")
-	}
-	defer session.Close()
+func (stg CassandraPlanStorage) Read(ctx context.Context, userId string, id uuid.UUID) (*Plan, error) {
 	selectCQL := `
 			SELECT 
 			id,
 			userId,
 			name,
-			active
+			active,
+			deletedAt,
+			archivedAt,
+			version,
+			updatedAt
 			FROM ohs_planner.plans 
 			WHERE userId = ? AND id = ?
 			LIMIT 1;
 	`
-	scanner := session.Query(selectCQL, userId, id.String()).Iter().Scanner()
+	scanner := stg.Session.Query(selectCQL, userId, id.String()).WithContext(ctx).Iter().Scanner()
 	if scanner.Next() {
 		var plan Plan
 		rawId := ""
-		err = scanner.Scan(
+		err := scanner.Scan(
 			&rawId,
 			&plan.UserId,
 			&plan.Name,
 			&plan.Active,
+			&plan.DeletedAt,
+			&plan.ArchivedAt,
+			&plan.Version,
+			&plan.UpdatedAt,
 		)
 		if err != nil {
 			return nil, err
@@ -395,83 +762,219 @@ func (stg CassandraPlanStorage) Read(userId string, id uuid.UUID) (*Plan, error)
 	return nil, nil
 }
 
-func (stg CassandraPlanStorage) Query(query PlanStorageQuery) (*[]Plan, error) {
-	session, err := stg.Cluster.CreateSession()
-	if err != nil {
-		return nil, errors.New("Cassandra Connection Error")
-	}
-	defer session.Close()
+func (stg CassandraPlanStorage) Query(ctx context.Context, query PlanStorageQuery) (*[]Plan, error) {
 	selectCQL := `
 			SELECT 
 			id,
 			userId,
 			name,
-			active
+			active,
+			deletedAt,
+			archivedAt,
+			version,
+			updatedAt
 			FROM ohs_planner.plans 
 			WHERE userId = ?;
 	`
-	scanner := session.Query(selectCQL, query.UserId).Iter().Scanner()
+	scanner := stg.Session.Query(selectCQL, query.UserId).WithContext(ctx).Iter().Scanner()
 	plans := make([]Plan, 0)
 	for scanner.Next() {
 		var plan Plan
 		rawId := ""
-		err = scanner.Scan(
+		err := scanner.Scan(
 			&rawId,
 			&plan.UserId,
 			&plan.Name,
 			&plan.Active,
+			&plan.DeletedAt,
+			&plan.ArchivedAt,
+			&plan.Version,
+			&plan.UpdatedAt,
 		)
 		if err != nil {
 			return nil, err
 		}
 		plan.Id = uuid.MustParse(rawId)
-		plans = append(plans, plan)
+		if matchesPlanQuery(plan, query) {
+			plans = append(plans, plan)
+		}
+	}
+	err := scanner.Err()
+	if err != nil {
+		return nil, err
 	}
 	return &plans, nil
 }
 
-func (stg CassandraPlanStorage) Update(plan Plan) error {
-	session, err := stg.Cluster.CreateSession()
-	if err != nil {
-		return errors.New("Cassandra Connection Error")
-	}
-	defer session.Close()
+func (stg CassandraPlanStorage) Update(ctx context.Context, plan Plan) error {
 	updateCQL := `
 			UPDATE ohs_planner.plans
 			SET 
 				name = ?,
-				active = ?
-			WHERE userId = ? AND id = ?;
+				active = ?,
+				deletedAt = ?,
+				archivedAt = ?,
+				version = ?,
+				updatedAt = ?
+			WHERE userId = ? AND id = ?
 	`
-	updateErr := session.Query(updateCQL,
+	expectedVersion := plan.Version
+	plan.Version++
+	plan.UpdatedAt = versionTime(ctx)
+	values := []interface{}{
 		plan.Name,
 		plan.Active,
+		plan.DeletedAt,
+		plan.ArchivedAt,
+		plan.Version,
+		plan.UpdatedAt,
 		plan.UserId,
 		plan.Id.String(),
-	).Exec()
+	}
+	// Reading first fails fast, the condition is what keeps a concurrent
+	// writer from being overwritten
+	previous, err := stg.Read(ctx, plan.UserId, plan.Id)
+	if err != nil {
+		return err
+	}
+	if previous == nil {
+		return ErrNotFound
+	}
+	if previous.Version != expectedVersion {
+		return ErrVersionConflict
+	}
+	updateErr := cassandraConditionalWrite(ctx, stg.Session, stg.Batch, stg.Conditions, cassandraCondition{
+		CQL:      updateCQL,
+		Values:   values,
+		If:       "IF version = ?",
+		IfValues: []interface{}{cassandraVersion(expectedVersion)},
+		Failed: func(ctx context.Context) error {
+			current, err := stg.Read(ctx, plan.UserId, plan.Id)
+			return cassandraConflict(current != nil, err)
+		},
+	}, nil)
 	if updateErr != nil {
 		return updateErr
 	}
 	return nil
 }
 
-func (stg CassandraPlanStorage) Delete(userId string, id uuid.UUID) error {
-	session, err := stg.Cluster.CreateSession()
-	if err != nil {
-		return errors.New("Cassandra Connection Error")
-	}
-	defer session.Close()
+func (stg CassandraPlanStorage) Delete(ctx context.Context, userId string, id uuid.UUID) error {
 	deleteCQL := `
 			DELETE FROM ohs_planner.plans
-			WHERE userId = ? AND id = ?;
+			WHERE userId = ? AND id = ?
 	`
-	deleteErr := session.Query(deleteCQL, userId, id.String()).Exec()
+	previous, err := stg.Read(ctx, userId, id)
+	if err != nil {
+		return err
+	}
+	if previous == nil {
+		return ErrNotFound
+	}
+	deleteErr := cassandraConditionalWrite(ctx, stg.Session, stg.Batch, stg.Conditions, cassandraCondition{
+		CQL:    deleteCQL,
+		Values: []interface{}{userId, id.String()},
+		If:     "IF EXISTS",
+		Failed: func(ctx context.Context) error {
+			return ErrNotFound
+		},
+	}, nil)
 	if deleteErr != nil {
 		return deleteErr
 	}
 	return nil
 }
 
+// DeleteAllForUser deletes the user's plans one by one, each conditioned like
+// Delete so that no plain write lands on rows lightweight transactions also
+// write.
+func (stg CassandraPlanStorage) DeleteAllForUser(ctx context.Context, userId string) error {
+	for _, trashed := range []bool{false, true} {
+		plans, err := stg.Query(ctx, PlanStorageQuery{UserId: userId, Trashed: trashed, IncludeArchived: true})
+		if err != nil {
+			return err
+		}
+		for _, plan := range *plans {
+			deleteErr := stg.Delete(ctx, userId, plan.Id)
+			if deleteErr != nil && !errors.Is(deleteErr, ErrNotFound) {
+				return deleteErr
+			}
+		}
+	}
+	return nil
+}
+
+// Put can't reach another user's item with the same id, the user is part of
+// the primary key. It is conditioned on the plan it replaces, or on there
+// being none, like the other writes to the table.
+func (stg CassandraPlanStorage) Put(ctx context.Context, plan Plan) error {
+	insertCQL := `
+			INSERT INTO ohs_planner.plans (
+				name,
+				active,
+				deletedAt,
+				archivedAt,
+				version,
+				updatedAt,
+				userId,
+				id
+			)
+			VALUES (
+				?,
+				?,
+				?,
+				?,
+				?,
+				?,
+				?,
+				?
+			)
+	`
+	updateCQL := `
+			UPDATE ohs_planner.plans
+			SET 
+				name = ?,
+				active = ?,
+				deletedAt = ?,
+				archivedAt = ?,
+				version = ?,
+				updatedAt = ?
+			WHERE userId = ? AND id = ?
+	`
+	previous, err := stg.Read(ctx, plan.UserId, plan.Id)
+	if err != nil {
+		return err
+	}
+	condition := cassandraCondition{
+		CQL: insertCQL,
+		Values: []interface{}{
+			plan.Name,
+			plan.Active,
+			plan.DeletedAt,
+			plan.ArchivedAt,
+			plan.Version,
+			plan.UpdatedAt,
+			plan.UserId,
+			plan.Id.String(),
+		},
+		If: "IF NOT EXISTS",
+		Failed: func(ctx context.Context) error {
+			current, err := stg.Read(ctx, plan.UserId, plan.Id)
+			return cassandraConflict(current != nil, err)
+		},
+	}
+	if previous != nil {
+		condition.CQL = updateCQL
+		condition.If = "IF version = ?"
+		condition.IfValues = []interface{}{cassandraVersion(previous.Version)}
+	}
+	return cassandraConditionalWrite(ctx, stg.Session, stg.Batch, stg.Conditions, condition, nil)
+}
+
+func (stg CassandraPlanStorage) UserIds(ctx context.Context) ([]string, error) {
+	return cassandraUserIds(ctx, stg.Session, `SELECT DISTINCT userId FROM ohs_planner.plans;`)
+}
+
 func getCassandratorageClient() (Storage, error) {
 	hosts := []string{"localhost:9042"}
 
@@ -494,63 +997,266 @@ func getCassandratorageClient() (Storage, error) {
 		password = passwordSetting
 	}
 
+	numConns, err := intSetting("PLANNER_CASSANDRA_NUM_CONNS", 2)
+	if err != nil {
+		return Storage{}, err
+	}
+	retries, err := intSetting("PLANNER_CASSANDRA_RETRIES", 3)
+	if err != nil {
+		return Storage{}, err
+	}
+	timeout, err := durationSetting("PLANNER_CASSANDRA_TIMEOUT", time.Second*10)
+	if err != nil {
+		return Storage{}, err
+	}
+	connectTimeout, err := durationSetting("PLANNER_CASSANDRA_CONNECT_TIMEOUT", time.Second*10)
+	if err != nil {
+		return Storage{}, err
+	}
+
 	cluster := gocql.NewCluster(hosts...)
 	cluster.Authenticator = gocql.PasswordAuthenticator{Username: username, Password: password}
 	cluster.Consistency = gocql.Quorum
 	cluster.ProtoVersion = 4
-	cluster.ConnectTimeout = time.Second * 10
+	cluster.NumConns = numConns
+	cluster.Timeout = timeout
+	cluster.ConnectTimeout = connectTimeout
+	cluster.RetryPolicy = &gocql.ExponentialBackoffRetryPolicy{
+		NumRetries: retries,
+		Min:        time.Millisecond * 100,
+		Max:        time.Second * 2,
+	}
+	// gocql sessions are safe for concurrent use and pool their own
+	// connections, one is shared by every storage until Close
 	session, err := cluster.CreateSession()
 	if err != nil {
 		return Storage{}, errors.New("Cassandra Connection Error")
 	}
-	defer session.Close()
-
-	// Migrations
-	migrations := []string{
-		"CREATE KEYSPACE IF NOT EXISTS ohs_planner WITH REPLICATION = {'class' : 'SimpleStrategy', 'replication_factor' : 1};",
-		`CREATE TABLE IF NOT EXISTS ohs_planner.activities (
-			userId text,
-			id UUID,
-			recurringActivityId UUID,
-			planId UUID,
-			summary text,
-			stages text,
-			dateTime timestamp,
-			timeRelevant boolean,
-			completed boolean,
-			notes text,
-			PRIMARY KEY ((userId), id)
-		);`,
-		"CREATE INDEX IF NOT EXISTS ON ohs_planner.activities (dateTime);",
-		"CREATE INDEX IF NOT EXISTS ON ohs_planner.activities (planId);",
-		`CREATE TABLE IF NOT EXISTS ohs_planner.plans (
-			userId text,
-			id UUID,
-			name text,
-			active boolean,
-			PRIMARY KEY ((userId), id)
-		);`,
-		`CREATE TABLE IF NOT EXISTS ohs_planner.recurring_activities (
-			userId text,
-			id UUID,
-			planId UUID,
-			summary text,
-			stages text,
-			recurrEachDays int,
-			dateTimeStart timestamp,
-			timeRelevant boolean,
-			PRIMARY KEY ((userId), id)
-		);`,
-	}
-	for _, migration := range migrations {
-		err = session.Query(migration).Exec()
+
+	err = migrateCassandra(session, cassandraMigrations)
+	if err != nil {
+		session.Close()
+		return Storage{}, err
+	}
+	return withErrorKinds(withAudit(Storage{
+		Activity:          CassandraActivityStorage{Session: session},
+		RecurringActivity: CassandraRecurringActivityStorage{Session: session},
+		Plan:              CassandraPlanStorage{Session: session},
+		Audit:             CassandraAuditStorage{Session: session},
+		UnitOfWork:        cassandraUnitOfWork{Session: session},
+		closer: closerFunc(func() error {
+			session.Close()
+			return nil
+		}),
+	}), cassandraError), nil
+}
+
+func intSetting(name string, fallback int) (int, error) {
+	setting := os.Getenv(name)
+	if setting == "" {
+		return fallback, nil
+	}
+	value, err := strconv.Atoi(setting)
+	if err != nil || value < 0 {
+		return 0, fmt.Errorf("%s must be a non-negative whole number", name)
+	}
+	return value, nil
+}
+
+func durationSetting(name string, fallback time.Duration) (time.Duration, error) {
+	setting := os.Getenv(name)
+	if setting == "" {
+		return fallback, nil
+	}
+	value, err := time.ParseDuration(setting)
+	if err != nil || value <= 0 {
+		return 0, fmt.Errorf("%s must be a positive duration such as 10s", name)
+	}
+	return value, nil
+}
+
+// cassandraUserIds lists the partition keys of a table partitioned by userId
+func cassandraUserIds(ctx context.Context, session *gocql.Session, selectCQL string) ([]string, error) {
+	scanner := session.Query(selectCQL).WithContext(ctx).Iter().Scanner()
+	userIds := make([]string, 0)
+	for scanner.Next() {
+		var userId string
+		err := scanner.Scan(&userId)
 		if err != nil {
-			return Storage{}, err
+			return nil, err
+		}
+		userIds = append(userIds, userId)
+	}
+	return userIds, scanner.Err()
+}
+
+// cassandraWrite runs a single write statement, or queues it on the batch when
+// the storage belongs to a unit of work.
+func cassandraWrite(ctx context.Context, session *gocql.Session, batch *gocql.Batch, cql string, values ...interface{}) error {
+	if batch != nil {
+		batch.Query(cql, values...)
+		return nil
+	}
+	return session.Query(cql, values...).WithContext(ctx).Exec()
+}
+
+// cassandraCompareAndSet runs a write as a lightweight transaction, whose IF
+// condition is checked atomically with the write, and reports whether the
+// condition held.
+func cassandraCompareAndSet(ctx context.Context, session *gocql.Session, cql string, values ...interface{}) (bool, error) {
+	return session.Query(cql, values...).WithContext(ctx).MapScanCAS(make(map[string]interface{}))
+}
+
+// cassandraVersion binds a version in a lightweight transaction condition.
+// Rows written before versioning hold null, which reads back as version 0.
+func cassandraVersion(version int64) interface{} {
+	if version == 0 {
+		return nil
+	}
+	return version
+}
+
+// cassandraConflict tells why a versioned write didn't apply from whether the
+// item still exists.
+func cassandraConflict(exists bool, err error) error {
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return ErrNotFound
+	}
+	return ErrVersionConflict
+}
+
+// cassandraCondition is a write that only applies while its IF clause holds,
+// Failed tells why it didn't.
+type cassandraCondition struct {
+	CQL      string
+	Values   []interface{}
+	If       string
+	IfValues []interface{}
+	Failed   func(ctx context.Context) error
+}
+
+func (condition cassandraCondition) apply(ctx context.Context, session *gocql.Session) error {
+	values := append(append([]interface{}{}, condition.Values...), condition.IfValues...)
+	applied, err := cassandraCompareAndSet(ctx, session, condition.CQL+condition.If, values...)
+	if err != nil {
+		return err
+	}
+	if !applied {
+		return condition.Failed(ctx)
+	}
+	return nil
+}
+
+// cassandraConditions collects the conditional writes of a unit of work
+type cassandraConditions struct {
+	writes []cassandraCondition
+}
+
+// cassandraConditionalWrite runs condition as a lightweight transaction and,
+// once it applied, the writes queue adds, which may be nil. In a unit of work
+// the condition is left to Do.
+func cassandraConditionalWrite(ctx context.Context, session *gocql.Session, batch *gocql.Batch, conditions *cassandraConditions, condition cassandraCondition, queue func(batch *gocql.Batch) error) error {
+	if batch != nil {
+		conditions.writes = append(conditions.writes, condition)
+		if queue == nil {
+			return nil
 		}
+		return queue(batch)
+	}
+	err := condition.apply(ctx, session)
+	if err != nil || queue == nil {
+		return err
+	}
+	batch = session.NewBatch(gocql.LoggedBatch)
+	err = queue(batch)
+	if err != nil {
+		return err
+	}
+	return cassandraFollowUp(ctx, session, batch)
+}
+
+const (
+	cassandraFollowUpTimeout = time.Minute
+	cassandraFollowUpBackoff = 100 * time.Millisecond
+)
+
+// cassandraFollowUp executes the batch following a lightweight transaction
+// that already applied, retrying until it lands. The request going away
+// doesn't stop it, only cassandraFollowUpTimeout does. Its writes set and
+// remove rows by key under one timestamp fixed up front, so running it again
+// can't undo a later write.
+func cassandraFollowUp(ctx context.Context, session *gocql.Session, batch *gocql.Batch) error {
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), cassandraFollowUpTimeout)
+	defer cancel()
+	batch = batch.WithContext(ctx).WithTimestamp(time.Now().UnixMicro())
+	backoff := cassandraFollowUpBackoff
+	for {
+		err := session.ExecuteBatch(batch)
+		if err == nil || !errors.Is(cassandraError(err), ErrUnavailable) {
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+}
+
+// cassandraBatchWrite queues related writes together, on the unit of work's
+// batch when there is one and otherwise on a logged batch of their own.
+func cassandraBatchWrite(ctx context.Context, session *gocql.Session, batch *gocql.Batch, queue func(batch *gocql.Batch) error) error {
+	if batch != nil {
+		return queue(batch)
+	}
+	batch = session.NewBatch(gocql.LoggedBatch).WithContext(ctx)
+	err := queue(batch)
+	if err != nil {
+		return err
+	}
+	return session.ExecuteBatch(batch)
+}
+
+// Writes made inside Do are collected into one logged batch, which Cassandra
+// guarantees will eventually apply in full. Reads inside Do do not see the
+// queued writes.
+//
+// Lightweight transactions can't join a batch spanning partitions. A unit of
+// work with a single conditional write, such as an audited change, runs that
+// write as one ahead of the batch and only applies the batch if it held. One
+// with several fails with ErrInvalid rather than dropping their conditions.
+type cassandraUnitOfWork struct {
+	Session *gocql.Session
+}
+
+func (uow cassandraUnitOfWork) Do(ctx context.Context, fn func(Storage) error) error {
+	batch := uow.Session.NewBatch(gocql.LoggedBatch).WithContext(ctx)
+	conditions := &cassandraConditions{}
+	err := fn(withAudit(Storage{
+		Activity:          CassandraActivityStorage{Session: uow.Session, Batch: batch, Conditions: conditions},
+		RecurringActivity: CassandraRecurringActivityStorage{Session: uow.Session, Batch: batch, Conditions: conditions},
+		Plan:              CassandraPlanStorage{Session: uow.Session, Batch: batch, Conditions: conditions},
+		Audit:             CassandraAuditStorage{Session: uow.Session, Batch: batch},
+	}))
+	if err != nil {
+		return err
+	}
+	if len(conditions.writes) > 1 {
+		return fmt.Errorf("%w: a unit of work holds %d conditional writes, Cassandra can only check one", ErrInvalid, len(conditions.writes))
+	}
+	if len(conditions.writes) == 1 {
+		err = conditions.writes[0].apply(ctx, uow.Session)
+		if err != nil || batch.Size() == 0 {
+			return err
+		}
+		return cassandraFollowUp(ctx, uow.Session, batch)
+	}
+	if batch.Size() == 0 {
+		return nil
 	}
-	return Storage{
-		Activity:          CassandraActivityStorage{Cluster: cluster},
-		RecurringActivity: CassandraRecurringActivityStorage{Cluster: cluster},
-		Plan:              CassandraPlanStorage{Cluster: cluster},
-	}, nil
+	return uow.Session.ExecuteBatch(batch)
 }
@@ -0,0 +1,143 @@
+package storage
+
+import (
+	"context"
+	"encoding/json"
+
+	"github.com/gocql/gocql"
+	"github.com/google/uuid"
+)
+
+// Audit entries cluster by their time based id, so a partition slice for one
+// entity reads back oldest first.
+type CassandraAuditStorage struct {
+	Session *gocql.Session
+	Batch   *gocql.Batch
+}
+
+func (stg CassandraAuditStorage) Append(ctx context.Context, entry AuditEntry) error {
+	insertCQL := `
+			INSERT INTO ohs_planner.audit_log (
+				userId,
+				entityId,
+				id,
+				entityType,
+				action,
+				oldValue,
+				newValue,
+				timestamp
+			)
+			VALUES (
+				?,
+				?,
+				?,
+				?,
+				?,
+				?,
+				?,
+				?
+			);
+	`
+	return cassandraWrite(ctx, stg.Session, stg.Batch, insertCQL,
+		entry.UserId,
+		entry.EntityId.String(),
+		entry.Id.String(),
+		entry.EntityType,
+		entry.Action,
+		string(entry.OldValue),
+		string(entry.NewValue),
+		entry.Timestamp,
+	)
+}
+
+func (stg CassandraAuditStorage) History(ctx context.Context, userId string, entityType string, entityId uuid.UUID) (*[]AuditEntry, error) {
+	selectCQL := `
+			SELECT
+			entityId,
+			id,
+			entityType,
+			action,
+			oldValue,
+			newValue,
+			timestamp
+			FROM ohs_planner.audit_log
+			WHERE userId = ? AND entityId = ?;
+	`
+	entries, err := stg.query(ctx, userId, selectCQL, userId, entityId.String())
+	if err != nil {
+		return nil, err
+	}
+	history := make([]AuditEntry, 0, len(*entries))
+	for _, entry := range *entries {
+		if entry.EntityType == entityType {
+			history = append(history, entry)
+		}
+	}
+	return &history, nil
+}
+
+func (stg CassandraAuditStorage) ForUser(ctx context.Context, userId string) (*[]AuditEntry, error) {
+	selectCQL := `
+			SELECT
+			entityId,
+			id,
+			entityType,
+			action,
+			oldValue,
+			newValue,
+			timestamp
+			FROM ohs_planner.audit_log
+			WHERE userId = ?;
+	`
+	return stg.query(ctx, userId, selectCQL, userId)
+}
+
+func (stg CassandraAuditStorage) query(ctx context.Context, userId string, selectCQL string, values ...interface{}) (*[]AuditEntry, error) {
+	scanner := stg.Session.Query(selectCQL, values...).WithContext(ctx).Iter().Scanner()
+	entries := make([]AuditEntry, 0)
+	for scanner.Next() {
+		entry := AuditEntry{UserId: userId}
+		rawEntityId := ""
+		rawId := ""
+		oldValue := ""
+		newValue := ""
+		err := scanner.Scan(
+			&rawEntityId,
+			&rawId,
+			&entry.EntityType,
+			&entry.Action,
+			&oldValue,
+			&newValue,
+			&entry.Timestamp,
+		)
+		if err != nil {
+			return nil, err
+		}
+		entry.EntityId = uuid.MustParse(rawEntityId)
+		entry.Id = uuid.MustParse(rawId)
+		if oldValue != "" {
+			entry.OldValue = json.RawMessage(oldValue)
+		}
+		if newValue != "" {
+			entry.NewValue = json.RawMessage(newValue)
+		}
+		entries = append(entries, entry)
+	}
+	err := scanner.Err()
+	if err != nil {
+		return nil, err
+	}
+	return &entries, nil
+}
+
+func (stg CassandraAuditStorage) DeleteAllForUser(ctx context.Context, userId string) error {
+	deleteCQL := `
+			DELETE FROM ohs_planner.audit_log
+			WHERE userId = ?;
+	`
+	deleteErr := cassandraWrite(ctx, stg.Session, stg.Batch, deleteCQL, userId)
+	if deleteErr != nil {
+		return deleteErr
+	}
+	return nil
+}
@@ -0,0 +1,263 @@
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"time"
+
+	"github.com/gocql/gocql"
+	"github.com/google/uuid"
+)
+
+// activities_by_month duplicates every activity into a partition per user and
+// calendar month (UTC), clustered by dateTime, so date range queries such as
+// the weekly view read a few small partitions instead of filtering the
+// user's whole history.
+const cassandraBucketFormat = "2006-01"
+
+// Wider ranges fall back to the activities table rather than fanning out to
+// hundreds of partitions
+const maxCassandraBuckets = 36
+
+type cassandraStatement struct {
+	CQL    string
+	Values []interface{}
+}
+
+func monthBucket(dateTime time.Time) string {
+	return dateTime.UTC().Format(cassandraBucketFormat)
+}
+
+// monthBuckets lists the buckets a range overlaps, or nil when the range is
+// missing or too wide to be worth splitting.
+func monthBuckets(dateRange *DateRange) []string {
+	if dateRange == nil || dateRange.End.Before(dateRange.Start) {
+		return nil
+	}
+	start := dateRange.Start.UTC()
+	month := time.Date(start.Year(), start.Month(), 1, 0, 0, 0, 0, time.UTC)
+	buckets := make([]string, 0)
+	for !month.After(dateRange.End) {
+		if len(buckets) == maxCassandraBuckets {
+			return nil
+		}
+		buckets = append(buckets, month.Format(cassandraBucketFormat))
+		month = month.AddDate(0, 1, 0)
+	}
+	return buckets
+}
+
+// queueCassandraMonthBucket moves the bucketed copy from the previous to the
+// current version of an activity, either of which may be nil.
+func queueCassandraMonthBucket(batch *gocql.Batch, previous *Activity, current *Activity) error {
+	if previous != nil && (current == nil || !previous.DateTime.Equal(current.DateTime)) {
+		batch.Query(`
+			DELETE FROM ohs_planner.activities_by_month
+			WHERE userId = ? AND bucket = ? AND dateTime = ? AND id = ?;`,
+			previous.UserId, monthBucket(previous.DateTime), previous.DateTime, previous.Id.String())
+	}
+	if current == nil {
+		return nil
+	}
+	jsonStr, err := json.Marshal(current.Stages)
+	if err != nil {
+		return err
+	}
+	var planIdString *string
+	if current.PlanId != nil {
+		dirString := current.PlanId.String()
+		planIdString = &dirString
+	}
+	var recurringIdString *string
+	if current.RecurringActivityId != nil {
+		dirString := current.RecurringActivityId.String()
+		recurringIdString = &dirString
+	}
+	columns := []string{
+		"userId",
+		"bucket",
+		"dateTime",
+		"id",
+		"recurringActivityId",
+		"planId",
+		"summary",
+		"stages",
+		"timeRelevant",
+		"completed",
+		"notes",
+	}
+	values := []interface{}{
+		current.UserId,
+		monthBucket(current.DateTime),
+		current.DateTime,
+		current.Id.String(),
+		recurringIdString,
+		planIdString,
+		current.Summary,
+		jsonStr,
+		current.TimeRelevant,
+		current.Completed,
+		current.Notes,
+	}
+	// deletedAt and the version are only written when set, as the backfill of
+	// version 3 runs before the columns exist
+	if current.DeletedAt != nil {
+		columns = append(columns, "deletedAt")
+		values = append(values, current.DeletedAt)
+	}
+	if current.Version != 0 {
+		columns = append(columns, "version", "updatedAt")
+		values = append(values, current.Version, current.UpdatedAt)
+	}
+	placeholders := strings.TrimSuffix(strings.Repeat("?, ", len(columns)), ", ")
+	batch.Query(`
+			INSERT INTO ohs_planner.activities_by_month (`+strings.Join(columns, ", ")+`)
+			VALUES (`+placeholders+`);`,
+		values...,
+	)
+	if current.DeletedAt == nil && previous != nil && previous.DeletedAt != nil && previous.DateTime.Equal(current.DateTime) {
+		batch.Query(`
+			DELETE deletedAt FROM ohs_planner.activities_by_month
+			WHERE userId = ? AND bucket = ? AND dateTime = ? AND id = ?;`,
+			current.UserId, monthBucket(current.DateTime), current.DateTime, current.Id.String())
+	}
+	return nil
+}
+
+// backfillCassandraMonthBuckets copies activities written before the bucket
+// table existed. Re-running it only rewrites the same rows.
+func backfillCassandraMonthBuckets(ctx context.Context, session *gocql.Session) error {
+	scanner := session.Query(`
+			SELECT 
+				id,
+				userId,
+				recurringActivityId,
+				planId,
+				summary,
+				stages,
+				dateTime,
+				timeRelevant,
+				completed,
+				notes
+			FROM ohs_planner.activities;`).WithContext(ctx).Iter().Scanner()
+	for scanner.Next() {
+		var activity Activity
+		rawStages := "[]"
+		rawId := ""
+		rawPlanId := ""
+		rawRecurringId := ""
+		err := scanner.Scan(
+			&rawId,
+			&activity.UserId,
+			&rawRecurringId,
+			&rawPlanId,
+			&activity.Summary,
+			&rawStages,
+			&activity.DateTime,
+			&activity.TimeRelevant,
+			&activity.Completed,
+			&activity.Notes,
+		)
+		if err != nil {
+			return err
+		}
+		err = json.Unmarshal([]byte(rawStages), &activity.Stages)
+		if err != nil {
+			return err
+		}
+		activity.Id = uuid.MustParse(rawId)
+		if rawPlanId != "" {
+			dirRef := uuid.MustParse(rawPlanId)
+			activity.PlanId = &dirRef
+		}
+		if rawRecurringId != "" {
+			dirRef := uuid.MustParse(rawRecurringId)
+			activity.RecurringActivityId = &dirRef
+		}
+		batch := session.NewBatch(gocql.UnloggedBatch).WithContext(ctx)
+		err = queueCassandraMonthBucket(batch, nil, &activity)
+		if err != nil {
+			return err
+		}
+		err = session.ExecuteBatch(batch)
+		if err != nil {
+			return err
+		}
+	}
+	return scanner.Err()
+}
+
+// queueCassandraActivityMonth lists the month bucket of an activity written
+func queueCassandraActivityMonth(batch *gocql.Batch, current *Activity) {
+	batch.Query(`
+			INSERT INTO ohs_planner.activity_months (
+				userId,
+				bucket
+			)
+			VALUES (
+				?,
+				?
+			);`,
+		current.UserId, monthBucket(current.DateTime))
+}
+
+// backfillCassandraActivityMonths lists the buckets of activities written
+// before the table existed. Re-running it only rewrites the same rows.
+func backfillCassandraActivityMonths(ctx context.Context, session *gocql.Session) error {
+	scanner := session.Query(`
+			SELECT
+				userId,
+				dateTime
+			FROM ohs_planner.activities;`).WithContext(ctx).Iter().Scanner()
+	for scanner.Next() {
+		var activity Activity
+		err := scanner.Scan(
+			&activity.UserId,
+			&activity.DateTime,
+		)
+		if err != nil {
+			return err
+		}
+		batch := session.NewBatch(gocql.UnloggedBatch).WithContext(ctx)
+		queueCassandraActivityMonth(batch, &activity)
+		err = session.ExecuteBatch(batch)
+		if err != nil {
+			return err
+		}
+	}
+	return scanner.Err()
+}
+
+// cassandraPageBuckets lists the user's month buckets a page of query may
+// come from, in the order of the query.
+func cassandraPageBuckets(ctx context.Context, session *gocql.Session, query ActivityStorageQuery) ([]string, error) {
+	selectCQL := `
+			SELECT bucket
+			FROM ohs_planner.activity_months
+			WHERE userId = ?`
+	if query.Order == Descending {
+		selectCQL = selectCQL + ` ORDER BY bucket DESC`
+	}
+	scanner := session.Query(selectCQL, query.UserId).WithContext(ctx).Iter().Scanner()
+	buckets := make([]string, 0)
+	for scanner.Next() {
+		var bucket string
+		err := scanner.Scan(&bucket)
+		if err != nil {
+			return nil, err
+		}
+		// The bucket format sorts chronologically
+		if query.Cursor != nil {
+			cursorBucket := monthBucket(query.Cursor.DateTime)
+			if (query.Order == Descending && bucket > cursorBucket) || (query.Order != Descending && bucket < cursorBucket) {
+				continue
+			}
+		}
+		if query.DateRange != nil && (bucket < monthBucket(query.DateRange.Start) || bucket > monthBucket(query.DateRange.End)) {
+			continue
+		}
+		buckets = append(buckets, bucket)
+	}
+	return buckets, scanner.Err()
+}
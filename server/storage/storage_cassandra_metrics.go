@@ -0,0 +1,131 @@
+package storage
+
+import (
+	"context"
+	"encoding/json"
+
+	"github.com/gocql/gocql"
+	"github.com/google/uuid"
+)
+
+// activity_metric_totals keeps the metricTotals of every activity in a
+// partition per user and unit, clustered by total, so metric filters read a
+// slice of one partition.
+//
+// queueCassandraMetricTotals moves the totals from the previous to the current
+// version of an activity, either of which may be nil.
+func queueCassandraMetricTotals(batch *gocql.Batch, previous *Activity, current *Activity) {
+	currentTotals := make(map[string]int)
+	if current != nil {
+		currentTotals = metricTotals(current.Stages)
+	}
+	if previous != nil {
+		for unit, total := range metricTotals(previous.Stages) {
+			// Deleting and inserting the same row in one batch would drop it
+			if currentTotal, ok := currentTotals[unit]; ok && currentTotal == total {
+				continue
+			}
+			batch.Query(`
+			DELETE FROM ohs_planner.activity_metric_totals
+			WHERE userId = ? AND unit = ? AND total = ? AND activityId = ?;`,
+				previous.UserId, unit, total, previous.Id.String())
+		}
+	}
+	for unit, total := range currentTotals {
+		batch.Query(`
+			INSERT INTO ohs_planner.activity_metric_totals (
+				userId,
+				unit,
+				total,
+				activityId
+			)
+			VALUES (
+				?,
+				?,
+				?,
+				?
+			);`,
+			current.UserId, unit, total, current.Id.String())
+	}
+}
+
+// cassandraInChunk caps the ids looked up by one IN query
+const cassandraInChunk = 100
+
+// cassandraMetricCandidates returns the ids of the user's activities matching
+// all of filters
+func cassandraMetricCandidates(ctx context.Context, session *gocql.Session, userId string, filters []MetricFilter) (map[string]bool, error) {
+	var candidates map[string]bool
+	for _, filter := range filters {
+		selectCQL := `
+			SELECT activityId
+			FROM ohs_planner.activity_metric_totals
+			WHERE userId = ? AND unit = ?`
+		params := []interface{}{userId, filter.Unit}
+		if filter.Min != nil {
+			selectCQL = selectCQL + ` AND total >= ?`
+			params = append(params, *filter.Min)
+		}
+		if filter.Max != nil {
+			selectCQL = selectCQL + ` AND total <= ?`
+			params = append(params, *filter.Max)
+		}
+		scanner := session.Query(selectCQL, params...).WithContext(ctx).Iter().Scanner()
+		matches := make(map[string]bool)
+		for scanner.Next() {
+			rawId := ""
+			err := scanner.Scan(&rawId)
+			if err != nil {
+				return nil, err
+			}
+			if candidates == nil || candidates[rawId] {
+				matches[rawId] = true
+			}
+		}
+		err := scanner.Err()
+		if err != nil {
+			return nil, err
+		}
+		candidates = matches
+		if len(candidates) == 0 {
+			break
+		}
+	}
+	return candidates, nil
+}
+
+// backfillCassandraMetricTotals adds the totals of activities written before
+// the table existed. Re-running it only rewrites the same rows.
+func backfillCassandraMetricTotals(ctx context.Context, session *gocql.Session) error {
+	scanner := session.Query(`
+			SELECT 
+				id,
+				userId,
+				stages
+			FROM ohs_planner.activities;`).WithContext(ctx).Iter().Scanner()
+	for scanner.Next() {
+		var activity Activity
+		rawId := ""
+		rawStages := "[]"
+		err := scanner.Scan(
+			&rawId,
+			&activity.UserId,
+			&rawStages,
+		)
+		if err != nil {
+			return err
+		}
+		err = json.Unmarshal([]byte(rawStages), &activity.Stages)
+		if err != nil {
+			return err
+		}
+		activity.Id = uuid.MustParse(rawId)
+		batch := session.NewBatch(gocql.UnloggedBatch).WithContext(ctx)
+		queueCassandraMetricTotals(batch, nil, &activity)
+		err = session.ExecuteBatch(batch)
+		if err != nil {
+			return err
+		}
+	}
+	return scanner.Err()
+}
@@ -0,0 +1,431 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gocql/gocql"
+	"github.com/google/uuid"
+)
+
+// CQL DDL is not transactional, so a migration interrupted part way is
+// re-run from its first statement. Keep statements safe to repeat
+// (IF NOT EXISTS etc), ALTER TABLE ADD of an existing column is tolerated.
+var cassandraMigrations = []schemaMigration{
+	{
+		Version:     1,
+		Description: "initial schema",
+		Statements: []string{
+			`CREATE TABLE IF NOT EXISTS ohs_planner.activities (
+			userId text,
+			id UUID,
+			recurringActivityId UUID,
+			planId UUID,
+			summary text,
+			stages text,
+			dateTime timestamp,
+			timeRelevant boolean,
+			completed boolean,
+			notes text,
+			PRIMARY KEY ((userId), id)
+		);`,
+			"CREATE INDEX IF NOT EXISTS ON ohs_planner.activities (dateTime);",
+			"CREATE INDEX IF NOT EXISTS ON ohs_planner.activities (planId);",
+			`CREATE TABLE IF NOT EXISTS ohs_planner.plans (
+			userId text,
+			id UUID,
+			name text,
+			active boolean,
+			PRIMARY KEY ((userId), id)
+		);`,
+			`CREATE TABLE IF NOT EXISTS ohs_planner.recurring_activities (
+			userId text,
+			id UUID,
+			planId UUID,
+			summary text,
+			stages text,
+			recurrEachDays int,
+			dateTimeStart timestamp,
+			timeRelevant boolean,
+			PRIMARY KEY ((userId), id)
+		);`,
+		},
+	},
+	{
+		Version:     2,
+		Description: "activity search tokens",
+		Statements: []string{
+			`CREATE TABLE IF NOT EXISTS ohs_planner.activity_search_tokens (
+			userId text,
+			token text,
+			activityId UUID,
+			weight int,
+			dateTime timestamp,
+			PRIMARY KEY ((userId, token), activityId)
+		);`,
+		},
+	},
+	{
+		Version:     3,
+		Description: "activities bucketed by month",
+		Statements: []string{
+			`CREATE TABLE IF NOT EXISTS ohs_planner.activities_by_month (
+			userId text,
+			bucket text,
+			dateTime timestamp,
+			id UUID,
+			recurringActivityId UUID,
+			planId UUID,
+			summary text,
+			stages text,
+			timeRelevant boolean,
+			completed boolean,
+			notes text,
+			PRIMARY KEY ((userId, bucket), dateTime, id)
+		) WITH CLUSTERING ORDER BY (dateTime ASC, id ASC);`,
+		},
+	},
+	{
+		Version:     4,
+		Description: "trash",
+		Statements: []string{
+			`ALTER TABLE ohs_planner.activities ADD deletedAt timestamp;`,
+			`ALTER TABLE ohs_planner.activities_by_month ADD deletedAt timestamp;`,
+			`ALTER TABLE ohs_planner.plans ADD deletedAt timestamp;`,
+			`ALTER TABLE ohs_planner.recurring_activities ADD deletedAt timestamp;`,
+		},
+	},
+	{
+		Version:     5,
+		Description: "plan archiving",
+		Statements: []string{
+			`ALTER TABLE ohs_planner.plans ADD archivedAt timestamp;`,
+		},
+	},
+	{
+		// Rows written before this read back as version 0
+		Version:     6,
+		Description: "versioning",
+		Statements: []string{
+			`ALTER TABLE ohs_planner.activities ADD (version bigint, updatedAt timestamp);`,
+			`ALTER TABLE ohs_planner.activities_by_month ADD (version bigint, updatedAt timestamp);`,
+			`ALTER TABLE ohs_planner.plans ADD (version bigint, updatedAt timestamp);`,
+			`ALTER TABLE ohs_planner.recurring_activities ADD (version bigint, updatedAt timestamp);`,
+		},
+	},
+	{
+		Version:     7,
+		Description: "audit log",
+		Statements: []string{
+			`CREATE TABLE IF NOT EXISTS ohs_planner.audit_log (
+			userId text,
+			entityId UUID,
+			id timeuuid,
+			entityType text,
+			action text,
+			oldValue text,
+			newValue text,
+			timestamp timestamp,
+			PRIMARY KEY ((userId), entityId, id)
+		);`,
+		},
+	},
+	{
+		Version:     8,
+		Description: "metric totals",
+		Statements: []string{
+			`CREATE TABLE IF NOT EXISTS ohs_planner.activity_metric_totals (
+			userId text,
+			unit text,
+			total int,
+			activityId UUID,
+			PRIMARY KEY ((userId, unit), total, activityId)
+		);`,
+		},
+	},
+	{
+		Version:     9,
+		Description: "recurrence rules",
+		Statements: []string{
+			`ALTER TABLE ohs_planner.recurring_activities ADD rrule text;`,
+		},
+	},
+	{
+		Version:     10,
+		Description: "stage tables",
+		Statements: []string{
+			`CREATE TABLE IF NOT EXISTS ohs_planner.activity_stages (
+			userId text,
+			activityId UUID,
+			position int,
+			stageOrder int,
+			description text,
+			repetitions int,
+			completed boolean,
+			PRIMARY KEY ((userId, activityId), position)
+		);`,
+			`CREATE TABLE IF NOT EXISTS ohs_planner.activity_stage_metrics (
+			userId text,
+			activityId UUID,
+			stagePosition int,
+			position int,
+			amount int,
+			unit text,
+			PRIMARY KEY ((userId, activityId), stagePosition, position)
+		);`,
+		},
+	},
+	{
+		// Lists the month buckets each user has activities in, so pages can
+		// be read from the buckets in order. Buckets are never removed, an
+		// empty one only costs a read.
+		Version:     11,
+		Description: "activity months",
+		Statements: []string{
+			`CREATE TABLE IF NOT EXISTS ohs_planner.activity_months (
+			userId text,
+			bucket text,
+			PRIMARY KEY ((userId), bucket)
+		);`,
+		},
+	},
+}
+
+// cassandraBackfills rewrite existing data after the statements of the
+// migration with the same version. They must be safe to repeat.
+var cassandraBackfills = map[int]func(ctx context.Context, session *gocql.Session) error{
+	2:  backfillCassandraSearchIndex,
+	3:  backfillCassandraMonthBuckets,
+	8:  backfillCassandraMetricTotals,
+	10: backfillCassandraStages,
+	11: backfillCassandraActivityMonths,
+}
+
+const (
+	cassandraMigrationLockName    = "migrations"
+	cassandraMigrationLockTTL     = 300
+	cassandraMigrationLockTimeout = 5 * time.Minute
+	cassandraMigrationLockBackoff = 2 * time.Second
+	cassandraMigrationLockRenewal = time.Minute
+)
+
+func migrateCassandra(session *gocql.Session, migrations []schemaMigration) error {
+	bootstrap := []string{
+		"CREATE KEYSPACE IF NOT EXISTS ohs_planner WITH REPLICATION = {'class' : 'SimpleStrategy', 'replication_factor' : 1};",
+		`CREATE TABLE IF NOT EXISTS ohs_planner.schema_version (
+			version int,
+			description text,
+			checksum text,
+			appliedAt timestamp,
+			PRIMARY KEY (version)
+		);`,
+		`CREATE TABLE IF NOT EXISTS ohs_planner.schema_lock (
+			name text,
+			owner text,
+			acquiredAt timestamp,
+			PRIMARY KEY (name)
+		);`,
+	}
+	for _, statement := range bootstrap {
+		err := session.Query(statement).Exec()
+		if err != nil {
+			return err
+		}
+	}
+
+	owner := uuid.New().String()
+	err := acquireCassandraMigrationLock(session, owner)
+	if err != nil {
+		return err
+	}
+	defer releaseCassandraMigrationLock(session, owner)
+	ctx, stop := holdCassandraMigrationLock(session, owner)
+	defer stop()
+
+	// Read the history only once the lock is held, another replica may have
+	// just finished migrating
+	applied, err := readAppliedCassandraMigrations(session)
+	if err != nil {
+		return err
+	}
+	pending, err := pendingMigrations(applied, migrations)
+	if err != nil {
+		return err
+	}
+	for _, migration := range pending {
+		err = applyCassandraMigration(ctx, session, migration)
+		if ctx.Err() != nil {
+			err = context.Cause(ctx)
+		}
+		if err != nil {
+			return fmt.Errorf("applying migration %d (%s): %w", migration.Version, migration.Description, err)
+		}
+	}
+	return nil
+}
+
+func acquireCassandraMigrationLock(session *gocql.Session, owner string) error {
+	deadline := time.Now().Add(cassandraMigrationLockTimeout)
+	for {
+		applied, err := session.Query(`
+			INSERT INTO ohs_planner.schema_lock (
+				name,
+				owner,
+				acquiredAt
+			)
+			VALUES (
+				?,
+				?,
+				?
+			)
+			IF NOT EXISTS
+			USING TTL ?;`,
+			cassandraMigrationLockName,
+			owner,
+			time.Now().UTC(),
+			cassandraMigrationLockTTL,
+		).MapScanCAS(map[string]interface{}{})
+		if err != nil {
+			return err
+		}
+		if applied {
+			return nil
+		}
+		if time.Now().After(deadline) {
+			return errors.New("timed out waiting for the Cassandra migration lock")
+		}
+		time.Sleep(cassandraMigrationLockBackoff)
+	}
+}
+
+// holdCassandraMigrationLock renews the lock every
+// cassandraMigrationLockRenewal, well within its TTL, until stop is called, so
+// backfills may take longer than the TTL. The context is cancelled when a
+// renewal fails, as the lock may then pass to another replica and the
+// migration has to stop.
+func holdCassandraMigrationLock(session *gocql.Session, owner string) (context.Context, func()) {
+	ctx, cancel := context.WithCancelCause(context.Background())
+	done := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(cassandraMigrationLockRenewal)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				err := renewCassandraMigrationLock(ctx, session, owner)
+				if err != nil {
+					cancel(fmt.Errorf("renewing the Cassandra migration lock: %w", err))
+					return
+				}
+			}
+		}
+	}()
+	return ctx, func() {
+		close(done)
+		cancel(nil)
+	}
+}
+
+// renewCassandraMigrationLock rewrites the owner with a fresh TTL, which keeps
+// the row alive, as long as the owner still holds it.
+func renewCassandraMigrationLock(ctx context.Context, session *gocql.Session, owner string) error {
+	applied, err := session.Query(`
+			UPDATE ohs_planner.schema_lock
+			USING TTL ?
+			SET owner = ?
+			WHERE name = ?
+			IF owner = ?;`,
+		cassandraMigrationLockTTL,
+		owner,
+		cassandraMigrationLockName,
+		owner,
+	).WithContext(ctx).MapScanCAS(map[string]interface{}{})
+	if err != nil {
+		return err
+	}
+	if !applied {
+		return errors.New("the lock expired or passed to another replica")
+	}
+	return nil
+}
+
+func releaseCassandraMigrationLock(session *gocql.Session, owner string) error {
+	_, err := session.Query(`
+			DELETE FROM ohs_planner.schema_lock
+			WHERE name = ?
+			IF owner = ?;`,
+		cassandraMigrationLockName,
+		owner,
+	).MapScanCAS(map[string]interface{}{})
+	return err
+}
+
+func readAppliedCassandraMigrations(session *gocql.Session) ([]appliedMigration, error) {
+	scanner := session.Query(`SELECT version, checksum FROM ohs_planner.schema_version;`).
+		Consistency(gocql.Quorum).Iter().Scanner()
+	applied := make([]appliedMigration, 0)
+	for scanner.Next() {
+		var migration appliedMigration
+		err := scanner.Scan(&migration.Version, &migration.Checksum)
+		if err != nil {
+			return nil, err
+		}
+		applied = append(applied, migration)
+	}
+	err := scanner.Err()
+	if err != nil {
+		return nil, err
+	}
+	// The table is keyed by version alone, so rows come back in token order
+	sort.Slice(applied, func(i, j int) bool {
+		return applied[i].Version < applied[j].Version
+	})
+	return applied, nil
+}
+
+func applyCassandraMigration(ctx context.Context, session *gocql.Session, migration schemaMigration) error {
+	for _, statement := range migration.Statements {
+		if strings.TrimSpace(statement) == "" {
+			continue
+		}
+		err := session.Query(statement).WithContext(ctx).Exec()
+		if err != nil && !isRepeatedCassandraAlter(err) {
+			return err
+		}
+	}
+	backfill, ok := cassandraBackfills[migration.Version]
+	if ok {
+		err := backfill(ctx, session)
+		if err != nil {
+			return err
+		}
+	}
+	return session.Query(`
+			INSERT INTO ohs_planner.schema_version (
+				version,
+				description,
+				checksum,
+				appliedAt
+			)
+			VALUES (
+				?,
+				?,
+				?,
+				?
+			);`,
+		migration.Version,
+		migration.Description,
+		migration.checksum(),
+		time.Now().UTC(),
+	).WithContext(ctx).Exec()
+}
+
+func isRepeatedCassandraAlter(err error) bool {
+	return strings.Contains(err.Error(), "conflicts with an existing column")
+}
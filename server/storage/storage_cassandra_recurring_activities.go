@@ -1,6 +1,7 @@
 package storage
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 
@@ -9,16 +10,15 @@ import (
 )
 
 type CassandraRecurringActivityStorage struct {
-	Cluster *gocql.ClusterConfig
+	Session    *gocql.Session
+	Batch      *gocql.Batch
+	Conditions *cassandraConditions
 }
 
-func (stg CassandraRecurringActivityStorage) Create(activity RecurringActivity) (RecurringActivity, error) {
-	session, err := stg.Cluster.CreateSession()
-	if err != nil {
-		return RecurringActivity{}, errors.New("Cassandra Connection Error")
-	}
-	defer session.Close()
+func (stg CassandraRecurringActivityStorage) Create(ctx context.Context, activity RecurringActivity) (RecurringActivity, error) {
 	newId := uuid.New()
+	activity.Version = 1
+	activity.UpdatedAt = versionTime(ctx)
 	insertCQL := `
 			INSERT INTO ohs_planner.recurring_activities (
 				id,
@@ -27,8 +27,12 @@ func (stg CassandraRecurringActivityStorage) Create(activity RecurringActivity)
 				summary,
 				stages,
 				recurrEachDays,
+				rrule,
 				dateTimeStart,
-				timeRelevant
+				timeRelevant,
+				deletedAt,
+				version,
+				updatedAt
 			)
 			VALUES (
 				?,
@@ -38,6 +42,10 @@ func (stg CassandraRecurringActivityStorage) Create(activity RecurringActivity)
 				?,
 				?,
 				?,
+				?,
+				?,
+				?,
+				?,
 				?
 			);
 	`
@@ -50,16 +58,20 @@ func (stg CassandraRecurringActivityStorage) Create(activity RecurringActivity)
 		dirString := activity.PlanId.String()
 		planIdString = &dirString
 	}
-	insertErr := session.Query(insertCQL,
+	insertErr := cassandraWrite(ctx, stg.Session, stg.Batch, insertCQL,
 		newId.String(),
 		activity.UserId,
 		planIdString,
 		activity.Summary,
 		jsonStr,
 		activity.RecurrEachDays,
+		activity.RRule,
 		activity.DateTimeStart,
 		activity.TimeRelevant,
-	).Exec()
+		activity.DeletedAt,
+		activity.Version,
+		activity.UpdatedAt,
+	)
 	if insertErr != nil {
 		return activity, insertErr
 	}
@@ -67,12 +79,7 @@ func (stg CassandraRecurringActivityStorage) Create(activity RecurringActivity)
 	return activity, nil
 }
 
-func (stg CassandraRecurringActivityStorage) Read(userId string, id uuid.UUID) (*RecurringActivity, error) {
-	session, err := stg.Cluster.CreateSession()
-	if err != nil {
-		return nil, errors.New("Cassandra Connection Error")
-	}
-	defer session.Close()
+func (stg CassandraRecurringActivityStorage) Read(ctx context.Context, userId string, id uuid.UUID) (*RecurringActivity, error) {
 	selectCQL := `
 			SELECT 
 				id,
@@ -81,32 +88,40 @@ func (stg CassandraRecurringActivityStorage) Read(userId string, id uuid.UUID) (
 				summary,
 				stages,
 				recurrEachDays,
+				rrule,
 				dateTimeStart,
-				timeRelevant
+				timeRelevant,
+				deletedAt,
+				version,
+				updatedAt
 			FROM ohs_planner.recurring_activities 
 			WHERE userId = ? AND id = ?
 			LIMIT 1;
 	`
-	scanner := session.Query(selectCQL, userId, id.String()).Iter().Scanner()
+	scanner := stg.Session.Query(selectCQL, userId, id.String()).WithContext(ctx).Iter().Scanner()
 	if scanner.Next() {
 		var activity RecurringActivity
 		rawStages := "[]"
 		rawId := ""
 		rawPlanId := ""
-		err = scanner.Scan(
+		err := scanner.Scan(
 			&rawId,
 			&activity.UserId,
 			&rawPlanId,
 			&activity.Summary,
 			&rawStages,
 			&activity.RecurrEachDays,
+			&activity.RRule,
 			&activity.DateTimeStart,
 			&activity.TimeRelevant,
+			&activity.DeletedAt,
+			&activity.Version,
+			&activity.UpdatedAt,
 		)
 		if err != nil {
 			return nil, err
 		}
-		err := json.Unmarshal([]byte(rawStages), &activity.Stages)
+		err = json.Unmarshal([]byte(rawStages), &activity.Stages)
 		if err != nil {
 			return nil, err
 		}
@@ -120,12 +135,7 @@ func (stg CassandraRecurringActivityStorage) Read(userId string, id uuid.UUID) (
 	return nil, nil
 }
 
-func (stg CassandraRecurringActivityStorage) Query(query RecurringActivityStorageQuery) (*[]RecurringActivity, error) {
-	session, err := stg.Cluster.CreateSession()
-	if err != nil {
-		return nil, errors.New("Cassandra Connection Error")
-	}
-	defer session.Close()
+func (stg CassandraRecurringActivityStorage) Query(ctx context.Context, query RecurringActivityStorageQuery) (*[]RecurringActivity, error) {
 	params := []interface{}{query.UserId}
 	selectCQL := `
 	SELECT 
@@ -135,8 +145,12 @@ func (stg CassandraRecurringActivityStorage) Query(query RecurringActivityStorag
 		summary,
 		stages,
 		recurrEachDays,
+		rrule,
 		dateTimeStart,
-		timeRelevant
+		timeRelevant,
+		deletedAt,
+		version,
+		updatedAt
 	FROM ohs_planner.recurring_activities 
 	WHERE userId = ?`
 	if query.PlanId != nil {
@@ -146,7 +160,7 @@ func (stg CassandraRecurringActivityStorage) Query(query RecurringActivityStorag
 	if query.PlanId != nil {
 		selectCQL = selectCQL + ` ALLOW FILTERING`
 	}
-	rows := session.Query(selectCQL, params...).Iter().Scanner()
+	rows := stg.Session.Query(selectCQL, params...).WithContext(ctx).Iter().Scanner()
 	activities := make([]RecurringActivity, 0)
 	// Print the results of the query
 	for rows.Next() {
@@ -154,21 +168,25 @@ func (stg CassandraRecurringActivityStorage) Query(query RecurringActivityStorag
 		rawStages := "[]"
 		rawId := ""
 		rawPlanId := ""
-		err = rows.Scan(
+		err := rows.Scan(
 			&rawId,
 			&activity.UserId,
 			&rawPlanId,
 			&activity.Summary,
 			&rawStages,
 			&activity.RecurrEachDays,
+			&activity.RRule,
 			&activity.DateTimeStart,
 			&activity.TimeRelevant,
+			&activity.DeletedAt,
+			&activity.Version,
+			&activity.UpdatedAt,
 		)
 		if err != nil {
 			return nil, err
 		}
 
-		err := json.Unmarshal([]byte(rawStages), &activity.Stages)
+		err = json.Unmarshal([]byte(rawStages), &activity.Stages)
 		if err != nil {
 			return nil, err
 		}
@@ -177,17 +195,14 @@ func (stg CassandraRecurringActivityStorage) Query(query RecurringActivityStorag
 			dirRef := uuid.MustParse(rawPlanId)
 			activity.PlanId = &dirRef
 		}
-		activities = append(activities, activity)
+		if (activity.DeletedAt != nil) == query.Trashed {
+			activities = append(activities, activity)
+		}
 	}
 	return &activities, nil
 }
 
-func (stg CassandraRecurringActivityStorage) Update(activity RecurringActivity) error {
-	session, err := stg.Cluster.CreateSession()
-	if err != nil {
-		return errors.New("Cassandra Connection Error")
-	}
-	defer session.Close()
+func (stg CassandraRecurringActivityStorage) Update(ctx context.Context, activity RecurringActivity) error {
 	updateCQL := `
 			UPDATE ohs_planner.recurring_activities
 			SET 
@@ -195,9 +210,13 @@ func (stg CassandraRecurringActivityStorage) Update(activity RecurringActivity)
 				summary = ?,
 				stages = ?,
 				recurrEachDays = ?,
+				rrule = ?,
 				dateTimeStart = ?,
-				timeRelevant = ?
-			WHERE userId = ? AND id = ?;
+				timeRelevant = ?,
+				deletedAt = ?,
+				version = ?,
+				updatedAt = ?
+			WHERE userId = ? AND id = ?
 	`
 	jsonStr, err := json.Marshal(activity.Stages)
 	if err != nil {
@@ -208,70 +227,197 @@ func (stg CassandraRecurringActivityStorage) Update(activity RecurringActivity)
 		dirString := activity.PlanId.String()
 		planIdString = &dirString
 	}
-	updateErr := session.Query(updateCQL,
+	expectedVersion := activity.Version
+	activity.Version++
+	activity.UpdatedAt = versionTime(ctx)
+	values := []interface{}{
 		planIdString,
 		activity.Summary,
 		jsonStr,
 		activity.RecurrEachDays,
+		activity.RRule,
 		activity.DateTimeStart,
 		activity.TimeRelevant,
+		activity.DeletedAt,
+		activity.Version,
+		activity.UpdatedAt,
 		activity.UserId,
 		activity.Id.String(),
-	).Exec()
+	}
+	// Reading first fails fast, the condition is what keeps a concurrent
+	// writer from being overwritten
+	previous, err := stg.Read(ctx, activity.UserId, activity.Id)
+	if err != nil {
+		return err
+	}
+	if previous == nil {
+		return ErrNotFound
+	}
+	if previous.Version != expectedVersion {
+		return ErrVersionConflict
+	}
+	updateErr := cassandraConditionalWrite(ctx, stg.Session, stg.Batch, stg.Conditions, cassandraCondition{
+		CQL:      updateCQL,
+		Values:   values,
+		If:       "IF version = ?",
+		IfValues: []interface{}{cassandraVersion(expectedVersion)},
+		Failed: func(ctx context.Context) error {
+			current, err := stg.Read(ctx, activity.UserId, activity.Id)
+			return cassandraConflict(current != nil, err)
+		},
+	}, nil)
 	if updateErr != nil {
 		return updateErr
 	}
 	return nil
 }
 
-func (stg CassandraRecurringActivityStorage) Delete(userId string, id uuid.UUID) error {
-	session, err := stg.Cluster.CreateSession()
-	if err != nil {
-		return errors.New("Cassandra Connection Error")
-	}
-	defer session.Close()
+func (stg CassandraRecurringActivityStorage) Delete(ctx context.Context, userId string, id uuid.UUID) error {
 	deleteCQL := `
 			DELETE FROM ohs_planner.recurring_activities
-			WHERE userId = ? AND id = ?;
+			WHERE userId = ? AND id = ?
 	`
-	deleteErr := session.Query(deleteCQL, userId, id.String()).Exec()
+	previous, err := stg.Read(ctx, userId, id)
+	if err != nil {
+		return err
+	}
+	if previous == nil {
+		return ErrNotFound
+	}
+	deleteErr := cassandraConditionalWrite(ctx, stg.Session, stg.Batch, stg.Conditions, cassandraCondition{
+		CQL:    deleteCQL,
+		Values: []interface{}{userId, id.String()},
+		If:     "IF EXISTS",
+		Failed: func(ctx context.Context) error {
+			return ErrNotFound
+		},
+	}, nil)
 	if deleteErr != nil {
 		return deleteErr
 	}
 	return nil
 }
 
-func (stg CassandraRecurringActivityStorage) DeleteForPlan(userId string, planId uuid.UUID) error {
-	planActivities, err := stg.Query(RecurringActivityStorageQuery{UserId: userId, PlanId: &planId})
+func (stg CassandraRecurringActivityStorage) DeleteForPlan(ctx context.Context, userId string, planId uuid.UUID) error {
+	return stg.deleteEach(ctx, RecurringActivityStorageQuery{UserId: userId, PlanId: &planId})
+}
+
+func (stg CassandraRecurringActivityStorage) DeleteAllForUser(ctx context.Context, userId string) error {
+	return stg.deleteEach(ctx, RecurringActivityStorageQuery{UserId: userId})
+}
+
+// deleteEach deletes the recurring activities matching query, trashed or not,
+// one by one, each conditioned like Delete so that no plain write lands on
+// rows lightweight transactions also write.
+func (stg CassandraRecurringActivityStorage) deleteEach(ctx context.Context, query RecurringActivityStorageQuery) error {
+	for _, trashed := range []bool{false, true} {
+		query.Trashed = trashed
+		activities, err := stg.Query(ctx, query)
+		if err != nil {
+			return err
+		}
+		for _, activity := range *activities {
+			deleteErr := stg.Delete(ctx, query.UserId, activity.Id)
+			if deleteErr != nil && !errors.Is(deleteErr, ErrNotFound) {
+				return deleteErr
+			}
+		}
+	}
+	return nil
+}
+
+// Put can't reach another user's item with the same id, the user is part of
+// the primary key. It is conditioned on the recurring activity it replaces, or
+// on there being none, like the other writes to the table.
+func (stg CassandraRecurringActivityStorage) Put(ctx context.Context, activity RecurringActivity) error {
+	insertCQL := `
+			INSERT INTO ohs_planner.recurring_activities (
+				planId,
+				summary,
+				stages,
+				recurrEachDays,
+				rrule,
+				dateTimeStart,
+				timeRelevant,
+				deletedAt,
+				version,
+				updatedAt,
+				userId,
+				id
+			)
+			VALUES (
+				?,
+				?,
+				?,
+				?,
+				?,
+				?,
+				?,
+				?,
+				?,
+				?,
+				?,
+				?
+			)
+	`
+	updateCQL := `
+			UPDATE ohs_planner.recurring_activities
+			SET 
+				planId = ?,
+				summary = ?,
+				stages = ?,
+				recurrEachDays = ?,
+				rrule = ?,
+				dateTimeStart = ?,
+				timeRelevant = ?,
+				deletedAt = ?,
+				version = ?,
+				updatedAt = ?
+			WHERE userId = ? AND id = ?
+	`
+	jsonStr, err := json.Marshal(activity.Stages)
 	if err != nil {
 		return err
 	}
-	if len(*planActivities) == 0 {
-		return nil
+	var planIdString *string
+	if activity.PlanId != nil {
+		dirString := activity.PlanId.String()
+		planIdString = &dirString
 	}
-	session, err := stg.Cluster.CreateSession()
+	previous, err := stg.Read(ctx, activity.UserId, activity.Id)
 	if err != nil {
-		return errors.New("Cassandra Connection Error")
+		return err
 	}
-	defer session.Close()
-	params := make([]interface{}, 0)
-	params = append(params, userId)
-	for _, value := range *planActivities {
-		activityId := value.Id.String()
-		params = append(params, activityId)
+	condition := cassandraCondition{
+		CQL: insertCQL,
+		Values: []interface{}{
+			planIdString,
+			activity.Summary,
+			jsonStr,
+			activity.RecurrEachDays,
+			activity.RRule,
+			activity.DateTimeStart,
+			activity.TimeRelevant,
+			activity.DeletedAt,
+			activity.Version,
+			activity.UpdatedAt,
+			activity.UserId,
+			activity.Id.String(),
+		},
+		If: "IF NOT EXISTS",
+		Failed: func(ctx context.Context) error {
+			current, err := stg.Read(ctx, activity.UserId, activity.Id)
+			return cassandraConflict(current != nil, err)
+		},
 	}
-	deleteCQL := `
-			DELETE FROM ohs_planner.recurring_activities
-			WHERE userId = ? AND id IN (`
-	for i := 1; i < len(params); i++ {
-		deleteCQL = deleteCQL + "?,"
+	if previous != nil {
+		condition.CQL = updateCQL
+		condition.If = "IF version = ?"
+		condition.IfValues = []interface{}{cassandraVersion(previous.Version)}
 	}
-	deleteCQL = deleteCQL[:len(deleteCQL)-1]
-	deleteCQL = deleteCQL + ")"
+	return cassandraConditionalWrite(ctx, stg.Session, stg.Batch, stg.Conditions, condition, nil)
+}
 
-	deleteErr := session.Query(deleteCQL, params...).Exec()
-	if deleteErr != nil {
-		return deleteErr
-	}
-	return nil
+func (stg CassandraRecurringActivityStorage) UserIds(ctx context.Context) ([]string, error) {
+	return cassandraUserIds(ctx, stg.Session, `SELECT DISTINCT userId FROM ohs_planner.recurring_activities;`)
 }
@@ -0,0 +1,165 @@
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"sort"
+	"time"
+
+	"github.com/gocql/gocql"
+	"github.com/google/uuid"
+)
+
+// Cassandra has no ranked text search, so every word of an activity is kept
+// in a token table partitioned by user and word. A search reads one partition
+// per term and sums the weights.
+//
+// queueCassandraSearchIndex moves the index from the previous to the current
+// version of an activity, either of which may be nil. Trashed activities are
+// left out of the index.
+func queueCassandraSearchIndex(batch *gocql.Batch, previous *Activity, current *Activity) {
+	if previous != nil && previous.DeletedAt != nil {
+		previous = nil
+	}
+	if current != nil && current.DeletedAt != nil {
+		current = nil
+	}
+	currentWeights := make(map[string]int)
+	if current != nil {
+		currentWeights = activitySearchWeights(*current)
+	}
+	if previous != nil {
+		for token := range activitySearchWeights(*previous) {
+			if _, ok := currentWeights[token]; ok {
+				continue
+			}
+			batch.Query(`
+			DELETE FROM ohs_planner.activity_search_tokens
+			WHERE userId = ? AND token = ? AND activityId = ?;`,
+				previous.UserId, token, previous.Id.String())
+		}
+	}
+	for token, weight := range currentWeights {
+		batch.Query(`
+			INSERT INTO ohs_planner.activity_search_tokens (
+				userId,
+				token,
+				activityId,
+				weight,
+				dateTime
+			)
+			VALUES (
+				?,
+				?,
+				?,
+				?,
+				?
+			);`,
+			current.UserId, token, current.Id.String(), weight, current.DateTime)
+	}
+}
+
+// backfillCassandraSearchIndex indexes activities written before the token
+// table existed. Re-running it only rewrites the same rows.
+func backfillCassandraSearchIndex(ctx context.Context, session *gocql.Session) error {
+	scanner := session.Query(`
+			SELECT 
+				id,
+				userId,
+				summary,
+				stages,
+				dateTime,
+				notes
+			FROM ohs_planner.activities;`).WithContext(ctx).Iter().Scanner()
+	for scanner.Next() {
+		var activity Activity
+		rawId := ""
+		rawStages := "[]"
+		err := scanner.Scan(
+			&rawId,
+			&activity.UserId,
+			&activity.Summary,
+			&rawStages,
+			&activity.DateTime,
+			&activity.Notes,
+		)
+		if err != nil {
+			return err
+		}
+		err = json.Unmarshal([]byte(rawStages), &activity.Stages)
+		if err != nil {
+			return err
+		}
+		activity.Id = uuid.MustParse(rawId)
+		batch := session.NewBatch(gocql.UnloggedBatch).WithContext(ctx)
+		queueCassandraSearchIndex(batch, nil, &activity)
+		err = session.ExecuteBatch(batch)
+		if err != nil {
+			return err
+		}
+	}
+	return scanner.Err()
+}
+
+func (stg CassandraActivityStorage) Search(ctx context.Context, query ActivitySearchQuery) (*[]Activity, error) {
+	type match struct {
+		id       uuid.UUID
+		score    int
+		dateTime time.Time
+	}
+	matches := make(map[uuid.UUID]*match)
+	for _, term := range searchTerms(query.Text) {
+		scanner := stg.Session.Query(`
+			SELECT 
+				activityId,
+				weight,
+				dateTime
+			FROM ohs_planner.activity_search_tokens
+			WHERE userId = ? AND token = ?;`,
+			query.UserId, term).WithContext(ctx).Iter().Scanner()
+		for scanner.Next() {
+			rawId := ""
+			var weight int
+			var dateTime time.Time
+			err := scanner.Scan(&rawId, &weight, &dateTime)
+			if err != nil {
+				return nil, err
+			}
+			id := uuid.MustParse(rawId)
+			if matches[id] == nil {
+				matches[id] = &match{id: id, dateTime: dateTime}
+			}
+			matches[id].score += weight
+		}
+		err := scanner.Err()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// Rank on the index alone so only the returned activities are read
+	ranked := make([]*match, 0, len(matches))
+	for _, m := range matches {
+		ranked = append(ranked, m)
+	}
+	sort.Slice(ranked, func(i, j int) bool {
+		if ranked[i].score != ranked[j].score {
+			return ranked[i].score > ranked[j].score
+		}
+		return activityBefore(Activity{Id: ranked[j].id, DateTime: ranked[j].dateTime}, Activity{Id: ranked[i].id, DateTime: ranked[i].dateTime})
+	})
+	if query.Limit > 0 && len(ranked) > query.Limit {
+		ranked = ranked[:query.Limit]
+	}
+	activities := make([]Activity, 0, len(ranked))
+	for _, m := range ranked {
+		activity, err := stg.Read(ctx, query.UserId, m.id)
+		if err != nil {
+			return nil, err
+		}
+		if activity != nil && activity.DeletedAt == nil {
+			activities = append(activities, *activity)
+		}
+	}
+	return &activities, nil
+}
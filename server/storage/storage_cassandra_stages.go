@@ -0,0 +1,125 @@
+package storage
+
+import (
+	"context"
+	"encoding/json"
+
+	"github.com/gocql/gocql"
+	"github.com/google/uuid"
+)
+
+// activity_stages and activity_stage_metrics hold the stages of every activity
+// in a partition of its own, keyed by their positions in the stages column.
+// The column stays the source for reads, like on SQLite.
+//
+// queueCassandraStages moves the stage rows from the previous to the current
+// version of an activity, either of which may be nil. Rows at positions the
+// current version still has are overwritten rather than deleted, deleting and
+// inserting the same row in one batch would drop it.
+func queueCassandraStages(batch *gocql.Batch, previous *Activity, current *Activity) {
+	if current == nil {
+		batch.Query(`
+			DELETE FROM ohs_planner.activity_stages
+			WHERE userId = ? AND activityId = ?;`,
+			previous.UserId, previous.Id.String())
+		batch.Query(`
+			DELETE FROM ohs_planner.activity_stage_metrics
+			WHERE userId = ? AND activityId = ?;`,
+			previous.UserId, previous.Id.String())
+		return
+	}
+	if previous != nil && len(previous.Stages) > len(current.Stages) {
+		batch.Query(`
+			DELETE FROM ohs_planner.activity_stages
+			WHERE userId = ? AND activityId = ? AND position >= ?;`,
+			current.UserId, current.Id.String(), len(current.Stages))
+		batch.Query(`
+			DELETE FROM ohs_planner.activity_stage_metrics
+			WHERE userId = ? AND activityId = ? AND stagePosition >= ?;`,
+			current.UserId, current.Id.String(), len(current.Stages))
+	}
+	for position, stage := range current.Stages {
+		batch.Query(`
+			INSERT INTO ohs_planner.activity_stages (
+				userId,
+				activityId,
+				position,
+				stageOrder,
+				description,
+				repetitions,
+				completed
+			)
+			VALUES (
+				?,
+				?,
+				?,
+				?,
+				?,
+				?,
+				?
+			);`,
+			current.UserId, current.Id.String(), position, stage.Order, stage.Description, stage.Repetitions, stage.Completed)
+		if previous != nil && position < len(previous.Stages) && len(previous.Stages[position].Metrics) > len(stage.Metrics) {
+			batch.Query(`
+			DELETE FROM ohs_planner.activity_stage_metrics
+			WHERE userId = ? AND activityId = ? AND stagePosition = ? AND position >= ?;`,
+				current.UserId, current.Id.String(), position, len(stage.Metrics))
+		}
+		for metricPosition, metric := range stage.Metrics {
+			batch.Query(`
+			INSERT INTO ohs_planner.activity_stage_metrics (
+				userId,
+				activityId,
+				stagePosition,
+				position,
+				amount,
+				unit
+			)
+			VALUES (
+				?,
+				?,
+				?,
+				?,
+				?,
+				?
+			);`,
+				current.UserId, current.Id.String(), position, metricPosition, metric.Amount, metric.Unit)
+		}
+	}
+}
+
+// backfillCassandraStages adds the stage rows of activities written before the
+// tables existed. Re-running it only rewrites the same rows.
+func backfillCassandraStages(ctx context.Context, session *gocql.Session) error {
+	scanner := session.Query(`
+			SELECT
+				id,
+				userId,
+				stages
+			FROM ohs_planner.activities;`).WithContext(ctx).Iter().Scanner()
+	for scanner.Next() {
+		var activity Activity
+		rawId := ""
+		rawStages := "[]"
+		err := scanner.Scan(
+			&rawId,
+			&activity.UserId,
+			&rawStages,
+		)
+		if err != nil {
+			return err
+		}
+		err = json.Unmarshal([]byte(rawStages), &activity.Stages)
+		if err != nil {
+			return err
+		}
+		activity.Id = uuid.MustParse(rawId)
+		batch := session.NewBatch(gocql.UnloggedBatch).WithContext(ctx)
+		queueCassandraStages(batch, nil, &activity)
+		err = session.ExecuteBatch(batch)
+		if err != nil {
+			return err
+		}
+	}
+	return scanner.Err()
+}
@@ -0,0 +1,96 @@
+package storage
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gocql/gocql"
+)
+
+func TestCassandraSettingsDefaultAndParse(t *testing.T) {
+	t.Setenv("PLANNER_CASSANDRA_NUM_CONNS", "")
+	numConns, err := intSetting("PLANNER_CASSANDRA_NUM_CONNS", 2)
+	if err != nil || numConns != 2 {
+		t.Errorf("Expected default of 2, got %d (%v)", numConns, err)
+	}
+	t.Setenv("PLANNER_CASSANDRA_NUM_CONNS", "8")
+	numConns, err = intSetting("PLANNER_CASSANDRA_NUM_CONNS", 2)
+	if err != nil || numConns != 8 {
+		t.Errorf("Expected 8, got %d (%v)", numConns, err)
+	}
+	t.Setenv("PLANNER_CASSANDRA_NUM_CONNS", "lots")
+	_, err = intSetting("PLANNER_CASSANDRA_NUM_CONNS", 2)
+	if err == nil {
+		t.Errorf("Expected an error for a non-numeric setting")
+	}
+
+	t.Setenv("PLANNER_CASSANDRA_TIMEOUT", "750ms")
+	timeout, err := durationSetting("PLANNER_CASSANDRA_TIMEOUT", time.Second)
+	if err != nil || timeout != 750*time.Millisecond {
+		t.Errorf("Expected 750ms, got %s (%v)", timeout, err)
+	}
+	t.Setenv("PLANNER_CASSANDRA_TIMEOUT", "-1s")
+	_, err = durationSetting("PLANNER_CASSANDRA_TIMEOUT", time.Second)
+	if err == nil {
+		t.Errorf("Expected an error for a negative timeout")
+	}
+}
+
+func TestMonthBucketsCoverTheRange(t *testing.T) {
+	weekly := monthBuckets(&DateRange{
+		Start: time.Date(2023, 4, 28, 0, 0, 0, 0, time.UTC),
+		End:   time.Date(2023, 5, 5, 0, 0, 0, 0, time.UTC),
+	})
+	if fmt.Sprint(weekly) != "[2023-04 2023-05]" {
+		t.Errorf("Expected April and May buckets, got %v", weekly)
+	}
+	// Buckets are UTC months, a local midnight may fall in the previous month
+	local := time.FixedZone("UTC+2", 2*60*60)
+	shifted := monthBuckets(&DateRange{
+		Start: time.Date(2023, 6, 1, 0, 0, 0, 0, local),
+		End:   time.Date(2023, 6, 2, 0, 0, 0, 0, local),
+	})
+	if fmt.Sprint(shifted) != "[2023-05 2023-06]" {
+		t.Errorf("Expected May and June buckets, got %v", shifted)
+	}
+	if monthBuckets(nil) != nil {
+		t.Errorf("Expected no buckets without a range")
+	}
+	wide := monthBuckets(&DateRange{
+		Start: time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC),
+		End:   time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC),
+	})
+	if wide != nil {
+		t.Errorf("Expected wide ranges to fall back, got %d buckets", len(wide))
+	}
+	if monthBucket(time.Date(2023, 12, 31, 23, 0, 0, 0, local)) != "2023-12" {
+		t.Errorf("Expected the UTC month of the activity")
+	}
+}
+
+func TestCassandraStagesOnlyDeletePositionsLeftOver(t *testing.T) {
+	previous := Activity{UserId: "some-user", Stages: []ActivityStage{
+		{Metrics: []ActivityStageMetric{{Amount: 1, Unit: "km"}, {Amount: 4, Unit: "min"}}},
+		{Metrics: []ActivityStageMetric{{Amount: 10, Unit: "min"}}},
+	}}
+	current := previous
+	current.Stages = []ActivityStage{{Metrics: []ActivityStageMetric{{Amount: 2, Unit: "km"}}}}
+	batch := &gocql.Batch{}
+	queueCassandraStages(batch, &previous, &current)
+	deletes := make([]string, 0)
+	inserts := 0
+	for _, entry := range batch.Entries {
+		if strings.Contains(entry.Stmt, "DELETE") {
+			deletes = append(deletes, fmt.Sprint(entry.Args[2:]))
+		} else {
+			inserts++
+		}
+	}
+	// Stages from position 1 on, their metrics, and the second metric of the
+	// first stage
+	if fmt.Sprint(deletes) != "[[1] [1] [0 1]]" || inserts != 2 {
+		t.Errorf("Unexpected stage writes, deletes %v and %d inserts", deletes, inserts)
+	}
+}
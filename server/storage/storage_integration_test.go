@@ -1,14 +1,46 @@
 package storage
 
 import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"os"
 	"testing"
 	"time"
 
+	"github.com/gocql/gocql"
 	"github.com/google/uuid"
 )
 
+// cassandraTestStorage connects to cassandra when PLANNER_TEST_CASSANDRA is
+// set, so the tests don't need a cluster to pass
+func cassandraTestStorage(t *testing.T) (Storage, bool) {
+	if os.Getenv("PLANNER_TEST_CASSANDRA") == "" {
+		return Storage{}, false
+	}
+	strg, err := getCassandratorageClient()
+	if err != nil {
+		t.Errorf("Error creating cassandra storage: %s", err.Error())
+		return Storage{}, false
+	}
+	return strg, true
+}
+
+// cassandraSession digs the session out of a Cassandra storage, for checking
+// tables no storage method reads directly
+func cassandraSession(strg Storage) (*gocql.Session, bool) {
+	activities := unaudited(strg).Activity
+	if kinded, ok := activities.(kindedActivityStorage); ok {
+		activities = kinded.ActivityStorage
+	}
+	cassandraActivities, ok := activities.(CassandraActivityStorage)
+	return cassandraActivities.Session, ok
+}
+
 func TestActivityCreateReadUpdateDelete(t *testing.T) {
+	ctx := context.Background()
 	var allStorages []ActivityStorage
 	sqliteStorage, sqliteErr := getSqliteStorageClient(":memory:")
 	if sqliteErr != nil {
@@ -16,15 +48,13 @@ func TestActivityCreateReadUpdateDelete(t *testing.T) {
 		return
 	}
 	allStorages = append(allStorages, sqliteStorage.Activity)
-	cassandraStorage, cassandraErr := getCassandratorageClient()
-	if cassandraErr != nil {
-		t.Errorf("Error creating cassandra storage: %s", cassandraErr.Error())
-	} else {
+	allStorages = append(allStorages, NewMemoryStorage().Activity)
+	if cassandraStorage, ok := cassandraTestStorage(t); ok {
 		allStorages = append(allStorages, cassandraStorage.Activity)
 	}
 	for _, storage := range allStorages {
 		userId := fmt.Sprintf("test-user-id-%s", uuid.New())
-		res, err := storage.Read(userId, uuid.New())
+		res, err := storage.Read(ctx, userId, uuid.New())
 		if err != nil {
 			t.Errorf("Error reading with empty uuid: %s", err.Error())
 			return
@@ -53,7 +83,7 @@ func TestActivityCreateReadUpdateDelete(t *testing.T) {
 			Completed:    false,
 			Notes:        "",
 		}
-		created, err := storage.Create(createActivity)
+		created, err := storage.Create(ctx, createActivity)
 		if err != nil {
 			t.Errorf("Error creating activity: %s", err.Error())
 			return
@@ -67,7 +97,7 @@ func TestActivityCreateReadUpdateDelete(t *testing.T) {
 			return
 		}
 
-		read, err := storage.Read(userId, created.Id)
+		read, err := storage.Read(ctx, userId, created.Id)
 		if err != nil {
 			t.Errorf("Error reading activity %s", err)
 			return
@@ -81,14 +111,14 @@ func TestActivityCreateReadUpdateDelete(t *testing.T) {
 
 		updateActivity.Summary = "Updated Activity Name"
 
-		updateErr := storage.Update(*updateActivity)
+		updateErr := storage.Update(ctx, *updateActivity)
 
 		if updateErr != nil {
 			t.Errorf("Error updating activity %s", updateErr)
 			return
 		}
 
-		reread, err := storage.Read(userId, created.Id)
+		reread, err := storage.Read(ctx, userId, created.Id)
 		if err != nil {
 			t.Errorf("Error reading activity %s", err)
 			return
@@ -98,7 +128,7 @@ func TestActivityCreateReadUpdateDelete(t *testing.T) {
 			return
 		}
 
-		query, err := storage.Query(ActivityStorageQuery{
+		query, err := storage.Query(ctx, ActivityStorageQuery{
 			UserId: read.UserId,
 		})
 
@@ -115,14 +145,14 @@ func TestActivityCreateReadUpdateDelete(t *testing.T) {
 			return
 		}
 
-		deleteErr := storage.Delete(userId, read.Id)
+		deleteErr := storage.Delete(ctx, userId, read.Id)
 
 		if deleteErr != nil {
 			t.Errorf("Error deleting activity %s", deleteErr)
 			return
 		}
 
-		rereread, err := storage.Read(userId, read.Id)
+		rereread, err := storage.Read(ctx, userId, read.Id)
 		if err != nil {
 			t.Errorf("Error rerereading activity %s", err)
 			return
@@ -135,6 +165,7 @@ func TestActivityCreateReadUpdateDelete(t *testing.T) {
 }
 
 func TestRecurringActivityCreateReadUpdateDelete(t *testing.T) {
+	ctx := context.Background()
 	var allStorages []RecurringActivityStorage
 	sqliteStorage, sqliteErr := getSqliteStorageClient(":memory:")
 	if sqliteErr != nil {
@@ -142,15 +173,13 @@ func TestRecurringActivityCreateReadUpdateDelete(t *testing.T) {
 		return
 	}
 	allStorages = append(allStorages, sqliteStorage.RecurringActivity)
-	cassandraStorage, cassandraErr := getCassandratorageClient()
-	if cassandraErr != nil {
-		t.Errorf("Error creating cassandra storage: %s", cassandraErr.Error())
-	} else {
+	allStorages = append(allStorages, NewMemoryStorage().RecurringActivity)
+	if cassandraStorage, ok := cassandraTestStorage(t); ok {
 		allStorages = append(allStorages, cassandraStorage.RecurringActivity)
 	}
 	for _, storage := range allStorages {
 		userId := fmt.Sprintf("test-user-id-%s", uuid.New())
-		res, err := storage.Read(userId, uuid.New())
+		res, err := storage.Read(ctx, userId, uuid.New())
 		if err != nil {
 			t.Errorf("Error reading with empty uuid: %s", err.Error())
 			return
@@ -177,7 +206,7 @@ func TestRecurringActivityCreateReadUpdateDelete(t *testing.T) {
 			DateTimeStart:  time.Now(),
 			TimeRelevant:   false,
 		}
-		created, err := storage.Create(createActivity)
+		created, err := storage.Create(ctx, createActivity)
 		if err != nil {
 			t.Errorf("Error creating recurring activity: %s", err.Error())
 			return
@@ -191,7 +220,7 @@ func TestRecurringActivityCreateReadUpdateDelete(t *testing.T) {
 			return
 		}
 
-		read, err := storage.Read(userId, created.Id)
+		read, err := storage.Read(ctx, userId, created.Id)
 		if err != nil {
 			t.Errorf("Error reading recurring activity %s", err)
 			return
@@ -205,14 +234,14 @@ func TestRecurringActivityCreateReadUpdateDelete(t *testing.T) {
 
 		updateActivity.Summary = "Updated recurring Activity Name"
 
-		updateErr := storage.Update(*updateActivity)
+		updateErr := storage.Update(ctx, *updateActivity)
 
 		if updateErr != nil {
 			t.Errorf("Error updating recurring activity %s", updateErr)
 			return
 		}
 
-		reread, err := storage.Read(userId, created.Id)
+		reread, err := storage.Read(ctx, userId, created.Id)
 		if err != nil {
 			t.Errorf("Error reading recurring activity %s", err)
 			return
@@ -222,7 +251,7 @@ func TestRecurringActivityCreateReadUpdateDelete(t *testing.T) {
 			return
 		}
 
-		query, err := storage.Query(RecurringActivityStorageQuery{
+		query, err := storage.Query(ctx, RecurringActivityStorageQuery{
 			UserId: read.UserId,
 		})
 
@@ -239,14 +268,14 @@ func TestRecurringActivityCreateReadUpdateDelete(t *testing.T) {
 			return
 		}
 
-		deleteErr := storage.Delete(userId, read.Id)
+		deleteErr := storage.Delete(ctx, userId, read.Id)
 
 		if deleteErr != nil {
 			t.Errorf("Error deleting recurring activity %s", deleteErr)
 			return
 		}
 
-		rereread, err := storage.Read(userId, read.Id)
+		rereread, err := storage.Read(ctx, userId, read.Id)
 		if err != nil {
 			t.Errorf("Error rerereading recurring activity %s", err)
 			return
@@ -259,6 +288,7 @@ func TestRecurringActivityCreateReadUpdateDelete(t *testing.T) {
 }
 
 func TestDeleteActivitiesForPlan(t *testing.T) {
+	ctx := context.Background()
 	var allStorages []ActivityStorage
 	sqliteStorage, sqliteErr := getSqliteStorageClient(":memory:")
 	if sqliteErr != nil {
@@ -266,17 +296,15 @@ func TestDeleteActivitiesForPlan(t *testing.T) {
 		return
 	}
 	allStorages = append(allStorages, sqliteStorage.Activity)
-	cassandraStorage, cassandraErr := getCassandratorageClient()
-	if cassandraErr != nil {
-		t.Errorf("Error creating cassandra storage: %s", cassandraErr.Error())
-	} else {
+	allStorages = append(allStorages, NewMemoryStorage().Activity)
+	if cassandraStorage, ok := cassandraTestStorage(t); ok {
 		allStorages = append(allStorages, cassandraStorage.Activity)
 	}
 	for _, storage := range allStorages {
 
 		userId := fmt.Sprintf("test-user-id-%s", uuid.New())
 		planId := uuid.New()
-		res, err := storage.Read(userId, uuid.New())
+		res, err := storage.Read(ctx, userId, uuid.New())
 		if err != nil {
 			t.Errorf("Error reading with empty uuid: %s", err.Error())
 			return
@@ -324,17 +352,17 @@ func TestDeleteActivitiesForPlan(t *testing.T) {
 			Completed:    false,
 			Notes:        "",
 		}
-		storage.Create(createActivityPlanless)
-		storage.Create(createActivityPlanned)
+		storage.Create(ctx, createActivityPlanless)
+		storage.Create(ctx, createActivityPlanned)
 
-		delErr := storage.DeleteForPlan(userId, planId)
+		delErr := storage.DeleteForPlan(ctx, userId, planId)
 
 		if delErr != nil {
 			t.Errorf("Error deleting planned: %s", delErr.Error())
 			return
 		}
 
-		allActivities, _ := storage.Query(ActivityStorageQuery{UserId: userId})
+		allActivities, _ := storage.Query(ctx, ActivityStorageQuery{UserId: userId})
 		if len(*allActivities) != 1 {
 
 			t.Errorf("Error expected 1 activities got: %d", len(*allActivities))
@@ -344,6 +372,7 @@ func TestDeleteActivitiesForPlan(t *testing.T) {
 }
 
 func TestActivityQuery(t *testing.T) {
+	ctx := context.Background()
 	var allStorages []ActivityStorage
 	sqliteStorage, sqliteErr := getSqliteStorageClient(":memory:")
 	if sqliteErr != nil {
@@ -351,15 +380,13 @@ func TestActivityQuery(t *testing.T) {
 		return
 	}
 	allStorages = append(allStorages, sqliteStorage.Activity)
-	cassandraStorage, cassandraErr := getCassandratorageClient()
-	if cassandraErr != nil {
-		t.Errorf("Error creating cassandra storage: %s", cassandraErr.Error())
-	} else {
+	allStorages = append(allStorages, NewMemoryStorage().Activity)
+	if cassandraStorage, ok := cassandraTestStorage(t); ok {
 		allStorages = append(allStorages, cassandraStorage.Activity)
 	}
 	for _, storage := range allStorages {
 		userId := fmt.Sprintf("test-user-id-%s", uuid.New())
-		res, err := storage.Read(userId, uuid.New())
+		res, err := storage.Read(ctx, userId, uuid.New())
 		if err != nil {
 			t.Errorf("Error reading with empty uuid: %s", err.Error())
 			return
@@ -397,11 +424,11 @@ func TestActivityQuery(t *testing.T) {
 			Completed:    false,
 			Notes:        "",
 		}
-		storage.Create(createStartPlan)
-		storage.Create(createMidPlan)
-		storage.Create(createLatePlan)
+		storage.Create(ctx, createStartPlan)
+		storage.Create(ctx, createMidPlan)
+		storage.Create(ctx, createLatePlan)
 
-		midActivity, _ := storage.Query(ActivityStorageQuery{
+		midActivity, _ := storage.Query(ctx, ActivityStorageQuery{
 			UserId: userId,
 			DateRange: &DateRange{
 				Start: time.Date(2011, 12, 13, 12, 12, 12, 12, time.UTC),
@@ -419,7 +446,228 @@ func TestActivityQuery(t *testing.T) {
 	}
 }
 
+func TestActivityQueryPagination(t *testing.T) {
+	ctx := context.Background()
+	var allStorages []ActivityStorage
+	sqliteStorage, sqliteErr := getSqliteStorageClient(":memory:")
+	if sqliteErr != nil {
+		t.Errorf("Error creating storage: %s", sqliteErr.Error())
+		return
+	}
+	allStorages = append(allStorages, sqliteStorage.Activity)
+	allStorages = append(allStorages, NewMemoryStorage().Activity)
+	if cassandraStorage, ok := cassandraTestStorage(t); ok {
+		allStorages = append(allStorages, cassandraStorage.Activity)
+	}
+	for _, storage := range allStorages {
+		userId := fmt.Sprintf("test-user-id-%s", uuid.New())
+		sharedTime := time.Date(2012, 12, 12, 12, 12, 12, 0, time.UTC)
+		for i := 0; i < 5; i++ {
+			dateTime := sharedTime
+			// Leave some activities on the same time so the id breaks the tie,
+			// and the rest in later months
+			if i > 2 {
+				dateTime = sharedTime.AddDate(0, i-2, 0)
+			}
+			storage.Create(ctx, Activity{
+				UserId:   userId,
+				Summary:  fmt.Sprintf("Activity %d", i),
+				Stages:   []ActivityStage{},
+				DateTime: dateTime,
+			})
+		}
+
+		for _, order := range []SortOrder{Ascending, Descending} {
+			all, _ := storage.Query(ctx, ActivityStorageQuery{UserId: userId, Order: order})
+			seen := make(map[uuid.UUID]bool)
+			var cursor *ActivityCursor
+			pages := 0
+			for {
+				page, err := storage.Query(ctx, ActivityStorageQuery{UserId: userId, Order: order, Limit: 2, Cursor: cursor})
+				if err != nil {
+					t.Errorf("Error querying page: %s", err.Error())
+					return
+				}
+				if len(*page) == 0 {
+					break
+				}
+				pages++
+				if len(*page) > 2 {
+					t.Errorf("Error expected at most 2 activities got: %d", len(*page))
+					return
+				}
+				for _, activity := range *page {
+					if seen[activity.Id] {
+						t.Errorf("Error activity %s returned twice", activity.Id)
+						return
+					}
+					if activity.Id != (*all)[len(seen)].Id {
+						t.Errorf("Error expected the %s pages in the order of the whole query", order)
+						return
+					}
+					seen[activity.Id] = true
+				}
+				next := CursorAfter((*page)[len(*page)-1])
+				cursor = &next
+			}
+			if len(seen) != 5 || pages != 3 {
+				t.Errorf("Error expected 5 activities over 3 pages got: %d over %d", len(seen), pages)
+				return
+			}
+		}
+	}
+}
+
+func TestActivityQueryFilters(t *testing.T) {
+	ctx := context.Background()
+	var allStorages []Storage
+	sqliteStorage, sqliteErr := getSqliteStorageClient(":memory:")
+	if sqliteErr != nil {
+		t.Errorf("Error creating storage: %s", sqliteErr.Error())
+		return
+	}
+	allStorages = append(allStorages, sqliteStorage)
+	allStorages = append(allStorages, NewMemoryStorage())
+	if cassandraStorage, ok := cassandraTestStorage(t); ok {
+		allStorages = append(allStorages, cassandraStorage)
+	}
+	for _, storage := range allStorages {
+		userId := fmt.Sprintf("test-user-id-%s", uuid.New())
+		activePlan, _ := storage.Plan.Create(ctx, Plan{UserId: userId, Name: "Active", Active: true})
+		inactivePlan, _ := storage.Plan.Create(ctx, Plan{UserId: userId, Name: "Inactive", Active: false})
+		recurringId := uuid.New()
+		baseTime := time.Date(2012, 12, 12, 12, 12, 12, 0, time.UTC)
+		storage.Activity.Create(ctx, Activity{UserId: userId, Summary: "No Plan", Stages: []ActivityStage{}, DateTime: baseTime})
+		storage.Activity.Create(ctx, Activity{UserId: userId, Summary: "Active Plan", PlanId: &activePlan.Id, Stages: []ActivityStage{}, DateTime: baseTime.Add(time.Hour), Completed: true})
+		storage.Activity.Create(ctx, Activity{UserId: userId, Summary: "Inactive Plan", PlanId: &inactivePlan.Id, Stages: []ActivityStage{}, DateTime: baseTime.Add(2 * time.Hour), RecurringActivityId: &recurringId})
+
+		completed := true
+		cases := []struct {
+			name     string
+			query    ActivityStorageQuery
+			expected []string
+		}{
+			{"all", ActivityStorageQuery{UserId: userId}, []string{"No Plan", "Active Plan", "Inactive Plan"}},
+			{"descending", ActivityStorageQuery{UserId: userId, Order: Descending}, []string{"Inactive Plan", "Active Plan", "No Plan"}},
+			{"no plan", ActivityStorageQuery{UserId: userId, NoPlan: true}, []string{"No Plan"}},
+			{"plan ids", ActivityStorageQuery{UserId: userId, PlanIds: []uuid.UUID{activePlan.Id, inactivePlan.Id}}, []string{"Active Plan", "Inactive Plan"}},
+			{"active plans", ActivityStorageQuery{UserId: userId, ActivePlansOnly: true}, []string{"Active Plan"}},
+			{"completed", ActivityStorageQuery{UserId: userId, Completed: &completed}, []string{"Active Plan"}},
+			{"recurring", ActivityStorageQuery{UserId: userId, RecurringActivityId: &recurringId}, []string{"Inactive Plan"}},
+		}
+		for _, c := range cases {
+			queried, err := storage.Activity.Query(ctx, c.query)
+			if err != nil {
+				t.Errorf("Error querying %s: %s", c.name, err.Error())
+				return
+			}
+			summaries := make([]string, 0)
+			for _, activity := range *queried {
+				summaries = append(summaries, activity.Summary)
+			}
+			if fmt.Sprint(summaries) != fmt.Sprint(c.expected) {
+				t.Errorf("Error querying %s expected %v got %v", c.name, c.expected, summaries)
+			}
+		}
+
+		descending, _ := storage.Activity.Query(ctx, ActivityStorageQuery{UserId: userId, Order: Descending, Limit: 1})
+		cursor := CursorAfter((*descending)[0])
+		next, _ := storage.Activity.Query(ctx, ActivityStorageQuery{UserId: userId, Order: Descending, Limit: 1, Cursor: &cursor})
+		if len(*next) != 1 || (*next)[0].Summary != "Active Plan" {
+			t.Errorf("Error expected descending page to continue with Active Plan")
+		}
+	}
+}
+
+func TestActivitySearch(t *testing.T) {
+	ctx := context.Background()
+	var allStorages []ActivityStorage
+	sqliteStorage, sqliteErr := getSqliteStorageClient(":memory:")
+	if sqliteErr != nil {
+		t.Errorf("Error creating storage: %s", sqliteErr.Error())
+		return
+	}
+	allStorages = append(allStorages, sqliteStorage.Activity)
+	allStorages = append(allStorages, NewMemoryStorage().Activity)
+	if cassandraStorage, ok := cassandraTestStorage(t); ok {
+		allStorages = append(allStorages, cassandraStorage.Activity)
+	}
+	for _, storage := range allStorages {
+		userId := fmt.Sprintf("test-user-id-%s", uuid.New())
+		otherUserId := fmt.Sprintf("test-user-id-%s", uuid.New())
+		baseTime := time.Date(2012, 12, 12, 12, 12, 12, 0, time.UTC)
+		storage.Create(ctx, Activity{UserId: userId, Summary: "Easy run", Notes: "knee hurt near the end", Stages: []ActivityStage{}, DateTime: baseTime})
+		storage.Create(ctx, Activity{UserId: userId, Summary: "Tempo run", Notes: "felt strong", Stages: []ActivityStage{}, DateTime: baseTime.Add(time.Hour)})
+		storage.Create(ctx, Activity{UserId: userId, Summary: "Intervals", Stages: []ActivityStage{{Description: "Tempo warm up"}}, DateTime: baseTime.Add(2 * time.Hour)})
+		storage.Create(ctx, Activity{UserId: otherUserId, Summary: "Tempo run", Notes: "knee hurt", Stages: []ActivityStage{}, DateTime: baseTime})
+		updated, _ := storage.Create(ctx, Activity{UserId: userId, Summary: "Tempo swim", Stages: []ActivityStage{}, DateTime: baseTime})
+		updated.Summary = "Recovery swim"
+		storage.Update(ctx, updated)
+
+		cases := []struct {
+			text     string
+			expected []string
+		}{
+			{"tempo", []string{"Tempo run", "Intervals"}},
+			{"KNEE!", []string{"Easy run"}},
+			{"cycling", []string{}},
+			{"swim", []string{"Recovery swim"}},
+		}
+		for _, c := range cases {
+			found, err := storage.Search(ctx, ActivitySearchQuery{UserId: userId, Text: c.text, Limit: 10})
+			if err != nil {
+				t.Errorf("Error searching %q: %s", c.text, err.Error())
+				return
+			}
+			summaries := make([]string, 0)
+			for _, activity := range *found {
+				summaries = append(summaries, activity.Summary)
+			}
+			if fmt.Sprint(summaries) != fmt.Sprint(c.expected) {
+				t.Errorf("Error searching %q expected %v got %v", c.text, c.expected, summaries)
+			}
+		}
+
+		// Partial matches rank by their weights on every backend
+		found, _ := storage.Search(ctx, ActivitySearchQuery{UserId: userId, Text: "that tempo run where my knee hurt", Limit: 10})
+		summaries := make([]string, 0)
+		for _, activity := range *found {
+			summaries = append(summaries, activity.Summary)
+		}
+		if fmt.Sprint(summaries) != fmt.Sprint([]string{"Tempo run", "Easy run", "Intervals"}) {
+			t.Errorf("Error expected the partial matches ranked by weight got: %v", summaries)
+		}
+
+		// The limit applies after ranking, equal scores go to the most recent
+		limited, _ := storage.Search(ctx, ActivitySearchQuery{UserId: userId, Text: "run", Limit: 1})
+		if len(*limited) != 1 || (*limited)[0].Summary != "Tempo run" {
+			t.Errorf("Error expected only Tempo run got: %v", limited)
+		}
+	}
+}
+
+func TestActivityCursorRoundTrip(t *testing.T) {
+	cursor := ActivityCursor{
+		DateTime: time.Date(2012, 12, 12, 12, 12, 12, 12, time.UTC),
+		Id:       uuid.New(),
+	}
+	parsed, err := ParseActivityCursor(cursor.Encode())
+	if err != nil {
+		t.Errorf("Error parsing cursor: %s", err.Error())
+		return
+	}
+	if !parsed.DateTime.Equal(cursor.DateTime) || parsed.Id != cursor.Id {
+		t.Errorf("Error expected %v got %v", cursor, parsed)
+	}
+	for _, bad := range []string{"not base64!", "bm8tc2VwYXJhdG9y", ""} {
+		if _, err := ParseActivityCursor(bad); err != ErrInvalidCursor {
+			t.Errorf("Error expected invalid cursor for %q", bad)
+		}
+	}
+}
+
 func TestPlanCreateReadUpdateDelete(t *testing.T) {
+	ctx := context.Background()
 	var allStorages []PlanStorage
 	sqliteStorage, sqliteErr := getSqliteStorageClient(":memory:")
 	if sqliteErr != nil {
@@ -427,15 +675,13 @@ func TestPlanCreateReadUpdateDelete(t *testing.T) {
 		return
 	}
 	allStorages = append(allStorages, sqliteStorage.Plan)
-	cassandraStorage, cassandraErr := getCassandratorageClient()
-	if cassandraErr != nil {
-		t.Errorf("Error creating cassandra storage: %s", cassandraErr.Error())
-	} else {
+	allStorages = append(allStorages, NewMemoryStorage().Plan)
+	if cassandraStorage, ok := cassandraTestStorage(t); ok {
 		allStorages = append(allStorages, cassandraStorage.Plan)
 	}
 	for _, storage := range allStorages {
 		userId := fmt.Sprintf("test-user-id-%s", uuid.New())
-		res, err := storage.Read(userId, uuid.New())
+		res, err := storage.Read(ctx, userId, uuid.New())
 		if err != nil {
 			t.Errorf("Error reading with empty uuid: %s", err.Error())
 			return
@@ -449,7 +695,7 @@ func TestPlanCreateReadUpdateDelete(t *testing.T) {
 			Name:   "Test Plan",
 			Active: true,
 		}
-		created, err := storage.Create(createPlan)
+		created, err := storage.Create(ctx, createPlan)
 		if err != nil {
 			t.Errorf("Error creating plan %s", err)
 			return
@@ -463,7 +709,7 @@ func TestPlanCreateReadUpdateDelete(t *testing.T) {
 			return
 		}
 
-		read, err := storage.Read(userId, created.Id)
+		read, err := storage.Read(ctx, userId, created.Id)
 		if err != nil {
 			t.Errorf("Error reading plan %s", err)
 			return
@@ -473,14 +719,14 @@ func TestPlanCreateReadUpdateDelete(t *testing.T) {
 
 		updatePlan.Name = "Updated plan Name"
 
-		updateErr := storage.Update(*updatePlan)
+		updateErr := storage.Update(ctx, *updatePlan)
 
 		if updateErr != nil {
 			t.Errorf("Error updating plan %s", updateErr)
 			return
 		}
 
-		reread, err := storage.Read(userId, created.Id)
+		reread, err := storage.Read(ctx, userId, created.Id)
 		if err != nil {
 			t.Errorf("Error reading plan %s", err)
 			return
@@ -490,7 +736,7 @@ func TestPlanCreateReadUpdateDelete(t *testing.T) {
 			return
 		}
 
-		query, err := storage.Query(PlanStorageQuery{
+		query, err := storage.Query(ctx, PlanStorageQuery{
 			UserId: read.UserId,
 		})
 
@@ -507,14 +753,14 @@ func TestPlanCreateReadUpdateDelete(t *testing.T) {
 			return
 		}
 
-		deleteErr := storage.Delete(userId, read.Id)
+		deleteErr := storage.Delete(ctx, userId, read.Id)
 
 		if deleteErr != nil {
 			t.Errorf("Error deleting plan %s", deleteErr)
 			return
 		}
 
-		rereread, err := storage.Read(userId, read.Id)
+		rereread, err := storage.Read(ctx, userId, read.Id)
 		if err != nil {
 			t.Errorf("Error rerereading plan %s", err)
 			return
@@ -526,3 +772,795 @@ func TestPlanCreateReadUpdateDelete(t *testing.T) {
 	}
 
 }
+
+func TestTrashedItemsAreExcluded(t *testing.T) {
+	ctx := context.Background()
+	var allStorages []Storage
+	sqliteStorage, sqliteErr := getSqliteStorageClient(":memory:")
+	if sqliteErr != nil {
+		t.Errorf("Error creating storage: %s", sqliteErr.Error())
+		return
+	}
+	allStorages = append(allStorages, sqliteStorage)
+	allStorages = append(allStorages, NewMemoryStorage())
+	if cassandraStorage, ok := cassandraTestStorage(t); ok {
+		allStorages = append(allStorages, cassandraStorage)
+	}
+	for _, storage := range allStorages {
+		userId := fmt.Sprintf("test-user-id-%s", uuid.New())
+		deletedAt := time.Date(2023, 6, 1, 12, 0, 0, 0, time.UTC)
+		plan, err := storage.Plan.Create(ctx, Plan{UserId: userId, Name: "Trashed Plan"})
+		if err != nil {
+			t.Errorf("Error creating plan %s", err)
+			return
+		}
+		activity, err := storage.Activity.Create(ctx, Activity{UserId: userId, PlanId: &plan.Id, Summary: "Trashed tempo run", Stages: []ActivityStage{}, DateTime: time.Date(2023, 5, 1, 10, 0, 0, 0, time.UTC)})
+		if err != nil {
+			t.Errorf("Error creating activity %s", err)
+			return
+		}
+		recurring, err := storage.RecurringActivity.Create(ctx, RecurringActivity{UserId: userId, PlanId: &plan.Id, Summary: "Weekly", Stages: []ActivityStage{}, RecurrEachDays: 7})
+		if err != nil {
+			t.Errorf("Error creating recurring activity %s", err)
+			return
+		}
+
+		plan.DeletedAt = &deletedAt
+		activity.DeletedAt = &deletedAt
+		recurring.DeletedAt = &deletedAt
+		err = storage.Plan.Update(ctx, plan)
+		if err == nil {
+			err = storage.Activity.Update(ctx, activity)
+		}
+		if err == nil {
+			err = storage.RecurringActivity.Update(ctx, recurring)
+		}
+		if err != nil {
+			t.Errorf("Error trashing %s", err)
+			return
+		}
+
+		readActivity, err := storage.Activity.Read(ctx, userId, activity.Id)
+		if err != nil || readActivity == nil || readActivity.DeletedAt == nil || !readActivity.DeletedAt.Equal(deletedAt) {
+			t.Errorf("Trashed activity should still be readable with its deletedAt, got %+v %s", readActivity, err)
+			return
+		}
+		readPlan, err := storage.Plan.Read(ctx, userId, plan.Id)
+		if err != nil || readPlan == nil || readPlan.DeletedAt == nil || !readPlan.DeletedAt.Equal(deletedAt) {
+			t.Errorf("Trashed plan should still be readable with its deletedAt, got %+v %s", readPlan, err)
+			return
+		}
+
+		activities, _ := storage.Activity.Query(ctx, ActivityStorageQuery{UserId: userId})
+		ranged, _ := storage.Activity.Query(ctx, ActivityStorageQuery{UserId: userId, DateRange: &DateRange{
+			Start: time.Date(2023, 4, 1, 0, 0, 0, 0, time.UTC),
+			End:   time.Date(2023, 6, 1, 0, 0, 0, 0, time.UTC),
+		}})
+		found, _ := storage.Activity.Search(ctx, ActivitySearchQuery{UserId: userId, Text: "tempo"})
+		plans, _ := storage.Plan.Query(ctx, PlanStorageQuery{UserId: userId})
+		recurrings, _ := storage.RecurringActivity.Query(ctx, RecurringActivityStorageQuery{UserId: userId})
+		if len(*activities) != 0 || len(*ranged) != 0 || len(*found) != 0 || len(*plans) != 0 || len(*recurrings) != 0 {
+			t.Errorf("Trashed items returned by queries: %d activities, %d in range, %d found, %d plans, %d recurring",
+				len(*activities), len(*ranged), len(*found), len(*plans), len(*recurrings))
+			return
+		}
+
+		activities, _ = storage.Activity.Query(ctx, ActivityStorageQuery{UserId: userId, Trashed: true})
+		plans, _ = storage.Plan.Query(ctx, PlanStorageQuery{UserId: userId, Trashed: true})
+		recurrings, _ = storage.RecurringActivity.Query(ctx, RecurringActivityStorageQuery{UserId: userId, Trashed: true})
+		if len(*activities) != 1 || len(*plans) != 1 || len(*recurrings) != 1 {
+			t.Errorf("Expected one trashed item of each kind, got %d activities, %d plans, %d recurring",
+				len(*activities), len(*plans), len(*recurrings))
+			return
+		}
+
+		// Trashing stored the next version
+		activity.Version++
+		activity.DeletedAt = nil
+		err = storage.Activity.Update(ctx, activity)
+		if err != nil {
+			t.Errorf("Error restoring activity %s", err)
+			return
+		}
+		ranged, _ = storage.Activity.Query(ctx, ActivityStorageQuery{UserId: userId, DateRange: &DateRange{
+			Start: time.Date(2023, 4, 1, 0, 0, 0, 0, time.UTC),
+			End:   time.Date(2023, 6, 1, 0, 0, 0, 0, time.UTC),
+		}})
+		found, _ = storage.Activity.Search(ctx, ActivitySearchQuery{UserId: userId, Text: "tempo"})
+		if len(*ranged) != 1 || len(*found) != 1 {
+			t.Errorf("Restored activity missing, got %d in range and %d found", len(*ranged), len(*found))
+			return
+		}
+	}
+}
+
+func TestArchivedPlansAreExcluded(t *testing.T) {
+	ctx := context.Background()
+	var allStorages []PlanStorage
+	sqliteStorage, sqliteErr := getSqliteStorageClient(":memory:")
+	if sqliteErr != nil {
+		t.Errorf("Error creating storage: %s", sqliteErr.Error())
+		return
+	}
+	allStorages = append(allStorages, sqliteStorage.Plan)
+	allStorages = append(allStorages, NewMemoryStorage().Plan)
+	if cassandraStorage, ok := cassandraTestStorage(t); ok {
+		allStorages = append(allStorages, cassandraStorage.Plan)
+	}
+	for _, storage := range allStorages {
+		userId := fmt.Sprintf("test-user-id-%s", uuid.New())
+		archivedAt := time.Date(2023, 6, 1, 12, 0, 0, 0, time.UTC)
+		archived, err := storage.Create(ctx, Plan{UserId: userId, Name: "Archived", ArchivedAt: &archivedAt})
+		if err != nil {
+			t.Errorf("Error creating plan %s", err)
+			return
+		}
+		_, err = storage.Create(ctx, Plan{UserId: userId, Name: "Current", Active: true})
+		if err != nil {
+			t.Errorf("Error creating plan %s", err)
+			return
+		}
+		plans, _ := storage.Query(ctx, PlanStorageQuery{UserId: userId})
+		if len(*plans) != 1 || (*plans)[0].Name != "Current" {
+			t.Errorf("Expected only the current plan, got %+v", *plans)
+			return
+		}
+		plans, _ = storage.Query(ctx, PlanStorageQuery{UserId: userId, IncludeArchived: true})
+		if len(*plans) != 2 {
+			t.Errorf("Expected both plans with archived included, got %d", len(*plans))
+			return
+		}
+		read, err := storage.Read(ctx, userId, archived.Id)
+		if err != nil || read == nil || read.ArchivedAt == nil || !read.ArchivedAt.Equal(archivedAt) {
+			t.Errorf("Archived plan read back wrong: %+v %s", read, err)
+			return
+		}
+		read.ArchivedAt = nil
+		err = storage.Update(ctx, *read)
+		if err != nil {
+			t.Errorf("Error unarchiving plan %s", err)
+			return
+		}
+		plans, _ = storage.Query(ctx, PlanStorageQuery{UserId: userId})
+		if len(*plans) != 2 {
+			t.Errorf("Expected the unarchived plan back, got %d plans", len(*plans))
+			return
+		}
+	}
+}
+
+func TestPurgeUser(t *testing.T) {
+	ctx := context.Background()
+	var allStorages []Storage
+	sqliteStorage, sqliteErr := getSqliteStorageClient(":memory:")
+	if sqliteErr != nil {
+		t.Errorf("Error creating storage: %s", sqliteErr.Error())
+		return
+	}
+	allStorages = append(allStorages, sqliteStorage)
+	allStorages = append(allStorages, NewMemoryStorage())
+	if cassandraStorage, ok := cassandraTestStorage(t); ok {
+		allStorages = append(allStorages, cassandraStorage)
+	}
+	for _, storage := range allStorages {
+		userId := fmt.Sprintf("test-user-id-%s", uuid.New())
+		otherUserId := fmt.Sprintf("test-user-id-%s", uuid.New())
+		deletedAt := time.Date(2023, 6, 1, 12, 0, 0, 0, time.UTC)
+		plan, err := storage.Plan.Create(ctx, Plan{UserId: userId, Name: "Test Plan"})
+		if err != nil {
+			t.Errorf("Error creating plan %s", err)
+			return
+		}
+		storage.Plan.Create(ctx, Plan{UserId: userId, Name: "Trashed Plan", DeletedAt: &deletedAt})
+		storage.Activity.Create(ctx, Activity{UserId: userId, PlanId: &plan.Id, Summary: "Planned", Stages: []ActivityStage{}, DateTime: time.Date(2023, 5, 1, 10, 0, 0, 0, time.UTC)})
+		storage.Activity.Create(ctx, Activity{UserId: userId, Summary: "Trashed", Stages: []ActivityStage{}, DeletedAt: &deletedAt, DateTime: time.Date(2023, 5, 2, 10, 0, 0, 0, time.UTC)})
+		storage.RecurringActivity.Create(ctx, RecurringActivity{UserId: userId, PlanId: &plan.Id, Summary: "Weekly", Stages: []ActivityStage{}, RecurrEachDays: 7})
+		otherPlan, _ := storage.Plan.Create(ctx, Plan{UserId: otherUserId, Name: "Other Plan"})
+		storage.Activity.Create(ctx, Activity{UserId: otherUserId, PlanId: &otherPlan.Id, Summary: "Planned", Stages: []ActivityStage{}, DateTime: time.Date(2023, 5, 1, 10, 0, 0, 0, time.UTC)})
+
+		summary, err := PurgeUser(ctx, storage, userId)
+		if err != nil {
+			t.Errorf("Error purging user %s", err)
+			return
+		}
+		if summary != (PurgeSummary{Plans: 2, Activities: 2, RecurringActivities: 1}) {
+			t.Errorf("Unexpected purge summary %+v", summary)
+		}
+		remaining, _ := CountUserData(ctx, storage, userId)
+		if remaining != (PurgeSummary{}) {
+			t.Errorf("Expected no data left for the user, got %+v", remaining)
+		}
+		others, _ := CountUserData(ctx, storage, otherUserId)
+		if others != (PurgeSummary{Plans: 1, Activities: 1}) {
+			t.Errorf("Expected other users data untouched, got %+v", others)
+		}
+		searched, _ := storage.Activity.Search(ctx, ActivitySearchQuery{UserId: userId, Text: "Planned"})
+		if searched != nil && len(*searched) != 0 {
+			t.Errorf("Expected purged activities to be gone from search, got %d", len(*searched))
+		}
+		if session, ok := cassandraSession(storage); ok {
+			months, _ := cassandraPageBuckets(ctx, session, ActivityStorageQuery{UserId: userId})
+			if len(months) != 0 {
+				t.Errorf("Expected the purged user's months gone, got %v", months)
+			}
+		}
+	}
+}
+
+func TestArchiveRoundTrip(t *testing.T) {
+	ctx := context.Background()
+	var allStorages []Storage
+	sqliteStorage, sqliteErr := getSqliteStorageClient(":memory:")
+	if sqliteErr != nil {
+		t.Errorf("Error creating storage: %s", sqliteErr.Error())
+		return
+	}
+	allStorages = append(allStorages, sqliteStorage)
+	allStorages = append(allStorages, NewMemoryStorage())
+	if cassandraStorage, ok := cassandraTestStorage(t); ok {
+		allStorages = append(allStorages, cassandraStorage)
+	}
+	for _, storage := range allStorages {
+		userId := fmt.Sprintf("test-user-id-%s", uuid.New())
+		importUserId := fmt.Sprintf("test-user-id-%s", uuid.New())
+		plan, err := storage.Plan.Create(ctx, Plan{UserId: userId, Name: "Test Plan"})
+		if err != nil {
+			t.Errorf("Error creating plan %s", err)
+			return
+		}
+		recurring, _ := storage.RecurringActivity.Create(ctx, RecurringActivity{UserId: userId, PlanId: &plan.Id, Summary: "Weekly", Stages: []ActivityStage{}, RecurrEachDays: 7})
+		// Enough activities to need more than one page
+		start := time.Date(2023, 5, 1, 10, 0, 0, 0, time.UTC)
+		for i := 0; i <= ARCHIVE_PAGE_SIZE; i++ {
+			storage.Activity.Create(ctx, Activity{UserId: userId, PlanId: &plan.Id, RecurringActivityId: &recurring.Id, Summary: "Planned", Stages: []ActivityStage{}, DateTime: start.Add(time.Duration(i) * time.Hour)})
+		}
+
+		archive, err := LoadArchive(ctx, storage, userId)
+		if err != nil {
+			t.Errorf("Error loading archive %s", err)
+			return
+		}
+		var buffer bytes.Buffer
+		err = StreamArchive(ctx, storage, userId, archive, &buffer)
+		if err != nil {
+			t.Errorf("Error streaming archive %s", err)
+			return
+		}
+		var decoded Archive
+		err = json.Unmarshal(buffer.Bytes(), &decoded)
+		if err != nil {
+			t.Errorf("Error decoding archive %s", err)
+			return
+		}
+		if len(decoded.Plans) != 1 || len(decoded.RecurringActivities) != 1 || len(decoded.Activities) != ARCHIVE_PAGE_SIZE+1 {
+			t.Errorf("Unexpected archive contents %d plans, %d recurring, %d activities", len(decoded.Plans), len(decoded.RecurringActivities), len(decoded.Activities))
+			return
+		}
+
+		_, err = ImportArchive(ctx, storage, importUserId, decoded, ImportMerge)
+		if err != nil {
+			t.Errorf("Error importing archive %s", err)
+			return
+		}
+		imported, _ := LoadArchive(ctx, storage, importUserId)
+		if len(imported.Plans) != 1 || imported.Plans[0].Id == plan.Id || len(imported.RecurringActivities) != 1 {
+			t.Errorf("Expected the plan and recurring activity under new ids, got %+v", imported)
+			return
+		}
+		importedPlanId := imported.Plans[0].Id
+		if imported.RecurringActivities[0].PlanId == nil || *imported.RecurringActivities[0].PlanId != importedPlanId {
+			t.Errorf("Expected the recurring activity linked to the imported plan")
+		}
+		activities, _ := storage.Activity.Query(ctx, ActivityStorageQuery{UserId: importUserId, PlanId: &importedPlanId})
+		if len(*activities) != ARCHIVE_PAGE_SIZE+1 {
+			t.Errorf("Expected %d imported activities, got %d", ARCHIVE_PAGE_SIZE+1, len(*activities))
+			return
+		}
+		if *(*activities)[0].RecurringActivityId != imported.RecurringActivities[0].Id {
+			t.Errorf("Expected activities linked to the imported recurring activity")
+		}
+
+		// Importing again rewrites the same items, which is how a failed import
+		// is resumed
+		_, err = ImportArchive(ctx, storage, importUserId, decoded, ImportMerge)
+		if err != nil {
+			t.Errorf("Error importing archive again %s", err)
+			return
+		}
+		reimported, _ := CountUserData(ctx, storage, importUserId)
+		if reimported != (PurgeSummary{Plans: 1, Activities: ARCHIVE_PAGE_SIZE + 1, RecurringActivities: 1}) {
+			t.Errorf("Expected importing again to leave the same items, got %+v", reimported)
+		}
+	}
+}
+
+func TestUpdateVersionConflict(t *testing.T) {
+	ctx := context.Background()
+	var allStorages []Storage
+	sqliteStorage, sqliteErr := getSqliteStorageClient(":memory:")
+	if sqliteErr != nil {
+		t.Errorf("Error creating storage: %s", sqliteErr.Error())
+		return
+	}
+	allStorages = append(allStorages, sqliteStorage)
+	allStorages = append(allStorages, NewMemoryStorage())
+	if cassandraStorage, ok := cassandraTestStorage(t); ok {
+		allStorages = append(allStorages, cassandraStorage)
+	}
+	for _, storage := range allStorages {
+		userId := fmt.Sprintf("test-user-id-%s", uuid.New())
+		plan, err := storage.Plan.Create(ctx, Plan{UserId: userId, Name: "Test Plan"})
+		if err != nil {
+			t.Errorf("Error creating plan %s", err)
+			return
+		}
+		activity, _ := storage.Activity.Create(ctx, Activity{UserId: userId, PlanId: &plan.Id, Summary: "Planned", Stages: []ActivityStage{}, DateTime: time.Date(2023, 5, 1, 10, 0, 0, 0, time.UTC)})
+		recurring, _ := storage.RecurringActivity.Create(ctx, RecurringActivity{UserId: userId, Summary: "Weekly", Stages: []ActivityStage{}, RecurrEachDays: 7})
+		if plan.Version != 1 || activity.Version != 1 || recurring.Version != 1 || plan.UpdatedAt.IsZero() {
+			t.Errorf("Expected new items at version 1, got %d %d %d", plan.Version, activity.Version, recurring.Version)
+			return
+		}
+
+		plan.Name = "Renamed"
+		activity.Summary = "Changed"
+		recurring.Summary = "Changed"
+		if storage.Plan.Update(ctx, plan) != nil || storage.Activity.Update(ctx, activity) != nil || storage.RecurringActivity.Update(ctx, recurring) != nil {
+			t.Errorf("Error updating the current versions")
+			return
+		}
+		// Writing the same, now stale, versions again must not overwrite
+		plan.Name = "Stale"
+		activity.Summary = "Stale"
+		recurring.Summary = "Stale"
+		if !errors.Is(storage.Plan.Update(ctx, plan), ErrVersionConflict) ||
+			!errors.Is(storage.Activity.Update(ctx, activity), ErrVersionConflict) ||
+			!errors.Is(storage.RecurringActivity.Update(ctx, recurring), ErrVersionConflict) {
+			t.Errorf("Expected stale updates to conflict")
+			return
+		}
+
+		readPlan, _ := storage.Plan.Read(ctx, userId, plan.Id)
+		readActivity, _ := storage.Activity.Read(ctx, userId, activity.Id)
+		readRecurring, _ := storage.RecurringActivity.Read(ctx, userId, recurring.Id)
+		if readPlan.Name != "Renamed" || readPlan.Version != 2 || readActivity.Summary != "Changed" || readActivity.Version != 2 || readRecurring.Version != 2 {
+			t.Errorf("Unexpected stored items %+v %+v %+v", readPlan, readActivity, readRecurring)
+		}
+		if readPlan.UpdatedAt.Before(plan.UpdatedAt) {
+			t.Errorf("Expected updatedAt to move forward")
+		}
+		err = storage.Plan.Update(ctx, Plan{Id: uuid.New(), UserId: userId, Version: 1})
+		if !errors.Is(err, ErrNotFound) {
+			t.Errorf("Expected updating a missing plan to be not found, got %v", err)
+		}
+	}
+}
+
+func TestUnitOfWorkCommitsAndRollsBack(t *testing.T) {
+	ctx := context.Background()
+	var allStorages []Storage
+	sqliteStorage, sqliteErr := getSqliteStorageClient(":memory:")
+	if sqliteErr != nil {
+		t.Errorf("Error creating storage: %s", sqliteErr.Error())
+		return
+	}
+	allStorages = append(allStorages, sqliteStorage)
+	allStorages = append(allStorages, NewMemoryStorage())
+	for _, storage := range allStorages {
+		userId := fmt.Sprintf("test-user-id-%s", uuid.New())
+		plan, err := storage.Plan.Create(ctx, Plan{UserId: userId, Name: "Test Plan"})
+		if err != nil {
+			t.Errorf("Error creating plan %s", err)
+			return
+		}
+		_, err = storage.Activity.Create(ctx, Activity{UserId: userId, PlanId: &plan.Id, Summary: "Planned", Stages: []ActivityStage{}})
+		if err != nil {
+			t.Errorf("Error creating activity %s", err)
+			return
+		}
+
+		rollbackErr := storage.UnitOfWork.Do(ctx, func(tx Storage) error {
+			err := tx.Plan.Delete(ctx, userId, plan.Id)
+			if err != nil {
+				return err
+			}
+			err = tx.Activity.DeleteForPlan(ctx, userId, plan.Id)
+			if err != nil {
+				return err
+			}
+			return fmt.Errorf("simulated failure")
+		})
+		if rollbackErr == nil {
+			t.Errorf("Expected the unit of work error to be returned")
+			return
+		}
+		stillThere, err := storage.Plan.Read(ctx, userId, plan.Id)
+		if err != nil || stillThere == nil {
+			t.Errorf("Plan delete was not rolled back")
+			return
+		}
+		activities, _ := storage.Activity.Query(ctx, ActivityStorageQuery{UserId: userId})
+		if len(*activities) != 1 {
+			t.Errorf("Activity delete was not rolled back, got %d activities", len(*activities))
+			return
+		}
+
+		commitErr := storage.UnitOfWork.Do(ctx, func(tx Storage) error {
+			err := tx.Plan.Delete(ctx, userId, plan.Id)
+			if err != nil {
+				return err
+			}
+			return tx.Activity.DeleteForPlan(ctx, userId, plan.Id)
+		})
+		if commitErr != nil {
+			t.Errorf("Error committing unit of work %s", commitErr)
+			return
+		}
+		gone, _ := storage.Plan.Read(ctx, userId, plan.Id)
+		if gone != nil {
+			t.Errorf("Plan delete was not committed")
+			return
+		}
+		activities, _ = storage.Activity.Query(ctx, ActivityStorageQuery{UserId: userId})
+		if len(*activities) != 0 {
+			t.Errorf("Activity delete was not committed, got %d activities", len(*activities))
+			return
+		}
+	}
+}
+
+func TestCancelledContextIsRespected(t *testing.T) {
+	sqliteStorage, sqliteErr := getSqliteStorageClient(":memory:")
+	if sqliteErr != nil {
+		t.Errorf("Error creating storage: %s", sqliteErr.Error())
+		return
+	}
+	for _, storage := range []Storage{sqliteStorage, NewMemoryStorage()} {
+		ctx := canceledContext()
+		userId := fmt.Sprintf("test-user-id-%s", uuid.New())
+		err := storage.UnitOfWork.Do(ctx, func(tx Storage) error {
+			_, err := tx.Plan.Create(ctx, Plan{UserId: userId, Name: "Cancelled"})
+			return err
+		})
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("Error expected context.Canceled got: %v", err)
+		}
+		plans, _ := storage.Plan.Query(context.Background(), PlanStorageQuery{UserId: userId})
+		if len(*plans) != 0 {
+			t.Errorf("Error expected the cancelled unit of work not to commit")
+		}
+	}
+	_, err := sqliteStorage.Activity.Query(canceledContext(), ActivityStorageQuery{UserId: "user"})
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("Error expected context.Canceled got: %v", err)
+	}
+}
+
+func canceledContext() context.Context {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	return ctx
+}
+
+func TestCloseReleasesStorage(t *testing.T) {
+	sqliteStorage, sqliteErr := getSqliteStorageClient(":memory:")
+	if sqliteErr != nil {
+		t.Errorf("Error creating storage: %s", sqliteErr.Error())
+		return
+	}
+	err := sqliteStorage.Close()
+	if err != nil {
+		t.Errorf("Error closing storage: %s", err.Error())
+	}
+	_, err = sqliteStorage.Plan.Query(context.Background(), PlanStorageQuery{UserId: "user"})
+	if err == nil {
+		t.Errorf("Error expected queries on a closed storage to fail")
+	}
+	err = NewMemoryStorage().Close()
+	if err != nil {
+		t.Errorf("Error closing memory storage: %s", err.Error())
+	}
+}
+
+func TestAuditLog(t *testing.T) {
+	ctx := context.Background()
+	var allStorages []Storage
+	sqliteStorage, sqliteErr := getSqliteStorageClient(":memory:")
+	if sqliteErr != nil {
+		t.Errorf("Error creating storage: %s", sqliteErr.Error())
+		return
+	}
+	allStorages = append(allStorages, sqliteStorage)
+	allStorages = append(allStorages, NewMemoryStorage())
+	if cassandraStorage, ok := cassandraTestStorage(t); ok {
+		allStorages = append(allStorages, cassandraStorage)
+	}
+	for _, storage := range allStorages {
+		userId := fmt.Sprintf("test-user-id-%s", uuid.New())
+		plan, err := storage.Plan.Create(ctx, Plan{UserId: userId, Name: "Test Plan"})
+		if err != nil {
+			t.Errorf("Error creating plan %s", err)
+			return
+		}
+		activity, _ := storage.Activity.Create(ctx, Activity{UserId: userId, PlanId: &plan.Id, Summary: "Planned", Stages: []ActivityStage{}, DateTime: time.Date(2023, 5, 1, 10, 0, 0, 0, time.UTC)})
+		recurring, _ := storage.RecurringActivity.Create(ctx, RecurringActivity{UserId: userId, PlanId: &plan.Id, Summary: "Weekly", Stages: []ActivityStage{}, RecurrEachDays: 7})
+		activity.Summary = "Changed"
+		err = storage.Activity.Update(ctx, activity)
+		if err != nil {
+			t.Errorf("Error updating activity %s", err)
+			return
+		}
+		updated, err := storage.Activity.Read(ctx, userId, activity.Id)
+		if err != nil || updated == nil {
+			t.Errorf("Error reading activity %s", err)
+			return
+		}
+		// Failed updates are not recorded
+		storage.Activity.Update(ctx, activity)
+		err = storage.UnitOfWork.Do(ctx, func(tx Storage) error {
+			err := tx.Activity.DeleteForPlan(ctx, userId, plan.Id)
+			if err != nil {
+				return err
+			}
+			err = tx.RecurringActivity.DeleteForPlan(ctx, userId, plan.Id)
+			if err != nil {
+				return err
+			}
+			return tx.Plan.Delete(ctx, userId, plan.Id)
+		})
+		if err != nil {
+			t.Errorf("Error deleting plan %s", err)
+			return
+		}
+
+		history, err := storage.Audit.History(ctx, userId, AuditActivity, activity.Id)
+		if err != nil {
+			t.Errorf("Error reading history %s", err)
+			return
+		}
+		if len(*history) != 3 || (*history)[0].Action != AuditCreate || (*history)[1].Action != AuditUpdate || (*history)[2].Action != AuditDelete {
+			t.Errorf("Unexpected activity history %+v", *history)
+			return
+		}
+		var before, after Activity
+		json.Unmarshal((*history)[1].OldValue, &before)
+		json.Unmarshal((*history)[1].NewValue, &after)
+		if before.Summary != "Planned" || after.Summary != "Changed" || after.Version != 2 || (*history)[2].NewValue != nil {
+			t.Errorf("Unexpected update entry %+v", (*history)[1])
+		}
+		if !after.UpdatedAt.Equal(updated.UpdatedAt) {
+			t.Errorf("Expected the update entry to record the stored time %s, got %s", updated.UpdatedAt, after.UpdatedAt)
+		}
+		for _, entityType := range []string{AuditPlan, AuditRecurringActivity} {
+			entityId := plan.Id
+			if entityType == AuditRecurringActivity {
+				entityId = recurring.Id
+			}
+			history, _ = storage.Audit.History(ctx, userId, entityType, entityId)
+			if len(*history) != 2 || (*history)[1].Action != AuditDelete || (*history)[1].OldValue == nil {
+				t.Errorf("Unexpected %s history %+v", entityType, *history)
+			}
+		}
+		history, _ = storage.Audit.History(ctx, "another-user", AuditActivity, activity.Id)
+		if len(*history) != 0 {
+			t.Errorf("Expected history to be scoped to the user")
+		}
+
+		_, err = PurgeUser(ctx, storage, userId)
+		if err != nil {
+			t.Errorf("Error purging user %s", err)
+			return
+		}
+		history, _ = storage.Audit.History(ctx, userId, AuditActivity, activity.Id)
+		if len(*history) != 0 {
+			t.Errorf("Expected the purge to remove the audit log")
+		}
+	}
+}
+
+func TestAuditLogPutAndDeleteAllForUser(t *testing.T) {
+	ctx := context.Background()
+	var allStorages []Storage
+	sqliteStorage, sqliteErr := getSqliteStorageClient(":memory:")
+	if sqliteErr != nil {
+		t.Errorf("Error creating storage: %s", sqliteErr.Error())
+		return
+	}
+	allStorages = append(allStorages, sqliteStorage)
+	allStorages = append(allStorages, NewMemoryStorage())
+	if cassandraStorage, ok := cassandraTestStorage(t); ok {
+		allStorages = append(allStorages, cassandraStorage)
+	}
+	for _, storage := range allStorages {
+		userId := fmt.Sprintf("test-user-id-%s", uuid.New())
+		plan := Plan{Id: uuid.New(), UserId: userId, Name: "Imported", Version: 1, UpdatedAt: time.Date(2023, 5, 1, 10, 0, 0, 0, time.UTC)}
+		err := storage.Plan.Put(ctx, plan)
+		if err != nil {
+			t.Errorf("Error putting plan %s", err)
+			return
+		}
+		plan.Name = "Imported again"
+		err = storage.Plan.Put(ctx, plan)
+		if err != nil {
+			t.Errorf("Error putting plan %s", err)
+			return
+		}
+		activity := Activity{Id: uuid.New(), UserId: userId, PlanId: &plan.Id, Summary: "Imported", Stages: []ActivityStage{}, DateTime: time.Date(2023, 5, 1, 10, 0, 0, 0, time.UTC), Version: 1, UpdatedAt: time.Date(2023, 5, 1, 10, 0, 0, 0, time.UTC)}
+		err = storage.Activity.Put(ctx, activity)
+		if err != nil {
+			t.Errorf("Error putting activity %s", err)
+			return
+		}
+		err = storage.Activity.DeleteAllForUser(ctx, userId)
+		if err != nil {
+			t.Errorf("Error deleting activities %s", err)
+			return
+		}
+		err = storage.Plan.DeleteAllForUser(ctx, userId)
+		if err != nil {
+			t.Errorf("Error deleting plans %s", err)
+			return
+		}
+
+		history, err := storage.Audit.History(ctx, userId, AuditPlan, plan.Id)
+		if err != nil {
+			t.Errorf("Error reading history %s", err)
+			return
+		}
+		if len(*history) != 3 || (*history)[0].Action != AuditCreate || (*history)[1].Action != AuditUpdate || (*history)[2].Action != AuditDelete {
+			t.Errorf("Unexpected plan history %+v", *history)
+			return
+		}
+		var put Plan
+		json.Unmarshal((*history)[1].NewValue, &put)
+		if put.Name != "Imported again" || (*history)[1].OldValue == nil {
+			t.Errorf("Unexpected put entry %+v", (*history)[1])
+		}
+		history, _ = storage.Audit.History(ctx, userId, AuditActivity, activity.Id)
+		if len(*history) != 2 || (*history)[0].Action != AuditCreate || (*history)[1].Action != AuditDelete {
+			t.Errorf("Unexpected activity history %+v", *history)
+		}
+	}
+}
+
+func TestActivityMetricFilters(t *testing.T) {
+	ctx := context.Background()
+	var allStorages []Storage
+	sqliteStorage, sqliteErr := getSqliteStorageClient(":memory:")
+	if sqliteErr != nil {
+		t.Errorf("Error creating storage: %s", sqliteErr.Error())
+		return
+	}
+	allStorages = append(allStorages, sqliteStorage)
+	allStorages = append(allStorages, NewMemoryStorage())
+	if cassandraStorage, ok := cassandraTestStorage(t); ok {
+		allStorages = append(allStorages, cassandraStorage)
+	}
+	for _, storage := range allStorages {
+		userId := fmt.Sprintf("test-user-id-%s", uuid.New())
+		baseTime := time.Date(2012, 12, 12, 12, 12, 12, 0, time.UTC)
+		storage.Activity.Create(ctx, Activity{UserId: userId, Summary: "Long Run", DateTime: baseTime, Stages: []ActivityStage{
+			{Order: 0, Description: "run", Repetitions: 1, Metrics: []ActivityStageMetric{{Amount: 12, Unit: "km"}}},
+		}})
+		intervals, _ := storage.Activity.Create(ctx, Activity{UserId: userId, Summary: "Intervals", DateTime: baseTime.Add(time.Hour), Stages: []ActivityStage{
+			{Order: 0, Description: "fast", Repetitions: 6, Metrics: []ActivityStageMetric{{Amount: 1, Unit: "km"}, {Amount: 4, Unit: "min"}}},
+			{Order: 1, Description: "cool down", Metrics: []ActivityStageMetric{{Amount: 10, Unit: "min"}}},
+		}})
+		storage.Activity.Create(ctx, Activity{UserId: userId, Summary: "Rest", DateTime: baseTime.Add(2 * time.Hour), Stages: []ActivityStage{}})
+
+		ten, eleven, thirty, forty := 10, 11, 30, 40
+		cases := []struct {
+			name     string
+			metrics  []MetricFilter
+			expected []string
+		}{
+			{"over 10 km", []MetricFilter{{Unit: "km", Min: &ten}}, []string{"Long Run"}},
+			{"up to 10 km", []MetricFilter{{Unit: "km", Max: &ten}}, []string{"Intervals"}},
+			{"any minutes", []MetricFilter{{Unit: "min"}}, []string{"Intervals"}},
+			{"30 to 40 minutes", []MetricFilter{{Unit: "min", Min: &thirty, Max: &forty}}, []string{"Intervals"}},
+			{"both", []MetricFilter{{Unit: "min"}, {Unit: "km", Min: &eleven}}, []string{}},
+			{"both matching", []MetricFilter{{Unit: "min"}, {Unit: "km", Max: &ten}}, []string{"Intervals"}},
+			{"unknown unit", []MetricFilter{{Unit: "laps"}}, []string{}},
+		}
+		check := func(name string, metrics []MetricFilter, dateRange *DateRange, expected []string) {
+			queried, err := storage.Activity.Query(ctx, ActivityStorageQuery{UserId: userId, Metrics: metrics, DateRange: dateRange})
+			if err != nil {
+				t.Errorf("Error querying %s: %s", name, err.Error())
+				return
+			}
+			summaries := make([]string, 0)
+			for _, activity := range *queried {
+				summaries = append(summaries, activity.Summary)
+			}
+			if fmt.Sprint(summaries) != fmt.Sprint(expected) {
+				t.Errorf("Error querying %s expected %v got %v", name, expected, summaries)
+			}
+		}
+		for _, c := range cases {
+			check(c.name, c.metrics, nil, c.expected)
+		}
+		check("any km in the first half hour", []MetricFilter{{Unit: "km"}}, &DateRange{Start: baseTime.Add(-time.Minute), End: baseTime.Add(30 * time.Minute)}, []string{"Long Run"})
+		check("up to 10 km in two hours", []MetricFilter{{Unit: "km", Max: &ten}}, &DateRange{Start: baseTime.Add(-time.Minute), End: baseTime.Add(2 * time.Hour)}, []string{"Intervals"})
+
+		// The stage data follows updates and deletes
+		intervals.Stages[0].Metrics[0].Amount = 2
+		err := storage.Activity.Update(ctx, intervals)
+		if err != nil {
+			t.Errorf("Error updating activity %s", err)
+			return
+		}
+		check("over 10 km after update", []MetricFilter{{Unit: "km", Min: &ten}}, nil, []string{"Long Run", "Intervals"})
+		storage.Activity.Delete(ctx, userId, intervals.Id)
+		check("over 10 km after delete", []MetricFilter{{Unit: "km", Min: &ten}}, nil, []string{"Long Run"})
+	}
+}
+
+func TestCopyUser(t *testing.T) {
+	ctx := context.Background()
+	source, sourceErr := getSqliteStorageClient(":memory:")
+	if sourceErr != nil {
+		t.Errorf("Error creating storage: %s", sourceErr.Error())
+		return
+	}
+	var targets []Storage
+	targets = append(targets, NewMemoryStorage())
+	if cassandraStorage, ok := cassandraTestStorage(t); ok {
+		targets = append(targets, cassandraStorage)
+	}
+	userId := fmt.Sprintf("test-user-id-%s", uuid.New())
+	deletedAt := time.Date(2023, 6, 1, 12, 0, 0, 0, time.UTC)
+	plan, _ := source.Plan.Create(ctx, Plan{UserId: userId, Name: "Test Plan"})
+	plan.Name = "Renamed Plan"
+	source.Plan.Update(ctx, plan)
+	recurring, _ := source.RecurringActivity.Create(ctx, RecurringActivity{UserId: userId, PlanId: &plan.Id, Summary: "Weekly", Stages: []ActivityStage{}, RecurrEachDays: 7})
+	activity, _ := source.Activity.Create(ctx, Activity{UserId: userId, PlanId: &plan.Id, RecurringActivityId: &recurring.Id, Summary: "Planned", Stages: []ActivityStage{}, DateTime: time.Date(2023, 5, 1, 10, 0, 0, 0, time.UTC)})
+	source.Activity.Create(ctx, Activity{UserId: userId, Summary: "Trashed", Stages: []ActivityStage{}, DeletedAt: &deletedAt, DateTime: time.Date(2023, 5, 2, 10, 0, 0, 0, time.UTC)})
+
+	userIds, err := UserIds(ctx, source)
+	if err != nil || len(userIds) != 1 || userIds[0] != userId {
+		t.Errorf("Expected only the test user in the source, got %v (%v)", userIds, err)
+	}
+	expected, _ := CountUserData(ctx, source, userId)
+	expectedEntries, _ := CountAuditEntries(ctx, source, userId)
+	if expectedEntries != 5 {
+		t.Errorf("Expected 5 audit entries in the source, got %d", expectedEntries)
+	}
+	for _, target := range targets {
+		// Copying twice, as a resumed migration does, must not duplicate anything
+		for i := 0; i < 2; i++ {
+			err = CopyUser(ctx, source, target, userId)
+			if err != nil {
+				t.Errorf("Error copying user %s", err)
+				return
+			}
+		}
+		copied, _ := CountUserData(ctx, target, userId)
+		if copied != expected {
+			t.Errorf("Expected %+v after copying, got %+v", expected, copied)
+		}
+		// The copy is no change of its own, only the source's entries arrive
+		copiedEntries, _ := CountAuditEntries(ctx, target, userId)
+		if copiedEntries != expectedEntries {
+			t.Errorf("Expected %d audit entries after copying, got %d", expectedEntries, copiedEntries)
+		}
+		history, _ := target.Audit.History(ctx, userId, AuditPlan, plan.Id)
+		if len(*history) != 2 || (*history)[0].Action != AuditCreate || (*history)[1].Action != AuditUpdate {
+			t.Errorf("Expected the plan's create and update copied, got %+v", history)
+		}
+		copiedPlan, _ := target.Plan.Read(ctx, userId, plan.Id)
+		if copiedPlan == nil || copiedPlan.Name != "Renamed Plan" || copiedPlan.Version != 2 {
+			t.Errorf("Expected the plan copied with its id and version, got %+v", copiedPlan)
+		}
+		copiedActivity, _ := target.Activity.Read(ctx, userId, activity.Id)
+		if copiedActivity == nil || *copiedActivity.PlanId != plan.Id || *copiedActivity.RecurringActivityId != recurring.Id {
+			t.Errorf("Expected the activity copied with its links, got %+v", copiedActivity)
+		}
+		targetUserIds, _ := UserIds(ctx, target)
+		found := false
+		for _, id := range targetUserIds {
+			found = found || id == userId
+		}
+		if !found {
+			t.Errorf("Expected %s among the target users", userId)
+		}
+	}
+}
@@ -0,0 +1,554 @@
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// memoryStore backs all three in-memory storages so they behave like tables
+// in one database. Values are copied in and out so callers never share
+// slices with the store.
+type memoryStore struct {
+	mu                  sync.RWMutex
+	activities          map[uuid.UUID]Activity
+	recurringActivities map[uuid.UUID]RecurringActivity
+	plans               map[uuid.UUID]Plan
+	auditLog            []AuditEntry
+}
+
+func copyStages(stages []ActivityStage) []ActivityStage {
+	if stages == nil {
+		return nil
+	}
+	copied := make([]ActivityStage, len(stages))
+	for i, stage := range stages {
+		copied[i] = stage
+		if stage.Metrics != nil {
+			copied[i].Metrics = append([]ActivityStageMetric{}, stage.Metrics...)
+		}
+	}
+	return copied
+}
+
+func copyUUID(id *uuid.UUID) *uuid.UUID {
+	if id == nil {
+		return nil
+	}
+	copied := *id
+	return &copied
+}
+
+func copyTime(t *time.Time) *time.Time {
+	if t == nil {
+		return nil
+	}
+	copied := *t
+	return &copied
+}
+
+func copyActivity(activity Activity) Activity {
+	activity.Stages = copyStages(activity.Stages)
+	activity.PlanId = copyUUID(activity.PlanId)
+	activity.RecurringActivityId = copyUUID(activity.RecurringActivityId)
+	activity.DeletedAt = copyTime(activity.DeletedAt)
+	return activity
+}
+
+func copyRecurringActivity(activity RecurringActivity) RecurringActivity {
+	activity.Stages = copyStages(activity.Stages)
+	activity.PlanId = copyUUID(activity.PlanId)
+	activity.DeletedAt = copyTime(activity.DeletedAt)
+	return activity
+}
+
+func copyPlan(plan Plan) Plan {
+	plan.DeletedAt = copyTime(plan.DeletedAt)
+	plan.ArchivedAt = copyTime(plan.ArchivedAt)
+	return plan
+}
+
+func copyAuditEntry(entry AuditEntry) AuditEntry {
+	entry.OldValue = append(json.RawMessage(nil), entry.OldValue...)
+	entry.NewValue = append(json.RawMessage(nil), entry.NewValue...)
+	return entry
+}
+
+type MemoryActivityStorage struct {
+	store *memoryStore
+}
+
+func (stg MemoryActivityStorage) Create(ctx context.Context, activity Activity) (Activity, error) {
+	stg.store.mu.Lock()
+	defer stg.store.mu.Unlock()
+	activity.Id = uuid.New()
+	activity.Version = 1
+	activity.UpdatedAt = versionTime(ctx)
+	stg.store.activities[activity.Id] = copyActivity(activity)
+	return activity, nil
+}
+
+func (stg MemoryActivityStorage) Read(ctx context.Context, userId string, id uuid.UUID) (*Activity, error) {
+	stg.store.mu.RLock()
+	defer stg.store.mu.RUnlock()
+	activity, ok := stg.store.activities[id]
+	if !ok || activity.UserId != userId {
+		return nil, nil
+	}
+	activity = copyActivity(activity)
+	return &activity, nil
+}
+
+func (stg MemoryActivityStorage) Query(ctx context.Context, query ActivityStorageQuery) (*[]Activity, error) {
+	stg.store.mu.RLock()
+	defer stg.store.mu.RUnlock()
+	activePlans := make([]uuid.UUID, 0)
+	for _, plan := range stg.store.plans {
+		if plan.UserId == query.UserId && activePlan(plan) {
+			activePlans = append(activePlans, plan.Id)
+		}
+	}
+	activities := make([]Activity, 0)
+	for _, activity := range stg.store.activities {
+		if matchesActivityQuery(activity, query, activePlans) {
+			activities = append(activities, copyActivity(activity))
+		}
+	}
+	activities = pageActivities(activities, query)
+	return &activities, nil
+}
+
+func (stg MemoryActivityStorage) Search(ctx context.Context, query ActivitySearchQuery) (*[]Activity, error) {
+	stg.store.mu.RLock()
+	defer stg.store.mu.RUnlock()
+	terms := searchTerms(query.Text)
+	activities := make([]Activity, 0)
+	scores := make(map[uuid.UUID]int)
+	for _, activity := range stg.store.activities {
+		if activity.UserId != query.UserId || activity.DeletedAt != nil {
+			continue
+		}
+		score := activitySearchScore(activity, terms)
+		if score > 0 {
+			scores[activity.Id] = score
+			activities = append(activities, copyActivity(activity))
+		}
+	}
+	activities = rankSearchResults(activities, scores, query.Limit)
+	return &activities, nil
+}
+
+func (stg MemoryActivityStorage) Update(ctx context.Context, activity Activity) error {
+	stg.store.mu.Lock()
+	defer stg.store.mu.Unlock()
+	stored, ok := stg.store.activities[activity.Id]
+	if !ok || stored.UserId != activity.UserId {
+		return ErrNotFound
+	}
+	if stored.Version != activity.Version {
+		return ErrVersionConflict
+	}
+	activity.Version++
+	activity.UpdatedAt = versionTime(ctx)
+	stg.store.activities[activity.Id] = copyActivity(activity)
+	return nil
+}
+
+func (stg MemoryActivityStorage) Delete(ctx context.Context, userId string, id uuid.UUID) error {
+	stg.store.mu.Lock()
+	defer stg.store.mu.Unlock()
+	stored, ok := stg.store.activities[id]
+	if !ok || stored.UserId != userId {
+		return ErrNotFound
+	}
+	delete(stg.store.activities, id)
+	return nil
+}
+
+func (stg MemoryActivityStorage) DeleteForPlan(ctx context.Context, userId string, planId uuid.UUID) error {
+	stg.store.mu.Lock()
+	defer stg.store.mu.Unlock()
+	for id, stored := range stg.store.activities {
+		if stored.UserId == userId && stored.PlanId != nil && *stored.PlanId == planId {
+			delete(stg.store.activities, id)
+		}
+	}
+	return nil
+}
+
+func (stg MemoryActivityStorage) DeleteAllForUser(ctx context.Context, userId string) error {
+	stg.store.mu.Lock()
+	defer stg.store.mu.Unlock()
+	for id, stored := range stg.store.activities {
+		if stored.UserId == userId {
+			delete(stg.store.activities, id)
+		}
+	}
+	return nil
+}
+
+func (stg MemoryActivityStorage) Put(ctx context.Context, activity Activity) error {
+	stg.store.mu.Lock()
+	defer stg.store.mu.Unlock()
+	stored, ok := stg.store.activities[activity.Id]
+	if ok && stored.UserId != activity.UserId {
+		return ErrNotFound
+	}
+	stg.store.activities[activity.Id] = copyActivity(activity)
+	return nil
+}
+
+func (stg MemoryActivityStorage) UserIds(ctx context.Context) ([]string, error) {
+	stg.store.mu.RLock()
+	defer stg.store.mu.RUnlock()
+	seen := make(map[string]bool)
+	userIds := make([]string, 0)
+	for _, stored := range stg.store.activities {
+		if !seen[stored.UserId] {
+			seen[stored.UserId] = true
+			userIds = append(userIds, stored.UserId)
+		}
+	}
+	return userIds, nil
+}
+
+type MemoryRecurringActivityStorage struct {
+	store *memoryStore
+}
+
+func (stg MemoryRecurringActivityStorage) Create(ctx context.Context, activity RecurringActivity) (RecurringActivity, error) {
+	stg.store.mu.Lock()
+	defer stg.store.mu.Unlock()
+	activity.Id = uuid.New()
+	activity.Version = 1
+	activity.UpdatedAt = versionTime(ctx)
+	stg.store.recurringActivities[activity.Id] = copyRecurringActivity(activity)
+	return activity, nil
+}
+
+func (stg MemoryRecurringActivityStorage) Read(ctx context.Context, userId string, id uuid.UUID) (*RecurringActivity, error) {
+	stg.store.mu.RLock()
+	defer stg.store.mu.RUnlock()
+	activity, ok := stg.store.recurringActivities[id]
+	if !ok || activity.UserId != userId {
+		return nil, nil
+	}
+	activity = copyRecurringActivity(activity)
+	return &activity, nil
+}
+
+func (stg MemoryRecurringActivityStorage) Query(ctx context.Context, query RecurringActivityStorageQuery) (*[]RecurringActivity, error) {
+	stg.store.mu.RLock()
+	defer stg.store.mu.RUnlock()
+	activities := make([]RecurringActivity, 0)
+	for _, activity := range stg.store.recurringActivities {
+		if activity.UserId != query.UserId || (activity.DeletedAt != nil) != query.Trashed {
+			continue
+		}
+		if query.PlanId != nil && (activity.PlanId == nil || *activity.PlanId != *query.PlanId) {
+			continue
+		}
+		activities = append(activities, copyRecurringActivity(activity))
+	}
+	sort.Slice(activities, func(i, j int) bool {
+		if activities[i].DateTimeStart.Equal(activities[j].DateTimeStart) {
+			return activities[i].Id.String() < activities[j].Id.String()
+		}
+		return activities[i].DateTimeStart.Before(activities[j].DateTimeStart)
+	})
+	return &activities, nil
+}
+
+func (stg MemoryRecurringActivityStorage) Update(ctx context.Context, activity RecurringActivity) error {
+	stg.store.mu.Lock()
+	defer stg.store.mu.Unlock()
+	stored, ok := stg.store.recurringActivities[activity.Id]
+	if !ok || stored.UserId != activity.UserId {
+		return ErrNotFound
+	}
+	if stored.Version != activity.Version {
+		return ErrVersionConflict
+	}
+	activity.Version++
+	activity.UpdatedAt = versionTime(ctx)
+	stg.store.recurringActivities[activity.Id] = copyRecurringActivity(activity)
+	return nil
+}
+
+func (stg MemoryRecurringActivityStorage) Delete(ctx context.Context, userId string, id uuid.UUID) error {
+	stg.store.mu.Lock()
+	defer stg.store.mu.Unlock()
+	stored, ok := stg.store.recurringActivities[id]
+	if !ok || stored.UserId != userId {
+		return ErrNotFound
+	}
+	delete(stg.store.recurringActivities, id)
+	return nil
+}
+
+func (stg MemoryRecurringActivityStorage) DeleteForPlan(ctx context.Context, userId string, planId uuid.UUID) error {
+	stg.store.mu.Lock()
+	defer stg.store.mu.Unlock()
+	for id, stored := range stg.store.recurringActivities {
+		if stored.UserId == userId && stored.PlanId != nil && *stored.PlanId == planId {
+			delete(stg.store.recurringActivities, id)
+		}
+	}
+	return nil
+}
+
+func (stg MemoryRecurringActivityStorage) DeleteAllForUser(ctx context.Context, userId string) error {
+	stg.store.mu.Lock()
+	defer stg.store.mu.Unlock()
+	for id, stored := range stg.store.recurringActivities {
+		if stored.UserId == userId {
+			delete(stg.store.recurringActivities, id)
+		}
+	}
+	return nil
+}
+
+func (stg MemoryRecurringActivityStorage) Put(ctx context.Context, activity RecurringActivity) error {
+	stg.store.mu.Lock()
+	defer stg.store.mu.Unlock()
+	stored, ok := stg.store.recurringActivities[activity.Id]
+	if ok && stored.UserId != activity.UserId {
+		return ErrNotFound
+	}
+	stg.store.recurringActivities[activity.Id] = copyRecurringActivity(activity)
+	return nil
+}
+
+func (stg MemoryRecurringActivityStorage) UserIds(ctx context.Context) ([]string, error) {
+	stg.store.mu.RLock()
+	defer stg.store.mu.RUnlock()
+	seen := make(map[string]bool)
+	userIds := make([]string, 0)
+	for _, stored := range stg.store.recurringActivities {
+		if !seen[stored.UserId] {
+			seen[stored.UserId] = true
+			userIds = append(userIds, stored.UserId)
+		}
+	}
+	return userIds, nil
+}
+
+type MemoryPlanStorage struct {
+	store *memoryStore
+}
+
+func (stg MemoryPlanStorage) Create(ctx context.Context, plan Plan) (Plan, error) {
+	stg.store.mu.Lock()
+	defer stg.store.mu.Unlock()
+	plan.Id = uuid.New()
+	plan.Version = 1
+	plan.UpdatedAt = versionTime(ctx)
+	stg.store.plans[plan.Id] = copyPlan(plan)
+	return plan, nil
+}
+
+func (stg MemoryPlanStorage) Read(ctx context.Context, userId string, id uuid.UUID) (*Plan, error) {
+	stg.store.mu.RLock()
+	defer stg.store.mu.RUnlock()
+	plan, ok := stg.store.plans[id]
+	if !ok || plan.UserId != userId {
+		return nil, nil
+	}
+	plan = copyPlan(plan)
+	return &plan, nil
+}
+
+func (stg MemoryPlanStorage) Query(ctx context.Context, query PlanStorageQuery) (*[]Plan, error) {
+	stg.store.mu.RLock()
+	defer stg.store.mu.RUnlock()
+	plans := make([]Plan, 0)
+	for _, plan := range stg.store.plans {
+		if matchesPlanQuery(plan, query) {
+			plans = append(plans, copyPlan(plan))
+		}
+	}
+	sort.Slice(plans, func(i, j int) bool {
+		if plans[i].Name == plans[j].Name {
+			return plans[i].Id.String() < plans[j].Id.String()
+		}
+		return plans[i].Name < plans[j].Name
+	})
+	return &plans, nil
+}
+
+func (stg MemoryPlanStorage) Update(ctx context.Context, plan Plan) error {
+	stg.store.mu.Lock()
+	defer stg.store.mu.Unlock()
+	stored, ok := stg.store.plans[plan.Id]
+	if !ok || stored.UserId != plan.UserId {
+		return ErrNotFound
+	}
+	if stored.Version != plan.Version {
+		return ErrVersionConflict
+	}
+	plan.Version++
+	plan.UpdatedAt = versionTime(ctx)
+	stg.store.plans[plan.Id] = copyPlan(plan)
+	return nil
+}
+
+func (stg MemoryPlanStorage) Delete(ctx context.Context, userId string, id uuid.UUID) error {
+	stg.store.mu.Lock()
+	defer stg.store.mu.Unlock()
+	stored, ok := stg.store.plans[id]
+	if !ok || stored.UserId != userId {
+		return ErrNotFound
+	}
+	delete(stg.store.plans, id)
+	return nil
+}
+
+func (stg MemoryPlanStorage) DeleteAllForUser(ctx context.Context, userId string) error {
+	stg.store.mu.Lock()
+	defer stg.store.mu.Unlock()
+	for id, stored := range stg.store.plans {
+		if stored.UserId == userId {
+			delete(stg.store.plans, id)
+		}
+	}
+	return nil
+}
+
+func (stg MemoryPlanStorage) Put(ctx context.Context, plan Plan) error {
+	stg.store.mu.Lock()
+	defer stg.store.mu.Unlock()
+	stored, ok := stg.store.plans[plan.Id]
+	if ok && stored.UserId != plan.UserId {
+		return ErrNotFound
+	}
+	stg.store.plans[plan.Id] = copyPlan(plan)
+	return nil
+}
+
+func (stg MemoryPlanStorage) UserIds(ctx context.Context) ([]string, error) {
+	stg.store.mu.RLock()
+	defer stg.store.mu.RUnlock()
+	seen := make(map[string]bool)
+	userIds := make([]string, 0)
+	for _, stored := range stg.store.plans {
+		if !seen[stored.UserId] {
+			seen[stored.UserId] = true
+			userIds = append(userIds, stored.UserId)
+		}
+	}
+	return userIds, nil
+}
+
+type MemoryAuditStorage struct {
+	store *memoryStore
+}
+
+func (stg MemoryAuditStorage) Append(ctx context.Context, entry AuditEntry) error {
+	stg.store.mu.Lock()
+	defer stg.store.mu.Unlock()
+	stg.store.auditLog = append(stg.store.auditLog, copyAuditEntry(entry))
+	return nil
+}
+
+func (stg MemoryAuditStorage) History(ctx context.Context, userId string, entityType string, entityId uuid.UUID) (*[]AuditEntry, error) {
+	stg.store.mu.RLock()
+	defer stg.store.mu.RUnlock()
+	entries := make([]AuditEntry, 0)
+	for _, entry := range stg.store.auditLog {
+		if entry.UserId == userId && entry.EntityType == entityType && entry.EntityId == entityId {
+			entries = append(entries, copyAuditEntry(entry))
+		}
+	}
+	return &entries, nil
+}
+
+func (stg MemoryAuditStorage) ForUser(ctx context.Context, userId string) (*[]AuditEntry, error) {
+	stg.store.mu.RLock()
+	defer stg.store.mu.RUnlock()
+	entries := make([]AuditEntry, 0)
+	for _, entry := range stg.store.auditLog {
+		if entry.UserId == userId {
+			entries = append(entries, copyAuditEntry(entry))
+		}
+	}
+	return &entries, nil
+}
+
+func (stg MemoryAuditStorage) DeleteAllForUser(ctx context.Context, userId string) error {
+	stg.store.mu.Lock()
+	defer stg.store.mu.Unlock()
+	kept := make([]AuditEntry, 0, len(stg.store.auditLog))
+	for _, entry := range stg.store.auditLog {
+		if entry.UserId != userId {
+			kept = append(kept, entry)
+		}
+	}
+	stg.store.auditLog = kept
+	return nil
+}
+
+// Units of work hold the store's write lock throughout and operate on a copy,
+// which replaces the live data only when fn succeeds.
+type memoryUnitOfWork struct {
+	store *memoryStore
+}
+
+func (uow memoryUnitOfWork) Do(ctx context.Context, fn func(Storage) error) error {
+	uow.store.mu.Lock()
+	defer uow.store.mu.Unlock()
+	staging := &memoryStore{
+		activities:          make(map[uuid.UUID]Activity, len(uow.store.activities)),
+		recurringActivities: make(map[uuid.UUID]RecurringActivity, len(uow.store.recurringActivities)),
+		plans:               make(map[uuid.UUID]Plan, len(uow.store.plans)),
+		auditLog:            append([]AuditEntry{}, uow.store.auditLog...),
+	}
+	for id, activity := range uow.store.activities {
+		staging.activities[id] = activity
+	}
+	for id, activity := range uow.store.recurringActivities {
+		staging.recurringActivities[id] = activity
+	}
+	for id, plan := range uow.store.plans {
+		staging.plans[id] = plan
+	}
+	err := fn(withAudit(Storage{
+		Activity:          MemoryActivityStorage{store: staging},
+		RecurringActivity: MemoryRecurringActivityStorage{store: staging},
+		Plan:              MemoryPlanStorage{store: staging},
+		Audit:             MemoryAuditStorage{store: staging},
+	}))
+	if err != nil {
+		return err
+	}
+	// Like a database transaction, a cancelled unit of work does not commit
+	err = ctx.Err()
+	if err != nil {
+		return err
+	}
+	uow.store.activities = staging.activities
+	uow.store.recurringActivities = staging.recurringActivities
+	uow.store.plans = staging.plans
+	uow.store.auditLog = staging.auditLog
+	return nil
+}
+
+// NewMemoryStorage returns an empty, concurrency-safe Storage held entirely in
+// memory. Nothing is persisted, so it suits development and tests.
+func NewMemoryStorage() Storage {
+	store := &memoryStore{
+		activities:          make(map[uuid.UUID]Activity),
+		recurringActivities: make(map[uuid.UUID]RecurringActivity),
+		plans:               make(map[uuid.UUID]Plan),
+	}
+	return withAudit(Storage{
+		Activity:          MemoryActivityStorage{store: store},
+		RecurringActivity: MemoryRecurringActivityStorage{store: store},
+		Plan:              MemoryPlanStorage{store: store},
+		Audit:             MemoryAuditStorage{store: store},
+		UnitOfWork:        memoryUnitOfWork{store: store},
+	})
+}
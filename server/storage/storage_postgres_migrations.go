@@ -0,0 +1,192 @@
+package storage
+
+var postgresMigrations = []schemaMigration{
+	{
+		Version:     1,
+		Description: "initial schema",
+		Statements: []string{`
+	CREATE TABLE IF NOT EXISTS activities (
+			id UUID PRIMARY KEY,
+			recurringActivityId UUID NULL,
+			userId TEXT NOT NULL,
+			planId UUID NULL,
+			summary TEXT,
+			stages JSONB NOT NULL DEFAULT '[]',
+			dateTime TIMESTAMPTZ,
+			timeRelevant BOOLEAN,
+			completed BOOLEAN,
+			notes TEXT
+	);`,
+			`CREATE INDEX IF NOT EXISTS activities_user_datetime ON activities (userId, dateTime);`,
+			`CREATE INDEX IF NOT EXISTS activities_user_plan ON activities (userId, planId);`,
+			`CREATE TABLE IF NOT EXISTS plans (
+			id UUID PRIMARY KEY,
+			userId TEXT NOT NULL,
+			name TEXT,
+			active BOOLEAN
+	);`,
+			`CREATE INDEX IF NOT EXISTS plans_user ON plans (userId);`,
+			`CREATE TABLE IF NOT EXISTS recurring_activities (
+			id UUID PRIMARY KEY,
+			userId TEXT NOT NULL,
+			planId UUID NULL,
+			summary TEXT,
+			stages JSONB NOT NULL DEFAULT '[]',
+			recurrEachDays INT,
+			dateTimeStart TIMESTAMPTZ,
+			timeRelevant BOOLEAN
+	);`,
+			`CREATE INDEX IF NOT EXISTS recurring_activities_user_plan ON recurring_activities (userId, planId);`,
+		},
+	},
+	{
+		Version:     2,
+		Description: "activity full text search",
+		Statements: []string{`
+	ALTER TABLE activities ADD COLUMN IF NOT EXISTS search tsvector
+		GENERATED ALWAYS AS (
+			setweight(to_tsvector('simple', coalesce(summary, '')), 'A') ||
+			setweight(to_tsvector('simple', coalesce(notes, '')), 'B') ||
+			setweight(to_tsvector('simple', jsonb_path_query_array(stages, '$[*].description')), 'B')
+		) STORED;`,
+			`CREATE INDEX IF NOT EXISTS activities_search ON activities USING GIN (search);`,
+		},
+	},
+	{
+		Version:     3,
+		Description: "trash",
+		Statements: []string{
+			`ALTER TABLE activities ADD COLUMN IF NOT EXISTS deletedAt TIMESTAMPTZ NULL;`,
+			`ALTER TABLE plans ADD COLUMN IF NOT EXISTS deletedAt TIMESTAMPTZ NULL;`,
+			`ALTER TABLE recurring_activities ADD COLUMN IF NOT EXISTS deletedAt TIMESTAMPTZ NULL;`,
+		},
+	},
+	{
+		Version:     4,
+		Description: "plan archiving",
+		Statements: []string{
+			`ALTER TABLE plans ADD COLUMN IF NOT EXISTS archivedAt TIMESTAMPTZ NULL;`,
+		},
+	},
+	{
+		Version:     5,
+		Description: "versioning",
+		Statements: []string{
+			`ALTER TABLE activities ADD COLUMN IF NOT EXISTS version BIGINT NOT NULL DEFAULT 1;`,
+			`ALTER TABLE activities ADD COLUMN IF NOT EXISTS updatedAt TIMESTAMPTZ NOT NULL DEFAULT now();`,
+			`ALTER TABLE plans ADD COLUMN IF NOT EXISTS version BIGINT NOT NULL DEFAULT 1;`,
+			`ALTER TABLE plans ADD COLUMN IF NOT EXISTS updatedAt TIMESTAMPTZ NOT NULL DEFAULT now();`,
+			`ALTER TABLE recurring_activities ADD COLUMN IF NOT EXISTS version BIGINT NOT NULL DEFAULT 1;`,
+			`ALTER TABLE recurring_activities ADD COLUMN IF NOT EXISTS updatedAt TIMESTAMPTZ NOT NULL DEFAULT now();`,
+		},
+	},
+	{
+		Version:     6,
+		Description: "audit log",
+		Statements: []string{
+			`CREATE TABLE IF NOT EXISTS audit_log (
+			seq BIGSERIAL PRIMARY KEY,
+			id UUID NOT NULL UNIQUE,
+			userId TEXT NOT NULL,
+			entityType TEXT NOT NULL,
+			entityId UUID NOT NULL,
+			action TEXT NOT NULL,
+			oldValue JSONB NULL,
+			newValue JSONB NULL,
+			timestamp TIMESTAMPTZ NOT NULL
+	);`,
+			`CREATE INDEX IF NOT EXISTS audit_log_user_entity ON audit_log (userId, entityId, seq);`,
+		},
+	},
+	{
+		Version:     7,
+		Description: "stage tables",
+		Statements: []string{
+			`CREATE TABLE IF NOT EXISTS activity_stages (
+			activityId UUID NOT NULL REFERENCES activities (id) ON DELETE CASCADE,
+			position INTEGER NOT NULL,
+			stageOrder INTEGER,
+			description TEXT,
+			repetitions INTEGER,
+			completed BOOLEAN,
+			PRIMARY KEY (activityId, position)
+	);`,
+			`CREATE TABLE IF NOT EXISTS activity_stage_metrics (
+			activityId UUID NOT NULL,
+			stagePosition INTEGER NOT NULL,
+			position INTEGER NOT NULL,
+			amount INTEGER,
+			unit TEXT,
+			PRIMARY KEY (activityId, stagePosition, position),
+			FOREIGN KEY (activityId, stagePosition) REFERENCES activity_stages (activityId, position) ON DELETE CASCADE
+	);`,
+			`CREATE INDEX IF NOT EXISTS activity_stage_metrics_unit ON activity_stage_metrics (unit, activityId);`,
+			`CREATE OR REPLACE FUNCTION sync_activity_stages() RETURNS trigger AS $$
+	BEGIN
+			DELETE FROM activity_stages WHERE activityId = NEW.id;
+			` + postgresInsertStagesSQL("NEW") + `
+			RETURN NULL;
+	END;
+	$$ LANGUAGE plpgsql;`,
+			`DROP TRIGGER IF EXISTS activity_stages_sync ON activities;`,
+			`CREATE TRIGGER activity_stages_sync AFTER INSERT OR UPDATE OF stages ON activities
+			FOR EACH ROW EXECUTE FUNCTION sync_activity_stages();`,
+			`DELETE FROM activity_stages;`,
+			postgresInsertStagesSQL("activities"),
+		},
+	},
+	{
+		Version:     8,
+		Description: "recurrence rules",
+		Statements: []string{
+			`ALTER TABLE recurring_activities ADD COLUMN IF NOT EXISTS rrule TEXT NOT NULL DEFAULT '';`,
+		},
+	},
+}
+
+// postgresInsertStagesSQL copies the stages and metrics of row, the NEW row in
+// a trigger or the activities table to backfill, into the stage tables.
+// Deleting an activity cascades to its stages.
+func postgresInsertStagesSQL(row string) string {
+	from := ""
+	if row != "NEW" {
+		from = row + ", "
+	}
+	stages := `jsonb_array_elements(CASE WHEN jsonb_typeof(` + row + `.stages) = 'array' THEN ` + row + `.stages ELSE '[]' END) WITH ORDINALITY s(stage, n)`
+	return `INSERT INTO activity_stages (activityId, position, stageOrder, description, repetitions, completed)
+			SELECT ` + row + `.id, s.n - 1, (s.stage->>'order')::int, s.stage->>'description',
+				(s.stage->>'repetitions')::int, (s.stage->>'completed')::boolean
+			FROM ` + from + stages + `
+			WHERE jsonb_typeof(s.stage) = 'object';
+			INSERT INTO activity_stage_metrics (activityId, stagePosition, position, amount, unit)
+			SELECT ` + row + `.id, s.n - 1, m.n - 1, (m.metric->>'amount')::int, m.metric->>'unit'
+			FROM ` + from + stages + `,
+				jsonb_array_elements(CASE WHEN jsonb_typeof(s.stage->'metrics') = 'array' THEN s.stage->'metrics' ELSE '[]' END) WITH ORDINALITY m(metric, n)
+			WHERE jsonb_typeof(s.stage) = 'object' AND jsonb_typeof(m.metric) = 'object';`
+}
+
+// The advisory lock stops several replicas migrating the same database at once
+var postgresMigrationDialect = sqlMigrationDialect{
+	CreateVersionTable: `
+	CREATE TABLE IF NOT EXISTS schema_version (
+			version INTEGER PRIMARY KEY,
+			description TEXT,
+			checksum TEXT,
+			appliedAt TIMESTAMPTZ
+	);`,
+	InsertVersion: `
+			INSERT INTO schema_version (
+				version,
+				description,
+				checksum,
+				appliedAt
+			)
+			VALUES (
+				$1,
+				$2,
+				$3,
+				$4
+			);`,
+	Lock:   `SELECT pg_advisory_lock(hashtext('planner_schema_migrations'));`,
+	Unlock: `SELECT pg_advisory_unlock(hashtext('planner_schema_migrations'));`,
+}
@@ -0,0 +1,326 @@
+package storage
+
+import (
+	"context"
+	"encoding/json"
+
+	"github.com/google/uuid"
+)
+
+type PostgresRecurringActivityStorage struct {
+	DB sqlExecutor
+}
+
+func (stg PostgresRecurringActivityStorage) Create(ctx context.Context, activity RecurringActivity) (RecurringActivity, error) {
+	newId := uuid.New()
+	activity.Version = 1
+	activity.UpdatedAt = versionTime(ctx)
+	insertSQL := `
+			INSERT INTO recurring_activities (
+				id,
+				userId,
+				planId,
+				summary,
+				stages,
+				recurrEachDays,
+				rrule,
+				dateTimeStart,
+				timeRelevant,
+				deletedAt,
+				version,
+				updatedAt
+			)
+			VALUES (
+				$1,
+				$2,
+				$3,
+				$4,
+				$5,
+				$6,
+				$7,
+				$8,
+				$9,
+				$10,
+				$11,
+				$12
+			);
+	`
+	jsonStr, err := json.Marshal(activity.Stages)
+	if err != nil {
+		return activity, err
+	}
+	_, insertErr := stg.DB.ExecContext(ctx, insertSQL,
+		newId,
+		activity.UserId,
+		activity.PlanId,
+		activity.Summary,
+		string(jsonStr),
+		activity.RecurrEachDays,
+		activity.RRule,
+		activity.DateTimeStart,
+		activity.TimeRelevant,
+		activity.DeletedAt,
+		activity.Version,
+		activity.UpdatedAt,
+	)
+	if insertErr != nil {
+		return activity, insertErr
+	}
+	activity.Id = newId
+	return activity, nil
+}
+
+func (stg PostgresRecurringActivityStorage) Read(ctx context.Context, userId string, id uuid.UUID) (*RecurringActivity, error) {
+	selectSQL := `
+			SELECT
+				id,
+				userId,
+				planId,
+				summary,
+				stages,
+				recurrEachDays,
+				rrule,
+				dateTimeStart,
+				timeRelevant,
+				deletedAt,
+				version,
+				updatedAt
+			FROM recurring_activities
+			WHERE userId = $1 AND id = $2;
+	`
+	rows, err := stg.DB.QueryContext(ctx, selectSQL, userId, id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	if rows.Next() {
+		var activity RecurringActivity
+		rawStages := []byte("[]")
+		err = rows.Scan(
+			&activity.Id,
+			&activity.UserId,
+			&activity.PlanId,
+			&activity.Summary,
+			&rawStages,
+			&activity.RecurrEachDays,
+			&activity.RRule,
+			&activity.DateTimeStart,
+			&activity.TimeRelevant,
+			&activity.DeletedAt,
+			&activity.Version,
+			&activity.UpdatedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+		err := json.Unmarshal(rawStages, &activity.Stages)
+		if err != nil {
+			return nil, err
+		}
+		return &activity, nil
+	}
+	return nil, nil
+}
+
+func (stg PostgresRecurringActivityStorage) Query(ctx context.Context, query RecurringActivityStorageQuery) (*[]RecurringActivity, error) {
+	selectSQL := `
+	SELECT
+		id,
+		userId,
+		planId,
+		summary,
+		stages,
+		recurrEachDays,
+		rrule,
+		dateTimeStart,
+		timeRelevant,
+		deletedAt,
+		version,
+		updatedAt
+	FROM recurring_activities
+	WHERE userId = $1
+	AND ($2::uuid IS NULL OR planId = $2)
+	AND ` + trashedSQL(query.Trashed) + `;
+`
+	rows, err := stg.DB.QueryContext(ctx, selectSQL,
+		query.UserId,
+		query.PlanId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	activities := make([]RecurringActivity, 0)
+	for rows.Next() {
+		var activity RecurringActivity
+		rawStages := []byte("[]")
+		err = rows.Scan(
+			&activity.Id,
+			&activity.UserId,
+			&activity.PlanId,
+			&activity.Summary,
+			&rawStages,
+			&activity.RecurrEachDays,
+			&activity.RRule,
+			&activity.DateTimeStart,
+			&activity.TimeRelevant,
+			&activity.DeletedAt,
+			&activity.Version,
+			&activity.UpdatedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		err := json.Unmarshal(rawStages, &activity.Stages)
+		if err != nil {
+			return nil, err
+		}
+		activities = append(activities, activity)
+	}
+	return &activities, nil
+}
+
+func (stg PostgresRecurringActivityStorage) Update(ctx context.Context, activity RecurringActivity) error {
+	updateSQL := `
+			UPDATE recurring_activities
+			SET
+				planId = $1,
+				summary = $2,
+				stages = $3,
+				recurrEachDays = $4,
+				rrule = $12,
+				dateTimeStart = $5,
+				timeRelevant = $6,
+				deletedAt = $7,
+				version = version + 1,
+				updatedAt = $10
+			WHERE userId = $8 AND id = $9 AND version = $11;
+	`
+	jsonStr, err := json.Marshal(activity.Stages)
+	if err != nil {
+		return err
+	}
+	result, updateErr := stg.DB.ExecContext(ctx, updateSQL,
+		activity.PlanId,
+		activity.Summary,
+		string(jsonStr),
+		activity.RecurrEachDays,
+		activity.DateTimeStart,
+		activity.TimeRelevant,
+		activity.DeletedAt,
+		activity.UserId,
+		activity.Id,
+		versionTime(ctx),
+		activity.Version,
+		activity.RRule,
+	)
+	if updateErr != nil {
+		return updateErr
+	}
+	return versionUpdated(ctx, stg.DB, result, `SELECT 1 FROM recurring_activities WHERE userId = $1 AND id = $2;`, activity.UserId, activity.Id)
+}
+
+func (stg PostgresRecurringActivityStorage) Delete(ctx context.Context, userId string, id uuid.UUID) error {
+	deleteSQL := `
+			DELETE FROM recurring_activities
+			WHERE userId = $1 AND id = $2;
+	`
+	result, deleteErr := stg.DB.ExecContext(ctx, deleteSQL, userId, id)
+	if deleteErr != nil {
+		return deleteErr
+	}
+	return rowDeleted(result)
+}
+
+func (stg PostgresRecurringActivityStorage) DeleteForPlan(ctx context.Context, userId string, planId uuid.UUID) error {
+	deleteSQL := `
+			DELETE FROM recurring_activities
+			WHERE userId = $1 AND planId = $2;
+	`
+	_, deleteErr := stg.DB.ExecContext(ctx, deleteSQL, userId, planId)
+	if deleteErr != nil {
+		return deleteErr
+	}
+	return nil
+}
+
+func (stg PostgresRecurringActivityStorage) DeleteAllForUser(ctx context.Context, userId string) error {
+	deleteSQL := `
+			DELETE FROM recurring_activities
+			WHERE userId = $1;
+	`
+	_, deleteErr := stg.DB.ExecContext(ctx, deleteSQL, userId)
+	if deleteErr != nil {
+		return deleteErr
+	}
+	return nil
+}
+
+func (stg PostgresRecurringActivityStorage) Put(ctx context.Context, activity RecurringActivity) error {
+	upsertSQL := `
+			INSERT INTO recurring_activities (
+				id,
+				userId,
+				planId,
+				summary,
+				stages,
+				recurrEachDays,
+				rrule,
+				dateTimeStart,
+				timeRelevant,
+				deletedAt,
+				version,
+				updatedAt
+			)
+			VALUES (
+				$1,
+				$2,
+				$3,
+				$4,
+				$5,
+				$6,
+				$7,
+				$8,
+				$9,
+				$10,
+				$11,
+				$12
+			)
+			ON CONFLICT (id) DO UPDATE SET
+				planId = excluded.planId,
+				summary = excluded.summary,
+				stages = excluded.stages,
+				recurrEachDays = excluded.recurrEachDays,
+				rrule = excluded.rrule,
+				dateTimeStart = excluded.dateTimeStart,
+				timeRelevant = excluded.timeRelevant,
+				deletedAt = excluded.deletedAt,
+				version = excluded.version,
+				updatedAt = excluded.updatedAt
+			WHERE recurring_activities.userId = excluded.userId;
+	`
+	jsonStr, err := json.Marshal(activity.Stages)
+	if err != nil {
+		return err
+	}
+	result, upsertErr := stg.DB.ExecContext(ctx, upsertSQL,
+		activity.Id,
+		activity.UserId,
+		activity.PlanId,
+		activity.Summary,
+		string(jsonStr),
+		activity.RecurrEachDays,
+		activity.RRule,
+		activity.DateTimeStart,
+		activity.TimeRelevant,
+		activity.DeletedAt,
+		activity.Version,
+		activity.UpdatedAt,
+	)
+	return upserted(result, upsertErr)
+}
+
+func (stg PostgresRecurringActivityStorage) UserIds(ctx context.Context) ([]string, error) {
+	return sqlUserIds(ctx, stg.DB, `SELECT DISTINCT userId FROM recurring_activities;`)
+}
@@ -0,0 +1,110 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"strconv"
+)
+
+// sqlExecutor is satisfied by both *sql.DB and *sql.Tx, so the database/sql
+// backed storages run unchanged inside a transaction.
+type sqlExecutor interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+}
+
+func sqlitePlaceholder(int) string {
+	return "?"
+}
+
+func postgresPlaceholder(n int) string {
+	return "$" + strconv.Itoa(n)
+}
+
+type sqlUnitOfWork struct {
+	DB         *sql.DB
+	storageFor func(exec sqlExecutor) Storage
+}
+
+func (uow sqlUnitOfWork) Do(ctx context.Context, fn func(Storage) error) error {
+	tx, err := uow.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	err = fn(withAudit(uow.storageFor(tx)))
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// upserted checks that a Put applied. Its upsert only replaces rows of the
+// same user, so nothing changes when another user owns the id.
+func upserted(result sql.Result, err error) error {
+	if err != nil {
+		return err
+	}
+	changed, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if changed == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// versionUpdated checks that a versioned UPDATE applied. When it didn't,
+// existsSQL tells an item the user doesn't have from one changed in the
+// meantime.
+func versionUpdated(ctx context.Context, db sqlExecutor, result sql.Result, existsSQL string, args ...any) error {
+	updated, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if updated > 0 {
+		return nil
+	}
+	rows, err := db.QueryContext(ctx, existsSQL, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	if !rows.Next() {
+		if rows.Err() != nil {
+			return rows.Err()
+		}
+		return ErrNotFound
+	}
+	return ErrVersionConflict
+}
+
+func rowDeleted(result sql.Result) error {
+	deleted, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if deleted == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func sqlUserIds(ctx context.Context, db sqlExecutor, selectSQL string) ([]string, error) {
+	rows, err := db.QueryContext(ctx, selectSQL)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	userIds := make([]string, 0)
+	for rows.Next() {
+		var userId string
+		err = rows.Scan(&userId)
+		if err != nil {
+			return nil, err
+		}
+		userIds = append(userIds, userId)
+	}
+	return userIds, rows.Err()
+}
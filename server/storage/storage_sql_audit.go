@@ -0,0 +1,134 @@
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/google/uuid"
+)
+
+// sqlAuditStorage serves both SQLite and Postgres, which only differ in their
+// placeholders. Entries are ordered by their insertion sequence because
+// several changes can share a timestamp.
+type sqlAuditStorage struct {
+	DB          sqlExecutor
+	placeholder func(int) string
+}
+
+func sqlAuditValue(value json.RawMessage) any {
+	if len(value) == 0 {
+		return nil
+	}
+	return string(value)
+}
+
+func (stg sqlAuditStorage) Append(ctx context.Context, entry AuditEntry) error {
+	insertSQL := fmt.Sprintf(`
+			INSERT INTO audit_log (
+				id,
+				userId,
+				entityType,
+				entityId,
+				action,
+				oldValue,
+				newValue,
+				timestamp
+			)
+			VALUES (%s, %s, %s, %s, %s, %s, %s, %s);
+	`, stg.placeholder(1), stg.placeholder(2), stg.placeholder(3), stg.placeholder(4),
+		stg.placeholder(5), stg.placeholder(6), stg.placeholder(7), stg.placeholder(8))
+	_, insertErr := stg.DB.ExecContext(ctx, insertSQL,
+		entry.Id,
+		entry.UserId,
+		entry.EntityType,
+		entry.EntityId,
+		entry.Action,
+		sqlAuditValue(entry.OldValue),
+		sqlAuditValue(entry.NewValue),
+		entry.Timestamp,
+	)
+	return insertErr
+}
+
+func (stg sqlAuditStorage) History(ctx context.Context, userId string, entityType string, entityId uuid.UUID) (*[]AuditEntry, error) {
+	selectSQL := fmt.Sprintf(`
+		SELECT
+			id,
+			userId,
+			entityType,
+			entityId,
+			action,
+			oldValue,
+			newValue,
+			timestamp
+		FROM audit_log
+		WHERE userId = %s AND entityType = %s AND entityId = %s
+		ORDER BY seq;
+	`, stg.placeholder(1), stg.placeholder(2), stg.placeholder(3))
+	return stg.query(ctx, selectSQL, userId, entityType, entityId)
+}
+
+func (stg sqlAuditStorage) ForUser(ctx context.Context, userId string) (*[]AuditEntry, error) {
+	selectSQL := fmt.Sprintf(`
+		SELECT
+			id,
+			userId,
+			entityType,
+			entityId,
+			action,
+			oldValue,
+			newValue,
+			timestamp
+		FROM audit_log
+		WHERE userId = %s
+		ORDER BY seq;
+	`, stg.placeholder(1))
+	return stg.query(ctx, selectSQL, userId)
+}
+
+func (stg sqlAuditStorage) query(ctx context.Context, selectSQL string, args ...any) (*[]AuditEntry, error) {
+	rows, err := stg.DB.QueryContext(ctx, selectSQL, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	entries := make([]AuditEntry, 0)
+	for rows.Next() {
+		var entry AuditEntry
+		var oldValue, newValue []byte
+		err = rows.Scan(
+			&entry.Id,
+			&entry.UserId,
+			&entry.EntityType,
+			&entry.EntityId,
+			&entry.Action,
+			&oldValue,
+			&newValue,
+			&entry.Timestamp,
+		)
+		if err != nil {
+			return nil, err
+		}
+		if oldValue != nil {
+			entry.OldValue = json.RawMessage(oldValue)
+		}
+		if newValue != nil {
+			entry.NewValue = json.RawMessage(newValue)
+		}
+		entries = append(entries, entry)
+	}
+	return &entries, rows.Err()
+}
+
+func (stg sqlAuditStorage) DeleteAllForUser(ctx context.Context, userId string) error {
+	deleteSQL := `
+			DELETE FROM audit_log
+			WHERE userId = ` + stg.placeholder(1) + `;
+	`
+	_, deleteErr := stg.DB.ExecContext(ctx, deleteSQL, userId)
+	if deleteErr != nil {
+		return deleteErr
+	}
+	return nil
+}
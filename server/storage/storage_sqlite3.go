@@ -1,20 +1,24 @@
 package storage
 
 import (
+	"context"
 	"database/sql"
 	"encoding/json"
-	"time"
 
 	"github.com/google/uuid"
 	_ "github.com/mattn/go-sqlite3"
 )
 
 type Sqlite3ActivityStorage struct {
-	DB *sql.DB
+	DB sqlExecutor
+	// FullTextSearch is set when the FTS5 index is available
+	FullTextSearch bool
 }
 
-func (stg Sqlite3ActivityStorage) Create(activity Activity) (Activity, error) {
+func (stg Sqlite3ActivityStorage) Create(ctx context.Context, activity Activity) (Activity, error) {
 	newId := uuid.New()
+	activity.Version = 1
+	activity.UpdatedAt = versionTime(ctx)
 	insertSQL := `
 			INSERT INTO activities (
 				id,
@@ -26,7 +30,10 @@ func (stg Sqlite3ActivityStorage) Create(activity Activity) (Activity, error) {
 				dateTime,
 				timeRelevant,
 				completed,
-				notes
+				notes,
+				deletedAt,
+				version,
+				updatedAt
 			)
 			VALUES (
 				?,
@@ -38,6 +45,9 @@ func (stg Sqlite3ActivityStorage) Create(activity Activity) (Activity, error) {
 				?,
 				?,
 				?,
+				?,
+				?,
+				?,
 				?
 			);
 	`
@@ -45,7 +55,7 @@ func (stg Sqlite3ActivityStorage) Create(activity Activity) (Activity, error) {
 	if err != nil {
 		return activity, err
 	}
-	_, insertErr := stg.DB.Exec(insertSQL,
+	_, insertErr := stg.DB.ExecContext(ctx, insertSQL,
 		newId,
 		activity.UserId,
 		activity.RecurringActivityId,
@@ -56,6 +66,9 @@ func (stg Sqlite3ActivityStorage) Create(activity Activity) (Activity, error) {
 		activity.TimeRelevant,
 		activity.Completed,
 		activity.Notes,
+		activity.DeletedAt,
+		activity.Version,
+		activity.UpdatedAt,
 	)
 	if insertErr != nil {
 		return activity, insertErr
@@ -64,7 +77,7 @@ func (stg Sqlite3ActivityStorage) Create(activity Activity) (Activity, error) {
 	return activity, nil
 }
 
-func (stg Sqlite3ActivityStorage) Read(userId string, id uuid.UUID) (*Activity, error) {
+func (stg Sqlite3ActivityStorage) Read(ctx context.Context, userId string, id uuid.UUID) (*Activity, error) {
 	selectSQL := `
 			SELECT 
 				id,
@@ -76,11 +89,14 @@ func (stg Sqlite3ActivityStorage) Read(userId string, id uuid.UUID) (*Activity,
 				dateTime,
 				timeRelevant,
 				completed,
-				notes
+				notes,
+				deletedAt,
+				version,
+				updatedAt
 			FROM activities 
-			WHERE id = ?;
+			WHERE id = ? AND userId = ?;
 	`
-	rows, err := stg.DB.Query(selectSQL, id)
+	rows, err := stg.DB.QueryContext(ctx, selectSQL, id, userId)
 	if err != nil {
 		return nil, err
 	}
@@ -101,6 +117,9 @@ func (stg Sqlite3ActivityStorage) Read(userId string, id uuid.UUID) (*Activity,
 			&activity.TimeRelevant,
 			&activity.Completed,
 			&activity.Notes,
+			&activity.DeletedAt,
+			&activity.Version,
+			&activity.UpdatedAt,
 		)
 		if err != nil {
 			return nil, err
@@ -114,13 +133,8 @@ func (stg Sqlite3ActivityStorage) Read(userId string, id uuid.UUID) (*Activity,
 	return nil, nil
 }
 
-func (stg Sqlite3ActivityStorage) Query(query ActivityStorageQuery) (*[]Activity, error) {
-	var startTime *time.Time
-	var endTime *time.Time
-	if query.DateRange != nil {
-		startTime = &query.DateRange.Start
-		endTime = &query.DateRange.End
-	}
+func (stg Sqlite3ActivityStorage) Query(ctx context.Context, query ActivityStorageQuery) (*[]Activity, error) {
+	clauses, params := activityQuerySQL(query, sqlitePlaceholder)
 	selectSQL := `
 	SELECT 
 		id,
@@ -132,16 +146,13 @@ func (stg Sqlite3ActivityStorage) Query(query ActivityStorageQuery) (*[]Activity
 		dateTime,
 		timeRelevant,
 		completed,
-		notes
+		notes,
+		deletedAt,
+		version,
+		updatedAt
 	FROM activities 
-	WHERE userId = ?
-	AND (? IS NULL OR planId = ?)
-	AND (? IS NULL OR (dateTime > ? AND dateTime < ?));
-`
-	rows, err := stg.DB.Query(selectSQL,
-		query.UserId,
-		query.PlanId, query.PlanId,
-		startTime, startTime, endTime)
+	` + clauses
+	rows, err := stg.DB.QueryContext(ctx, selectSQL, params...)
 	if err != nil {
 		return nil, err
 	}
@@ -162,6 +173,9 @@ func (stg Sqlite3ActivityStorage) Query(query ActivityStorageQuery) (*[]Activity
 			&activity.TimeRelevant,
 			&activity.Completed,
 			&activity.Notes,
+			&activity.DeletedAt,
+			&activity.Version,
+			&activity.UpdatedAt,
 		)
 		if err != nil {
 			return nil, err
@@ -176,7 +190,7 @@ func (stg Sqlite3ActivityStorage) Query(query ActivityStorageQuery) (*[]Activity
 	return &activities, nil
 }
 
-func (stg Sqlite3ActivityStorage) Update(activity Activity) error {
+func (stg Sqlite3ActivityStorage) Update(ctx context.Context, activity Activity) error {
 	updateSQL := `
 			UPDATE activities
 			SET 
@@ -187,14 +201,17 @@ func (stg Sqlite3ActivityStorage) Update(activity Activity) error {
 				dateTime = ?,
 				timeRelevant = ?,
 				completed = ?,
-				notes = ?
-			WHERE id = ? AND userId = ?;
+				notes = ?,
+				deletedAt = ?,
+				version = version + 1,
+				updatedAt = ?
+			WHERE id = ? AND userId = ? AND version = ?;
 	`
 	jsonStr, err := json.Marshal(activity.Stages)
 	if err != nil {
 		return err
 	}
-	_, updateErr := stg.DB.Exec(updateSQL,
+	result, updateErr := stg.DB.ExecContext(ctx, updateSQL,
 		activity.RecurringActivityId,
 		activity.PlanId,
 		activity.Summary,
@@ -203,64 +220,165 @@ func (stg Sqlite3ActivityStorage) Update(activity Activity) error {
 		activity.TimeRelevant,
 		activity.Completed,
 		activity.Notes,
+		activity.DeletedAt,
+		versionTime(ctx),
 		activity.Id,
 		activity.UserId,
+		activity.Version,
 	)
 	if updateErr != nil {
 		return updateErr
 	}
-	return nil
+	return versionUpdated(ctx, stg.DB, result, `SELECT 1 FROM activities WHERE id = ? AND userId = ?;`, activity.Id, activity.UserId)
 }
 
-func (stg Sqlite3ActivityStorage) Delete(userId string, id uuid.UUID) error {
+func (stg Sqlite3ActivityStorage) Delete(ctx context.Context, userId string, id uuid.UUID) error {
 	deleteSQL := `
 			DELETE FROM activities
-			WHERE id = ?;
+			WHERE id = ? AND userId = ?;
 	`
-	_, deleteErr := stg.DB.Exec(deleteSQL, id)
+	result, deleteErr := stg.DB.ExecContext(ctx, deleteSQL, id, userId)
+	if deleteErr != nil {
+		return deleteErr
+	}
+	return rowDeleted(result)
+}
+
+func (stg Sqlite3ActivityStorage) DeleteForPlan(ctx context.Context, userId string, planId uuid.UUID) error {
+	deleteSQL := `
+			DELETE FROM activities
+			WHERE planId = ? AND userId = ?;
+	`
+	_, deleteErr := stg.DB.ExecContext(ctx, deleteSQL, planId, userId)
 	if deleteErr != nil {
 		return deleteErr
 	}
 	return nil
 }
 
-func (stg Sqlite3ActivityStorage) DeleteForPlan(userId string, planId uuid.UUID) error {
+func (stg Sqlite3ActivityStorage) DeleteAllForUser(ctx context.Context, userId string) error {
 	deleteSQL := `
 			DELETE FROM activities
-			WHERE planId = ?;
+			WHERE userId = ?;
 	`
-	_, deleteErr := stg.DB.Exec(deleteSQL, planId)
+	_, deleteErr := stg.DB.ExecContext(ctx, deleteSQL, userId)
 	if deleteErr != nil {
 		return deleteErr
 	}
 	return nil
 }
 
+func (stg Sqlite3ActivityStorage) Put(ctx context.Context, activity Activity) error {
+	upsertSQL := `
+			INSERT INTO activities (
+				id,
+				userId,
+				recurringActivityId,
+				planId,
+				summary,
+				stages,
+				dateTime,
+				timeRelevant,
+				completed,
+				notes,
+				deletedAt,
+				version,
+				updatedAt
+			)
+			VALUES (
+				?,
+				?,
+				?,
+				?,
+				?,
+				?,
+				?,
+				?,
+				?,
+				?,
+				?,
+				?,
+				?
+			)
+			ON CONFLICT (id) DO UPDATE SET
+				recurringActivityId = excluded.recurringActivityId,
+				planId = excluded.planId,
+				summary = excluded.summary,
+				stages = excluded.stages,
+				dateTime = excluded.dateTime,
+				timeRelevant = excluded.timeRelevant,
+				completed = excluded.completed,
+				notes = excluded.notes,
+				deletedAt = excluded.deletedAt,
+				version = excluded.version,
+				updatedAt = excluded.updatedAt
+			WHERE activities.userId = excluded.userId;
+	`
+	jsonStr, err := json.Marshal(activity.Stages)
+	if err != nil {
+		return err
+	}
+	result, upsertErr := stg.DB.ExecContext(ctx, upsertSQL,
+		activity.Id,
+		activity.UserId,
+		activity.RecurringActivityId,
+		activity.PlanId,
+		activity.Summary,
+		jsonStr,
+		activity.DateTime,
+		activity.TimeRelevant,
+		activity.Completed,
+		activity.Notes,
+		activity.DeletedAt,
+		activity.Version,
+		activity.UpdatedAt,
+	)
+	return upserted(result, upsertErr)
+}
+
+func (stg Sqlite3ActivityStorage) UserIds(ctx context.Context) ([]string, error) {
+	return sqlUserIds(ctx, stg.DB, `SELECT DISTINCT userId FROM activities;`)
+}
+
 type Sqlite3PlanStorage struct {
-	DB *sql.DB
+	DB sqlExecutor
 }
 
-func (stg Sqlite3PlanStorage) Create(plan Plan) (Plan, error) {
+func (stg Sqlite3PlanStorage) Create(ctx context.Context, plan Plan) (Plan, error) {
 	newId := uuid.New()
+	plan.Version = 1
+	plan.UpdatedAt = versionTime(ctx)
 	insertSQL := `
 			INSERT INTO plans (
 				id,
 				userId,
 				name,
-				active
+				active,
+				deletedAt,
+				archivedAt,
+				version,
+				updatedAt
 			)
 			VALUES (
+				?,
+				?,
+				?,
+				?,
 				?,
 				?,
 				?,
 				?
 			);
 	`
-	_, insertErr := stg.DB.Exec(insertSQL,
+	_, insertErr := stg.DB.ExecContext(ctx, insertSQL,
 		newId,
 		plan.UserId,
 		plan.Name,
 		plan.Active,
+		plan.DeletedAt,
+		plan.ArchivedAt,
+		plan.Version,
+		plan.UpdatedAt,
 	)
 	if insertErr != nil {
 		return plan, insertErr
@@ -269,17 +387,21 @@ func (stg Sqlite3PlanStorage) Create(plan Plan) (Plan, error) {
 	return plan, nil
 }
 
-func (stg Sqlite3PlanStorage) Read(userId string, id uuid.UUID) (*Plan, error) {
+func (stg Sqlite3PlanStorage) Read(ctx context.Context, userId string, id uuid.UUID) (*Plan, error) {
 	selectSQL := `
 			SELECT 
 				id,
 				userId,
 				name,
-				active
+				active,
+				deletedAt,
+				archivedAt,
+				version,
+				updatedAt
 			FROM plans 
-			WHERE id = ?;
+			WHERE id = ? AND userId = ?;
 	`
-	rows, err := stg.DB.Query(selectSQL, id)
+	rows, err := stg.DB.QueryContext(ctx, selectSQL, id, userId)
 	if err != nil {
 		return nil, err
 	}
@@ -292,6 +414,10 @@ func (stg Sqlite3PlanStorage) Read(userId string, id uuid.UUID) (*Plan, error) {
 			&plan.UserId,
 			&plan.Name,
 			&plan.Active,
+			&plan.DeletedAt,
+			&plan.ArchivedAt,
+			&plan.Version,
+			&plan.UpdatedAt,
 		)
 		if err != nil {
 			return nil, err
@@ -301,17 +427,21 @@ func (stg Sqlite3PlanStorage) Read(userId string, id uuid.UUID) (*Plan, error) {
 	return nil, nil
 }
 
-func (stg Sqlite3PlanStorage) Query(query PlanStorageQuery) (*[]Plan, error) {
+func (stg Sqlite3PlanStorage) Query(ctx context.Context, query PlanStorageQuery) (*[]Plan, error) {
 	selectSQL := `
 	SELECT 
 		id,
 		userId,
 		name,
-		active
+		active,
+		deletedAt,
+		archivedAt,
+		version,
+		updatedAt
 	FROM plans 
-	WHERE userId = ?;
+	WHERE userId = ? AND ` + planQueryConditionsSQL(query) + `;
 `
-	rows, err := stg.DB.Query(selectSQL, query.UserId)
+	rows, err := stg.DB.QueryContext(ctx, selectSQL, query.UserId)
 	if err != nil {
 		return nil, err
 	}
@@ -324,6 +454,10 @@ func (stg Sqlite3PlanStorage) Query(query PlanStorageQuery) (*[]Plan, error) {
 			&plan.UserId,
 			&plan.Name,
 			&plan.Active,
+			&plan.DeletedAt,
+			&plan.ArchivedAt,
+			&plan.Version,
+			&plan.UpdatedAt,
 		)
 		if err != nil {
 			return nil, err
@@ -333,84 +467,138 @@ func (stg Sqlite3PlanStorage) Query(query PlanStorageQuery) (*[]Plan, error) {
 	return &plans, nil
 }
 
-func (stg Sqlite3PlanStorage) Update(plan Plan) error {
+func (stg Sqlite3PlanStorage) Update(ctx context.Context, plan Plan) error {
 	insertSQL := `
 			UPDATE plans
 			SET 
-				userId = ?,
 				name = ?,
-				active = ?
-			WHERE id = ?;
+				active = ?,
+				deletedAt = ?,
+				archivedAt = ?,
+				version = version + 1,
+				updatedAt = ?
+			WHERE id = ? AND userId = ? AND version = ?;
 	`
-	_, updateErr := stg.DB.Exec(insertSQL,
-		plan.UserId,
+	result, updateErr := stg.DB.ExecContext(ctx, insertSQL,
 		plan.Name,
 		plan.Active,
+		plan.DeletedAt,
+		plan.ArchivedAt,
+		versionTime(ctx),
 		plan.Id,
+		plan.UserId,
+		plan.Version,
 	)
 	if updateErr != nil {
 		return updateErr
 	}
-	return nil
+	return versionUpdated(ctx, stg.DB, result, `SELECT 1 FROM plans WHERE id = ? AND userId = ?;`, plan.Id, plan.UserId)
 }
 
-func (stg Sqlite3PlanStorage) Delete(userId string, id uuid.UUID) error {
+func (stg Sqlite3PlanStorage) Delete(ctx context.Context, userId string, id uuid.UUID) error {
 	deleteSQL := `
 			DELETE FROM plans
-			WHERE id = ?;
+			WHERE id = ? AND userId = ?;
 	`
-	_, deleteErr := stg.DB.Exec(deleteSQL, id)
+	result, deleteErr := stg.DB.ExecContext(ctx, deleteSQL, id, userId)
+	if deleteErr != nil {
+		return deleteErr
+	}
+	return rowDeleted(result)
+}
+
+func (stg Sqlite3PlanStorage) DeleteAllForUser(ctx context.Context, userId string) error {
+	deleteSQL := `
+			DELETE FROM plans
+			WHERE userId = ?;
+	`
+	_, deleteErr := stg.DB.ExecContext(ctx, deleteSQL, userId)
 	if deleteErr != nil {
 		return deleteErr
 	}
 	return nil
 }
 
+func (stg Sqlite3PlanStorage) Put(ctx context.Context, plan Plan) error {
+	upsertSQL := `
+			INSERT INTO plans (
+				id,
+				userId,
+				name,
+				active,
+				deletedAt,
+				archivedAt,
+				version,
+				updatedAt
+			)
+			VALUES (
+				?,
+				?,
+				?,
+				?,
+				?,
+				?,
+				?,
+				?
+			)
+			ON CONFLICT (id) DO UPDATE SET
+				name = excluded.name,
+				active = excluded.active,
+				deletedAt = excluded.deletedAt,
+				archivedAt = excluded.archivedAt,
+				version = excluded.version,
+				updatedAt = excluded.updatedAt
+			WHERE plans.userId = excluded.userId;
+	`
+	result, upsertErr := stg.DB.ExecContext(ctx, upsertSQL,
+		plan.Id,
+		plan.UserId,
+		plan.Name,
+		plan.Active,
+		plan.DeletedAt,
+		plan.ArchivedAt,
+		plan.Version,
+		plan.UpdatedAt,
+	)
+	return upserted(result, upsertErr)
+}
+
+func (stg Sqlite3PlanStorage) UserIds(ctx context.Context) ([]string, error) {
+	return sqlUserIds(ctx, stg.DB, `SELECT DISTINCT userId FROM plans;`)
+}
+
 func getSqliteStorageClient(filepath string) (Storage, error) {
 	db, err := sql.Open("sqlite3", filepath)
 	if err != nil {
 		return Storage{}, err
 	}
-	// Do migrations
-	migrations := []string{`
-	CREATE TABLE IF NOT EXISTS activities (
-			id TEXT PRIMARY KEY,
-			recurringActivityId TEXT NULL,
-			userId TEXT,
-			planId TEXT NULL,
-			summary TEXT,
-			stages TEXT,
-			dateTime DATETIME,
-			timeRelevant BOOLEAN,
-			completed BOOLEAN,
-			notes TEXT
-	);`,
-		`CREATE TABLE IF NOT EXISTS plans (
-			id TEXT PRIMARY KEY,
-			userId TEXT,
-			name TEXT,
-			active BOOLEAN
-	);`,
-		`CREATE TABLE IF NOT EXISTS recurring_activities (
-			id TEXT PRIMARY KEY,
-			userId TEXT,
-			planId TEXT NULL,
-			summary TEXT,
-			stages TEXT,
-			recurrEachDays INT,
-			dateTimeStart DATETIME,
-			timeRelevant BOOLEAN
-	);`,
+	// A single connection keeps ":memory:" databases shared and serialises writers
+	db.SetMaxOpenConns(1)
+	err = migrateSqlite(db, sqliteMigrations)
+	if err != nil {
+		return Storage{}, err
 	}
-	for _, migration := range migrations {
-		_, err = db.Exec(migration)
-		if err != nil {
-			return Storage{}, err
-		}
+	fullTextSearch, err := setupSqliteSearch(db)
+	if err != nil {
+		return Storage{}, err
+	}
+	storageFor := func(exec sqlExecutor) Storage {
+		return sqliteStorageFor(exec, fullTextSearch)
+	}
+	strg := storageFor(db)
+	strg.UnitOfWork = sqlUnitOfWork{DB: db, storageFor: storageFor}
+	strg.closer = db
+	strg.backup = func(ctx context.Context, path string) error {
+		return sqliteBackup(ctx, db, filepath, path)
 	}
+	return withErrorKinds(withAudit(strg), sqliteError), nil
+}
+
+func sqliteStorageFor(exec sqlExecutor, fullTextSearch bool) Storage {
 	return Storage{
-		Activity:          Sqlite3ActivityStorage{DB: db},
-		RecurringActivity: Sqlite3RecurringActivityStorage{DB: db},
-		Plan:              Sqlite3PlanStorage{DB: db},
-	}, nil
+		Activity:          Sqlite3ActivityStorage{DB: exec, FullTextSearch: fullTextSearch},
+		RecurringActivity: Sqlite3RecurringActivityStorage{DB: exec},
+		Plan:              Sqlite3PlanStorage{DB: exec},
+		Audit:             sqlAuditStorage{DB: exec, placeholder: sqlitePlaceholder},
+	}
 }
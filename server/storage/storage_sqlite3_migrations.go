@@ -0,0 +1,182 @@
+package storage
+
+import (
+	"database/sql"
+)
+
+var sqliteMigrations = []schemaMigration{
+	{
+		Version:     1,
+		Description: "initial schema",
+		Statements: []string{`
+	CREATE TABLE IF NOT EXISTS activities (
+			id TEXT PRIMARY KEY,
+			recurringActivityId TEXT NULL,
+			userId TEXT,
+			planId TEXT NULL,
+			summary TEXT,
+			stages TEXT,
+			dateTime DATETIME,
+			timeRelevant BOOLEAN,
+			completed BOOLEAN,
+			notes TEXT
+	);`,
+			`CREATE TABLE IF NOT EXISTS plans (
+			id TEXT PRIMARY KEY,
+			userId TEXT,
+			name TEXT,
+			active BOOLEAN
+	);`,
+			`CREATE TABLE IF NOT EXISTS recurring_activities (
+			id TEXT PRIMARY KEY,
+			userId TEXT,
+			planId TEXT NULL,
+			summary TEXT,
+			stages TEXT,
+			recurrEachDays INT,
+			dateTimeStart DATETIME,
+			timeRelevant BOOLEAN
+	);`,
+		},
+	},
+	{
+		Version:     2,
+		Description: "trash",
+		Statements: []string{
+			`ALTER TABLE activities ADD COLUMN deletedAt DATETIME NULL;`,
+			`ALTER TABLE plans ADD COLUMN deletedAt DATETIME NULL;`,
+			`ALTER TABLE recurring_activities ADD COLUMN deletedAt DATETIME NULL;`,
+		},
+	},
+	{
+		Version:     3,
+		Description: "plan archiving",
+		Statements: []string{
+			`ALTER TABLE plans ADD COLUMN archivedAt DATETIME NULL;`,
+		},
+	},
+	{
+		Version:     4,
+		Description: "versioning",
+		Statements: []string{
+			`ALTER TABLE activities ADD COLUMN version INTEGER NOT NULL DEFAULT 1;`,
+			`ALTER TABLE activities ADD COLUMN updatedAt DATETIME NULL;`,
+			`UPDATE activities SET updatedAt = CURRENT_TIMESTAMP;`,
+			`ALTER TABLE plans ADD COLUMN version INTEGER NOT NULL DEFAULT 1;`,
+			`ALTER TABLE plans ADD COLUMN updatedAt DATETIME NULL;`,
+			`UPDATE plans SET updatedAt = CURRENT_TIMESTAMP;`,
+			`ALTER TABLE recurring_activities ADD COLUMN version INTEGER NOT NULL DEFAULT 1;`,
+			`ALTER TABLE recurring_activities ADD COLUMN updatedAt DATETIME NULL;`,
+			`UPDATE recurring_activities SET updatedAt = CURRENT_TIMESTAMP;`,
+		},
+	},
+	{
+		Version:     5,
+		Description: "audit log",
+		Statements: []string{
+			`CREATE TABLE IF NOT EXISTS audit_log (
+			seq INTEGER PRIMARY KEY AUTOINCREMENT,
+			id TEXT NOT NULL UNIQUE,
+			userId TEXT NOT NULL,
+			entityType TEXT NOT NULL,
+			entityId TEXT NOT NULL,
+			action TEXT NOT NULL,
+			oldValue TEXT NULL,
+			newValue TEXT NULL,
+			timestamp DATETIME NOT NULL
+	);`,
+			`CREATE INDEX IF NOT EXISTS audit_log_user_entity ON audit_log (userId, entityId, seq);`,
+		},
+	},
+	{
+		// The stages column stays the source for reads, triggers keep the
+		// stage tables in step with it for queries
+		Version:     6,
+		Description: "stage tables",
+		Statements: []string{
+			`CREATE TABLE IF NOT EXISTS activity_stages (
+			activityId TEXT NOT NULL,
+			position INTEGER NOT NULL,
+			stageOrder INTEGER,
+			description TEXT,
+			repetitions INTEGER,
+			completed BOOLEAN,
+			PRIMARY KEY (activityId, position)
+	);`,
+			`CREATE TABLE IF NOT EXISTS activity_stage_metrics (
+			activityId TEXT NOT NULL,
+			stagePosition INTEGER NOT NULL,
+			position INTEGER NOT NULL,
+			amount INTEGER,
+			unit TEXT,
+			PRIMARY KEY (activityId, stagePosition, position)
+	);`,
+			`CREATE INDEX IF NOT EXISTS activity_stage_metrics_unit ON activity_stage_metrics (unit, activityId);`,
+			`CREATE TRIGGER IF NOT EXISTS activity_stages_insert AFTER INSERT ON activities BEGIN
+			` + sqliteInsertStagesSQL("NEW") + `
+	END;`,
+			`CREATE TRIGGER IF NOT EXISTS activity_stages_update AFTER UPDATE OF stages ON activities BEGIN
+			DELETE FROM activity_stages WHERE activityId = OLD.id;
+			DELETE FROM activity_stage_metrics WHERE activityId = OLD.id;
+			` + sqliteInsertStagesSQL("NEW") + `
+	END;`,
+			`CREATE TRIGGER IF NOT EXISTS activity_stages_delete AFTER DELETE ON activities BEGIN
+			DELETE FROM activity_stages WHERE activityId = OLD.id;
+			DELETE FROM activity_stage_metrics WHERE activityId = OLD.id;
+	END;`,
+			sqliteInsertStagesSQL("activities"),
+		},
+	},
+	{
+		Version:     7,
+		Description: "recurrence rules",
+		Statements: []string{
+			`ALTER TABLE recurring_activities ADD COLUMN rrule TEXT NOT NULL DEFAULT '';`,
+		},
+	},
+}
+
+// sqliteInsertStagesSQL copies the stages and metrics of row, the NEW row in
+// a trigger or the activities table to backfill, into the stage tables.
+func sqliteInsertStagesSQL(row string) string {
+	from := ""
+	if row != "NEW" {
+		from = row + ", "
+	}
+	return `INSERT INTO activity_stages (activityId, position, stageOrder, description, repetitions, completed)
+			SELECT ` + row + `.id, s.key, json_extract(s.value, '$.order'), json_extract(s.value, '$.description'),
+				json_extract(s.value, '$.repetitions'), json_extract(s.value, '$.completed')
+			FROM ` + from + `json_each(` + row + `.stages) s
+			WHERE s.type = 'object';
+			INSERT INTO activity_stage_metrics (activityId, stagePosition, position, amount, unit)
+			SELECT ` + row + `.id, s.key, m.key, json_extract(m.value, '$.amount'), json_extract(m.value, '$.unit')
+			FROM ` + from + `json_each(` + row + `.stages) s, json_each(s.value, '$.metrics') m
+			WHERE s.type = 'object' AND m.type = 'object';`
+}
+
+var sqliteMigrationDialect = sqlMigrationDialect{
+	CreateVersionTable: `
+	CREATE TABLE IF NOT EXISTS schema_version (
+			version INTEGER PRIMARY KEY,
+			description TEXT,
+			checksum TEXT,
+			appliedAt DATETIME
+	);`,
+	InsertVersion: `
+			INSERT INTO schema_version (
+				version,
+				description,
+				checksum,
+				appliedAt
+			)
+			VALUES (
+				?,
+				?,
+				?,
+				?
+			);`,
+}
+
+func migrateSqlite(db *sql.DB, migrations []schemaMigration) error {
+	return migrateSqlDatabase(db, sqliteMigrationDialect, migrations)
+}
@@ -0,0 +1,151 @@
+package storage
+
+import (
+	"database/sql"
+	"testing"
+)
+
+func openMigrationTestDb(t *testing.T) *sql.DB {
+	db, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatalf("Error opening database: %s", err)
+	}
+	db.SetMaxOpenConns(1)
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+func TestSqliteMigrationsApplyOnce(t *testing.T) {
+	db := openMigrationTestDb(t)
+	migrations := []schemaMigration{
+		{Version: 1, Description: "create", Statements: []string{`CREATE TABLE things (id TEXT PRIMARY KEY);`}},
+		{Version: 2, Description: "alter", Statements: []string{`ALTER TABLE things ADD COLUMN name TEXT;`}},
+	}
+	err := migrateSqlite(db, migrations)
+	if err != nil {
+		t.Fatalf("Error migrating: %s", err)
+	}
+	// Re-running must be a no-op, the ALTER TABLE would fail if re-applied
+	err = migrateSqlite(db, migrations)
+	if err != nil {
+		t.Fatalf("Error re-running migrations: %s", err)
+	}
+	var version int
+	err = db.QueryRow(`SELECT MAX(version) FROM schema_version;`).Scan(&version)
+	if err != nil {
+		t.Fatalf("Error reading schema version: %s", err)
+	}
+	if version != 2 {
+		t.Errorf("Expected schema version 2, got %d", version)
+	}
+	_, err = db.Exec(`INSERT INTO things (id, name) VALUES ('a', 'b');`)
+	if err != nil {
+		t.Errorf("Migrated column missing: %s", err)
+	}
+}
+
+func TestSqliteMigrationsUpgradeExistingDatabase(t *testing.T) {
+	db := openMigrationTestDb(t)
+	initial := []schemaMigration{
+		{Version: 1, Description: "create", Statements: []string{`CREATE TABLE things (id TEXT PRIMARY KEY);`}},
+	}
+	err := migrateSqlite(db, initial)
+	if err != nil {
+		t.Fatalf("Error migrating: %s", err)
+	}
+	upgraded := append(initial, schemaMigration{Version: 2, Description: "alter", Statements: []string{`ALTER TABLE things ADD COLUMN name TEXT;`}})
+	err = migrateSqlite(db, upgraded)
+	if err != nil {
+		t.Fatalf("Error upgrading: %s", err)
+	}
+	_, err = db.Exec(`INSERT INTO things (id, name) VALUES ('a', 'b');`)
+	if err != nil {
+		t.Errorf("Migrated column missing: %s", err)
+	}
+}
+
+func TestSqliteMigrationsRejectEditedMigration(t *testing.T) {
+	db := openMigrationTestDb(t)
+	err := migrateSqlite(db, []schemaMigration{
+		{Version: 1, Description: "create", Statements: []string{`CREATE TABLE things (id TEXT PRIMARY KEY);`}},
+	})
+	if err != nil {
+		t.Fatalf("Error migrating: %s", err)
+	}
+	err = migrateSqlite(db, []schemaMigration{
+		{Version: 1, Description: "create", Statements: []string{`CREATE TABLE things (id INTEGER PRIMARY KEY);`}},
+	})
+	if err == nil {
+		t.Errorf("Expected checksum mismatch error")
+	}
+}
+
+func TestSqliteMigrationsRejectNewerDatabase(t *testing.T) {
+	db := openMigrationTestDb(t)
+	err := migrateSqlite(db, []schemaMigration{
+		{Version: 1, Description: "create", Statements: []string{`CREATE TABLE things (id TEXT PRIMARY KEY);`}},
+		{Version: 2, Description: "alter", Statements: []string{`ALTER TABLE things ADD COLUMN name TEXT;`}},
+	})
+	if err != nil {
+		t.Fatalf("Error migrating: %s", err)
+	}
+	err = migrateSqlite(db, []schemaMigration{
+		{Version: 1, Description: "create", Statements: []string{`CREATE TABLE things (id TEXT PRIMARY KEY);`}},
+	})
+	if err == nil {
+		t.Errorf("Expected error for database newer than build")
+	}
+}
+
+func TestSqliteMigrationsRollBackFailedStep(t *testing.T) {
+	db := openMigrationTestDb(t)
+	err := migrateSqlite(db, []schemaMigration{
+		{Version: 1, Description: "broken", Statements: []string{
+			`CREATE TABLE things (id TEXT PRIMARY KEY);`,
+			`NOT VALID SQL;`,
+		}},
+	})
+	if err == nil {
+		t.Fatalf("Expected migration failure")
+	}
+	var count int
+	err = db.QueryRow(`SELECT COUNT(*) FROM sqlite_master WHERE name = 'things';`).Scan(&count)
+	if err != nil {
+		t.Fatalf("Error reading schema: %s", err)
+	}
+	if count != 0 {
+		t.Errorf("Failed migration was not rolled back")
+	}
+}
+
+func TestSqliteStageTablesBackfill(t *testing.T) {
+	db := openMigrationTestDb(t)
+	err := migrateSqlite(db, sqliteMigrations[:5])
+	if err != nil {
+		t.Fatalf("Error migrating: %s", err)
+	}
+	_, err = db.Exec(`INSERT INTO activities (id, userId, stages) VALUES
+		('a', 'user', '[{"order":0,"repetitions":4,"metrics":[{"amount":400,"unit":"m"},{"amount":2,"unit":"min"}]},{"order":1,"metrics":[{"amount":10,"unit":"min"}]}]'),
+		('b', 'user', 'null');`)
+	if err != nil {
+		t.Fatalf("Error inserting activity: %s", err)
+	}
+	err = migrateSqlite(db, sqliteMigrations)
+	if err != nil {
+		t.Fatalf("Error migrating: %s", err)
+	}
+	var stages, minutes int
+	err = db.QueryRow(`SELECT COUNT(*) FROM activity_stages;`).Scan(&stages)
+	if err != nil {
+		t.Fatalf("Error reading stages: %s", err)
+	}
+	err = db.QueryRow(`SELECT ` + metricTotalSQL + ` FROM activity_stage_metrics m
+		JOIN activity_stages s ON s.activityId = m.activityId AND s.position = m.stagePosition
+		WHERE m.activityId = 'a' AND m.unit = 'min';`).Scan(&minutes)
+	if err != nil {
+		t.Fatalf("Error reading metrics: %s", err)
+	}
+	if stages != 2 || minutes != 18 {
+		t.Errorf("Expected 2 stages and 18 minutes, got %d and %d", stages, minutes)
+	}
+}
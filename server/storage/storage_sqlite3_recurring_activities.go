@@ -1,7 +1,7 @@
 package storage
 
 import (
-	"database/sql"
+	"context"
 	"encoding/json"
 
 	"github.com/google/uuid"
@@ -9,11 +9,13 @@ import (
 )
 
 type Sqlite3RecurringActivityStorage struct {
-	DB *sql.DB
+	DB sqlExecutor
 }
 
-func (stg Sqlite3RecurringActivityStorage) Create(activity RecurringActivity) (RecurringActivity, error) {
+func (stg Sqlite3RecurringActivityStorage) Create(ctx context.Context, activity RecurringActivity) (RecurringActivity, error) {
 	newId := uuid.New()
+	activity.Version = 1
+	activity.UpdatedAt = versionTime(ctx)
 	insertSQL := `
 			INSERT INTO recurring_activities (
 				id,
@@ -22,8 +24,12 @@ func (stg Sqlite3RecurringActivityStorage) Create(activity RecurringActivity) (R
 				summary,
 				stages,
 				recurrEachDays,
+				rrule,
 				dateTimeStart,
-				timeRelevant
+				timeRelevant,
+				deletedAt,
+				version,
+				updatedAt
 			)
 			VALUES (
 				?,
@@ -33,6 +39,10 @@ func (stg Sqlite3RecurringActivityStorage) Create(activity RecurringActivity) (R
 				?,
 				?,
 				?,
+				?,
+				?,
+				?,
+				?,
 				?
 			);
 	`
@@ -40,15 +50,19 @@ func (stg Sqlite3RecurringActivityStorage) Create(activity RecurringActivity) (R
 	if err != nil {
 		return activity, err
 	}
-	_, insertErr := stg.DB.Exec(insertSQL,
+	_, insertErr := stg.DB.ExecContext(ctx, insertSQL,
 		newId,
 		activity.UserId,
 		activity.PlanId,
 		activity.Summary,
 		jsonStr,
 		activity.RecurrEachDays,
+		activity.RRule,
 		activity.DateTimeStart,
 		activity.TimeRelevant,
+		activity.DeletedAt,
+		activity.Version,
+		activity.UpdatedAt,
 	)
 	if insertErr != nil {
 		return activity, insertErr
@@ -57,7 +71,7 @@ func (stg Sqlite3RecurringActivityStorage) Create(activity RecurringActivity) (R
 	return activity, nil
 }
 
-func (stg Sqlite3RecurringActivityStorage) Read(userId string, id uuid.UUID) (*RecurringActivity, error) {
+func (stg Sqlite3RecurringActivityStorage) Read(ctx context.Context, userId string, id uuid.UUID) (*RecurringActivity, error) {
 	selectSQL := `
 			SELECT 
 				id,
@@ -66,12 +80,16 @@ func (stg Sqlite3RecurringActivityStorage) Read(userId string, id uuid.UUID) (*R
 				summary,
 				stages,
 				recurrEachDays,
+				rrule,
 				dateTimeStart,
-				timeRelevant
+				timeRelevant,
+				deletedAt,
+				version,
+				updatedAt
 			FROM recurring_activities 
-			WHERE id = ?;
+			WHERE id = ? AND userId = ?;
 	`
-	rows, err := stg.DB.Query(selectSQL, id)
+	rows, err := stg.DB.QueryContext(ctx, selectSQL, id, userId)
 	if err != nil {
 		return nil, err
 	}
@@ -88,8 +106,12 @@ func (stg Sqlite3RecurringActivityStorage) Read(userId string, id uuid.UUID) (*R
 			&activity.Summary,
 			&rawStages,
 			&activity.RecurrEachDays,
+			&activity.RRule,
 			&activity.DateTimeStart,
 			&activity.TimeRelevant,
+			&activity.DeletedAt,
+			&activity.Version,
+			&activity.UpdatedAt,
 		)
 		if err != nil {
 			return nil, err
@@ -103,7 +125,7 @@ func (stg Sqlite3RecurringActivityStorage) Read(userId string, id uuid.UUID) (*R
 	return nil, nil
 }
 
-func (stg Sqlite3RecurringActivityStorage) Query(query RecurringActivityStorageQuery) (*[]RecurringActivity, error) {
+func (stg Sqlite3RecurringActivityStorage) Query(ctx context.Context, query RecurringActivityStorageQuery) (*[]RecurringActivity, error) {
 	selectSQL := `
 	SELECT 
 		id,
@@ -112,13 +134,18 @@ func (stg Sqlite3RecurringActivityStorage) Query(query RecurringActivityStorageQ
 		summary,
 		stages,
 		recurrEachDays,
+		rrule,
 		dateTimeStart,
-		timeRelevant
+		timeRelevant,
+		deletedAt,
+		version,
+		updatedAt
 	FROM recurring_activities 
 	WHERE userId = ?
-	AND (? IS NULL OR planId = ?);
+	AND (? IS NULL OR planId = ?)
+	AND ` + trashedSQL(query.Trashed) + `;
 `
-	rows, err := stg.DB.Query(selectSQL,
+	rows, err := stg.DB.QueryContext(ctx, selectSQL,
 		query.UserId,
 		query.PlanId, query.PlanId)
 	if err != nil {
@@ -137,8 +164,12 @@ func (stg Sqlite3RecurringActivityStorage) Query(query RecurringActivityStorageQ
 			&activity.Summary,
 			&rawStages,
 			&activity.RecurrEachDays,
+			&activity.RRule,
 			&activity.DateTimeStart,
 			&activity.TimeRelevant,
+			&activity.DeletedAt,
+			&activity.Version,
+			&activity.UpdatedAt,
 		)
 		if err != nil {
 			return nil, err
@@ -153,7 +184,7 @@ func (stg Sqlite3RecurringActivityStorage) Query(query RecurringActivityStorageQ
 	return &activities, nil
 }
 
-func (stg Sqlite3RecurringActivityStorage) Update(activity RecurringActivity) error {
+func (stg Sqlite3RecurringActivityStorage) Update(ctx context.Context, activity RecurringActivity) error {
 	updateSQL := `
 			UPDATE recurring_activities
 			SET 
@@ -161,50 +192,138 @@ func (stg Sqlite3RecurringActivityStorage) Update(activity RecurringActivity) er
 				summary = ?,
 				stages = ?,
 				recurrEachDays = ?,
+				rrule = ?,
 				dateTimeStart = ?,
-				timeRelevant = ?
-			WHERE userId = ? AND id = ?;
+				timeRelevant = ?,
+				deletedAt = ?,
+				version = version + 1,
+				updatedAt = ?
+			WHERE userId = ? AND id = ? AND version = ?;
 	`
 	jsonStr, err := json.Marshal(activity.Stages)
 	if err != nil {
 		return err
 	}
-	_, updateErr := stg.DB.Exec(updateSQL,
+	result, updateErr := stg.DB.ExecContext(ctx, updateSQL,
 		activity.PlanId,
 		activity.Summary,
 		jsonStr,
 		activity.RecurrEachDays,
+		activity.RRule,
 		activity.DateTimeStart,
 		activity.TimeRelevant,
+		activity.DeletedAt,
+		versionTime(ctx),
 		activity.UserId,
 		activity.Id,
+		activity.Version,
 	)
 	if updateErr != nil {
 		return updateErr
 	}
-	return nil
+	return versionUpdated(ctx, stg.DB, result, `SELECT 1 FROM recurring_activities WHERE id = ? AND userId = ?;`, activity.Id, activity.UserId)
+}
+
+func (stg Sqlite3RecurringActivityStorage) Delete(ctx context.Context, userId string, id uuid.UUID) error {
+	deleteSQL := `
+			DELETE FROM recurring_activities
+			WHERE id = ? AND userId = ?;
+	`
+	result, deleteErr := stg.DB.ExecContext(ctx, deleteSQL, id, userId)
+	if deleteErr != nil {
+		return deleteErr
+	}
+	return rowDeleted(result)
 }
 
-func (stg Sqlite3RecurringActivityStorage) Delete(userId string, id uuid.UUID) error {
+func (stg Sqlite3RecurringActivityStorage) DeleteForPlan(ctx context.Context, userId string, planId uuid.UUID) error {
 	deleteSQL := `
 			DELETE FROM recurring_activities
-			WHERE id = ?;
+			WHERE planId = ? AND userId = ?;
 	`
-	_, deleteErr := stg.DB.Exec(deleteSQL, id)
+	_, deleteErr := stg.DB.ExecContext(ctx, deleteSQL, planId, userId)
 	if deleteErr != nil {
 		return deleteErr
 	}
 	return nil
 }
 
-func (stg Sqlite3RecurringActivityStorage) DeleteForPlan(userId string, planId uuid.UUID) error {
+func (stg Sqlite3RecurringActivityStorage) DeleteAllForUser(ctx context.Context, userId string) error {
 	deleteSQL := `
 			DELETE FROM recurring_activities
-			WHERE planId = ?;
+			WHERE userId = ?;
 	`
-	_, deleteErr := stg.DB.Exec(deleteSQL, planId)
+	_, deleteErr := stg.DB.ExecContext(ctx, deleteSQL, userId)
 	if deleteErr != nil {
 		return deleteErr
 	}
 	return nil
 }
+
+func (stg Sqlite3RecurringActivityStorage) Put(ctx context.Context, activity RecurringActivity) error {
+	upsertSQL := `
+			INSERT INTO recurring_activities (
+				id,
+				userId,
+				planId,
+				summary,
+				stages,
+				recurrEachDays,
+				rrule,
+				dateTimeStart,
+				timeRelevant,
+				deletedAt,
+				version,
+				updatedAt
+			)
+			VALUES (
+				?,
+				?,
+				?,
+				?,
+				?,
+				?,
+				?,
+				?,
+				?,
+				?,
+				?,
+				?
+			)
+			ON CONFLICT (id) DO UPDATE SET
+				planId = excluded.planId,
+				summary = excluded.summary,
+				stages = excluded.stages,
+				recurrEachDays = excluded.recurrEachDays,
+				rrule = excluded.rrule,
+				dateTimeStart = excluded.dateTimeStart,
+				timeRelevant = excluded.timeRelevant,
+				deletedAt = excluded.deletedAt,
+				version = excluded.version,
+				updatedAt = excluded.updatedAt
+			WHERE recurring_activities.userId = excluded.userId;
+	`
+	jsonStr, err := json.Marshal(activity.Stages)
+	if err != nil {
+		return err
+	}
+	result, upsertErr := stg.DB.ExecContext(ctx, upsertSQL,
+		activity.Id,
+		activity.UserId,
+		activity.PlanId,
+		activity.Summary,
+		jsonStr,
+		activity.RecurrEachDays,
+		activity.RRule,
+		activity.DateTimeStart,
+		activity.TimeRelevant,
+		activity.DeletedAt,
+		activity.Version,
+		activity.UpdatedAt,
+	)
+	return upserted(result, upsertErr)
+}
+
+func (stg Sqlite3RecurringActivityStorage) UserIds(ctx context.Context) ([]string, error) {
+	return sqlUserIds(ctx, stg.DB, `SELECT DISTINCT userId FROM recurring_activities;`)
+}
@@ -0,0 +1,229 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"strings"
+
+	"github.com/google/uuid"
+)
+
+// The FTS5 index lives outside the versioned migrations because FTS5 is only
+// compiled in with the sqlite_fts5 build tag. Builds without it drop the
+// triggers, which would otherwise fail every write, and search with LIKE
+// instead. The index is rebuilt whenever FTS5 comes back to a database.
+var sqliteSearchSetup = []string{
+	`CREATE VIRTUAL TABLE IF NOT EXISTS activities_fts USING fts5(
+			activityId UNINDEXED,
+			summary,
+			notes,
+			stages
+	);`,
+	`CREATE TRIGGER IF NOT EXISTS activities_fts_insert AFTER INSERT ON activities BEGIN
+			INSERT INTO activities_fts (activityId, summary, notes, stages)
+			VALUES (NEW.id, NEW.summary, NEW.notes, ` + sqliteStageDescriptionsSQL("NEW.stages") + `);
+	END;`,
+	`CREATE TRIGGER IF NOT EXISTS activities_fts_update AFTER UPDATE ON activities BEGIN
+			DELETE FROM activities_fts WHERE activityId = OLD.id;
+			INSERT INTO activities_fts (activityId, summary, notes, stages)
+			VALUES (NEW.id, NEW.summary, NEW.notes, ` + sqliteStageDescriptionsSQL("NEW.stages") + `);
+	END;`,
+	`CREATE TRIGGER IF NOT EXISTS activities_fts_delete AFTER DELETE ON activities BEGIN
+			DELETE FROM activities_fts WHERE activityId = OLD.id;
+	END;`,
+}
+
+var sqliteSearchTriggers = []string{
+	"activities_fts_insert",
+	"activities_fts_update",
+	"activities_fts_delete",
+}
+
+func sqliteStageDescriptionsSQL(column string) string {
+	return `(SELECT group_concat(json_extract(value, '$.description'), ' ') FROM json_each(` + column + `))`
+}
+
+// setupSqliteSearch prepares the full text index and reports whether FTS5 is
+// available to this build.
+func setupSqliteSearch(db *sql.DB) (bool, error) {
+	_, err := db.Exec(`CREATE VIRTUAL TABLE IF NOT EXISTS temp.fts5_probe USING fts5(probe);`)
+	if err != nil {
+		if !strings.Contains(err.Error(), "no such module") {
+			return false, err
+		}
+		for _, trigger := range sqliteSearchTriggers {
+			_, err = db.Exec(`DROP TRIGGER IF EXISTS ` + trigger + `;`)
+			if err != nil {
+				return false, err
+			}
+		}
+		return false, nil
+	}
+	_, err = db.Exec(`DROP TABLE temp.fts5_probe;`)
+	if err != nil {
+		return false, err
+	}
+
+	var triggers int
+	err = db.QueryRow(`SELECT count(*) FROM sqlite_master WHERE type = 'trigger' AND name IN (?, ?, ?);`,
+		sqliteSearchTriggers[0], sqliteSearchTriggers[1], sqliteSearchTriggers[2]).Scan(&triggers)
+	if err != nil {
+		return false, err
+	}
+	tx, err := db.Begin()
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+	for _, statement := range sqliteSearchSetup {
+		_, err = tx.Exec(statement)
+		if err != nil {
+			return false, err
+		}
+	}
+	if triggers != len(sqliteSearchTriggers) {
+		_, err = tx.Exec(`DELETE FROM activities_fts;`)
+		if err != nil {
+			return false, err
+		}
+		_, err = tx.Exec(`
+			INSERT INTO activities_fts (activityId, summary, notes, stages)
+			SELECT id, summary, notes, ` + sqliteStageDescriptionsSQL("stages") + `
+			FROM activities;`)
+		if err != nil {
+			return false, err
+		}
+	}
+	return true, tx.Commit()
+}
+
+func (stg Sqlite3ActivityStorage) Search(ctx context.Context, query ActivitySearchQuery) (*[]Activity, error) {
+	terms := searchTerms(query.Text)
+	if len(terms) == 0 {
+		activities := make([]Activity, 0)
+		return &activities, nil
+	}
+	if !stg.FullTextSearch {
+		return stg.searchWithLike(ctx, query, terms)
+	}
+	quoted := make([]string, len(terms))
+	for i, term := range terms {
+		quoted[i] = `"` + term + `"`
+	}
+	selectSQL := `
+	SELECT 
+		a.id,
+		a.userId,
+		a.recurringActivityId,
+		a.planId,
+		a.summary,
+		a.stages,
+		a.dateTime,
+		a.timeRelevant,
+		a.completed,
+		a.notes,
+		a.version,
+		a.updatedAt
+	FROM activities_fts f
+	JOIN activities a ON a.id = f.activityId
+	WHERE activities_fts MATCH ? AND a.userId = ? AND a.deletedAt IS NULL;
+`
+	rows, err := stg.DB.QueryContext(ctx, selectSQL, strings.Join(quoted, " OR "), query.UserId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	candidates, err := scanSqliteActivities(rows)
+	if err != nil {
+		return nil, err
+	}
+	// The index only finds the candidates, they are ranked like on the other
+	// backends rather than by bm25
+	scores := make(map[uuid.UUID]int, len(candidates))
+	for _, activity := range candidates {
+		scores[activity.Id] = activitySearchScore(activity, terms)
+	}
+	activities := rankSearchResults(candidates, scores, query.Limit)
+	return &activities, nil
+}
+
+// searchWithLike narrows the candidates in SQL and scores them in Go with the
+// same weights as the other backends.
+func (stg Sqlite3ActivityStorage) searchWithLike(ctx context.Context, query ActivitySearchQuery, terms []string) (*[]Activity, error) {
+	params := []interface{}{query.UserId}
+	conditions := make([]string, len(terms))
+	for i, term := range terms {
+		pattern := "%" + term + "%"
+		conditions[i] = "summary LIKE ? OR notes LIKE ? OR stages LIKE ?"
+		params = append(params, pattern, pattern, pattern)
+	}
+	selectSQL := `
+	SELECT 
+		id,
+		userId,
+		recurringActivityId,
+		planId,
+		summary,
+		stages,
+		dateTime,
+		timeRelevant,
+		completed,
+		notes,
+		version,
+		updatedAt
+	FROM activities 
+	WHERE userId = ? AND deletedAt IS NULL AND (` + strings.Join(conditions, " OR ") + `);
+`
+	rows, err := stg.DB.QueryContext(ctx, selectSQL, params...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	candidates, err := scanSqliteActivities(rows)
+	if err != nil {
+		return nil, err
+	}
+	activities := make([]Activity, 0)
+	scores := make(map[uuid.UUID]int)
+	for _, activity := range candidates {
+		score := activitySearchScore(activity, terms)
+		if score > 0 {
+			scores[activity.Id] = score
+			activities = append(activities, activity)
+		}
+	}
+	activities = rankSearchResults(activities, scores, query.Limit)
+	return &activities, nil
+}
+
+func scanSqliteActivities(rows *sql.Rows) ([]Activity, error) {
+	activities := make([]Activity, 0)
+	for rows.Next() {
+		var activity Activity
+		rawStages := "[]"
+		err := rows.Scan(
+			&activity.Id,
+			&activity.UserId,
+			&activity.RecurringActivityId,
+			&activity.PlanId,
+			&activity.Summary,
+			&rawStages,
+			&activity.DateTime,
+			&activity.TimeRelevant,
+			&activity.Completed,
+			&activity.Notes,
+			&activity.Version,
+			&activity.UpdatedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+		err = json.Unmarshal([]byte(rawStages), &activity.Stages)
+		if err != nil {
+			return nil, err
+		}
+		activities = append(activities, activity)
+	}
+	return activities, rows.Err()
+}
@@ -0,0 +1,48 @@
+package storage
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestSqliteSearchIndexRebuildsAfterMissedWrites(t *testing.T) {
+	ctx := context.Background()
+	db := openMigrationTestDb(t)
+	err := migrateSqlite(db, sqliteMigrations)
+	if err != nil {
+		t.Fatalf("Error migrating: %s", err)
+	}
+	fullTextSearch, err := setupSqliteSearch(db)
+	if err != nil {
+		t.Fatalf("Error setting up search: %s", err)
+	}
+	if !fullTextSearch {
+		t.Skip("FTS5 is not compiled in, run with -tags sqlite_fts5")
+	}
+	// Simulate a build without FTS5 writing to the database
+	for _, trigger := range sqliteSearchTriggers {
+		_, err = db.Exec(`DROP TRIGGER ` + trigger + `;`)
+		if err != nil {
+			t.Fatalf("Error dropping trigger: %s", err)
+		}
+	}
+	storage := Sqlite3ActivityStorage{DB: db, FullTextSearch: true}
+	_, err = storage.Create(ctx, Activity{UserId: "user", Summary: "Missed hill repeats", DateTime: time.Now()})
+	if err != nil {
+		t.Fatalf("Error creating activity: %s", err)
+	}
+	found, _ := storage.Search(ctx, ActivitySearchQuery{UserId: "user", Text: "hill"})
+	if len(*found) != 0 {
+		t.Fatalf("Expected the unindexed activity to be missing, got %d", len(*found))
+	}
+
+	_, err = setupSqliteSearch(db)
+	if err != nil {
+		t.Fatalf("Error setting up search again: %s", err)
+	}
+	found, _ = storage.Search(ctx, ActivitySearchQuery{UserId: "user", Text: "hill"})
+	if len(*found) != 1 {
+		t.Errorf("Expected the rebuilt index to find 1 activity, got %d", len(*found))
+	}
+}
@@ -0,0 +1,668 @@
+// Package storagetest is a conformance suite for storage implementations. It
+// checks the behaviour the handlers rely on, so every backend can be held to
+// the same contract:
+//
+//	func TestConformance(t *testing.T) {
+//		storagetest.Run(t, storage.NewMemoryStorage())
+//	}
+//
+// Each test works with users of its own, so the storage may hold other data
+// and may be shared between runs.
+package storagetest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"planner/storage"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Run runs every conformance test against strg as subtests of t
+func Run(t *testing.T, strg storage.Storage) {
+	t.Run("PlanCRUD", func(t *testing.T) { testPlanCRUD(t, strg) })
+	t.Run("ActivityCRUD", func(t *testing.T) { testActivityCRUD(t, strg) })
+	t.Run("RecurringActivityCRUD", func(t *testing.T) { testRecurringActivityCRUD(t, strg) })
+	t.Run("PlanQueryFilters", func(t *testing.T) { testPlanQueryFilters(t, strg) })
+	t.Run("ActivityQueryFilters", func(t *testing.T) { testActivityQueryFilters(t, strg) })
+	t.Run("RecurringActivityQueryFilters", func(t *testing.T) { testRecurringActivityQueryFilters(t, strg) })
+	t.Run("DateRangeEdges", func(t *testing.T) { testDateRangeEdges(t, strg) })
+	t.Run("TenantIsolation", func(t *testing.T) { testTenantIsolation(t, strg) })
+	t.Run("PutAnotherUsersId", func(t *testing.T) { testPutAnotherUsersId(t, strg) })
+	t.Run("DeleteForPlan", func(t *testing.T) { testDeleteForPlan(t, strg) })
+	t.Run("ConcurrentUpdates", func(t *testing.T) { testConcurrentUpdates(t, strg) })
+}
+
+func newUserId() string {
+	return fmt.Sprintf("conformance-user-%s", uuid.New())
+}
+
+func activityAt(userId string, planId *uuid.UUID, summary string, dateTime time.Time) storage.Activity {
+	return storage.Activity{
+		UserId:   userId,
+		PlanId:   planId,
+		Summary:  summary,
+		Stages:   []storage.ActivityStage{},
+		DateTime: dateTime,
+	}
+}
+
+// summaries lists the summaries of activities, sorted unless the order is
+// under test
+func summaries(activities *[]storage.Activity, sorted bool) []string {
+	names := make([]string, 0)
+	if activities == nil {
+		return names
+	}
+	for _, activity := range *activities {
+		names = append(names, activity.Summary)
+	}
+	if sorted {
+		sort.Strings(names)
+	}
+	return names
+}
+
+func equalNames(actual []string, expected ...string) bool {
+	if len(actual) != len(expected) {
+		return false
+	}
+	for i := range actual {
+		if actual[i] != expected[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func testPlanCRUD(t *testing.T, strg storage.Storage) {
+	ctx := context.Background()
+	userId := newUserId()
+
+	created, err := strg.Plan.Create(ctx, storage.Plan{UserId: userId, Name: "Marathon", Active: true})
+	if err != nil {
+		t.Fatalf("Error creating plan: %s", err)
+	}
+	if created.Id == uuid.Nil || created.Version != 1 {
+		t.Errorf("Expected an id and version 1, got %s and %d", created.Id, created.Version)
+	}
+	read, err := strg.Plan.Read(ctx, userId, created.Id)
+	if err != nil || read == nil {
+		t.Fatalf("Error reading plan: %v", err)
+	}
+	if read.Name != "Marathon" || !read.Active || read.UserId != userId || read.Version != 1 {
+		t.Errorf("Unexpected plan read back %+v", read)
+	}
+
+	read.Name = "Half Marathon"
+	err = strg.Plan.Update(ctx, *read)
+	if err != nil {
+		t.Fatalf("Error updating plan: %s", err)
+	}
+	updated, _ := strg.Plan.Read(ctx, userId, created.Id)
+	if updated == nil || updated.Name != "Half Marathon" || updated.Version != 2 {
+		t.Errorf("Expected the update stored as version 2, got %+v", updated)
+	}
+	err = strg.Plan.Update(ctx, *read)
+	if !errors.Is(err, storage.ErrVersionConflict) {
+		t.Errorf("Expected a stale update to conflict, got %v", err)
+	}
+	err = strg.Plan.Update(ctx, storage.Plan{Id: uuid.New(), UserId: userId, Version: 1})
+	if !errors.Is(err, storage.ErrNotFound) {
+		t.Errorf("Expected updating a missing plan to be not found, got %v", err)
+	}
+
+	err = strg.Plan.Delete(ctx, userId, created.Id)
+	if err != nil {
+		t.Fatalf("Error deleting plan: %s", err)
+	}
+	deleted, err := strg.Plan.Read(ctx, userId, created.Id)
+	if err != nil || deleted != nil {
+		t.Errorf("Expected a deleted plan to read as nil, got %+v (%v)", deleted, err)
+	}
+	err = strg.Plan.Delete(ctx, userId, created.Id)
+	if !errors.Is(err, storage.ErrNotFound) {
+		t.Errorf("Expected deleting a deleted plan to be not found, got %v", err)
+	}
+}
+
+func testActivityCRUD(t *testing.T, strg storage.Storage) {
+	ctx := context.Background()
+	userId := newUserId()
+	plan, _ := strg.Plan.Create(ctx, storage.Plan{UserId: userId, Name: "Plan"})
+	dateTime := time.Date(2023, 5, 1, 10, 30, 0, 0, time.UTC)
+	activity := activityAt(userId, &plan.Id, "Intervals", dateTime)
+	activity.Notes = "Keep it easy"
+	activity.TimeRelevant = true
+	activity.Stages = []storage.ActivityStage{
+		{Order: 0, Description: "Warm up", Metrics: []storage.ActivityStageMetric{{Amount: 10, Unit: "minutes"}}, Repetitions: 1},
+		{Order: 1, Description: "Sprint", Metrics: []storage.ActivityStageMetric{{Amount: 400, Unit: "meters"}}, Repetitions: 6},
+	}
+
+	created, err := strg.Activity.Create(ctx, activity)
+	if err != nil {
+		t.Fatalf("Error creating activity: %s", err)
+	}
+	if created.Id == uuid.Nil || created.Version != 1 {
+		t.Errorf("Expected an id and version 1, got %s and %d", created.Id, created.Version)
+	}
+	read, err := strg.Activity.Read(ctx, userId, created.Id)
+	if err != nil || read == nil {
+		t.Fatalf("Error reading activity: %v", err)
+	}
+	if read.Summary != "Intervals" || read.Notes != "Keep it easy" || !read.TimeRelevant || read.Completed {
+		t.Errorf("Unexpected activity read back %+v", read)
+	}
+	if !read.DateTime.Equal(dateTime) {
+		t.Errorf("Expected dateTime %s, got %s", dateTime, read.DateTime)
+	}
+	if read.PlanId == nil || *read.PlanId != plan.Id || read.RecurringActivityId != nil {
+		t.Errorf("Unexpected links %v %v", read.PlanId, read.RecurringActivityId)
+	}
+	if len(read.Stages) != 2 || read.Stages[1].Description != "Sprint" || read.Stages[1].Repetitions != 6 ||
+		len(read.Stages[1].Metrics) != 1 || read.Stages[1].Metrics[0] != (storage.ActivityStageMetric{Amount: 400, Unit: "meters"}) {
+		t.Errorf("Unexpected stages %+v", read.Stages)
+	}
+
+	read.Completed = true
+	read.PlanId = nil
+	err = strg.Activity.Update(ctx, *read)
+	if err != nil {
+		t.Fatalf("Error updating activity: %s", err)
+	}
+	updated, _ := strg.Activity.Read(ctx, userId, created.Id)
+	if updated == nil || !updated.Completed || updated.PlanId != nil || updated.Version != 2 {
+		t.Errorf("Expected the update stored as version 2, got %+v", updated)
+	}
+	err = strg.Activity.Update(ctx, *read)
+	if !errors.Is(err, storage.ErrVersionConflict) {
+		t.Errorf("Expected a stale update to conflict, got %v", err)
+	}
+
+	err = strg.Activity.Delete(ctx, userId, created.Id)
+	if err != nil {
+		t.Fatalf("Error deleting activity: %s", err)
+	}
+	deleted, err := strg.Activity.Read(ctx, userId, created.Id)
+	if err != nil || deleted != nil {
+		t.Errorf("Expected a deleted activity to read as nil, got %+v (%v)", deleted, err)
+	}
+	err = strg.Activity.Delete(ctx, userId, created.Id)
+	if !errors.Is(err, storage.ErrNotFound) {
+		t.Errorf("Expected deleting a deleted activity to be not found, got %v", err)
+	}
+}
+
+func testRecurringActivityCRUD(t *testing.T, strg storage.Storage) {
+	ctx := context.Background()
+	userId := newUserId()
+	plan, _ := strg.Plan.Create(ctx, storage.Plan{UserId: userId, Name: "Plan"})
+	start := time.Date(2023, 5, 1, 7, 0, 0, 0, time.UTC)
+
+	created, err := strg.RecurringActivity.Create(ctx, storage.RecurringActivity{
+		UserId:         userId,
+		PlanId:         &plan.Id,
+		Summary:        "Long run",
+		Stages:         []storage.ActivityStage{{Order: 0, Description: "Run", Metrics: []storage.ActivityStageMetric{{Amount: 20, Unit: "km"}}, Repetitions: 1}},
+		RecurrEachDays: 7,
+		RRule:          "FREQ=WEEKLY;BYDAY=TU,TH",
+		DateTimeStart:  start,
+	})
+	if err != nil {
+		t.Fatalf("Error creating recurring activity: %s", err)
+	}
+	if created.Id == uuid.Nil || created.Version != 1 {
+		t.Errorf("Expected an id and version 1, got %s and %d", created.Id, created.Version)
+	}
+	read, err := strg.RecurringActivity.Read(ctx, userId, created.Id)
+	if err != nil || read == nil {
+		t.Fatalf("Error reading recurring activity: %v", err)
+	}
+	if read.Summary != "Long run" || read.RecurrEachDays != 7 || read.RRule != "FREQ=WEEKLY;BYDAY=TU,TH" || !read.DateTimeStart.Equal(start) ||
+		read.PlanId == nil || *read.PlanId != plan.Id || len(read.Stages) != 1 || read.Stages[0].Metrics[0].Unit != "km" {
+		t.Errorf("Unexpected recurring activity read back %+v", read)
+	}
+
+	read.RecurrEachDays = 14
+	read.RRule = ""
+	err = strg.RecurringActivity.Update(ctx, *read)
+	if err != nil {
+		t.Fatalf("Error updating recurring activity: %s", err)
+	}
+	updated, _ := strg.RecurringActivity.Read(ctx, userId, created.Id)
+	if updated == nil || updated.RecurrEachDays != 14 || updated.RRule != "" || updated.Version != 2 {
+		t.Errorf("Expected the update stored as version 2, got %+v", updated)
+	}
+	err = strg.RecurringActivity.Update(ctx, *read)
+	if !errors.Is(err, storage.ErrVersionConflict) {
+		t.Errorf("Expected a stale update to conflict, got %v", err)
+	}
+
+	err = strg.RecurringActivity.Delete(ctx, userId, created.Id)
+	if err != nil {
+		t.Fatalf("Error deleting recurring activity: %s", err)
+	}
+	deleted, err := strg.RecurringActivity.Read(ctx, userId, created.Id)
+	if err != nil || deleted != nil {
+		t.Errorf("Expected a deleted recurring activity to read as nil, got %+v (%v)", deleted, err)
+	}
+	err = strg.RecurringActivity.Delete(ctx, userId, created.Id)
+	if !errors.Is(err, storage.ErrNotFound) {
+		t.Errorf("Expected deleting a deleted recurring activity to be not found, got %v", err)
+	}
+}
+
+func testPlanQueryFilters(t *testing.T, strg storage.Storage) {
+	ctx := context.Background()
+	userId := newUserId()
+	now := time.Date(2023, 6, 1, 12, 0, 0, 0, time.UTC)
+	strg.Plan.Create(ctx, storage.Plan{UserId: userId, Name: "Current"})
+	strg.Plan.Create(ctx, storage.Plan{UserId: userId, Name: "Archived", ArchivedAt: &now})
+	strg.Plan.Create(ctx, storage.Plan{UserId: userId, Name: "Trashed", DeletedAt: &now})
+
+	planNames := func(query storage.PlanStorageQuery) []string {
+		plans, err := strg.Plan.Query(ctx, query)
+		if err != nil {
+			t.Fatalf("Error querying plans: %s", err)
+		}
+		names := make([]string, 0)
+		for _, plan := range *plans {
+			names = append(names, plan.Name)
+		}
+		sort.Strings(names)
+		return names
+	}
+	if names := planNames(storage.PlanStorageQuery{UserId: userId}); !equalNames(names, "Current") {
+		t.Errorf("Expected only the current plan by default, got %v", names)
+	}
+	if names := planNames(storage.PlanStorageQuery{UserId: userId, IncludeArchived: true}); !equalNames(names, "Archived", "Current") {
+		t.Errorf("Expected archived plans included on request, got %v", names)
+	}
+	if names := planNames(storage.PlanStorageQuery{UserId: userId, Trashed: true}); !equalNames(names, "Trashed") {
+		t.Errorf("Expected only trashed plans from the trash, got %v", names)
+	}
+	if names := planNames(storage.PlanStorageQuery{UserId: newUserId()}); len(names) != 0 {
+		t.Errorf("Expected no plans for a new user, got %v", names)
+	}
+}
+
+func testActivityQueryFilters(t *testing.T, strg storage.Storage) {
+	ctx := context.Background()
+	userId := newUserId()
+	now := time.Date(2023, 6, 1, 12, 0, 0, 0, time.UTC)
+	plan, _ := strg.Plan.Create(ctx, storage.Plan{UserId: userId, Name: "Plan", Active: true})
+	inactivePlan, _ := strg.Plan.Create(ctx, storage.Plan{UserId: userId, Name: "Inactive"})
+	recurring, _ := strg.RecurringActivity.Create(ctx, storage.RecurringActivity{UserId: userId, PlanId: &plan.Id, Summary: "Weekly", Stages: []storage.ActivityStage{}, RecurrEachDays: 7})
+
+	day := func(n int) time.Time { return time.Date(2023, 5, n, 10, 0, 0, 0, time.UTC) }
+	strg.Activity.Create(ctx, activityAt(userId, &plan.Id, "a-planned", day(1)))
+	completed := activityAt(userId, &plan.Id, "b-completed", day(2))
+	completed.Completed = true
+	strg.Activity.Create(ctx, completed)
+	fromRecurring := activityAt(userId, &plan.Id, "c-recurring", day(3))
+	fromRecurring.RecurringActivityId = &recurring.Id
+	strg.Activity.Create(ctx, fromRecurring)
+	strg.Activity.Create(ctx, activityAt(userId, nil, "d-unplanned", day(4)))
+	strg.Activity.Create(ctx, activityAt(userId, &inactivePlan.Id, "e-inactive", day(5)))
+	trashed := activityAt(userId, &plan.Id, "f-trashed", day(6))
+	trashed.DeletedAt = &now
+	strg.Activity.Create(ctx, trashed)
+
+	query := func(query storage.ActivityStorageQuery, sorted bool) []string {
+		query.UserId = userId
+		activities, err := strg.Activity.Query(ctx, query)
+		if err != nil {
+			t.Fatalf("Error querying activities: %s", err)
+		}
+		return summaries(activities, sorted)
+	}
+	yes := true
+	no := false
+	cases := []struct {
+		name     string
+		query    storage.ActivityStorageQuery
+		expected []string
+	}{
+		{"all", storage.ActivityStorageQuery{}, []string{"a-planned", "b-completed", "c-recurring", "d-unplanned", "e-inactive"}},
+		{"plan", storage.ActivityStorageQuery{PlanId: &plan.Id}, []string{"a-planned", "b-completed", "c-recurring"}},
+		{"plans", storage.ActivityStorageQuery{PlanIds: []uuid.UUID{inactivePlan.Id, uuid.New()}}, []string{"e-inactive"}},
+		{"no plan", storage.ActivityStorageQuery{NoPlan: true}, []string{"d-unplanned"}},
+		{"active plans", storage.ActivityStorageQuery{ActivePlansOnly: true}, []string{"a-planned", "b-completed", "c-recurring"}},
+		{"recurring", storage.ActivityStorageQuery{RecurringActivityId: &recurring.Id}, []string{"c-recurring"}},
+		{"completed", storage.ActivityStorageQuery{Completed: &yes}, []string{"b-completed"}},
+		{"not completed", storage.ActivityStorageQuery{Completed: &no, PlanId: &plan.Id}, []string{"a-planned", "c-recurring"}},
+		{"trashed", storage.ActivityStorageQuery{Trashed: true}, []string{"f-trashed"}},
+	}
+	for _, c := range cases {
+		if names := query(c.query, true); !equalNames(names, c.expected...) {
+			t.Errorf("%s: expected %v, got %v", c.name, c.expected, names)
+		}
+	}
+
+	if names := query(storage.ActivityStorageQuery{}, false); !equalNames(names, "a-planned", "b-completed", "c-recurring", "d-unplanned", "e-inactive") {
+		t.Errorf("Expected ascending dateTime order by default, got %v", names)
+	}
+	if names := query(storage.ActivityStorageQuery{Order: storage.Descending, Limit: 2}, false); !equalNames(names, "e-inactive", "d-unplanned") {
+		t.Errorf("Expected the two latest activities in descending order, got %v", names)
+	}
+
+	// Plans still flagged active don't count once archived or trashed
+	archivedPlan, _ := strg.Plan.Create(ctx, storage.Plan{UserId: userId, Name: "Archived", Active: true, ArchivedAt: &now})
+	trashedPlan, _ := strg.Plan.Create(ctx, storage.Plan{UserId: userId, Name: "Trashed", Active: true, DeletedAt: &now})
+	strg.Activity.Create(ctx, activityAt(userId, &archivedPlan.Id, "g-archived-plan", day(7)))
+	strg.Activity.Create(ctx, activityAt(userId, &trashedPlan.Id, "h-trashed-plan", day(8)))
+	if names := query(storage.ActivityStorageQuery{ActivePlansOnly: true}, true); !equalNames(names, "a-planned", "b-completed", "c-recurring") {
+		t.Errorf("Expected only activities of active plans outside the archive and trash, got %v", names)
+	}
+}
+
+func testRecurringActivityQueryFilters(t *testing.T, strg storage.Storage) {
+	ctx := context.Background()
+	userId := newUserId()
+	now := time.Date(2023, 6, 1, 12, 0, 0, 0, time.UTC)
+	plan, _ := strg.Plan.Create(ctx, storage.Plan{UserId: userId, Name: "Plan"})
+	strg.RecurringActivity.Create(ctx, storage.RecurringActivity{UserId: userId, PlanId: &plan.Id, Summary: "In plan", Stages: []storage.ActivityStage{}, RecurrEachDays: 7})
+	strg.RecurringActivity.Create(ctx, storage.RecurringActivity{UserId: userId, Summary: "No plan", Stages: []storage.ActivityStage{}, RecurrEachDays: 7})
+	strg.RecurringActivity.Create(ctx, storage.RecurringActivity{UserId: userId, PlanId: &plan.Id, Summary: "Trashed", Stages: []storage.ActivityStage{}, RecurrEachDays: 7, DeletedAt: &now})
+
+	query := func(query storage.RecurringActivityStorageQuery) []string {
+		query.UserId = userId
+		activities, err := strg.RecurringActivity.Query(ctx, query)
+		if err != nil {
+			t.Fatalf("Error querying recurring activities: %s", err)
+		}
+		names := make([]string, 0)
+		for _, activity := range *activities {
+			names = append(names, activity.Summary)
+		}
+		sort.Strings(names)
+		return names
+	}
+	if names := query(storage.RecurringActivityStorageQuery{}); !equalNames(names, "In plan", "No plan") {
+		t.Errorf("Expected untrashed recurring activities, got %v", names)
+	}
+	if names := query(storage.RecurringActivityStorageQuery{PlanId: &plan.Id}); !equalNames(names, "In plan") {
+		t.Errorf("Expected the plan's recurring activities, got %v", names)
+	}
+	if names := query(storage.RecurringActivityStorageQuery{Trashed: true}); !equalNames(names, "Trashed") {
+		t.Errorf("Expected trashed recurring activities from the trash, got %v", names)
+	}
+}
+
+// Date ranges exclude both ends and may span months and years
+func testDateRangeEdges(t *testing.T, strg storage.Storage) {
+	ctx := context.Background()
+	userId := newUserId()
+	start := time.Date(2023, 12, 30, 0, 0, 0, 0, time.UTC)
+	end := time.Date(2024, 1, 2, 0, 0, 0, 0, time.UTC)
+	strg.Activity.Create(ctx, activityAt(userId, nil, "a-before", start.Add(-time.Second)))
+	strg.Activity.Create(ctx, activityAt(userId, nil, "b-at-start", start))
+	strg.Activity.Create(ctx, activityAt(userId, nil, "c-after-start", start.Add(time.Second)))
+	strg.Activity.Create(ctx, activityAt(userId, nil, "d-new-year", time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)))
+	strg.Activity.Create(ctx, activityAt(userId, nil, "e-before-end", end.Add(-time.Second)))
+	strg.Activity.Create(ctx, activityAt(userId, nil, "f-at-end", end))
+	strg.Activity.Create(ctx, activityAt(userId, nil, "g-after", end.Add(time.Second)))
+
+	activities, err := strg.Activity.Query(ctx, storage.ActivityStorageQuery{UserId: userId, DateRange: &storage.DateRange{Start: start, End: end}})
+	if err != nil {
+		t.Fatalf("Error querying activities: %s", err)
+	}
+	if names := summaries(activities, false); !equalNames(names, "c-after-start", "d-new-year", "e-before-end") {
+		t.Errorf("Expected only the activities strictly inside the range, got %v", names)
+	}
+
+	activities, err = strg.Activity.Query(ctx, storage.ActivityStorageQuery{UserId: userId, DateRange: &storage.DateRange{Start: end, End: start}})
+	if err != nil {
+		t.Fatalf("Error querying activities: %s", err)
+	}
+	if names := summaries(activities, false); len(names) != 0 {
+		t.Errorf("Expected nothing for a reversed range, got %v", names)
+	}
+}
+
+func testTenantIsolation(t *testing.T, strg storage.Storage) {
+	ctx := context.Background()
+	ownerId := newUserId()
+	otherId := newUserId()
+	plan, _ := strg.Plan.Create(ctx, storage.Plan{UserId: ownerId, Name: "Owned", Active: true})
+	activity, _ := strg.Activity.Create(ctx, activityAt(ownerId, &plan.Id, "Owned", time.Date(2023, 5, 1, 10, 0, 0, 0, time.UTC)))
+	recurring, _ := strg.RecurringActivity.Create(ctx, storage.RecurringActivity{UserId: ownerId, PlanId: &plan.Id, Summary: "Owned", Stages: []storage.ActivityStage{}, RecurrEachDays: 7})
+
+	readPlan, _ := strg.Plan.Read(ctx, otherId, plan.Id)
+	readActivity, _ := strg.Activity.Read(ctx, otherId, activity.Id)
+	readRecurring, _ := strg.RecurringActivity.Read(ctx, otherId, recurring.Id)
+	if readPlan != nil || readActivity != nil || readRecurring != nil {
+		t.Errorf("Expected another user's items to be unreadable")
+	}
+	plans, _ := strg.Plan.Query(ctx, storage.PlanStorageQuery{UserId: otherId, IncludeArchived: true})
+	activities, _ := strg.Activity.Query(ctx, storage.ActivityStorageQuery{UserId: otherId, PlanId: &plan.Id})
+	recurringActivities, _ := strg.RecurringActivity.Query(ctx, storage.RecurringActivityStorageQuery{UserId: otherId, PlanId: &plan.Id})
+	if len(*plans) != 0 || len(*activities) != 0 || len(*recurringActivities) != 0 {
+		t.Errorf("Expected queries to only return the user's own items")
+	}
+
+	stolenPlan := plan
+	stolenPlan.UserId = otherId
+	stolenPlan.Name = "Stolen"
+	err := strg.Plan.Update(ctx, stolenPlan)
+	if !errors.Is(err, storage.ErrNotFound) {
+		t.Errorf("Expected updating another user's plan to be not found, got %v", err)
+	}
+	stolenActivity := activity
+	stolenActivity.UserId = otherId
+	err = strg.Activity.Update(ctx, stolenActivity)
+	if !errors.Is(err, storage.ErrNotFound) {
+		t.Errorf("Expected updating another user's activity to be not found, got %v", err)
+	}
+	stolenRecurring := recurring
+	stolenRecurring.UserId = otherId
+	err = strg.RecurringActivity.Update(ctx, stolenRecurring)
+	if !errors.Is(err, storage.ErrNotFound) {
+		t.Errorf("Expected updating another user's recurring activity to be not found, got %v", err)
+	}
+
+	err = strg.Activity.Delete(ctx, otherId, activity.Id)
+	if !errors.Is(err, storage.ErrNotFound) {
+		t.Errorf("Expected deleting another user's activity to be not found, got %v", err)
+	}
+	err = strg.RecurringActivity.Delete(ctx, otherId, recurring.Id)
+	if !errors.Is(err, storage.ErrNotFound) {
+		t.Errorf("Expected deleting another user's recurring activity to be not found, got %v", err)
+	}
+	err = strg.Plan.Delete(ctx, otherId, plan.Id)
+	if !errors.Is(err, storage.ErrNotFound) {
+		t.Errorf("Expected deleting another user's plan to be not found, got %v", err)
+	}
+	strg.Activity.DeleteForPlan(ctx, otherId, plan.Id)
+	strg.RecurringActivity.DeleteForPlan(ctx, otherId, plan.Id)
+	strg.Activity.DeleteAllForUser(ctx, otherId)
+	strg.RecurringActivity.DeleteAllForUser(ctx, otherId)
+	strg.Plan.DeleteAllForUser(ctx, otherId)
+
+	readPlan, _ = strg.Plan.Read(ctx, ownerId, plan.Id)
+	readActivity, _ = strg.Activity.Read(ctx, ownerId, activity.Id)
+	readRecurring, _ = strg.RecurringActivity.Read(ctx, ownerId, recurring.Id)
+	if readPlan == nil || readPlan.Name != "Owned" || readPlan.Version != 1 ||
+		readActivity == nil || readActivity.Version != 1 ||
+		readRecurring == nil || readRecurring.Version != 1 {
+		t.Errorf("Expected the owner's items untouched, got %+v %+v %+v", readPlan, readActivity, readRecurring)
+	}
+}
+
+// Putting an id another user owns either fails as not found or, where items
+// are keyed by user, stores a separate item. It never replaces the owner's.
+func testPutAnotherUsersId(t *testing.T, strg storage.Storage) {
+	ctx := context.Background()
+	ownerId := newUserId()
+	otherId := newUserId()
+	plan, _ := strg.Plan.Create(ctx, storage.Plan{UserId: ownerId, Name: "Owned"})
+	activity, _ := strg.Activity.Create(ctx, activityAt(ownerId, nil, "Owned", time.Date(2023, 5, 1, 10, 0, 0, 0, time.UTC)))
+	recurring, _ := strg.RecurringActivity.Create(ctx, storage.RecurringActivity{UserId: ownerId, Summary: "Owned", Stages: []storage.ActivityStage{}, RecurrEachDays: 7})
+
+	stolenPlan := plan
+	stolenPlan.UserId = otherId
+	stolenPlan.Name = "Stolen"
+	stolenPlan.Version = 5
+	err := strg.Plan.Put(ctx, stolenPlan)
+	if err != nil && !errors.Is(err, storage.ErrNotFound) {
+		t.Errorf("Expected putting another user's plan id to be not found, got %v", err)
+	}
+	if err == nil {
+		readPlan, _ := strg.Plan.Read(ctx, otherId, plan.Id)
+		if readPlan == nil || readPlan.Name != "Stolen" {
+			t.Errorf("Expected a put that succeeded to store the user's own plan, got %+v", readPlan)
+		}
+	}
+	stolenActivity := activity
+	stolenActivity.UserId = otherId
+	stolenActivity.Summary = "Stolen"
+	stolenActivity.Version = 5
+	err = strg.Activity.Put(ctx, stolenActivity)
+	if err != nil && !errors.Is(err, storage.ErrNotFound) {
+		t.Errorf("Expected putting another user's activity id to be not found, got %v", err)
+	}
+	stolenRecurring := recurring
+	stolenRecurring.UserId = otherId
+	stolenRecurring.Summary = "Stolen"
+	stolenRecurring.Version = 5
+	err = strg.RecurringActivity.Put(ctx, stolenRecurring)
+	if err != nil && !errors.Is(err, storage.ErrNotFound) {
+		t.Errorf("Expected putting another user's recurring activity id to be not found, got %v", err)
+	}
+
+	readPlan, _ := strg.Plan.Read(ctx, ownerId, plan.Id)
+	readActivity, _ := strg.Activity.Read(ctx, ownerId, activity.Id)
+	readRecurring, _ := strg.RecurringActivity.Read(ctx, ownerId, recurring.Id)
+	if readPlan == nil || readPlan.Name != "Owned" || readPlan.Version != 1 ||
+		readActivity == nil || readActivity.Summary != "Owned" || readActivity.Version != 1 ||
+		readRecurring == nil || readRecurring.Summary != "Owned" || readRecurring.Version != 1 {
+		t.Errorf("Expected the owner's items untouched, got %+v %+v %+v", readPlan, readActivity, readRecurring)
+	}
+}
+
+func testDeleteForPlan(t *testing.T, strg storage.Storage) {
+	ctx := context.Background()
+	userId := newUserId()
+	now := time.Date(2023, 6, 1, 12, 0, 0, 0, time.UTC)
+	plan, _ := strg.Plan.Create(ctx, storage.Plan{UserId: userId, Name: "Deleted"})
+	otherPlan, _ := strg.Plan.Create(ctx, storage.Plan{UserId: userId, Name: "Kept"})
+	strg.Activity.Create(ctx, activityAt(userId, &plan.Id, "in-plan", time.Date(2023, 5, 1, 10, 0, 0, 0, time.UTC)))
+	trashed := activityAt(userId, &plan.Id, "trashed-in-plan", time.Date(2023, 5, 2, 10, 0, 0, 0, time.UTC))
+	trashed.DeletedAt = &now
+	strg.Activity.Create(ctx, trashed)
+	strg.Activity.Create(ctx, activityAt(userId, &otherPlan.Id, "other-plan", time.Date(2023, 5, 3, 10, 0, 0, 0, time.UTC)))
+	strg.Activity.Create(ctx, activityAt(userId, nil, "no-plan", time.Date(2023, 5, 4, 10, 0, 0, 0, time.UTC)))
+	strg.RecurringActivity.Create(ctx, storage.RecurringActivity{UserId: userId, PlanId: &plan.Id, Summary: "in-plan", Stages: []storage.ActivityStage{}, RecurrEachDays: 7})
+	strg.RecurringActivity.Create(ctx, storage.RecurringActivity{UserId: userId, PlanId: &otherPlan.Id, Summary: "other-plan", Stages: []storage.ActivityStage{}, RecurrEachDays: 7})
+
+	err := strg.Activity.DeleteForPlan(ctx, userId, plan.Id)
+	if err != nil {
+		t.Fatalf("Error deleting activities for plan: %s", err)
+	}
+	err = strg.RecurringActivity.DeleteForPlan(ctx, userId, plan.Id)
+	if err != nil {
+		t.Fatalf("Error deleting recurring activities for plan: %s", err)
+	}
+
+	activities, _ := strg.Activity.Query(ctx, storage.ActivityStorageQuery{UserId: userId})
+	if names := summaries(activities, true); !equalNames(names, "no-plan", "other-plan") {
+		t.Errorf("Expected only activities outside the plan left, got %v", names)
+	}
+	trashedActivities, _ := strg.Activity.Query(ctx, storage.ActivityStorageQuery{UserId: userId, Trashed: true})
+	if len(*trashedActivities) != 0 {
+		t.Errorf("Expected the plan's trashed activities deleted too, got %v", summaries(trashedActivities, true))
+	}
+	recurringActivities, _ := strg.RecurringActivity.Query(ctx, storage.RecurringActivityStorageQuery{UserId: userId})
+	if len(*recurringActivities) != 1 || (*recurringActivities)[0].Summary != "other-plan" {
+		t.Errorf("Expected only the other plan's recurring activity left, got %+v", recurringActivities)
+	}
+
+	err = strg.Activity.DeleteForPlan(ctx, userId, uuid.New())
+	if err != nil {
+		t.Errorf("Expected deleting for a plan without activities to succeed, got %v", err)
+	}
+}
+
+// concurrentWriters is how many writers race to update the same version
+const concurrentWriters = 8
+
+// raceUpdates runs update from concurrentWriters goroutines at once and
+// expects exactly one of them to succeed and the others to conflict
+func raceUpdates(t *testing.T, update func(writer int) error) {
+	var wg sync.WaitGroup
+	errs := make([]error, concurrentWriters)
+	start := make(chan struct{})
+	for writer := 0; writer < concurrentWriters; writer++ {
+		wg.Add(1)
+		go func(writer int) {
+			defer wg.Done()
+			<-start
+			errs[writer] = update(writer)
+		}(writer)
+	}
+	close(start)
+	wg.Wait()
+	succeeded := 0
+	for _, err := range errs {
+		if err == nil {
+			succeeded++
+		} else if !errors.Is(err, storage.ErrVersionConflict) {
+			t.Errorf("Expected a losing update to conflict, got %v", err)
+		}
+	}
+	if succeeded != 1 {
+		t.Errorf("Expected exactly one of %d concurrent updates to succeed, %d did", concurrentWriters, succeeded)
+	}
+}
+
+func testConcurrentUpdates(t *testing.T, strg storage.Storage) {
+	ctx := context.Background()
+	userId := newUserId()
+
+	plan, err := strg.Plan.Create(ctx, storage.Plan{UserId: userId, Name: "Plan"})
+	if err != nil {
+		t.Fatalf("Error creating plan: %s", err)
+	}
+	raceUpdates(t, func(writer int) error {
+		update := plan
+		update.Name = fmt.Sprintf("writer-%d", writer)
+		return strg.Plan.Update(ctx, update)
+	})
+	readPlan, _ := strg.Plan.Read(ctx, userId, plan.Id)
+	if readPlan == nil || readPlan.Version != 2 {
+		t.Errorf("Expected the plan stored once as version 2, got %+v", readPlan)
+	}
+
+	activity, err := strg.Activity.Create(ctx, activityAt(userId, &plan.Id, "activity", time.Date(2023, 5, 1, 10, 0, 0, 0, time.UTC)))
+	if err != nil {
+		t.Fatalf("Error creating activity: %s", err)
+	}
+	raceUpdates(t, func(writer int) error {
+		update := activity
+		update.Summary = fmt.Sprintf("writer-%d", writer)
+		return strg.Activity.Update(ctx, update)
+	})
+	readActivity, _ := strg.Activity.Read(ctx, userId, activity.Id)
+	if readActivity == nil || readActivity.Version != 2 {
+		t.Errorf("Expected the activity stored once as version 2, got %+v", readActivity)
+	}
+
+	recurring, err := strg.RecurringActivity.Create(ctx, storage.RecurringActivity{UserId: userId, PlanId: &plan.Id, Summary: "recurring", Stages: []storage.ActivityStage{}, RecurrEachDays: 7})
+	if err != nil {
+		t.Fatalf("Error creating recurring activity: %s", err)
+	}
+	raceUpdates(t, func(writer int) error {
+		update := recurring
+		update.Summary = fmt.Sprintf("writer-%d", writer)
+		return strg.RecurringActivity.Update(ctx, update)
+	})
+	readRecurring, _ := strg.RecurringActivity.Read(ctx, userId, recurring.Id)
+	if readRecurring == nil || readRecurring.Version != 2 {
+		t.Errorf("Expected the recurring activity stored once as version 2, got %+v", readRecurring)
+	}
+}